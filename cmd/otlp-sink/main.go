@@ -0,0 +1,71 @@
+// Command otlp-sink is a stand-in for an OpenTelemetry collector. It accepts OTLP/HTTP trace
+// exports and logs every span, which is enough to check tracing locally without running a
+// collector and a tracing backend:
+//
+//	go run ./cmd/otlp-sink &
+//	OTEL_EXPORTER_OTLP_ENDPOINT=localhost:4318 OTEL_EXPORTER_OTLP_INSECURE=true go run .
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	addr := flag.String("addr", ":4318", "address to listen on")
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+
+	http.HandleFunc("POST /v1/traces", handleTraces)
+
+	log.Info().Str("addr", *addr).Msg("OTLP sink listening")
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatal().Err(err).Msg("ListenAndServe() failed")
+	}
+}
+
+func handleTraces(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		log.Warn().Err(err).Msg("Could not decode export request")
+		http.Error(w, "Invalid protobuf payload", http.StatusBadRequest)
+		return
+	}
+
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				event := log.Info()
+				if span.Status != nil && span.Status.Message != "" {
+					event = log.Warn().Str("error", span.Status.Message)
+				}
+				event.
+					Str("trace_id", hex.EncodeToString(span.TraceId)).
+					Str("span_id", hex.EncodeToString(span.SpanId)).
+					Str("parent_id", hex.EncodeToString(span.ParentSpanId)).
+					Dur("duration", time.Duration(span.EndTimeUnixNano-span.StartTimeUnixNano)).
+					Msg(span.Name)
+			}
+		}
+	}
+
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
@@ -12,11 +12,12 @@ import (
 // BackupHandler handles HTTP requests related to backups.
 type BackupHandler struct {
 	service services.BackupServiceProvider
+	jobs    services.JobServiceProvider
 }
 
 // NewBackupHandler creates a new BackupHandler.
-func NewBackupHandler(service services.BackupServiceProvider) *BackupHandler {
-	return &BackupHandler{service: service}
+func NewBackupHandler(service services.BackupServiceProvider, jobs services.JobServiceProvider) *BackupHandler {
+	return &BackupHandler{service: service, jobs: jobs}
 }
 
 // CreateBackupPayload is the expected JSON body for creating a backup.
@@ -27,7 +28,7 @@ type CreateBackupPayload struct {
 // GetAllForServer handles the request to get all backups for a server.
 func (h *BackupHandler) GetAllForServer(w http.ResponseWriter, r *http.Request) {
 	serverID := chi.URLParam(r, "id")
-	backups, err := h.service.GetBackupsForServer(serverID)
+	backups, err := h.service.GetBackupsForServer(r.Context(), serverID)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to retrieve backups for server")
 		http.Error(w, "Failed to retrieve backups: "+err.Error(), http.StatusInternalServerError)
@@ -52,22 +53,23 @@ func (h *BackupHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Creating a backup can be a long-running task.
-	go func() {
-		if _, err := h.service.CreateBackup(serverID, payload.Name); err != nil {
-			log.Error().Err(err).Str("server_id", serverID).Str("backup_name", payload.Name).Msg("Failed to create backup in background")
-		}
-	}()
+	// Creating a backup can be a long-running task, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeCreateBackup, &serverID, services.CreateBackupJobPayload{Name: payload.Name})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Str("backup_name", payload.Name).Msg("Failed to queue backup")
+		http.Error(w, "Failed to queue backup: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Backup creation started."})
+	json.NewEncoder(w).Encode(job)
 }
 
 // Delete handles the request to delete a backup.
 func (h *BackupHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	backupID := chi.URLParam(r, "backupId")
-	if err := h.service.DeleteBackup(backupID); err != nil {
+	if err := h.service.DeleteBackup(r.Context(), backupID); err != nil {
 		log.Error().Err(err).Str("backup_id", backupID).Msg("Failed to delete backup")
 		http.Error(w, "Failed to delete backup: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -78,15 +80,21 @@ func (h *BackupHandler) Delete(w http.ResponseWriter, r *http.Request) {
 // Restore handles the request to restore a backup.
 func (h *BackupHandler) Restore(w http.ResponseWriter, r *http.Request) {
 	backupID := chi.URLParam(r, "backupId")
+	backup, err := h.service.GetBackupByID(r.Context(), backupID)
+	if err != nil {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
 
-	// Restoring is a long-running, critical task. We run it in a goroutine.
-	go func() {
-		if err := h.service.RestoreBackup(backupID); err != nil {
-			log.Error().Err(err).Str("backup_id", backupID).Msg("Failed to restore backup in background")
-		}
-	}()
+	// Restoring is a long-running, critical task, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeRestoreBackup, &backup.ServerID, services.RestoreBackupJobPayload{BackupID: backup.ID})
+	if err != nil {
+		log.Error().Err(err).Str("backup_id", backupID).Msg("Failed to queue backup restore")
+		http.Error(w, "Failed to queue backup restore: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Backup restoration started. The server will restart."})
+	json.NewEncoder(w).Encode(job)
 }
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// FileHandler handles HTTP requests of the file manager, which works on the files of a server's data directory.
+type FileHandler struct {
+	service services.FileServiceProvider
+	jobs    services.JobServiceProvider
+}
+
+// NewFileHandler creates a new FileHandler.
+func NewFileHandler(service services.FileServiceProvider, jobs services.JobServiceProvider) *FileHandler {
+	return &FileHandler{service: service, jobs: jobs}
+}
+
+// CreateUploadPayload is the expected JSON body for starting a chunked upload.
+type CreateUploadPayload struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+// FilePathsPayload is the expected JSON body for operations on several files.
+type FilePathsPayload struct {
+	Paths []string `json:"paths"`
+}
+
+// MoveFilePayload is the expected JSON body for renaming or moving a file.
+type MoveFilePayload struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FilePathPayload is the expected JSON body for operations on a single path.
+type FilePathPayload struct {
+	Path string `json:"path"`
+}
+
+// ChmodPayload is the expected JSON body for changing the mode of a file.
+type ChmodPayload struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"` // Octal, such as "644"
+}
+
+// ArchiveFilesPayload is the expected JSON body for zipping files.
+type ArchiveFilesPayload struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"` // Path of the new .zip
+}
+
+// ExtractArchivePayload is the expected JSON body for unzipping an archive.
+type ExtractArchivePayload struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"` // Directory to extract into, the archive's own if empty
+}
+
+// Download handles the request to download a file. Range requests are supported, so downloads can be resumed.
+func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	f, info, err := h.service.OpenFile(r.Context(), serverID, filePath)
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to download file")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// Upload handles a multipart upload of a file into the directory given by the "path" form field. Existing
+// files are only replaced if "overwrite" is true.
+func (h *FileHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	const maxUploadSize = 2 * 1024 * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "The uploaded file is too big. Please choose a file that's less than 2GB in size, or upload it in chunks.", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	overwrite, _ := strconv.ParseBool(r.FormValue("overwrite"))
+
+	target := path.Join("/", r.FormValue("path"), path.Base(header.Filename))
+	info, err := h.service.UploadFile(r.Context(), serverID, target, overwrite, file)
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to upload file")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// CreateUpload handles the request to start a chunked upload. Chunks are then sent with UploadChunk.
+func (h *FileHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload CreateUploadPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Path == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.service.CreateUpload(r.Context(), serverID, payload.Path, payload.Size, payload.Overwrite)
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to start upload")
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(upload)
+}
+
+// GetUpload handles the request for the state of a chunked upload, telling a client where to resume it.
+func (h *FileHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	upload, err := h.service.GetUpload(r.Context(), serverID, chi.URLParam(r, "uploadId"))
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to get upload")
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// UploadChunk handles a chunk of a chunked upload. The body is the chunk and the Upload-Offset header says
+// where it starts, which must be where the upload left off.
+func (h *FileHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "A valid Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	upload, err := h.service.WriteUploadChunk(r.Context(), serverID, chi.URLParam(r, "uploadId"), offset, r.Body)
+	if upload.ID != "" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	}
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to write upload chunk")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// CancelUpload handles the request to discard a chunked upload.
+func (h *FileHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	if err := h.service.CancelUpload(r.Context(), serverID, chi.URLParam(r, "uploadId")); err != nil {
+		writeFileError(w, err, serverID, "Failed to cancel upload")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete handles the request to delete files and directories.
+func (h *FileHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload FilePathsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.DeleteFiles(r.Context(), serverID, payload.Paths); err != nil {
+		writeFileError(w, err, serverID, "Failed to delete files")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Move handles the request to rename or move a file or directory.
+func (h *FileHandler) Move(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload MoveFilePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.From == "" || payload.To == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.MoveFile(r.Context(), serverID, payload.From, payload.To); err != nil {
+		writeFileError(w, err, serverID, "Failed to move file")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Mkdir handles the request to create a directory.
+func (h *FileHandler) Mkdir(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload FilePathPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Path == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.MakeDirectory(r.Context(), serverID, payload.Path); err != nil {
+		writeFileError(w, err, serverID, "Failed to create directory")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Chmod handles the request to change the permissions of a file or directory.
+func (h *FileHandler) Chmod(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload ChmodPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Path == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.ChangeMode(r.Context(), serverID, payload.Path, payload.Mode); err != nil {
+		writeFileError(w, err, serverID, "Failed to change file mode")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Archive handles the request to zip files and directories. Archiving large directories takes a while,
+// so it runs as a job.
+func (h *FileHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload ArchiveFilesPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.Paths) == 0 || payload.Destination == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeArchiveFiles, &serverID, services.ArchiveFilesJobPayload{
+		Paths:       payload.Paths,
+		Destination: payload.Destination,
+		RequestedBy: requester(r),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to queue archive job")
+		http.Error(w, "Failed to archive files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Extract handles the request to unzip an archive, which runs as a job.
+func (h *FileHandler) Extract(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload ExtractArchivePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Path == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.Destination == "" {
+		payload.Destination = path.Dir(path.Join("/", payload.Path))
+	}
+
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeExtractArchive, &serverID, services.ExtractArchiveJobPayload{
+		Path:        payload.Path,
+		Destination: payload.Destination,
+		RequestedBy: requester(r),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to queue extract job")
+		http.Error(w, "Failed to extract archive: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Search handles the request to search a directory recursively. "q" is matched against file names, or
+// against file contents if "content" is true.
+func (h *FileHandler) Search(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	query := r.URL.Query()
+	if query.Get("q") == "" {
+		http.Error(w, "A search query is required", http.StatusBadRequest)
+		return
+	}
+	content, _ := strconv.ParseBool(query.Get("content"))
+
+	results, err := h.service.SearchFiles(r.Context(), serverID, query.Get("path"), query.Get("q"), content)
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to search files")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// GetHistory handles the request to list the previous versions kept of a file.
+func (h *FileHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	name := r.URL.Query().Get("path")
+	if name == "" {
+		http.Error(w, "File path is required", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := h.service.ListFileVersions(r.Context(), serverID, name)
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to list file history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetVersion handles the request for the content of a previous version of a file.
+func (h *FileHandler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	_, content, err := h.service.ReadFileVersion(r.Context(), serverID, chi.URLParam(r, "versionId"))
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to read file version")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(content)
+}
+
+// DiffVersion handles the request for the unified diff from a previous version of a file to the "to"
+// version, or to the current file if none is given.
+func (h *FileHandler) DiffVersion(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	diff, err := h.service.DiffFileVersion(r.Context(), serverID, chi.URLParam(r, "versionId"), r.URL.Query().Get("to"))
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to diff file versions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Write([]byte(diff))
+}
+
+// RevertVersion handles the request to restore a previous version of a file.
+func (h *FileHandler) RevertVersion(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	info, err := h.service.RevertFile(r.Context(), serverID, chi.URLParam(r, "versionId"))
+	if err != nil {
+		writeFileError(w, err, serverID, "Failed to revert file")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// requester returns the username of the user making a request, for jobs to name in their events.
+func requester(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		return claims.Username
+	}
+	return ""
+}
+
+// writeFileError maps file service errors to HTTP statuses. Config files that fail validation are answered
+// with their issues, so editors can point at the lines.
+func writeFileError(w http.ResponseWriter, err error, serverID, message string) {
+	var invalid *services.ConfigValidationError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"error": invalid.Error(), "path": invalid.Path, "issues": invalid.Issues})
+	case errors.Is(err, services.ErrFileNotFound), errors.Is(err, services.ErrUploadNotFound), errors.Is(err, services.ErrFileVersionNotFound), errors.Is(err, os.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, sandbox.ErrEscape), errors.Is(err, sandbox.ErrHardLink):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrFileExists), errors.Is(err, services.ErrUploadOffset):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidFileOperation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Error().Err(err).Str("server_id", serverID).Msg(message)
+		http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHistoryRange = 30 * time.Minute
+	minHistoryStep      = 15 * time.Second // The stat updater samples every 15 seconds
+	targetHistoryPoints = 300
+	maxHistoryPoints    = 5000
+)
+
+// HistoryHandler handles HTTP requests for resource history.
+type HistoryHandler struct {
+	service services.HistoryServiceProvider
+}
+
+// NewHistoryHandler creates a new HistoryHandler.
+func NewHistoryHandler(service services.HistoryServiceProvider) *HistoryHandler {
+	return &HistoryHandler{service: service}
+}
+
+// GetServerHistory handles the request to get resource history for a server.
+// Query parameters: from and to (RFC3339 or unix seconds, default the last 30 minutes)
+// and step (a duration like "5m" or seconds, default picked to return about 300 buckets).
+func (h *HistoryHandler) GetServerHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.service.GetServerHistory(r.Context(), id, query)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to retrieve resource history")
+		http.Error(w, "Failed to retrieve resource history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// parseHistoryQuery reads from, to and step from the request, filling in defaults.
+func parseHistoryQuery(r *http.Request) (models.HistoryQuery, error) {
+	q := r.URL.Query()
+	query := models.HistoryQuery{To: time.Now()}
+
+	if v := q.Get("to"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid 'to': %w", err)
+		}
+		query.To = t
+	}
+	query.From = query.To.Add(-defaultHistoryRange)
+	if v := q.Get("from"); v != "" {
+		t, err := parseHistoryTime(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid 'from': %w", err)
+		}
+		query.From = t
+	}
+	if !query.From.Before(query.To) {
+		return query, fmt.Errorf("'from' must be before 'to'")
+	}
+
+	span := query.To.Sub(query.From)
+	if v := q.Get("step"); v != "" {
+		step, err := parseHistoryStep(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid 'step': %w", err)
+		}
+		query.Step = step
+	} else {
+		query.Step = (span / targetHistoryPoints).Round(time.Second)
+	}
+	if query.Step < minHistoryStep {
+		query.Step = minHistoryStep
+	}
+	if span/query.Step > maxHistoryPoints {
+		return query, fmt.Errorf("range and step would return more than %d buckets", maxHistoryPoints)
+	}
+	return query, nil
+}
+
+func parseHistoryTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseHistoryStep(v string) (time.Duration, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// JobHandler handles HTTP requests related to background jobs.
+type JobHandler struct {
+	service services.JobServiceProvider
+}
+
+// NewJobHandler creates a new JobHandler.
+func NewJobHandler(service services.JobServiceProvider) *JobHandler {
+	return &JobHandler{service: service}
+}
+
+// GetAll handles the request to list recent jobs, optionally for a single server.
+func (h *JobHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20 // Default limit
+	}
+	serverID := r.URL.Query().Get("serverId")
+
+	jobs, err := h.service.GetJobs(r.Context(), serverID, limit)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to retrieve jobs")
+		http.Error(w, "Failed to retrieve jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// Get handles the request to get a single job, including its progress.
+func (h *JobHandler) Get(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+	job, err := h.service.GetJobByID(r.Context(), jobID)
+	if errors.Is(err, services.ErrJobNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID).Msg("Failed to retrieve job")
+		http.Error(w, "Failed to retrieve job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Cancel handles the request to cancel a queued or running job.
+func (h *JobHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobId")
+	job, err := h.service.CancelJob(r.Context(), jobID)
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrJobFinished):
+		http.Error(w, "Job has already finished", http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("job_id", jobID).Msg("Failed to cancel job")
+		http.Error(w, "Failed to cancel job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// spoolUpload copies an uploaded file into dir, where it waits for the job that processes it.
+// It returns the path of the spooled file.
+func spoolUpload(dir, pattern string, file io.Reader) (string, error) {
+	spool, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(spool, file)
+	if closeErr := spool.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(spool.Name())
+		return "", err
+	}
+	return spool.Name(), nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// ModHandler handles HTTP requests related to the mods and plugins of a server.
+type ModHandler struct {
+	service services.ModServiceProvider
+}
+
+// NewModHandler creates a new ModHandler.
+func NewModHandler(service services.ModServiceProvider) *ModHandler {
+	return &ModHandler{service: service}
+}
+
+// GetAll handles the request to list the mods and plugins installed on a server.
+func (h *ModHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	mods, err := h.service.ListMods(r.Context(), serverID)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to list mods")
+		http.Error(w, "Failed to list mods: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mods)
+}
+
+// Add handles the upload of a mod or plugin jar. The optional "directory" form field picks mods or plugins;
+// without it the jar's metadata decides.
+func (h *ModHandler) Add(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	const maxUploadSize = 100 * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "The uploaded file is too big. Please choose a file that's less than 100MB in size.", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	mod, err := h.service.AddMod(r.Context(), serverID, r.FormValue("directory"), header.Filename, file)
+	if err != nil {
+		writeModError(w, err, serverID, "Failed to add mod")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(mod)
+}
+
+// Delete handles the request to remove a mod or plugin jar.
+func (h *ModHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	if err := h.service.RemoveMod(r.Context(), serverID, chi.URLParam(r, "directory"), chi.URLParam(r, "fileName")); err != nil {
+		writeModError(w, err, serverID, "Failed to remove mod")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Enable handles the request to enable a disabled mod or plugin.
+func (h *ModHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	h.setEnabled(w, r, true)
+}
+
+// Disable handles the request to disable a mod or plugin without removing it.
+func (h *ModHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	h.setEnabled(w, r, false)
+}
+
+func (h *ModHandler) setEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	serverID := chi.URLParam(r, "id")
+	mod, err := h.service.SetModEnabled(r.Context(), serverID, chi.URLParam(r, "directory"), chi.URLParam(r, "fileName"), enabled)
+	if err != nil {
+		writeModError(w, err, serverID, "Failed to update mod")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(mod)
+}
+
+// writeModError maps mod service errors to HTTP statuses.
+func writeModError(w http.ResponseWriter, err error, serverID, message string) {
+	switch {
+	case errors.Is(err, services.ErrModNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrModExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidMod):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Error().Err(err).Str("server_id", serverID).Msg(message)
+		http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
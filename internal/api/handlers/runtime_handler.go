@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// RuntimeHandler handles HTTP requests related to the Java runtime catalog.
+type RuntimeHandler struct {
+	service services.RuntimeServiceProvider
+	jobs    services.JobServiceProvider
+}
+
+// NewRuntimeHandler creates a new RuntimeHandler.
+func NewRuntimeHandler(service services.RuntimeServiceProvider, jobs services.JobServiceProvider) *RuntimeHandler {
+	return &RuntimeHandler{service: service, jobs: jobs}
+}
+
+// RuntimeRequirement is the response body of the runtime requirement lookup.
+type RuntimeRequirement struct {
+	MinecraftVersion string             `json:"minecraftVersion"`
+	JavaVersion      string             `json:"javaVersion"`
+	Default          models.JavaRuntime `json:"default"`
+}
+
+// GetAll handles the request to list the runtimes. With ?minecraftVersion= only runtimes new enough
+// for that version are listed.
+func (h *RuntimeHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	runtimes, err := h.service.GetRuntimes(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to retrieve runtimes")
+		http.Error(w, "Failed to retrieve runtimes", http.StatusInternalServerError)
+		return
+	}
+
+	if minecraftVersion := r.URL.Query().Get("minecraftVersion"); minecraftVersion != "" {
+		compatible := []models.JavaRuntime{}
+		for _, runtime := range runtimes {
+			if _, err := h.service.ResolveRuntime(r.Context(), runtime.ID, installer.JavaVersionFor(minecraftVersion)); err == nil {
+				compatible = append(compatible, runtime)
+			}
+		}
+		runtimes = compatible
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtimes)
+}
+
+// GetRequirement handles the request for the Java version a Minecraft version needs and the runtime
+// servers of that version get by default.
+func (h *RuntimeHandler) GetRequirement(w http.ResponseWriter, r *http.Request) {
+	minecraftVersion := r.URL.Query().Get("minecraftVersion")
+	if minecraftVersion == "" {
+		http.Error(w, "Missing required query parameter: minecraftVersion", http.StatusBadRequest)
+		return
+	}
+
+	javaVersion := installer.JavaVersionFor(minecraftVersion)
+	runtime, err := h.service.ResolveRuntime(r.Context(), "", javaVersion)
+	if err != nil {
+		log.Error().Err(err).Str("minecraft_version", minecraftVersion).Msg("Failed to resolve default runtime")
+		http.Error(w, "Failed to resolve runtime: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RuntimeRequirement{MinecraftVersion: minecraftVersion, JavaVersion: javaVersion, Default: runtime})
+}
+
+// Get handles the request to get a single runtime by its ID.
+func (h *RuntimeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	runtime, err := h.service.GetRuntime(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeRuntimeError(w, err, "Failed to retrieve runtime")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtime)
+}
+
+// Register handles the request to add a custom image to the catalog. The image is pulled and checked
+// in a job, whose progress reports the pull.
+func (h *RuntimeHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var payload services.RegisterRuntimeJobPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.Name == "" || payload.Image == "" {
+		http.Error(w, "Missing required fields: name, image", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeRegisterRuntime, nil, payload)
+	if err != nil {
+		log.Error().Err(err).Str("image", payload.Image).Msg("Failed to queue runtime registration")
+		http.Error(w, "Failed to register runtime: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Delete handles the request to remove a custom runtime.
+func (h *RuntimeHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeleteRuntime(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeRuntimeError(w, err, "Failed to delete runtime")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRuntimeError maps runtime service errors to HTTP statuses.
+func writeRuntimeError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrRuntimeNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrRuntimeInUse), errors.Is(err, services.ErrRuntimeBuiltin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Error().Err(err).Msg(message)
+		http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
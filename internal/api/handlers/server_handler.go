@@ -4,11 +4,16 @@ package handlers
 import (
 	"archive/zip"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/configcheck"
+	"github.com/isdelr/ender-deploy-be/internal/jvm"
 	"github.com/isdelr/ender-deploy-be/internal/models"
 	"github.com/isdelr/ender-deploy-be/internal/services"
 	"github.com/rs/zerolog/log"
@@ -16,23 +21,32 @@ import (
 
 // ServerHandler handles HTTP requests related to servers.
 type ServerHandler struct {
-	service services.ServerServiceProvider
+	service    services.ServerServiceProvider
+	jobs       services.JobServiceProvider
+	uploadPath string // Where uploads are spooled until their job has unpacked them
 }
 
 // NewServerHandler creates a new ServerHandler.
-func NewServerHandler(service services.ServerServiceProvider) *ServerHandler {
-	return &ServerHandler{service: service}
+func NewServerHandler(service services.ServerServiceProvider, jobs services.JobServiceProvider, uploadPath string) *ServerHandler {
+	return &ServerHandler{service: service, jobs: jobs, uploadPath: uploadPath}
 }
 
 // CreateServerPayload is the expected JSON body for creating a server.
 type CreateServerPayload struct {
-	Name       string `json:"name"`
-	TemplateID string `json:"templateId"`
+	Name            string `json:"name"`
+	TemplateID      string `json:"templateId"`
+	TemplateVersion int    `json:"templateVersion,omitempty"` // Defaults to the template's latest version
+	RuntimeID       string `json:"runtimeId,omitempty"`       // Defaults to the built-in runtime for the template's Java version
+}
+
+// ChangeRuntimePayload is the expected JSON body for moving a server to another Java runtime.
+type ChangeRuntimePayload struct {
+	RuntimeID string `json:"runtimeId"`
 }
 
 // GetAll handles the request to get all servers.
 func (h *ServerHandler) GetAll(w http.ResponseWriter, r *http.Request) {
-	servers, err := h.service.GetAllServers()
+	servers, err := h.service.GetAllServers(r.Context())
 	if err != nil {
 		// Log the actual error to your server's console
 		log.Error().Err(err).Msg("Failed to retrieve servers")
@@ -49,7 +63,7 @@ func (h *ServerHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 // Get handles the request to get a single server by its ID.
 func (h *ServerHandler) Get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	server, err := h.service.GetServerByID(id)
+	server, err := h.service.GetServerByID(r.Context(), id)
 	if err != nil {
 		http.Error(w, "Server not found", http.StatusNotFound)
 		return
@@ -67,16 +81,17 @@ func (h *ServerHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newServer, err := h.service.CreateServerFromTemplate(payload.Name, payload.TemplateID)
+	// Provisioning pulls images and unpacks the template, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeCreateServer, nil, services.CreateServerJobPayload{Name: payload.Name, TemplateID: payload.TemplateID, TemplateVersion: payload.TemplateVersion, RuntimeID: payload.RuntimeID})
 	if err != nil {
-		log.Error().Err(err).Str("server_name", payload.Name).Str("template_id", payload.TemplateID).Msg("Failed to create server")
+		log.Error().Err(err).Str("server_name", payload.Name).Str("template_id", payload.TemplateID).Msg("Failed to queue server creation")
 		http.Error(w, "Failed to create server: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newServer)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
 // Update handles the request to update an existing server.
@@ -89,7 +104,7 @@ func (h *ServerHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	server.ID = id
 
-	updatedServer, err := h.service.UpdateServer(id, server)
+	updatedServer, err := h.service.UpdateServer(r.Context(), id, server)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", id).Msg("Failed to update server")
 		http.Error(w, "Failed to update server", http.StatusInternalServerError)
@@ -100,10 +115,179 @@ func (h *ServerHandler) Update(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updatedServer)
 }
 
+// Upgrade handles the request to move a server to another Minecraft version or loader.
+func (h *ServerHandler) Upgrade(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var target services.UpgradeTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	server, err := h.service.GetServerByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if target.MinecraftVersion == "" {
+		http.Error(w, "Missing required field: minecraftVersion", http.StatusBadRequest)
+		return
+	}
+	if target.ServerType == "" && server.ServerType == "" {
+		http.Error(w, "The server's software is unknown, so serverType is required", http.StatusBadRequest)
+		return
+	}
+
+	// The upgrade takes a backup, installs the new version and waits for the server to boot, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeUpgradeServer, &id, services.UpgradeServerJobPayload{Target: target})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to queue server upgrade")
+		http.Error(w, "Failed to upgrade server: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ChangeRuntime handles the request to move a server to another Java runtime.
+func (h *ServerHandler) ChangeRuntime(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var payload ChangeRuntimePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.RuntimeID == "" {
+		http.Error(w, "Missing required field: runtimeId", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.GetServerByID(r.Context(), id); err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+
+	// The new image may have to be pulled and the server restarted, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeChangeRuntime, &id, services.ChangeRuntimeJobPayload{RuntimeID: payload.RuntimeID})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to queue runtime change")
+		http.Error(w, "Failed to change runtime: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// CloneServerPayload is the expected JSON body for cloning a server.
+type CloneServerPayload struct {
+	Name          string `json:"name"`                  // Defaults to the original's name with " (copy)" appended
+	IncludeWorlds bool   `json:"includeWorlds"`         // Without worlds the clone generates fresh ones
+	StorageRoot   string `json:"storageRoot,omitempty"` // Defaults to the original's storage root
+}
+
+// Clone handles the request to create a copy of a server with its own ports and container.
+func (h *ServerHandler) Clone(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var payload CloneServerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.GetServerByID(r.Context(), id); err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if payload.StorageRoot != "" && !h.isStorageRoot(r, payload.StorageRoot) {
+		http.Error(w, "Unknown storage root", http.StatusBadRequest)
+		return
+	}
+
+	// Copying the server's files takes a while, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeCloneServer, &id, services.CloneServerJobPayload{
+		Name:          payload.Name,
+		IncludeWorlds: payload.IncludeWorlds,
+		StorageRoot:   payload.StorageRoot,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to queue server clone")
+		http.Error(w, "Failed to clone server: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// MoveServerPayload is the expected JSON body for moving a server to another storage root.
+type MoveServerPayload struct {
+	StorageRoot string `json:"storageRoot"`
+}
+
+// Move handles the request to relocate a server's data directory to another storage root.
+func (h *ServerHandler) Move(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var payload MoveServerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.GetServerByID(r.Context(), id); err != nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	if !h.isStorageRoot(r, payload.StorageRoot) {
+		http.Error(w, "Unknown storage root", http.StatusBadRequest)
+		return
+	}
+
+	// The server is stopped and its files moved, possibly to another disk, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeMoveServer, &id, services.MoveServerJobPayload{StorageRoot: payload.StorageRoot})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to queue server move")
+		http.Error(w, "Failed to move server: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// isStorageRoot reports whether path is one of the configured storage roots.
+func (h *ServerHandler) isStorageRoot(r *http.Request, path string) bool {
+	roots, err := h.service.GetStorageRoots(r.Context())
+	if err != nil {
+		return false
+	}
+	for _, root := range roots {
+		if root.Path == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStorageRoots handles the request to list the directories server data can be kept in.
+func (h *ServerHandler) GetStorageRoots(w http.ResponseWriter, r *http.Request) {
+	roots, err := h.service.GetStorageRoots(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list storage roots")
+		http.Error(w, "Failed to list storage roots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
 // Delete handles the request to delete a server.
 func (h *ServerHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	err := h.service.DeleteServer(id)
+	err := h.service.DeleteServer(r.Context(), id)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", id).Msg("Failed to delete server")
 		http.Error(w, "Failed to delete server", http.StatusInternalServerError)
@@ -133,24 +317,41 @@ func (h *ServerHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	// Retrieve other form fields
 	serverName := r.FormValue("name")
 	javaVersion := r.FormValue("javaVersion")
+	runtimeID := r.FormValue("runtimeId")               // Optional; takes the place of javaVersion
 	serverExecutable := r.FormValue("serverExecutable") // The new field for the JAR name
 	maxMemoryMB, _ := strconv.Atoi(r.FormValue("maxMemoryMB"))
 
-	if serverName == "" || javaVersion == "" || maxMemoryMB <= 0 || serverExecutable == "" {
-		http.Error(w, "Missing required fields: name, javaVersion, maxMemoryMB, serverExecutable", http.StatusBadRequest)
+	if serverName == "" || (javaVersion == "" && runtimeID == "") || maxMemoryMB <= 0 || serverExecutable == "" {
+		http.Error(w, "Missing required fields: name, javaVersion or runtimeId, maxMemoryMB, serverExecutable", http.StatusBadRequest)
 		return
 	}
 
-	newServer, err := h.service.CreateServerFromUpload(serverName, javaVersion, serverExecutable, maxMemoryMB, file)
+	// Spool the upload to disk so the job can be resumed after a restart.
+	spool, err := spoolUpload(h.uploadPath, "upload-*.zip", file)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create server from upload")
+		log.Error().Err(err).Msg("Failed to spool upload")
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeUploadServer, nil, services.UploadServerJobPayload{
+		Name:             serverName,
+		JavaVersion:      javaVersion,
+		RuntimeID:        runtimeID,
+		ServerExecutable: serverExecutable,
+		MaxMemoryMB:      maxMemoryMB,
+		Archive:          filepath.Base(spool),
+	})
+	if err != nil {
+		os.Remove(spool)
+		log.Error().Err(err).Msg("Failed to queue server creation from upload")
 		http.Error(w, "Failed to create server: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newServer)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
 // ListZipContents handles the request to list executable files from an uploaded zip.
@@ -207,7 +408,7 @@ func (h *ServerHandler) PerformAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.service.PerformServerAction(id, payload.Action)
+	err := h.service.PerformServerAction(r.Context(), id, payload.Action)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", id).Str("action", payload.Action).Msg("Failed to perform server action")
 		http.Error(w, "Failed to perform action: "+err.Error(), http.StatusInternalServerError)
@@ -237,7 +438,7 @@ func (h *ServerHandler) SendServerConsoleCommand(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if _, err := h.service.SendCommandToServer(id, payload.Command); err != nil {
+	if _, err := h.service.SendCommandToServer(r.Context(), id, payload.Command); err != nil {
 		log.Error().Err(err).Str("server_id", id).Str("command", payload.Command).Msg("Failed to send command to server")
 		http.Error(w, "Failed to send command: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -249,7 +450,7 @@ func (h *ServerHandler) SendServerConsoleCommand(w http.ResponseWriter, r *http.
 
 // GetDashboardStats provides aggregated data for the main dashboard.
 func (h *ServerHandler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := h.service.GetDashboardStatistics()
+	stats, err := h.service.GetDashboardStatistics(r.Context())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to retrieve dashboard stats")
 		http.Error(w, "Failed to retrieve dashboard stats: "+err.Error(), http.StatusInternalServerError)
@@ -269,10 +470,9 @@ func (h *ServerHandler) GetServerFileContent(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	content, err := h.service.GetFileContent(serverID, filePath)
+	content, err := h.service.GetFileContent(r.Context(), serverID, filePath)
 	if err != nil {
-		log.Error().Err(err).Str("server_id", serverID).Str("path", filePath).Msg("Failed to get file content")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeFileError(w, err, serverID, "Failed to get file content")
 		return
 	}
 
@@ -285,10 +485,9 @@ func (h *ServerHandler) ListServerFiles(w http.ResponseWriter, r *http.Request)
 	serverID := chi.URLParam(r, "id")
 	dirPath := r.URL.Query().Get("path") // Optional, defaults to root
 
-	files, err := h.service.ListFiles(serverID, dirPath)
+	files, err := h.service.ListFiles(r.Context(), serverID, dirPath)
 	if err != nil {
-		log.Error().Err(err).Str("server_id", serverID).Str("path", dirPath).Msg("Failed to list files for server")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeFileError(w, err, serverID, "Failed to list files for server")
 		return
 	}
 
@@ -296,13 +495,15 @@ func (h *ServerHandler) ListServerFiles(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(files)
 }
 
-// UpdateServerFile updates the content of a specific file.
+// UpdateServerFile updates the content of a specific file. Config files are checked first, against the
+// bundled schemas too unless skipSchema is set, and refused with 422 and the issues found.
 func (h *ServerHandler) UpdateServerFile(w http.ResponseWriter, r *http.Request) {
 	serverID := chi.URLParam(r, "id")
 
 	var payload struct {
-		Path    string `json:"path"`
-		Content string `json:"content"`
+		Path       string `json:"path"`
+		Content    string `json:"content"`
+		SkipSchema bool   `json:"skipSchema"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -310,9 +511,8 @@ func (h *ServerHandler) UpdateServerFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.service.UpdateFileContent(serverID, payload.Path, []byte(payload.Content)); err != nil {
-		log.Error().Err(err).Str("server_id", serverID).Str("path", payload.Path).Msg("Failed to update file content")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.service.UpdateFileContent(r.Context(), serverID, payload.Path, []byte(payload.Content), !payload.SkipSchema); err != nil {
+		writeFileError(w, err, serverID, "Failed to update file content")
 		return
 	}
 
@@ -320,10 +520,31 @@ func (h *ServerHandler) UpdateServerFile(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(map[string]string{"message": "File updated successfully."})
 }
 
+// ValidateServerFile checks the content of a config file the way UpdateServerFile does, without writing it.
+func (h *ServerHandler) ValidateServerFile(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Path       string `json:"path"`
+		Content    string `json:"content"`
+		SkipSchema bool   `json:"skipSchema"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	format, _ := configcheck.FormatOf(payload.Path)
+	issues := configcheck.Check(payload.Path, []byte(payload.Content), !payload.SkipSchema)
+	if issues == nil {
+		issues = []models.ConfigIssue{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"format": format, "valid": len(issues) == 0, "issues": issues})
+}
+
 // GetServerSettings gets the server's parsed server.properties
 func (h *ServerHandler) GetServerSettings(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	settings, err := h.service.GetServerSettings(id)
+	settings, err := h.service.GetServerSettings(r.Context(), id)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", id).Msg("Failed to get server settings")
 		http.Error(w, "Failed to get server settings: "+err.Error(), http.StatusInternalServerError)
@@ -342,7 +563,7 @@ func (h *ServerHandler) UpdateServerSettings(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := h.service.UpdateServerSettings(id, settings); err != nil {
+	if err := h.service.UpdateServerSettings(r.Context(), id, settings); err != nil {
 		log.Error().Err(err).Str("server_id", id).Msg("Failed to update server settings")
 		http.Error(w, "Failed to update server settings: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -352,27 +573,56 @@ func (h *ServerHandler) UpdateServerSettings(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(map[string]string{"message": "Settings updated successfully. Server is restarting."})
 }
 
-// GetServerResourceHistory handles the request to get resource usage history for a server.
-func (h *ServerHandler) GetServerResourceHistory(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+// GetJVMProfiles lists the JVM profiles servers can be started with.
+func (h *ServerHandler) GetJVMProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jvm.Profiles())
+}
 
-	// You might add query parameters for time range, e.g., ?last=1h
-	// For now, we'll assume a default recent history is fetched by the service.
-	history, err := h.service.GetResourceHistory(id)
+// GetJVMSettings gets the server's JVM settings and the arguments java is started with.
+func (h *ServerHandler) GetJVMSettings(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	config, err := h.service.GetJVMConfig(r.Context(), id)
 	if err != nil {
-		log.Error().Err(err).Str("server_id", id).Msg("Failed to retrieve resource history")
-		http.Error(w, "Failed to retrieve resource history: "+err.Error(), http.StatusInternalServerError)
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to get JVM settings")
+		http.Error(w, "Failed to get JVM settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// UpdateJVMSettings changes the server's JVM settings, regenerating start.sh, and restarts it if it is running.
+func (h *ServerHandler) UpdateJVMSettings(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var settings models.JVMSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	config, err := h.service.UpdateJVMSettings(r.Context(), id, settings)
+	switch {
+	case errors.Is(err, jvm.ErrInvalidSettings):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, jvm.ErrUnmanagedLaunch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("server_id", id).Msg("Failed to update JVM settings")
+		http.Error(w, "Failed to update JVM settings: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(history)
+	json.NewEncoder(w).Encode(config)
 }
 
 // GetOnlinePlayers gets the list of online players for a server
 func (h *ServerHandler) GetOnlinePlayers(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	players, err := h.service.GetOnlinePlayers(id)
+	players, err := h.service.GetOnlinePlayers(r.Context(), id)
 	if err != nil {
 		// Distinguish between a server that's offline and a genuine error
 		if err.Error() == "server is not online" {
@@ -401,7 +651,7 @@ func (h *ServerHandler) ManagePlayer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.service.ManagePlayer(serverID, payload.Action, payload.Player, payload.Reason)
+	err := h.service.ManagePlayer(r.Context(), serverID, payload.Action, payload.Player, payload.Reason)
 	if err != nil {
 		log.Error().Err(err).Str("server_id", serverID).Str("action", payload.Action).Str("player", payload.Player).Msg("Failed to manage player")
 		http.Error(w, "Failed to "+payload.Action+" player: "+err.Error(), http.StatusInternalServerError)
@@ -431,7 +681,7 @@ func (h *ServerHandler) BindPort(w http.ResponseWriter, r *http.Request) {
 
 // GetSystemResourceStats provides information about the host system's RAM.
 func (h *ServerHandler) GetSystemResourceStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := h.service.GetSystemResourceStats()
+	stats, err := h.service.GetSystemResourceStats(r.Context())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to retrieve system resource stats")
 		http.Error(w, "Failed to retrieve system stats: "+err.Error(), http.StatusInternalServerError)
@@ -2,11 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/bundle"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
 	"github.com/isdelr/ender-deploy-be/internal/models"
 	"github.com/isdelr/ender-deploy-be/internal/services"
 	"github.com/rs/zerolog/log"
@@ -14,13 +20,15 @@ import (
 
 // TemplateHandler handles HTTP requests related to templates.
 type TemplateHandler struct {
-	service services.TemplateServiceProvider
+	service    services.TemplateServiceProvider
+	jobs       services.JobServiceProvider
+	uploadPath string // Where modpacks are spooled until their import job is done
 }
 
 // NewTemplateHandler creates a new TemplateHandler.
 // FIX: Corrected TplServiceProvider to TemplateServiceProvider
-func NewTemplateHandler(service services.TemplateServiceProvider) *TemplateHandler {
-	return &TemplateHandler{service: service}
+func NewTemplateHandler(service services.TemplateServiceProvider, jobs services.JobServiceProvider, uploadPath string) *TemplateHandler {
+	return &TemplateHandler{service: service, jobs: jobs, uploadPath: uploadPath}
 }
 
 // GetAll handles the request to get all templates.
@@ -105,7 +113,128 @@ func (h *TemplateHandler) Create(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(newTemplate)
 }
 
-// Update handles the request to update an existing template.
+// Import handles the request to create a template from an uploaded Modrinth (.mrpack) or CurseForge modpack.
+// Name, description and Java version default to the pack's own when left empty.
+func (h *TemplateHandler) Import(w http.ResponseWriter, r *http.Request) {
+	// Modpacks only list their mods, so they are much smaller than server zips.
+	const maxUploadSize = 100 * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		http.Error(w, "The uploaded file is too big or the form is invalid.", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	maxMemoryMB, _ := strconv.Atoi(r.FormValue("maxMemoryMB"))
+	if maxMemoryMB <= 0 {
+		http.Error(w, "Missing required field: maxMemoryMB", http.StatusBadRequest)
+		return
+	}
+
+	spool, err := spoolUpload(h.uploadPath, "modpack-*.zip", file)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to spool modpack upload")
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Downloading the pack's mods can take minutes, so the import runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeImportTemplate, nil, services.ImportTemplateJobPayload{
+		TemplateID:  uuid.New().String(),
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
+		JavaVersion: r.FormValue("javaVersion"),
+		MaxMemoryMB: maxMemoryMB,
+		Archive:     filepath.Base(spool),
+	})
+	if err != nil {
+		os.Remove(spool)
+		log.Error().Err(err).Msg("Failed to queue modpack import")
+		http.Error(w, "Failed to import modpack: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// InstallTemplatePayload is the expected JSON body for installing a template.
+type InstallTemplatePayload struct {
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	ServerType       string `json:"serverType"`
+	MinecraftVersion string `json:"minecraftVersion"` // Newest release when empty
+	JavaVersion      string `json:"javaVersion"`      // Derived from the Minecraft version when empty
+	MaxMemoryMB      int    `json:"maxMemoryMB"`
+}
+
+// Install handles the request to create a template by installing server software, rather than from a zip.
+func (h *TemplateHandler) Install(w http.ResponseWriter, r *http.Request) {
+	var payload InstallTemplatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.Name == "" || payload.ServerType == "" || payload.MaxMemoryMB <= 0 {
+		http.Error(w, "Missing required fields: name, serverType, maxMemoryMB", http.StatusBadRequest)
+		return
+	}
+
+	// Downloading the server and running loader installers takes a while, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeInstallTemplate, nil, services.InstallTemplateJobPayload{
+		TemplateID:       uuid.New().String(),
+		Name:             payload.Name,
+		Description:      payload.Description,
+		ServerType:       payload.ServerType,
+		MinecraftVersion: payload.MinecraftVersion,
+		JavaVersion:      payload.JavaVersion,
+		MaxMemoryMB:      payload.MaxMemoryMB,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_type", payload.ServerType).Msg("Failed to queue template installation")
+		http.Error(w, "Failed to install template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetServerSoftware handles the request to list the server software templates can be installed with.
+func (h *TemplateHandler) GetServerSoftware(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.GetServerSoftware())
+}
+
+// GetServerSoftwareVersions handles the request to list the Minecraft versions a server software supports.
+func (h *TemplateHandler) GetServerSoftwareVersions(w http.ResponseWriter, r *http.Request) {
+	software := chi.URLParam(r, "software")
+	versions, err := h.service.GetServerSoftwareVersions(r.Context(), software)
+	if errors.Is(err, installer.ErrUnknownSoftware) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("server_type", software).Msg("Failed to list server software versions")
+		http.Error(w, "Failed to list versions: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// Update handles the request to update an existing template, which publishes the new metadata as the
+// template's next version. The body's changelog describes what changed.
 func (h *TemplateHandler) Update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var template models.Template
@@ -137,3 +266,179 @@ func (h *TemplateHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// GetVersions handles the request to list the versions of a template.
+func (h *TemplateHandler) GetVersions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	versions, err := h.service.GetTemplateVersions(id)
+	if err != nil {
+		log.Warn().Err(err).Str("template_id", id).Msg("Failed to get template versions")
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetVersion handles the request to get a template as it was at one of its versions.
+func (h *TemplateHandler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || version <= 0 {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	template, err := h.service.GetTemplateVersion(id, version)
+	if err != nil {
+		log.Warn().Err(err).Str("template_id", id).Int("version", version).Msg("Failed to get template version")
+		http.Error(w, "Template version not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(template)
+}
+
+// CloneTemplatePayload is the expected JSON body for cloning a template.
+type CloneTemplatePayload struct {
+	Name    string `json:"name"`              // Derived from the original's name when empty
+	Version int    `json:"version,omitempty"` // Defaults to the latest version
+}
+
+// Clone handles the request to create a new template from a version of an existing one.
+func (h *TemplateHandler) Clone(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var payload CloneTemplatePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.service.GetTemplateVersion(id, payload.Version); err != nil {
+		http.Error(w, "Template version not found", http.StatusNotFound)
+		return
+	}
+
+	clone, err := h.service.CloneTemplate(id, payload.Version, payload.Name)
+	if err != nil {
+		log.Error().Err(err).Str("template_id", id).Msg("Failed to clone template")
+		http.Error(w, "Failed to clone template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(clone)
+}
+
+// CaptureServerPayload is the expected JSON body for saving a server as a template.
+type CaptureServerPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CaptureServer handles the request to save a server's files and settings as a new template.
+func (h *TemplateHandler) CaptureServer(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var payload CaptureServerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.Name == "" {
+		http.Error(w, "Template name is required", http.StatusBadRequest)
+		return
+	}
+
+	// Saving the world and packing the server's files takes a while, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeCaptureTemplate, &serverID, services.CaptureTemplateJobPayload{
+		TemplateID:  uuid.New().String(),
+		Name:        payload.Name,
+		Description: payload.Description,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to queue template capture")
+		http.Error(w, "Failed to save server as template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Export handles the request to download a version of a template as a signed bundle. The version
+// defaults to the latest.
+func (h *TemplateHandler) Export(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 0 {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+	template, err := h.service.GetTemplateVersion(id, version)
+	if err != nil {
+		http.Error(w, "Template version not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("template-%s-v%d.zip", template.ID, template.Version)))
+	if err := h.service.ExportTemplate(id, template.Version, w); err != nil {
+		// The response has already started, so the client only sees a truncated bundle.
+		log.Error().Err(err).Str("template_id", id).Int("version", template.Version).Msg("Failed to export template")
+	}
+}
+
+// ImportBundle handles the request to create a template from a bundle exported by this or a trusted host.
+func (h *TemplateHandler) ImportBundle(w http.ResponseWriter, r *http.Request) {
+	const maxUploadSize = 500 * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "The uploaded file is too big or the form is invalid.", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	spool, err := spoolUpload(h.uploadPath, "bundle-*.zip", file)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to spool template bundle upload")
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(spool)
+
+	template, err := h.service.ImportTemplateBundle(spool)
+	switch {
+	case errors.Is(err, bundle.ErrUntrustedSigner):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, bundle.ErrInvalidBundle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("Failed to import template bundle")
+		http.Error(w, "Failed to import template bundle: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(template)
+}
+
+// GetSigningKey handles the request for the public key this host signs bundles with, for other hosts
+// to add to their trusted keys.
+func (h *TemplateHandler) GetSigningKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"publicKey": h.service.SigningKey()})
+}
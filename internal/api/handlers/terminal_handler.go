@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// TerminalHandler handles HTTP requests for the audit trail of interactive shells. The shells themselves
+// are opened over the server's websocket.
+type TerminalHandler struct {
+	service services.TerminalServiceProvider
+}
+
+// NewTerminalHandler creates a new TerminalHandler.
+func NewTerminalHandler(service services.TerminalServiceProvider) *TerminalHandler {
+	return &TerminalHandler{service: service}
+}
+
+// GetSessions handles the request to list the shells opened on a server.
+func (h *TerminalHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	sessions, err := h.service.ListSessions(r.Context(), serverID)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to retrieve terminal sessions")
+		http.Error(w, "Failed to retrieve terminal sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// GetRecording handles the request to download the asciicast recording of a shell.
+func (h *TerminalHandler) GetRecording(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	sessionID := chi.URLParam(r, "sessionId")
+	recording, err := h.service.OpenRecording(r.Context(), serverID, sessionID)
+	if errors.Is(err, services.ErrTerminalSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("session_id", sessionID).Msg("Failed to open terminal recording")
+		http.Error(w, "Failed to open terminal recording: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer recording.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sessionID+".cast"))
+	io.Copy(w, recording)
+}
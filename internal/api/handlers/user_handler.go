@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -202,3 +203,106 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated successfully"})
 }
+
+// SetSFTPAccess sets whether the current user's SFTP sessions are limited to reading files. It applies to
+// password logins and to every key, including those not marked read-only themselves.
+func (h *UserHandler) SetSFTPAccess(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Could not retrieve user from token", http.StatusInternalServerError)
+		return
+	}
+	var payload struct {
+		ReadOnly bool `json:"readOnly"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.service.SetSFTPReadOnly(claims.UserID, payload.ReadOnly)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", claims.UserID).Msg("Failed to set SFTP access")
+		http.Error(w, "Failed to set SFTP access", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetSSHKeys lists the SSH keys the current user can log in to the SFTP server with.
+func (h *UserHandler) GetSSHKeys(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Could not retrieve user from token", http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := h.service.ListSSHKeys(claims.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", claims.UserID).Msg("Failed to list SSH keys")
+		http.Error(w, "Failed to list SSH keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// AddSSHKey registers a public key for the current user. Keys marked read-only open SFTP sessions that can't
+// change files.
+func (h *UserHandler) AddSSHKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Could not retrieve user from token", http.StatusInternalServerError)
+		return
+	}
+	var payload struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"publicKey"`
+		ReadOnly  bool   `json:"readOnly"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.service.AddSSHKey(claims.UserID, payload.Name, payload.PublicKey, payload.ReadOnly)
+	switch {
+	case errors.Is(err, services.ErrInvalidSSHKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrSSHKeyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("user_id", claims.UserID).Msg("Failed to add SSH key")
+		http.Error(w, "Failed to add SSH key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// DeleteSSHKey removes one of the current user's SSH keys.
+func (h *UserHandler) DeleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Could not retrieve user from token", http.StatusInternalServerError)
+		return
+	}
+
+	keyID := chi.URLParam(r, "keyId")
+	err := h.service.DeleteSSHKey(claims.UserID, keyID)
+	switch {
+	case errors.Is(err, services.ErrSSHKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("user_id", claims.UserID).Str("key_id", keyID).Msg("Failed to delete SSH key")
+		http.Error(w, "Failed to delete SSH key", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
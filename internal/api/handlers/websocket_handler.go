@@ -3,31 +3,46 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	ws "github.com/isdelr/ender-deploy-be/internal/websocket"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // WebSocketHandler handles upgrading HTTP connections to WebSocket connections.
 type WebSocketHandler struct {
 	hub              *ws.Hub
 	serverService    services.ServerServiceProvider
-	logStreamCancels map[*ws.Client]context.CancelFunc
+	terminalService  services.TerminalServiceProvider
+	logStreamCancels map[*ws.Client]map[string]context.CancelFunc // By server ID
+	terminals        map[*ws.Client]*clientTerminal
 	mu               sync.Mutex
 }
 
+// clientTerminal is the interactive shell a websocket client has open.
+type clientTerminal struct {
+	session *services.TerminalSession
+	ready   chan struct{} // Closed once the client has been told the session is open
+}
+
 // NewWebSocketHandler creates a new WebSocketHandler.
-func NewWebSocketHandler(hub *ws.Hub, serverService services.ServerServiceProvider) *WebSocketHandler {
+func NewWebSocketHandler(hub *ws.Hub, serverService services.ServerServiceProvider, terminalService services.TerminalServiceProvider) *WebSocketHandler {
 	return &WebSocketHandler{
 		hub:              hub,
 		serverService:    serverService,
-		logStreamCancels: make(map[*ws.Client]context.CancelFunc),
+		terminalService:  terminalService,
+		logStreamCancels: make(map[*ws.Client]map[string]context.CancelFunc),
+		terminals:        make(map[*ws.Client]*clientTerminal),
 	}
 }
 
@@ -40,21 +55,35 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// Serve handles the WebSocket connection request.
+// Serve handles the WebSocket connection request of a first version client, which is bound to one server
+// or to global updates for its lifetime.
 func (h *WebSocketHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	// Support both /ws/servers/{id} and /ws/global routes.
+	serverID := chi.URLParam(r, "id")
+	if serverID == "" {
+		serverID = "global"
+	}
+	h.serve(w, r, serverID, 1)
+}
+
+// ServeV2 handles the WebSocket connection request of a second version client, which subscribes to topics
+// with 'subscribe' and 'unsubscribe' requests. See ws.Envelope for the message format.
+func (h *WebSocketHandler) ServeV2(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, "", 2)
+}
+
+func (h *WebSocketHandler) serve(w http.ResponseWriter, r *http.Request, serverID string, protocol int) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to upgrade websocket connection")
 		return
 	}
 
-	// Support both /ws/servers/{id} and /ws/global routes.
-	serverID := chi.URLParam(r, "id")
-	if serverID == "" {
-		serverID = "global"
-	}
+	// The request is over once the connection is upgraded, but who made it still matters.
+	ctx := context.WithoutCancel(r.Context())
 
 	client := ws.NewClient(h.hub, conn, serverID)
+	client.Protocol = protocol
 	h.hub.Register <- client
 
 	var wg sync.WaitGroup
@@ -66,7 +95,9 @@ func (h *WebSocketHandler) Serve(w http.ResponseWriter, r *http.Request) {
 	}()
 	go func() {
 		defer wg.Done()
-		client.ReadPump(h.handleIncomingWSMessage)
+		client.ReadPump(func(client *ws.Client, message []byte) {
+			h.handleIncomingWSMessage(ctx, client, message)
+		})
 	}()
 
 	// Cleanup on disconnect.
@@ -74,67 +105,304 @@ func (h *WebSocketHandler) Serve(w http.ResponseWriter, r *http.Request) {
 		wg.Wait()
 
 		h.mu.Lock()
-		if cancel, ok := h.logStreamCancels[client]; ok {
-			log.Info().Str("client_id", client.ServerID).Msg("Client disconnected, cancelling associated log stream.")
-			cancel()
+		if cancels, ok := h.logStreamCancels[client]; ok {
+			log.Info().Str("client_id", client.ServerID).Msg("Client disconnected, cancelling associated log streams.")
+			for _, cancel := range cancels {
+				cancel()
+			}
 			delete(h.logStreamCancels, client)
 		}
+		var session *services.TerminalSession
+		if term := h.terminals[client]; term != nil {
+			session = term.session
+		}
+		delete(h.terminals, client)
 		h.mu.Unlock()
 
+		if session != nil {
+			session.Close()
+		}
+
 		h.hub.Unregister <- client
 	}()
 }
 
 // handleIncomingWSMessage processes messages received from a websocket client.
-func (h *WebSocketHandler) handleIncomingWSMessage(client *ws.Client, message []byte) {
+func (h *WebSocketHandler) handleIncomingWSMessage(ctx context.Context, client *ws.Client, message []byte) {
 	var msg ws.Message
 	if err := json.Unmarshal(message, &msg); err != nil {
 		log.Error().Err(err).Bytes("message", message).Msg("Error decoding websocket message")
 		return
 	}
 
-	switch msg.Action {
-	case "subscribe_docker_logs":
-		log.Info().Str("client_id", client.ServerID).Msg("Client subscribed to Docker logs")
-		ctx, cancel := context.WithCancel(context.Background())
+	switch {
+	case msg.Action == "subscribe" && client.Protocol >= 2:
+		h.subscribe(ctx, client, msg)
 
-		h.mu.Lock()
-		h.logStreamCancels[client] = cancel
-		h.mu.Unlock()
+	case msg.Action == "unsubscribe" && client.Protocol >= 2:
+		h.unsubscribe(client, msg)
 
-		go h.serverService.StreamServerLogs(ctx, client.ServerID, client.Send)
+	case msg.Action == "subscribe_docker_logs":
+		serverID, ok := h.targetServer(client, msg)
+		if !ok {
+			return
+		}
+		h.startLogStream(ctx, client, serverID)
+		h.reply(client, msg, nil)
 
-	case "unsubscribe_docker_logs":
-		log.Info().Str("client_id", client.ServerID).Msg("Client unsubscribed from Docker logs")
-		h.mu.Lock()
-		if cancel, ok := h.logStreamCancels[client]; ok {
-			cancel()
-			delete(h.logStreamCancels, client)
+	case msg.Action == "unsubscribe_docker_logs":
+		serverID, ok := h.targetServer(client, msg)
+		if !ok {
+			return
 		}
-		h.mu.Unlock()
+		h.stopLogStream(client, serverID)
+		h.reply(client, msg, nil)
 
-	case "send_rcon_command":
+	case msg.Action == "send_rcon_command":
 		h.executeCommand(client, msg, "rcon")
 
-	case "send_terminal_command":
+	case msg.Action == "send_terminal_command":
 		h.executeCommand(client, msg, "terminal")
 
+	case msg.Action == "subscribe_terminal":
+		h.openTerminal(ctx, client, msg)
+
+	case msg.Action == "unsubscribe_terminal":
+		h.mu.Lock()
+		var session *services.TerminalSession
+		if term := h.terminals[client]; term != nil {
+			session = term.session
+		}
+		h.mu.Unlock()
+		if session != nil {
+			go session.Close()
+		}
+		h.reply(client, msg, nil)
+
+	case msg.Action == "terminal_input":
+		h.writeTerminal(client, msg)
+
+	case msg.Action == "terminal_resize":
+		h.resizeTerminal(client, msg)
+
 	default:
 		log.Warn().Str("action", msg.Action).Msg("Unknown websocket action received")
-		client.Send <- ws.NewErrorMessage("Unknown action: " + msg.Action)
+		h.fail(client, msg, "Unknown action: "+msg.Action)
+	}
+}
+
+// reply acknowledges a request of a second version client that asked for a response. First version clients
+// get no acknowledgements.
+func (h *WebSocketHandler) reply(client *ws.Client, msg ws.Message, payload interface{}) {
+	if client.Protocol >= 2 && msg.ID != "" {
+		h.hub.Send(client, ws.NewResponse(msg.ID, msg.Action, payload, ""), ws.Wait)
+	}
+}
+
+// fail tells a client that a request failed: in a response if it asked for one, and as system console
+// output otherwise.
+func (h *WebSocketHandler) fail(client *ws.Client, msg ws.Message, errMsg string) {
+	if client.Protocol >= 2 && msg.ID != "" {
+		h.hub.Send(client, ws.NewResponse(msg.ID, msg.Action, nil, errMsg), ws.Wait)
+		return
+	}
+	h.hub.Send(client, ws.NewErrorMessage(errMsg), ws.Wait)
+}
+
+// targetServer returns the server a request is about: the one a first version client is bound to, or the
+// "serverId" in the payload of a second version request.
+func (h *WebSocketHandler) targetServer(client *ws.Client, msg ws.Message) (string, bool) {
+	if client.Protocol < 2 {
+		if client.ServerID == "global" {
+			h.fail(client, msg, "This action needs a server's connection")
+			return "", false
+		}
+		return client.ServerID, true
+	}
+	payload, _ := msg.Payload.(map[string]interface{})
+	serverID, _ := payload["serverId"].(string)
+	if serverID == "" {
+		h.fail(client, msg, "A serverId is required")
+		return "", false
+	}
+	return serverID, true
+}
+
+// subscribe adds topics to a second version client's subscriptions. Payload: {"topics": ["server:*", "events"],
+// "since": 41}. With since, buffered events after that sequence number are replayed before the response; the
+// response's gap is set if some of them are gone.
+func (h *WebSocketHandler) subscribe(ctx context.Context, client *ws.Client, msg ws.Message) {
+	payload, _ := msg.Payload.(map[string]interface{})
+	topics, err := topicsFrom(payload)
+	if err != nil {
+		h.fail(client, msg, err.Error())
+		return
+	}
+	var since *uint64
+	if v, ok := payload["since"].(float64); ok && v >= 0 {
+		seq := uint64(v)
+		since = &seq
+	}
+
+	// Log streams are followed per client rather than published through the hub.
+	var hubTopics []string
+	for _, topic := range topics {
+		if serverID, ok := logsTopicServer(topic); ok {
+			h.startLogStream(ctx, client, serverID)
+		} else {
+			hubTopics = append(hubTopics, topic)
+		}
+	}
+
+	result := h.hub.Subscribe(client, hubTopics, since)
+	result.Topics = h.withLogTopics(client, result.Topics)
+	h.reply(client, msg, result)
+}
+
+// unsubscribe removes topics from a second version client's subscriptions. Payload: {"topics": ["events"]}.
+func (h *WebSocketHandler) unsubscribe(client *ws.Client, msg ws.Message) {
+	payload, _ := msg.Payload.(map[string]interface{})
+	topics, err := topicsFrom(payload)
+	if err != nil {
+		h.fail(client, msg, err.Error())
+		return
+	}
+
+	var hubTopics []string
+	for _, topic := range topics {
+		if serverID, ok := logsTopicServer(topic); ok {
+			h.stopLogStream(client, serverID)
+		} else {
+			hubTopics = append(hubTopics, topic)
+		}
 	}
+
+	result := h.hub.Unsubscribe(client, hubTopics)
+	result.Topics = h.withLogTopics(client, result.Topics)
+	h.reply(client, msg, result)
+}
+
+// topicsFrom reads and validates the topics of a subscription request.
+func topicsFrom(payload map[string]interface{}) ([]string, error) {
+	list, _ := payload["topics"].([]interface{})
+	if len(list) == 0 {
+		return nil, fmt.Errorf("at least one topic is required")
+	}
+	topics := make([]string, 0, len(list))
+	for _, item := range list {
+		topic, _ := item.(string)
+		if !validTopic(topic) {
+			return nil, fmt.Errorf("unknown topic: %q", topic)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// validTopic reports whether a topic is one the server publishes to: "events", "job:<id>", "server:<id>",
+// "server:<id>:stats" or "server:<id>:logs". Any ID may be "*", except that of a log stream.
+func validTopic(topic string) bool {
+	parts := strings.Split(topic, ":")
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	switch {
+	case len(parts) == 1:
+		return topic == ws.EventsTopic
+	case len(parts) == 2:
+		return parts[0] == "job" || parts[0] == "server"
+	case len(parts) == 3 && parts[0] == "server":
+		return parts[2] == "stats" || (parts[2] == "logs" && parts[1] != "*")
+	}
+	return false
+}
+
+// logsTopicServer returns the server of a log stream topic.
+func logsTopicServer(topic string) (string, bool) {
+	serverID, ok := strings.CutPrefix(topic, "server:")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(serverID, ":logs")
+}
+
+// withLogTopics adds the log streams a client follows to its hub subscriptions.
+func (h *WebSocketHandler) withLogTopics(client *ws.Client, topics []string) []string {
+	h.mu.Lock()
+	for serverID := range h.logStreamCancels[client] {
+		topics = append(topics, ws.ServerLogsTopic(serverID))
+	}
+	h.mu.Unlock()
+	slices.Sort(topics)
+	return topics
+}
+
+// startLogStream follows a server's console output for a client, unless it already does. Second version
+// clients get each line as a live event on the server's log topic.
+func (h *WebSocketHandler) startLogStream(ctx context.Context, client *ws.Client, serverID string) {
+	h.mu.Lock()
+	if _, ok := h.logStreamCancels[client][serverID]; ok {
+		h.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if h.logStreamCancels[client] == nil {
+		h.logStreamCancels[client] = make(map[string]context.CancelFunc)
+	}
+	h.logStreamCancels[client][serverID] = cancel
+	h.mu.Unlock()
+	log.Info().Str("client_id", client.ServerID).Str("server_id", serverID).Msg("Client subscribed to Docker logs")
+
+	// Lines a slow client has no room for are dropped rather than holding up the log reader.
+	topic := ws.ServerLogsTopic(serverID)
+	go h.serverService.StreamServerLogs(ctx, serverID, func(message []byte) {
+		if client.Protocol >= 2 {
+			message = onTopic(topic, message)
+		}
+		h.hub.Send(client, message, ws.Drop)
+	})
+}
+
+// stopLogStream stops following a server's console output for a client.
+func (h *WebSocketHandler) stopLogStream(client *ws.Client, serverID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cancel, ok := h.logStreamCancels[client][serverID]; ok {
+		log.Info().Str("client_id", client.ServerID).Str("server_id", serverID).Msg("Client unsubscribed from Docker logs")
+		cancel()
+		delete(h.logStreamCancels[client], serverID)
+	}
+}
+
+// onTopic turns a first version message into a live event on topic.
+func onTopic(topic string, message []byte) []byte {
+	var msg struct {
+		Action  string          `json:"action"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return message
+	}
+	event, _ := json.Marshal(ws.Envelope{Type: "event", Topic: topic, Action: msg.Action, Payload: msg.Payload})
+	return event
 }
 
 // executeCommand is a helper to reduce code duplication for rcon and terminal commands.
 func (h *WebSocketHandler) executeCommand(client *ws.Client, msg ws.Message, source string) {
+	serverID, ok := h.targetServer(client, msg)
+	if !ok {
+		return
+	}
 	payload, ok := msg.Payload.(map[string]interface{})
 	if !ok {
-		client.Send <- ws.NewErrorMessage("Invalid payload for command")
+		h.fail(client, msg, "Invalid payload for command")
 		return
 	}
 	command, ok := payload["command"].(string)
 	if !ok || command == "" {
-		client.Send <- ws.NewErrorMessage("Invalid or empty command in payload")
+		h.fail(client, msg, "Invalid or empty command in payload")
 		return
 	}
 
@@ -144,19 +412,150 @@ func (h *WebSocketHandler) executeCommand(client *ws.Client, msg ws.Message, sou
 	// Create a context with a timeout for the command execution.
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
+	ctx, span := tracing.Start(ctx, "websocket."+source+"_command", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
 
 	if source == "rcon" {
-		response, err = h.serverService.SendCommandToServer(client.ServerID, command)
+		response, err = h.serverService.SendCommandToServer(ctx, serverID, command)
 	} else if source == "terminal" {
-		response, err = h.serverService.ExecuteTerminalCommand(ctx, client.ServerID, command)
+		response, err = h.serverService.ExecuteTerminalCommand(ctx, serverID, command)
 	}
 
 	if err != nil {
-		log.Error().Err(err).Str("server_id", client.ServerID).Str("command", command).Msg("Failed to execute command")
-		client.Send <- ws.NewErrorMessage(err.Error())
+		log.Error().Ctx(ctx).Err(err).Str("server_id", serverID).Str("command", command).Msg("Failed to execute command")
+		h.fail(client, msg, err.Error())
 		return
 	}
 
+	if client.Protocol >= 2 && msg.ID != "" {
+		h.reply(client, msg, ws.ConsoleOutputPayload{Source: source, Command: command, Line: response})
+		return
+	}
 	responseMsg := ws.NewConsoleOutputMessage(source, command, response)
-	client.Send <- responseMsg
+	h.hub.Send(client, responseMsg, ws.Wait)
+}
+
+// openTerminal opens an interactive shell in the client's server. Its output is streamed to the client as
+// 'terminal_output' messages, following a 'terminal_opened' message, until a 'terminal_closed' message.
+// Payload: {"cols": 80, "rows": 24}, both optional. Second version requests with an ID get the session ID in
+// their response instead of the 'terminal_opened' message.
+func (h *WebSocketHandler) openTerminal(ctx context.Context, client *ws.Client, msg ws.Message) {
+	serverID, ok := h.targetServer(client, msg)
+	if !ok {
+		return
+	}
+	payload, _ := msg.Payload.(map[string]interface{})
+	size := terminalSize(payload)
+
+	h.mu.Lock()
+	if h.terminals[client] != nil {
+		h.mu.Unlock()
+		h.fail(client, msg, "A terminal is already open on this connection")
+		return
+	}
+	term := &clientTerminal{ready: make(chan struct{})}
+	h.terminals[client] = term
+	h.mu.Unlock()
+
+	var err error
+	ctx, span := tracing.Start(ctx, "websocket.open_terminal", attribute.String("server.id", serverID))
+	defer func() { tracing.End(span, err) }()
+
+	var session *services.TerminalSession
+	session, err = h.terminalService.OpenSession(ctx, serverID, size, func(data []byte) {
+		select {
+		case <-term.ready:
+		case <-client.Done():
+			return
+		}
+		// Waiting for room holds the shell back instead of losing its output.
+		h.hub.Send(client, ws.NewTerminalOutputMessage(session.ID(), data), ws.Wait)
+	})
+	if err != nil {
+		h.mu.Lock()
+		delete(h.terminals, client)
+		h.mu.Unlock()
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("Failed to open terminal")
+		h.fail(client, msg, "Failed to open terminal: "+err.Error())
+		return
+	}
+
+	h.mu.Lock()
+	term.session = session
+	h.mu.Unlock()
+	if client.Protocol >= 2 && msg.ID != "" {
+		h.reply(client, msg, map[string]string{"sessionId": session.ID()})
+	} else {
+		h.hub.Send(client, ws.NewTerminalOpenedMessage(session.ID()), ws.Wait)
+	}
+	close(term.ready)
+
+	go func() {
+		<-session.Done()
+		h.mu.Lock()
+		if h.terminals[client] == term {
+			delete(h.terminals, client)
+		}
+		h.mu.Unlock()
+		h.hub.Send(client, ws.NewTerminalClosedMessage(session.ID(), session.Reason(), session.ExitCode()), ws.Wait)
+	}()
+}
+
+// writeTerminal sends input to the client's shell. Payload: {"data": "ls -la\r"}.
+func (h *WebSocketHandler) writeTerminal(client *ws.Client, msg ws.Message) {
+	session := h.openSession(client, msg)
+	if session == nil {
+		return
+	}
+	payload, _ := msg.Payload.(map[string]interface{})
+	data, ok := payload["data"].(string)
+	if !ok {
+		h.fail(client, msg, "Invalid terminal input")
+		return
+	}
+	if _, err := session.Write([]byte(data)); err != nil {
+		log.Debug().Err(err).Str("session_id", session.ID()).Msg("Failed to write to terminal")
+		h.fail(client, msg, "Failed to write to terminal: "+err.Error())
+		return
+	}
+	h.reply(client, msg, nil)
+}
+
+// resizeTerminal changes the size of the client's shell. Payload: {"cols": 120, "rows": 40}.
+func (h *WebSocketHandler) resizeTerminal(client *ws.Client, msg ws.Message) {
+	session := h.openSession(client, msg)
+	if session == nil {
+		return
+	}
+	payload, _ := msg.Payload.(map[string]interface{})
+	if err := session.Resize(terminalSize(payload)); err != nil {
+		h.fail(client, msg, "Failed to resize terminal: "+err.Error())
+		return
+	}
+	h.reply(client, msg, nil)
+}
+
+// openSession returns the client's open shell, telling the client if there is none.
+func (h *WebSocketHandler) openSession(client *ws.Client, msg ws.Message) *services.TerminalSession {
+	h.mu.Lock()
+	var session *services.TerminalSession
+	if term := h.terminals[client]; term != nil {
+		session = term.session
+	}
+	h.mu.Unlock()
+	if session == nil {
+		h.fail(client, msg, "No terminal is open on this connection")
+		return nil
+	}
+	return session
+}
+
+// terminalSize reads cols and rows from a message payload. Missing or invalid values are 0.
+func terminalSize(payload map[string]interface{}) services.TerminalSize {
+	cols, _ := payload["cols"].(float64)
+	rows, _ := payload["rows"].(float64)
+	if cols < 0 || cols > 1000 || rows < 0 || rows > 1000 {
+		return services.TerminalSize{}
+	}
+	return services.TerminalSize{Cols: uint(cols), Rows: uint(rows)}
 }
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// WorldHandler handles HTTP requests related to the worlds of a server and the player data saved in them.
+type WorldHandler struct {
+	service    services.WorldServiceProvider
+	jobs       services.JobServiceProvider
+	uploadPath string
+}
+
+// NewWorldHandler creates a new WorldHandler.
+func NewWorldHandler(service services.WorldServiceProvider, jobs services.JobServiceProvider, uploadPath string) *WorldHandler {
+	return &WorldHandler{service: service, jobs: jobs, uploadPath: uploadPath}
+}
+
+// ResetWorldPayload is the expected JSON body for resetting a world.
+type ResetWorldPayload struct {
+	KeepSeed bool   `json:"keepSeed"`
+	Seed     string `json:"seed,omitempty"` // Random if empty and not keeping the seed
+}
+
+// GetAll handles the request to list the worlds of a server.
+func (h *WorldHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	worlds, err := h.service.ListWorlds(r.Context(), serverID)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to list worlds")
+		http.Error(w, "Failed to list worlds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(worlds)
+}
+
+// Get handles the request to describe a world, including its seed, spawn and game rules.
+func (h *WorldHandler) Get(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	world, err := h.service.GetWorld(r.Context(), serverID, chi.URLParam(r, "world"))
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to get world")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world)
+}
+
+// Activate handles the request to make a world the one the server loads.
+func (h *WorldHandler) Activate(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	world, err := h.service.SetActiveWorld(r.Context(), serverID, chi.URLParam(r, "world"))
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to activate world")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world)
+}
+
+// Upload handles the upload of a zipped world. The optional "name" form field names the world; without it
+// the name of the zip is used.
+func (h *WorldHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	const maxUploadSize = 2 * 1024 * 1024 * 1024
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "The uploaded file is too big. Please choose a file that's less than 2GB in size.", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	}
+	if err := services.ValidateWorldName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Spool the upload to disk so the job can be resumed after a restart.
+	spool, err := spoolUpload(h.uploadPath, "world-*.zip", file)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to spool world upload")
+		http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeImportWorld, &serverID, services.ImportWorldJobPayload{
+		Name:    name,
+		Archive: filepath.Base(spool),
+	})
+	if err != nil {
+		os.Remove(spool)
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to queue world import")
+		http.Error(w, "Failed to import world: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Download handles the request to download a world as a zip, streamed as it is archived.
+func (h *WorldHandler) Download(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "world")
+	if _, err := h.service.GetWorld(r.Context(), serverID, name); err != nil {
+		writeWorldError(w, err, serverID, "Failed to download world")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	if err := h.service.ExportWorld(r.Context(), serverID, name, w); err != nil {
+		// The response has already started, so the client only sees a truncated archive.
+		log.Error().Err(err).Str("server_id", serverID).Str("world", name).Msg("Failed to export world")
+	}
+}
+
+// Reset handles the request to regenerate a world, with its old seed or a new one.
+func (h *WorldHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	name := chi.URLParam(r, "world")
+	var payload ResetWorldPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if payload.KeepSeed && payload.Seed != "" {
+		http.Error(w, "A seed can't be given when keeping the current one", http.StatusBadRequest)
+		return
+	}
+	world, err := h.service.GetWorld(r.Context(), serverID, name)
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to reset world")
+		return
+	}
+	if !world.Active {
+		http.Error(w, "Only the active world can be reset", http.StatusBadRequest)
+		return
+	}
+
+	// The server is stopped and restarted around the reset, so it runs as a job.
+	job, err := h.jobs.EnqueueJob(r.Context(), services.JobTypeResetWorld, &serverID, services.ResetWorldJobPayload{
+		Name:     name,
+		KeepSeed: payload.KeepSeed,
+		Seed:     payload.Seed,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("Failed to queue world reset")
+		http.Error(w, "Failed to reset world: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Delete handles the request to delete a world that is not the active one.
+func (h *WorldHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	if err := h.service.DeleteWorld(r.Context(), serverID, chi.URLParam(r, "world")); err != nil {
+		writeWorldError(w, err, serverID, "Failed to delete world")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPlayers handles the request to list the players a world has saved data for.
+func (h *WorldHandler) GetPlayers(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	players, err := h.service.ListPlayerData(r.Context(), serverID, chi.URLParam(r, "world"))
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to list player data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(players)
+}
+
+// GetPlayer handles the request to view a player's saved position, inventory and experience.
+func (h *WorldHandler) GetPlayer(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	player, err := h.service.GetPlayerData(r.Context(), serverID, chi.URLParam(r, "world"), chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to read player data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(player)
+}
+
+// UpdatePlayer handles the request to edit a player's saved state while the server is stopped.
+func (h *WorldHandler) UpdatePlayer(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	var update models.PlayerDataUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	player, err := h.service.UpdatePlayerData(r.Context(), serverID, chi.URLParam(r, "world"), chi.URLParam(r, "uuid"), update)
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to update player data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(player)
+}
+
+// RestorePlayer handles the request to restore a player's data from the game's copy of the previous save.
+func (h *WorldHandler) RestorePlayer(w http.ResponseWriter, r *http.Request) {
+	serverID := chi.URLParam(r, "id")
+	player, err := h.service.RestorePlayerData(r.Context(), serverID, chi.URLParam(r, "world"), chi.URLParam(r, "uuid"))
+	if err != nil {
+		writeWorldError(w, err, serverID, "Failed to restore player data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(player)
+}
+
+// writeWorldError maps world service errors to HTTP statuses.
+func writeWorldError(w http.ResponseWriter, err error, serverID, message string) {
+	switch {
+	case errors.Is(err, services.ErrWorldNotFound), errors.Is(err, services.ErrPlayerDataNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrWorldExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrServerRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrInvalidWorld), errors.Is(err, services.ErrInvalidPlayerData):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrCorruptPlayerData):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		log.Error().Err(err).Str("server_id", serverID).Msg(message)
+		http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+	}
+}
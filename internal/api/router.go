@@ -7,12 +7,14 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/isdelr/ender-deploy-be/internal/api/handlers"
 	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
 	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	"github.com/isdelr/ender-deploy-be/internal/websocket"
 )
 
 // NewRouter creates and annotes a new Chi router.
-func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider, templateService services.TemplateServiceProvider, userService services.UserServiceProvider, backupService services.BackupServiceProvider, eventService services.EventServiceProvider, scheduleService services.ScheduleServiceProvider) *chi.Mux {
+func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider, templateService services.TemplateServiceProvider, userService services.UserServiceProvider, backupService services.BackupServiceProvider, eventService services.EventServiceProvider, scheduleService services.ScheduleServiceProvider, historyService services.HistoryServiceProvider, jobService services.JobServiceProvider, modService services.ModServiceProvider, worldService services.WorldServiceProvider, fileService services.FileServiceProvider, runtimeService services.RuntimeServiceProvider, terminalService services.TerminalServiceProvider, uploadPath string, metricsToken string) *chi.Mux {
 	r := chi.NewRouter()
 
 	// Basic middleware stack
@@ -20,25 +22,37 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
 
 	// CORS configuration for development
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://127.0.0.1:3000"}, // Adjust for your frontend URL
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Upload-Offset", "Range"},
+		ExposedHeaders:   []string{"Link", "Upload-Offset", "Content-Range", "Content-Disposition"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	// Initialize handlers
-	serverHandler := handlers.NewServerHandler(serverService)
-	templateHandler := handlers.NewTemplateHandler(templateService)
+	serverHandler := handlers.NewServerHandler(serverService, jobService, uploadPath)
+	templateHandler := handlers.NewTemplateHandler(templateService, jobService, uploadPath)
 	userHandler := handlers.NewUserHandler(userService)
-	wsHandler := handlers.NewWebSocketHandler(hub, serverService)
-	backupHandler := handlers.NewBackupHandler(backupService)
+	wsHandler := handlers.NewWebSocketHandler(hub, serverService, terminalService)
+	backupHandler := handlers.NewBackupHandler(backupService, jobService)
 	eventHandler := handlers.NewEventHandler(eventService)
 	scheduleHandler := handlers.NewScheduleHandler(scheduleService)
+	historyHandler := handlers.NewHistoryHandler(historyService)
+	jobHandler := handlers.NewJobHandler(jobService)
+	modHandler := handlers.NewModHandler(modService)
+	worldHandler := handlers.NewWorldHandler(worldService, jobService, uploadPath)
+	fileHandler := handlers.NewFileHandler(fileService, jobService)
+	runtimeHandler := handlers.NewRuntimeHandler(runtimeService, jobService)
+	terminalHandler := handlers.NewTerminalHandler(terminalService)
+
+	// Prometheus scrape endpoint, guarded by its own token rather than a user JWT
+	r.With(metrics.RequireScrapeToken(metricsToken)).Handle("/metrics", metrics.Handler())
 
 	// API versioning
 	r.Route("/api/v1", func(r chi.Router) {
@@ -54,6 +68,7 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 			r.Use(auth.JWTMiddleware())
 			r.Get("/global", wsHandler.Serve)
 			r.Get("/servers/{id}", wsHandler.Serve)
+			r.Get("/v2", wsHandler.ServeV2) // One connection, many topic subscriptions
 		})
 
 		// Protected routes
@@ -64,6 +79,8 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 			r.Get("/dashboard/stats", serverHandler.GetDashboardStats)
 			r.Get("/events", eventHandler.GetRecent)
 			r.Get("/system-stats", serverHandler.GetSystemResourceStats)
+			r.Get("/jvm-profiles", serverHandler.GetJVMProfiles)
+			r.Get("/storage-roots", serverHandler.GetStorageRoots)
 
 			// REST API endpoints for servers
 			r.Route("/servers", func(r chi.Router) {
@@ -76,14 +93,21 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 					r.Put("/", serverHandler.Update)
 					r.Delete("/", serverHandler.Delete)
 					r.Post("/action", serverHandler.PerformAction)
+					r.Post("/upgrade", serverHandler.Upgrade)
+					r.Post("/runtime", serverHandler.ChangeRuntime)
+					r.Post("/template", templateHandler.CaptureServer)
+					r.Post("/clone", serverHandler.Clone)
+					r.Post("/move", serverHandler.Move)
 					r.Post("/command", serverHandler.SendServerConsoleCommand)
 
 					// Server Settings
 					r.Get("/settings", serverHandler.GetServerSettings)
 					r.Post("/settings", serverHandler.UpdateServerSettings)
+					r.Get("/jvm", serverHandler.GetJVMSettings)
+					r.Put("/jvm", serverHandler.UpdateJVMSettings)
 
 					// Resource History
-					r.Get("/resources/history", serverHandler.GetServerResourceHistory)
+					r.Get("/resources/history", historyHandler.GetServerHistory)
 
 					// Player Management
 					r.Get("/players", serverHandler.GetOnlinePlayers)
@@ -93,6 +117,62 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 					r.Get("/files", serverHandler.ListServerFiles)
 					r.Get("/files/content", serverHandler.GetServerFileContent)
 					r.Post("/files/update", serverHandler.UpdateServerFile)
+					r.Post("/files/validate", serverHandler.ValidateServerFile)
+					r.Get("/files/download", fileHandler.Download)
+					r.Post("/files/upload", fileHandler.Upload)
+					r.Post("/files/uploads", fileHandler.CreateUpload)
+					r.Route("/files/uploads/{uploadId}", func(r chi.Router) {
+						r.Get("/", fileHandler.GetUpload)
+						r.Patch("/", fileHandler.UploadChunk)
+						r.Delete("/", fileHandler.CancelUpload)
+					})
+					r.Post("/files/delete", fileHandler.Delete)
+					r.Post("/files/move", fileHandler.Move)
+					r.Post("/files/mkdir", fileHandler.Mkdir)
+					r.Post("/files/chmod", fileHandler.Chmod)
+					r.Post("/files/archive", fileHandler.Archive)
+					r.Post("/files/extract", fileHandler.Extract)
+					r.Get("/files/search", fileHandler.Search)
+					r.Get("/files/history", fileHandler.GetHistory)
+					r.Route("/files/history/{versionId}", func(r chi.Router) {
+						r.Get("/", fileHandler.GetVersion)
+						r.Get("/diff", fileHandler.DiffVersion)
+						r.Post("/revert", fileHandler.RevertVersion)
+					})
+
+					// Interactive shell audit trail
+					r.Get("/terminal/sessions", terminalHandler.GetSessions)
+					r.Get("/terminal/sessions/{sessionId}/recording", terminalHandler.GetRecording)
+
+					// Mods and plugins
+					r.Route("/mods", func(r chi.Router) {
+						r.Get("/", modHandler.GetAll)
+						r.Post("/", modHandler.Add)
+						r.Route("/{directory}/{fileName}", func(r chi.Router) {
+							r.Delete("/", modHandler.Delete)
+							r.Post("/enable", modHandler.Enable)
+							r.Post("/disable", modHandler.Disable)
+						})
+					})
+
+					// Worlds
+					r.Route("/worlds", func(r chi.Router) {
+						r.Get("/", worldHandler.GetAll)
+						r.Post("/", worldHandler.Upload)
+						r.Route("/{world}", func(r chi.Router) {
+							r.Get("/", worldHandler.Get)
+							r.Delete("/", worldHandler.Delete)
+							r.Post("/activate", worldHandler.Activate)
+							r.Get("/download", worldHandler.Download)
+							r.Post("/reset", worldHandler.Reset)
+							r.Get("/players", worldHandler.GetPlayers)
+							r.Route("/players/{uuid}", func(r chi.Router) {
+								r.Get("/", worldHandler.GetPlayer)
+								r.Put("/", worldHandler.UpdatePlayer)
+								r.Post("/restore", worldHandler.RestorePlayer)
+							})
+						})
+					})
 
 					// Backup Management
 					r.Route("/backups", func(r chi.Router) {
@@ -116,20 +196,56 @@ func NewRouter(hub *websocket.Hub, serverService services.ServerServiceProvider,
 				})
 			})
 
+			// Background jobs (server creation, uploads, backups, restores)
+			r.Route("/jobs", func(r chi.Router) {
+				r.Get("/", jobHandler.GetAll)
+				r.Route("/{jobId}", func(r chi.Router) {
+					r.Get("/", jobHandler.Get)
+					r.Post("/cancel", jobHandler.Cancel)
+				})
+			})
+
 			// REST API endpoints for templates
 			r.Route("/templates", func(r chi.Router) {
 				r.Get("/", templateHandler.GetAll)
 				r.Post("/", templateHandler.Create)
+				r.Post("/import", templateHandler.Import)
+				r.Post("/install", templateHandler.Install)
+				r.Get("/software", templateHandler.GetServerSoftware)
+				r.Get("/software/{software}/versions", templateHandler.GetServerSoftwareVersions)
+				r.Post("/bundles", templateHandler.ImportBundle)
+				r.Get("/signing-key", templateHandler.GetSigningKey)
 				r.Route("/{id}", func(r chi.Router) {
 					r.Get("/", templateHandler.Get)
 					r.Put("/", templateHandler.Update)
 					r.Delete("/", templateHandler.Delete)
+					r.Get("/versions", templateHandler.GetVersions)
+					r.Get("/versions/{version}", templateHandler.GetVersion)
+					r.Post("/clone", templateHandler.Clone)
+					r.Get("/export", templateHandler.Export)
+				})
+			})
+
+			// Java runtime catalog
+			r.Route("/runtimes", func(r chi.Router) {
+				r.Get("/", runtimeHandler.GetAll)
+				r.Post("/", runtimeHandler.Register)
+				r.Get("/requirement", runtimeHandler.GetRequirement)
+				r.Route("/{id}", func(r chi.Router) {
+					r.Get("/", runtimeHandler.Get)
+					r.Delete("/", runtimeHandler.Delete)
 				})
 			})
 
 			// REST API endpoints for users
 			r.Route("/users", func(r chi.Router) {
 				r.Get("/me", userHandler.GetMe) // Get the current authenticated user
+				r.Put("/me/sftp-access", userHandler.SetSFTPAccess)
+				r.Route("/me/ssh-keys", func(r chi.Router) {
+					r.Get("/", userHandler.GetSSHKeys)
+					r.Post("/", userHandler.AddSSHKey)
+					r.Delete("/{keyId}", userHandler.DeleteSSHKey)
+				})
 				r.Route("/{id}", func(r chi.Router) {
 					r.Get("/", userHandler.Get)
 					r.Put("/", userHandler.Update)
@@ -35,6 +35,12 @@ type contextKey string
 
 const UserClaimsKey = contextKey("userClaims")
 
+// ClaimsFromContext returns the claims of the user a request was authenticated as, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(UserClaimsKey).(*Claims)
+	return claims, ok
+}
+
 // GenerateJWT creates a new JWT for a given user.
 func GenerateJWT(user models.User) (string, error) {
 	if len(jwtKey) == 0 {
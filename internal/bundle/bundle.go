@@ -0,0 +1,257 @@
+// Package bundle reads and writes template bundles: a single zip holding a template's metadata, its
+// server archive and an Ed25519 signature over both, so templates can be moved between hosts.
+package bundle
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Entries of a bundle.
+const (
+	metadataEntry  = "template.json"
+	archiveEntry   = "template.zip"
+	signatureEntry = "signature.json"
+)
+
+// maxMetadataSize bounds the metadata read from a bundle.
+const maxMetadataSize = 1 << 20
+
+var (
+	// ErrInvalidBundle is returned for files that are not bundles or whose signature doesn't match their contents.
+	ErrInvalidBundle = errors.New("invalid template bundle")
+	// ErrUntrustedSigner is returned for bundles signed with a key that is not trusted.
+	ErrUntrustedSigner = errors.New("template bundle is signed by an untrusted key")
+)
+
+// Keys are the key bundles are signed with and the public keys of the hosts whose bundles are accepted.
+// Bundles signed with Private are always accepted.
+type Keys struct {
+	Private ed25519.PrivateKey
+	Trusted []ed25519.PublicKey
+}
+
+// PublicKey returns the encoded public key bundles are signed with, for other hosts to trust.
+func (k Keys) PublicKey() string {
+	return EncodePublicKey(k.Private.Public().(ed25519.PublicKey))
+}
+
+func (k Keys) trusts(key ed25519.PublicKey) bool {
+	if key.Equal(k.Private.Public()) {
+		return true
+	}
+	for _, trusted := range k.Trusted {
+		if key.Equal(trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodePublicKey encodes a public key as base64.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey decodes a base64 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%q is not a base64 Ed25519 public key", s)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// LoadOrCreateKey reads the PEM-encoded private key at path, generating and saving one if there is none.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "ED25519 PRIVATE KEY" || len(block.Bytes) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s is not an Ed25519 signing key", path)
+		}
+		return ed25519.NewKeyFromSeed(block.Bytes), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: key.Seed()})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %w", err)
+	}
+	return key, nil
+}
+
+// signature is the signature entry of a bundle.
+type signature struct {
+	PublicKey      string `json:"publicKey"`
+	MetadataSHA256 string `json:"metadataSha256"`
+	ArchiveSHA256  string `json:"archiveSha256"`
+	Signature      string `json:"signature"`
+}
+
+// signedMessage is what a bundle's signature covers: the digests of its metadata and its archive.
+func signedMessage(metadataSum, archiveSum string) []byte {
+	return []byte("ender-deploy template bundle v1\n" + metadataSum + "\n" + archiveSum + "\n")
+}
+
+// Write writes a bundle of metadata and the archive at archivePath to w, signed with keys.Private.
+func Write(w io.Writer, metadata []byte, archivePath string, keys Keys) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("could not open template archive: %w", err)
+	}
+	defer archive.Close()
+
+	zw := zip.NewWriter(w)
+	mw, err := zw.Create(metadataEntry)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(metadata); err != nil {
+		return err
+	}
+
+	// The archive is already compressed, so it is stored as is.
+	aw, err := zw.CreateHeader(&zip.FileHeader{Name: archiveEntry, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(aw, hash), archive); err != nil {
+		return fmt.Errorf("could not write template archive: %w", err)
+	}
+
+	metadataSum := sha256.Sum256(metadata)
+	sig := signature{
+		PublicKey:      keys.PublicKey(),
+		MetadataSHA256: hex.EncodeToString(metadataSum[:]),
+		ArchiveSHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(keys.Private, signedMessage(sig.MetadataSHA256, sig.ArchiveSHA256)))
+	sw, err := zw.Create(signatureEntry)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(sw).Encode(sig); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Read verifies the bundle at path against keys and extracts its archive to archiveDest. It returns the
+// bundle's metadata and the encoded public key it was signed with. Nothing is left at archiveDest on error.
+func Read(path, archiveDest string, keys Keys) (metadata []byte, signer string, err error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer zr.Close()
+
+	entries := map[string]*zip.File{}
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	for _, name := range []string{metadataEntry, archiveEntry, signatureEntry} {
+		if entries[name] == nil {
+			return nil, "", fmt.Errorf("%w: missing %s", ErrInvalidBundle, name)
+		}
+	}
+
+	var sig signature
+	sigData, err := readEntry(entries[signatureEntry])
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		return nil, "", fmt.Errorf("%w: unreadable signature: %v", ErrInvalidBundle, err)
+	}
+	publicKey, err := ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(publicKey, signedMessage(sig.MetadataSHA256, sig.ArchiveSHA256), signatureBytes) {
+		return nil, "", fmt.Errorf("%w: bad signature", ErrInvalidBundle)
+	}
+	if !keys.trusts(publicKey) {
+		return nil, "", fmt.Errorf("%w: %s", ErrUntrustedSigner, sig.PublicKey)
+	}
+
+	metadata, err = readEntry(entries[metadataEntry])
+	if err != nil {
+		return nil, "", err
+	}
+	if sum := sha256.Sum256(metadata); hex.EncodeToString(sum[:]) != sig.MetadataSHA256 {
+		return nil, "", fmt.Errorf("%w: metadata doesn't match its signature", ErrInvalidBundle)
+	}
+
+	archiveSum, err := extract(entries[archiveEntry], archiveDest)
+	if err != nil {
+		return nil, "", err
+	}
+	if archiveSum != sig.ArchiveSHA256 {
+		os.Remove(archiveDest)
+		return nil, "", fmt.Errorf("%w: archive doesn't match its signature", ErrInvalidBundle)
+	}
+	return metadata, sig.PublicKey, nil
+}
+
+func readEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxMetadataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if len(data) > maxMetadataSize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidBundle, f.Name)
+	}
+	return data, nil
+}
+
+// extract copies a zip entry to dest and returns its hex SHA-256.
+func extract(f *zip.File, dest string) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", fmt.Errorf("could not extract template archive: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -1,17 +1,46 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration.
 type Config struct {
 	ServerPort     int
 	DatabasePath   string
-	ServerDataBase string // Base path for server files
-	BackupPath     string // Base path for backup files
+	ServerDataBase string   // Base path for server files
+	StorageRoots   []string // Further directories servers can be cloned or moved to, such as mounts of bigger disks
+	BackupPath     string   // Base path for backup files
+	UploadPath     string   // Where uploaded server archives wait for their job
 	JWTSecret      string
+
+	FileHistoryPath  string // Where previous versions of edited server files are kept
+	FileHistoryLimit int    // Number of previous versions kept per file
+
+	JobWorkers int // Number of background jobs that may run at once
+
+	SFTPPort        int    // Port of the SFTP server for server files; 0, the default, disables it
+	SFTPHostKeyPath string // SSH host key of the SFTP server; an Ed25519 key is generated if missing
+
+	TerminalRecordingPath string        // Where recordings of interactive container shells are kept
+	TerminalMaxSessions   int           // Number of interactive shells that may be open on one server at once
+	TerminalIdleTimeout   time.Duration // How long a shell may go without input before it is closed
+
+	CurseForgeAPIKey string // Needed to import CurseForge modpacks
+
+	TemplateSigningKeyPath string   // Ed25519 key exported template bundles are signed with; generated if missing
+	TrustedTemplateKeys    []string // Base64 public keys of the hosts whose template bundles may be imported
+
+	MetricsIntervalSeconds int    // How often TPS/MSPT/JVM metrics are sampled
+	MetricsToken           string // Bearer token required to scrape /metrics; empty disables the endpoint
+
+	OTLPEndpoint     string  // OTLP/HTTP collector host:port; empty disables trace export
+	OTLPInsecure     bool    // Send traces over plain HTTP
+	TraceSampleRatio float64 // Fraction of traces to sample, 0..1
 }
 
 // Load loads configuration from environment variables or sets defaults.
@@ -22,12 +51,81 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	metricsInterval, err := strconv.Atoi(getEnv("METRICS_INTERVAL_SECONDS", "30"))
+	if err != nil {
+		return nil, err
+	}
+	if metricsInterval < 1 {
+		return nil, fmt.Errorf("METRICS_INTERVAL_SECONDS must be at least 1, got %d", metricsInterval)
+	}
+
+	jobWorkers, err := strconv.Atoi(getEnv("JOB_WORKERS", "2"))
+	if err != nil {
+		return nil, err
+	}
+
+	fileHistoryLimit, err := strconv.Atoi(getEnv("FILE_HISTORY_LIMIT", "20"))
+	if err != nil {
+		return nil, err
+	}
+
+	sftpPort, err := strconv.Atoi(getEnv("SFTP_PORT", "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	terminalMaxSessions, err := strconv.Atoi(getEnv("TERMINAL_MAX_SESSIONS", "3"))
+	if err != nil {
+		return nil, err
+	}
+
+	terminalIdleTimeout, err := time.ParseDuration(getEnv("TERMINAL_IDLE_TIMEOUT", "15m"))
+	if err != nil {
+		return nil, err
+	}
+
+	otlpInsecure, err := strconv.ParseBool(getEnv("OTEL_EXPORTER_OTLP_INSECURE", "false"))
+	if err != nil {
+		return nil, err
+	}
+
+	sampleRatio, err := strconv.ParseFloat(getEnv("OTEL_TRACES_SAMPLE_RATIO", "1"), 64)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		ServerPort:     port,
 		DatabasePath:   getEnv("DATABASE_PATH", "./ender.db"),
 		ServerDataBase: getEnv("SERVER_DATA_BASE", "./server-data"),
+		StorageRoots:   splitList(getEnv("SERVER_STORAGE_ROOTS", "")),
 		BackupPath:     getEnv("BACKUP_PATH", "./backups"),
+		UploadPath:     getEnv("UPLOAD_PATH", "./uploads"),
 		JWTSecret:      getEnv("JWT_SECRET", "a-very-secret-key-that-should-be-changed"),
+
+		FileHistoryPath:  getEnv("FILE_HISTORY_PATH", "./file-history"),
+		FileHistoryLimit: fileHistoryLimit,
+
+		JobWorkers: jobWorkers,
+
+		SFTPPort:        sftpPort,
+		SFTPHostKeyPath: getEnv("SFTP_HOST_KEY_PATH", "./sftp-host.key"),
+
+		TerminalRecordingPath: getEnv("TERMINAL_RECORDING_PATH", "./terminal-recordings"),
+		TerminalMaxSessions:   terminalMaxSessions,
+		TerminalIdleTimeout:   terminalIdleTimeout,
+
+		CurseForgeAPIKey: getEnv("CURSEFORGE_API_KEY", ""),
+
+		TemplateSigningKeyPath: getEnv("TEMPLATE_SIGNING_KEY_PATH", "./template-signing.key"),
+		TrustedTemplateKeys:    splitList(getEnv("TEMPLATE_TRUSTED_KEYS", "")),
+
+		MetricsIntervalSeconds: metricsInterval,
+		MetricsToken:           getEnv("METRICS_TOKEN", ""),
+
+		OTLPEndpoint:     getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTLPInsecure:     otlpInsecure,
+		TraceSampleRatio: sampleRatio,
 	}, nil
 }
 
@@ -38,3 +136,14 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// splitList splits a comma-separated environment variable, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
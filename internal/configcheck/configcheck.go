@@ -0,0 +1,320 @@
+// Package configcheck checks server config files before they are written. Files are parsed in the format
+// their extension implies, and the common files of vanilla, Bukkit, Spigot and Paper servers are checked
+// against bundled schemas, so a typo is reported with its line and column rather than keeping the server
+// from starting.
+package configcheck
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the syntax of a config file.
+type Format string
+
+// Formats that can be checked.
+const (
+	YAML       Format = "yaml"
+	TOML       Format = "toml"
+	JSON       Format = "json"
+	Properties Format = "properties"
+)
+
+// FormatOf returns the format of a file by its extension, and false for files that are not checked.
+func FormatOf(name string) (Format, bool) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yml", ".yaml":
+		return YAML, true
+	case ".toml":
+		return TOML, true
+	case ".json", ".mcmeta":
+		return JSON, true
+	case ".properties":
+		return Properties, true
+	}
+	return "", false
+}
+
+// Check parses a config file and, if schema is set and a bundled schema covers the file, checks its
+// settings. It returns nil for files that are fine and for formats it doesn't know.
+func Check(name string, data []byte, schema bool) []models.ConfigIssue {
+	format, ok := FormatOf(name)
+	if !ok {
+		return nil
+	}
+	var s *fileSchema
+	if schema {
+		s = schemas[path.Base(name)]
+	}
+
+	switch format {
+	case YAML:
+		return checkYAML(data, s)
+	case TOML:
+		return checkTOML(data)
+	case JSON:
+		return checkJSON(data)
+	case Properties:
+		return checkProperties(data, s)
+	}
+	return nil
+}
+
+// yamlErrorPattern matches the position yaml.v3 puts at the start of its syntax errors.
+var yamlErrorPattern = regexp.MustCompile(`^yaml: line (\d+):(?: column (\d+):)? (.*)$`)
+
+func checkYAML(data []byte, s *fileSchema) []models.ConfigIssue {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []*yaml.Node
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			issue := models.ConfigIssue{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+			if m := yamlErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+				issue.Line, _ = strconv.Atoi(m[1])
+				issue.Column, _ = strconv.Atoi(m[2])
+				issue.Message = m[3]
+			}
+			return []models.ConfigIssue{issue}
+		}
+		docs = append(docs, &doc)
+	}
+	if s == nil || len(docs) == 0 {
+		return nil
+	}
+
+	var issues []models.ConfigIssue
+	var walk func(prefix []string, node *yaml.Node)
+	walk = func(prefix []string, node *yaml.Node) {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			keyPath := append(append([]string(nil), prefix...), key.Value)
+			if rule, ok := s.lookup(keyPath); ok {
+				if msg := rule.checkYAML(value); msg != "" {
+					issues = append(issues, models.ConfigIssue{Line: value.Line, Column: value.Column, Key: strings.Join(keyPath, "."), Message: msg})
+					continue
+				}
+			}
+			walk(keyPath, value)
+		}
+	}
+	if root := docs[0]; root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		walk(nil, root.Content[0])
+	}
+	return issues
+}
+
+func checkTOML(data []byte) []models.ConfigIssue {
+	var v map[string]any
+	_, err := toml.Decode(string(data), &v)
+	if err == nil {
+		return nil
+	}
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		return []models.ConfigIssue{{Line: parseErr.Position.Line, Column: parseErr.Position.Col, Message: parseErr.Message}}
+	}
+	return []models.ConfigIssue{{Message: err.Error()}}
+}
+
+func checkJSON(data []byte) []models.ConfigIssue {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var v any
+	err := dec.Decode(&v)
+	if err == nil {
+		if _, trailing := dec.Token(); trailing != io.EOF {
+			offset := dec.InputOffset()
+			line, col := position(data, offset)
+			return []models.ConfigIssue{{Line: line, Column: col, Message: "unexpected content after the JSON value"}}
+		}
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := position(data, syntaxErr.Offset)
+		return []models.ConfigIssue{{Line: line, Column: col, Message: syntaxErr.Error()}}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		line, col := position(data, int64(len(data)))
+		return []models.ConfigIssue{{Line: line, Column: col, Message: "unexpected end of JSON input"}}
+	}
+	return []models.ConfigIssue{{Message: err.Error()}}
+}
+
+// checkProperties checks a Java properties file. Almost any text is a valid properties file, so only
+// malformed escapes are syntax errors.
+func checkProperties(data []byte, s *fileSchema) []models.ConfigIssue {
+	var issues []models.ConfigIssue
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		// A line ending in an odd number of backslashes continues on the next.
+		for strings.HasSuffix(line, `\`) && (len(line)-len(strings.TrimRight(line, `\`)))%2 == 1 && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+
+		key, value, valueCol, err := splitProperty(line)
+		if err != nil {
+			issues = append(issues, models.ConfigIssue{Line: lineNo, Column: err.column + len(lines[lineNo-1]) - len(strings.TrimLeft(lines[lineNo-1], " \t\f")), Message: err.message})
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		if rule, ok := s.lookup([]string{key}); ok {
+			if msg := rule.checkText(value); msg != "" {
+				col := valueCol + len(lines[lineNo-1]) - len(strings.TrimLeft(lines[lineNo-1], " \t\f"))
+				issues = append(issues, models.ConfigIssue{Line: lineNo, Column: col, Key: key, Message: msg})
+			}
+		}
+	}
+	return issues
+}
+
+// propertyError is a malformed escape in a properties file, at a 1-based column of the line.
+type propertyError struct {
+	column  int
+	message string
+}
+
+// splitProperty splits a properties line into its unescaped key and value, and returns the column the value
+// starts at.
+func splitProperty(line string) (key, value string, valueCol int, perr *propertyError) {
+	var b strings.Builder
+	i := 0
+	unescape := func() *propertyError {
+		// line[i] is a backslash.
+		if i+1 >= len(line) {
+			i++
+			return nil
+		}
+		c := line[i+1]
+		switch c {
+		case 'u':
+			if i+6 > len(line) {
+				return &propertyError{i + 1, `incomplete \u escape`}
+			}
+			r, err := strconv.ParseUint(line[i+2:i+6], 16, 16)
+			if err != nil {
+				return &propertyError{i + 1, fmt.Sprintf(`malformed \u escape %q`, line[i:i+6])}
+			}
+			b.WriteRune(rune(r))
+			i += 6
+			return nil
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		default:
+			b.WriteByte(c)
+		}
+		i += 2
+		return nil
+	}
+
+	for i < len(line) && !strings.ContainsRune("=: \t\f", rune(line[i])) {
+		if line[i] == '\\' {
+			if err := unescape(); err != nil {
+				return "", "", 0, err
+			}
+			continue
+		}
+		b.WriteByte(line[i])
+		i++
+	}
+	key = b.String()
+	b.Reset()
+
+	for i < len(line) && strings.ContainsRune(" \t\f", rune(line[i])) {
+		i++
+	}
+	if i < len(line) && (line[i] == '=' || line[i] == ':') {
+		i++
+	}
+	for i < len(line) && strings.ContainsRune(" \t\f", rune(line[i])) {
+		i++
+	}
+	valueCol = i + 1
+	for i < len(line) {
+		if line[i] == '\\' {
+			if err := unescape(); err != nil {
+				return "", "", 0, err
+			}
+			continue
+		}
+		b.WriteByte(line[i])
+		i++
+	}
+	return key, b.String(), valueCol, nil
+}
+
+// position converts a byte offset into data to a 1-based line and column.
+func position(data []byte, offset int64) (line, col int) {
+	offset = min(offset, int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, col
+}
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schemas maps file names to the schemas that cover them.
+var schemas = loadSchemas()
+
+func loadSchemas() map[string]*fileSchema {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	byFile := map[string]*fileSchema{}
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var s fileSchema
+		if err := json.Unmarshal(data, &s); err != nil {
+			panic(fmt.Sprintf("configcheck: bad schema %s: %v", entry.Name(), err))
+		}
+		for _, name := range s.Files {
+			byFile[name] = &s
+		}
+	}
+	return byFile
+}
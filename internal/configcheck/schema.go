@@ -0,0 +1,158 @@
+package configcheck
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSchema describes the known settings of a config file. Settings that aren't listed are not checked,
+// since plugins and newer server versions add their own.
+type fileSchema struct {
+	// Files are the base names of the files the schema covers.
+	Files []string `json:"files"`
+	// Keys maps dotted setting paths to their rules. A "*" segment matches any one key, such as a world name.
+	Keys map[string]rule `json:"keys"`
+}
+
+// yaml11Bools are the booleans of YAML 1.1, which Bukkit reads its configs as, that YAML 1.2 takes for
+// strings.
+var yaml11Bools = []string{"yes", "no", "on", "off", "y", "n"}
+
+// rule is what a setting's value must look like.
+type rule struct {
+	Type     string   `json:"type"` // bool, int, float, string, list or section
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Values   []string `json:"values,omitempty"`   // Allowed values of a string
+	Keywords []string `json:"keywords,omitempty"` // Values accepted in place of any other, such as "default"
+}
+
+// lookup returns the rule for a setting, preferring an exact path over one with wildcards.
+func (s *fileSchema) lookup(keyPath []string) (rule, bool) {
+	if r, ok := s.Keys[strings.Join(keyPath, ".")]; ok {
+		return r, true
+	}
+	for pattern, r := range s.Keys {
+		if !strings.Contains(pattern, "*") {
+			continue
+		}
+		parts := strings.Split(pattern, ".")
+		if len(parts) != len(keyPath) {
+			continue
+		}
+		match := true
+		for i, part := range parts {
+			if part != "*" && part != keyPath[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+// checkYAML checks a YAML value, using the type its tag resolves to. It returns a message describing the
+// problem, or "" if the value is fine.
+func (r rule) checkYAML(node *yaml.Node) string {
+	if node.Kind == yaml.ScalarNode && slices.Contains(r.Keywords, node.Value) {
+		return ""
+	}
+	switch r.Type {
+	case "section":
+		if node.Kind != yaml.MappingNode {
+			return "expected a section of settings"
+		}
+		return ""
+	case "list":
+		if node.Kind != yaml.SequenceNode {
+			return "expected a list"
+		}
+		return ""
+	}
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Sprintf("expected %s", r.describe())
+	}
+	switch r.Type {
+	case "bool":
+		if node.Tag != "!!bool" && !slices.Contains(yaml11Bools, strings.ToLower(node.Value)) {
+			return fmt.Sprintf("expected true or false, got %q", node.Value)
+		}
+	case "int":
+		if node.Tag != "!!int" {
+			return fmt.Sprintf("expected a whole number, got %q", node.Value)
+		}
+	case "float":
+		if node.Tag != "!!int" && node.Tag != "!!float" {
+			return fmt.Sprintf("expected a number, got %q", node.Value)
+		}
+	}
+	return r.checkValue(node.Value)
+}
+
+// checkText checks a value written as text, as in a properties file.
+func (r rule) checkText(value string) string {
+	if slices.Contains(r.Keywords, value) {
+		return ""
+	}
+	switch r.Type {
+	case "bool":
+		if value != "true" && value != "false" {
+			return fmt.Sprintf("expected true or false, got %q", value)
+		}
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Sprintf("expected a whole number, got %q", value)
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("expected a number, got %q", value)
+		}
+	}
+	return r.checkValue(value)
+}
+
+// checkValue checks the range of a number and the allowed values of a string.
+func (r rule) checkValue(value string) string {
+	if len(r.Values) > 0 && !slices.Contains(r.Values, value) {
+		return fmt.Sprintf("expected one of %s, got %q", strings.Join(r.Values, ", "), value)
+	}
+	if r.Type != "int" && r.Type != "float" {
+		return ""
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64)
+	if err != nil {
+		// YAML also writes ints in hex or octal; leave their range unchecked.
+		return ""
+	}
+	if r.Min != nil && n < *r.Min {
+		return fmt.Sprintf("must be at least %s, got %s", formatNumber(*r.Min), value)
+	}
+	if r.Max != nil && n > *r.Max {
+		return fmt.Sprintf("must be at most %s, got %s", formatNumber(*r.Max), value)
+	}
+	return ""
+}
+
+// describe names the kind of value a rule expects.
+func (r rule) describe() string {
+	switch r.Type {
+	case "bool":
+		return "true or false"
+	case "int":
+		return "a whole number"
+	case "float":
+		return "a number"
+	}
+	return "a single value"
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
@@ -2,13 +2,15 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	_ "modernc.org/sqlite" // SQLite driver
 )
 
 // New creates a new database connection pool.
 func New(dataSourceName string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dataSourceName+"?_foreign_keys=on")
+	db, err := tracing.OpenDB("sqlite", dataSourceName+"?_foreign_keys=on")
 	if err != nil {
 		return nil, err
 	}
@@ -46,9 +48,24 @@ func Migrate(db *sql.DB) error {
 		resource_packs_json TEXT,
 		banned_players_json TEXT,
 		banned_ips_json TEXT,
+		version INTEGER, -- Latest version; the row mirrors it
+		changelog TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	-- Immutable snapshots of a template. Servers are created from one of them, so editing a
+	-- template publishes a new version instead of changing the blueprint under existing servers.
+	CREATE TABLE IF NOT EXISTS template_versions (
+		template_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		changelog TEXT,
+		metadata_json TEXT NOT NULL,
+		archive_path TEXT NOT NULL,
+		archive_sha256 TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (template_id, version)
+	);
+
 	CREATE TABLE IF NOT EXISTS servers (
 		id TEXT NOT NULL PRIMARY KEY,
 		name TEXT NOT NULL,
@@ -69,6 +86,18 @@ func Migrate(db *sql.DB) error {
 		rcon_password TEXT,
 		template_id TEXT,
 		max_memory_mb INTEGER,
+		tps REAL,
+		mspt REAL,
+		heap_used_mb REAL,
+		heap_max_mb REAL,
+		gc_count INTEGER,
+		gc_time_ms INTEGER,
+		metrics_source TEXT,
+		server_type TEXT,
+		loader_version TEXT,
+		runtime_id TEXT,
+		jvm_settings_json TEXT,
+		template_version INTEGER,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(template_id) REFERENCES templates(id)
 	);
@@ -78,6 +107,7 @@ func Migrate(db *sql.DB) error {
 		username TEXT UNIQUE,
 		email TEXT UNIQUE,
 		password_hash TEXT,
+		sftp_read_only INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
@@ -88,6 +118,31 @@ func Migrate(db *sql.DB) error {
 		cpu_usage REAL,
 		ram_usage REAL,
 		players_current INTEGER,
+		tps REAL,
+		mspt REAL,
+		heap_used_mb REAL,
+		heap_max_mb REAL,
+		gc_count INTEGER,
+		gc_time_ms INTEGER,
+		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_resource_history_server_time ON resource_history(server_id, timestamp);
+
+	-- Downsampled resource history. Each row aggregates one bucket of 'resolution' seconds.
+	-- Metrics keep min/max/sum/count so buckets can be merged again without losing precision.
+	CREATE TABLE IF NOT EXISTS resource_rollups (
+		server_id TEXT NOT NULL,
+		resolution INTEGER NOT NULL,
+		bucket_start INTEGER NOT NULL, -- Unix seconds
+		samples INTEGER NOT NULL,
+		cpu_min REAL, cpu_max REAL, cpu_sum REAL, cpu_count INTEGER,
+		ram_min REAL, ram_max REAL, ram_sum REAL, ram_count INTEGER,
+		players_min REAL, players_max REAL, players_sum REAL, players_count INTEGER,
+		tps_min REAL, tps_max REAL, tps_sum REAL, tps_count INTEGER,
+		mspt_min REAL, mspt_max REAL, mspt_sum REAL, mspt_count INTEGER,
+		heap_used_min REAL, heap_used_max REAL, heap_used_sum REAL, heap_used_count INTEGER,
+		PRIMARY KEY (server_id, resolution, bucket_start),
 		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
 	);
 
@@ -107,6 +162,7 @@ func Migrate(db *sql.DB) error {
 		level TEXT NOT NULL,
 		message TEXT NOT NULL,
 		server_id TEXT,
+		trace_id TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
 	);
@@ -124,11 +180,161 @@ func Migrate(db *sql.DB) error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
 	);
+
+	-- Long-running operations (server creation, backups, restores) executed by the job workers.
+	-- server_id is not a foreign key so a job keeps its history when its server is deleted.
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT NOT NULL PRIMARY KEY,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		server_id TEXT,
+		payload TEXT,
+		result TEXT,
+		error TEXT,
+		progress_stage TEXT,
+		progress_current INTEGER NOT NULL DEFAULT 0,
+		progress_total INTEGER NOT NULL DEFAULT 0,
+		progress_unit TEXT,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		trace_parent TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		started_at DATETIME,
+		finished_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, created_at);
+
+	-- Java runtime images registered by admins, in addition to the built-in Temurin images.
+	CREATE TABLE IF NOT EXISTS java_runtimes (
+		id TEXT NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT,
+		image TEXT NOT NULL,
+		java_version INTEGER NOT NULL,
+		version_info TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS file_uploads (
+		id TEXT NOT NULL PRIMARY KEY,
+		server_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		received INTEGER NOT NULL DEFAULT 0,
+		overwrite BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS file_versions (
+		id TEXT NOT NULL PRIMARY KEY,
+		server_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		author TEXT,
+		size INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY(server_id) REFERENCES servers(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_file_versions_path ON file_versions(server_id, path, created_at);
+
+	CREATE TABLE IF NOT EXISTS ssh_keys (
+		id TEXT NOT NULL PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT,
+		public_key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL UNIQUE,
+		read_only INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS terminal_sessions (
+		id TEXT NOT NULL PRIMARY KEY,
+		server_id TEXT NOT NULL,
+		user_id TEXT,
+		username TEXT,
+		started_at DATETIME NOT NULL,
+		ended_at DATETIME,
+		end_reason TEXT,
+		exit_code INTEGER,
+		recording_size INTEGER NOT NULL DEFAULT 0,
+		truncated INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_terminal_sessions_server ON terminal_sessions(server_id, started_at);
 	`
 	_, err := db.Exec(sqlStmt)
 	if err != nil {
 		return err
 	}
 
+	// Columns added after the initial schema. CREATE TABLE IF NOT EXISTS won't
+	// touch tables that already exist, so older databases get them here.
+	for _, c := range addedColumns {
+		if err := ensureColumn(db, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// addedColumn describes a column that was added to an existing table.
+type addedColumn struct {
+	table      string
+	name       string
+	definition string
+}
+
+var addedColumns = []addedColumn{
+	{"servers", "tps", "REAL"},
+	{"servers", "mspt", "REAL"},
+	{"servers", "heap_used_mb", "REAL"},
+	{"servers", "heap_max_mb", "REAL"},
+	{"servers", "gc_count", "INTEGER"},
+	{"servers", "gc_time_ms", "INTEGER"},
+	{"servers", "metrics_source", "TEXT"},
+	{"servers", "server_type", "TEXT"},
+	{"servers", "loader_version", "TEXT"},
+	{"servers", "runtime_id", "TEXT"},
+	{"servers", "jvm_settings_json", "TEXT"},
+	{"servers", "template_version", "INTEGER"},
+	{"templates", "version", "INTEGER"},
+	{"templates", "changelog", "TEXT"},
+	{"resource_history", "tps", "REAL"},
+	{"resource_history", "mspt", "REAL"},
+	{"resource_history", "heap_used_mb", "REAL"},
+	{"resource_history", "heap_max_mb", "REAL"},
+	{"resource_history", "gc_count", "INTEGER"},
+	{"resource_history", "gc_time_ms", "INTEGER"},
+	{"events", "trace_id", "TEXT"},
+	{"users", "sftp_read_only", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// ensureColumn adds a column to a table if it doesn't exist yet.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
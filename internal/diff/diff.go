@@ -0,0 +1,204 @@
+// Package diff produces unified diffs of text files.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around each change.
+const contextLines = 3
+
+// maxEdits bounds the search for the shortest diff, whose memory grows with the square of the number of
+// changed lines. Files that differ more are diffed as a whole replacement.
+const maxEdits = 1000
+
+// op is one line of an edit script: kept (' '), deleted ('-') or inserted ('+').
+type op struct {
+	kind byte
+	text string
+}
+
+// Unified returns the unified diff that turns a into b, naming them fromName and toName in its header. It
+// returns "" if they are equal.
+func Unified(fromName, toName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	ops := edits(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Line numbers in a and b before each op.
+	aLine, bLine := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, o := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if o.kind != '+' {
+			aLine[i+1]++
+		}
+		if o.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// A hunk runs from the context before a change to the context after the last change that is
+		// close enough to be shown with it.
+		last := i
+		for {
+			next := last + 1
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-last-1 > 2*contextLines {
+				break
+			}
+			last = next
+		}
+		start, stop := max(i-contextLines, 0), min(last+contextLines+1, len(ops))
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[stop]-aLine[start]), hunkRange(bLine[start], bLine[stop]-bLine[start]))
+		for _, o := range ops[start:stop] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.text)
+			if !strings.HasSuffix(o.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines of one side of a hunk, which start after line start.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits data into lines, each keeping its newline. The last line lacks one if data doesn't end
+// with a newline.
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if i == 0 {
+			i = len(data)
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i:]
+	}
+	return lines
+}
+
+// edits returns an edit script that turns x into y, as short as practical.
+func edits(x, y []string) []op {
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var ops []op
+	for _, line := range x[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+	middle := myers(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])
+	if middle == nil {
+		for _, line := range x[prefix : len(x)-suffix] {
+			middle = append(middle, op{'-', line})
+		}
+		for _, line := range y[prefix : len(y)-suffix] {
+			middle = append(middle, op{'+', line})
+		}
+	}
+	ops = append(ops, middle...)
+	for _, line := range x[len(x)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+// myers finds a shortest edit script with Myers' algorithm. It returns nil if x and y are equal or differ by
+// more than maxEdits lines.
+func myers(x, y []string) []op {
+	n, m := len(x), len(y)
+	if n+m == 0 {
+		return nil
+	}
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	// v[offset+k] is the furthest index into x reached on diagonal k. trace[d] keeps diagonals -d-1..d+1 of
+	// v as they were before step d, which is all that step reads.
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				i = v[offset+k+1]
+			} else {
+				i = v[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[offset+k] = i
+			if i >= n && j >= m {
+				return backtrack(x, y, trace)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the steps recorded by myers back from the end of x and y.
+func backtrack(x, y []string, trace [][]int) []op {
+	var ops []op
+	i, j := len(x), len(y)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := i - j
+		prevK := k - 1
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			prevK = k + 1
+		}
+		prevI := at(prevK)
+		prevJ := prevI - prevK
+		for i > prevI && j > prevJ {
+			i, j = i-1, j-1
+			ops = append(ops, op{' ', x[i]})
+		}
+		if d > 0 {
+			if i == prevI {
+				ops = append(ops, op{'+', y[prevJ]})
+			} else {
+				ops = append(ops, op{'-', x[prevI]})
+			}
+		}
+		i, j = prevI, prevJ
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
@@ -3,12 +3,17 @@ package docker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Client wraps the official Docker client to provide specific functionalities.
@@ -16,6 +21,11 @@ type Client struct {
 	cli *client.Client
 }
 
+// containerID is the span attribute identifying the container an operation targets.
+func containerID(id string) attribute.KeyValue {
+	return attribute.String("container.id", id)
+}
+
 // New creates a new Docker client wrapper.
 func New() (*Client, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -26,34 +36,63 @@ func New() (*Client, error) {
 }
 
 // CreateContainer creates a new Docker container with the given configurations.
-func (c *Client) CreateContainer(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, containerName string) (container.CreateResponse, error) {
+func (c *Client) CreateContainer(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, containerName string) (resp container.CreateResponse, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_create", attribute.String("container.name", containerName), attribute.String("container.image", config.Image))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, containerName)
 }
 
 // StartContainer starts a container by its ID.
-func (c *Client) StartContainer(ctx context.Context, id string) error {
+func (c *Client) StartContainer(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_start", containerID(id))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerStart(ctx, id, container.StartOptions{})
 }
 
 // StopContainer stops a container by its ID with a timeout.
-func (c *Client) StopContainer(ctx context.Context, id string) error {
+func (c *Client) StopContainer(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_stop", containerID(id))
+	defer func() { tracing.End(span, err) }()
 	// Specify a 10-second timeout for graceful shutdown
 	timeout := 10
 	return c.cli.ContainerStop(ctx, id, container.StopOptions{Timeout: &timeout})
 }
 
 // RestartContainer restarts a container by its ID.
-func (c *Client) RestartContainer(ctx context.Context, id string) error {
+func (c *Client) RestartContainer(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_restart", containerID(id))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerRestart(ctx, id, container.StopOptions{})
 }
 
+// WaitContainer blocks until a container has stopped and returns its exit code.
+func (c *Client) WaitContainer(ctx context.Context, id string) (exitCode int64, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_wait", containerID(id))
+	defer func() { tracing.End(span, err) }()
+	statusCh, errCh := c.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return status.StatusCode, errors.New(status.Error.Message)
+		}
+		return status.StatusCode, nil
+	case err := <-errCh:
+		return -1, err
+	}
+}
+
 // RemoveContainer deletes a container by its ID.
-func (c *Client) RemoveContainer(ctx context.Context, id string) error {
+func (c *Client) RemoveContainer(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_remove", containerID(id))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true, RemoveVolumes: true})
 }
 
 // GetContainerLogs returns a reader for the container's logs.
-func (c *Client) GetContainerLogs(ctx context.Context, id string, follow bool) (io.ReadCloser, error) {
+func (c *Client) GetContainerLogs(ctx context.Context, id string, follow bool) (rc io.ReadCloser, err error) {
+	// The span only covers opening the stream, not following it.
+	ctx, span := tracing.StartChild(ctx, "docker.container_logs", containerID(id))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerLogs(ctx, id, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
@@ -63,23 +102,52 @@ func (c *Client) GetContainerLogs(ctx context.Context, id string, follow bool) (
 	})
 }
 
+// FollowContainerLogs returns a reader that follows the container's output from since onwards. It is the raw
+// output of the container's TTY, without timestamps.
+func (c *Client) FollowContainerLogs(ctx context.Context, id string, since time.Time) (rc io.ReadCloser, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_logs", containerID(id))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+	})
+}
+
+// ContainerAttach attaches to a running container's main process.
+func (c *Client) ContainerAttach(ctx context.Context, id string, options container.AttachOptions) (resp types.HijackedResponse, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_attach", containerID(id))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerAttach(ctx, id, options)
+}
+
 // InspectContainer returns the JSON response from a container inspect.
-func (c *Client) InspectContainer(ctx context.Context, containerID string) (types.ContainerJSON, error) {
-	return c.cli.ContainerInspect(ctx, containerID)
+func (c *Client) InspectContainer(ctx context.Context, id string) (info types.ContainerJSON, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_inspect", containerID(id))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerInspect(ctx, id)
 }
 
 // ImageInspectWithRaw returns the inspect data for an image.
-func (c *Client) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+func (c *Client) ImageInspectWithRaw(ctx context.Context, imageID string) (info types.ImageInspect, raw []byte, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.image_inspect", attribute.String("container.image", imageID))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ImageInspectWithRaw(ctx, imageID)
 }
 
 // ImagePull pulls an image from a registry and returns a reader for the pull progress.
-func (c *Client) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+func (c *Client) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (rc io.ReadCloser, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.image_pull", attribute.String("container.image", refStr))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ImagePull(ctx, refStr, options)
 }
 
 // GetContainerStats returns a reader for the container's resource usage stats.
-func (c *Client) GetContainerStats(ctx context.Context, id string) (*container.StatsResponse, error) {
+func (c *Client) GetContainerStats(ctx context.Context, id string) (_ *container.StatsResponse, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_stats", containerID(id))
+	defer func() { tracing.End(span, err) }()
+
 	stats, err := c.cli.ContainerStats(ctx, id, false) // false for not streaming
 	if err != nil {
 		return nil, err
@@ -95,7 +163,10 @@ func (c *Client) GetContainerStats(ctx context.Context, id string) (*container.S
 }
 
 // ListContainers lists all containers managed by this application.
-func (c *Client) ListContainers(ctx context.Context) ([]types.Container, error) {
+func (c *Client) ListContainers(ctx context.Context) (_ []types.Container, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.container_list")
+	defer func() { tracing.End(span, err) }()
+
 	// In a real app, you would filter by a specific label, e.g. "com.ender-deploy.managed=true"
 	return c.cli.ContainerList(ctx, container.ListOptions{All: true})
 }
@@ -124,11 +195,29 @@ func CalculateRAMPercent(stats *container.StatsResponse) float64 {
 }
 
 // ContainerExecCreate creates an execution instance in a container.
-func (c *Client) ContainerExecCreate(ctx context.Context, containerID string, config container.ExecOptions) (types.IDResponse, error) {
-	return c.cli.ContainerExecCreate(ctx, containerID, config)
+func (c *Client) ContainerExecCreate(ctx context.Context, id string, config container.ExecOptions) (resp types.IDResponse, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.exec_create", containerID(id))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerExecCreate(ctx, id, config)
 }
 
 // ContainerExecAttach attaches to an execution instance in a container.
-func (c *Client) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+func (c *Client) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (resp types.HijackedResponse, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.exec_attach", attribute.String("docker.exec_id", execID))
+	defer func() { tracing.End(span, err) }()
 	return c.cli.ContainerExecAttach(ctx, execID, config)
 }
+
+// ContainerExecResize changes the size of the TTY of an execution instance.
+func (c *Client) ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) (err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.exec_resize", attribute.String("docker.exec_id", execID))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerExecResize(ctx, execID, options)
+}
+
+// ContainerExecInspect returns the state of an execution instance, including its exit code once it has ended.
+func (c *Client) ContainerExecInspect(ctx context.Context, execID string) (info container.ExecInspect, err error) {
+	ctx, span := tracing.StartChild(ctx, "docker.exec_inspect", attribute.String("docker.exec_id", execID))
+	defer func() { tracing.End(span, err) }()
+	return c.cli.ContainerExecInspect(ctx, execID)
+}
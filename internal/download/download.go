@@ -0,0 +1,186 @@
+// Package download fetches files over HTTP and verifies them against their published checksums.
+package download
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Hashes are the expected hex digests of a download. Empty digests are not checked.
+type Hashes struct {
+	SHA1   string
+	SHA256 string
+	SHA512 string
+	MD5    string
+}
+
+// Empty reports whether no digest is set.
+func (h Hashes) Empty() bool {
+	return h.SHA1 == "" && h.SHA256 == "" && h.SHA512 == "" && h.MD5 == ""
+}
+
+// File downloads the first of urls that succeeds to path. The file only appears at path once
+// its hashes have been verified, so a failed or corrupt download never leaves a partial file behind.
+func File(ctx context.Context, client *http.Client, path string, urls []string, want Hashes) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no download URL for %s", filepath.Base(path))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var lastErr error
+	for _, url := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if lastErr = fetch(ctx, client, url, tmp, want); lastErr == nil {
+			break
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("failed to download %s: %w", filepath.Base(path), lastErr)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Copy downloads url to w and checks it against want. On failure w may hold part of the body, so it is for
+// writers that are thrown away unless the download succeeds.
+func Copy(ctx context.Context, client *http.Client, url string, w io.Writer, want Hashes) error {
+	return fetch(ctx, client, url, w, want)
+}
+
+// fetch writes the body of url to w and checks it against want.
+func fetch(ctx context.Context, client *http.Client, url string, w io.Writer, want Hashes) (err error) {
+	ctx, span := tracing.StartChild(ctx, "download", attribute.String("http.url", url))
+	defer func() { tracing.End(span, err) }()
+
+	resp, err := Get(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	h1, h256, h512, h5 := sha1.New(), sha256.New(), sha512.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(w, h1, h256, h512, h5), resp.Body); err != nil {
+		return fmt.Errorf("failed to read %s: %w", url, err)
+	}
+
+	for _, check := range []struct {
+		algo      string
+		want, got string
+	}{
+		{"sha512", want.SHA512, hex.EncodeToString(h512.Sum(nil))},
+		{"sha256", want.SHA256, hex.EncodeToString(h256.Sum(nil))},
+		{"sha1", want.SHA1, hex.EncodeToString(h1.Sum(nil))},
+		{"md5", want.MD5, hex.EncodeToString(h5.Sum(nil))},
+	} {
+		if check.want != "" && !strings.EqualFold(check.want, check.got) {
+			return fmt.Errorf("%s mismatch for %s: expected %s, got %s", check.algo, url, check.want, check.got)
+		}
+	}
+	return nil
+}
+
+// Get performs a GET request and fails on any status other than 200.
+func Get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// JSON fetches url and decodes its JSON body into v.
+func JSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	resp, err := Get(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// MavenSHA1 returns the checksum a Maven repository publishes next to an artifact.
+func MavenSHA1(ctx context.Context, client *http.Client, artifactURL string) (string, error) {
+	resp, err := Get(ctx, client, artifactURL+".sha1")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	sum, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(sum))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum at %s.sha1", artifactURL)
+	}
+	return fields[0], nil
+}
+
+// MavenMetadata is the maven-metadata.xml of an artifact.
+type MavenMetadata struct {
+	Versioning struct {
+		Latest   string   `xml:"latest"`
+		Release  string   `xml:"release"`
+		Versions []string `xml:"versions>version"`
+	} `xml:"versioning"`
+}
+
+// Maven fetches the metadata of the artifact at artifactURL, e.g. https://repo.example.com/org/example/artifact.
+func Maven(ctx context.Context, client *http.Client, artifactURL string) (MavenMetadata, error) {
+	var metadata MavenMetadata
+	resp, err := Get(ctx, client, artifactURL+"/maven-metadata.xml")
+	if err != nil {
+		return metadata, err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return metadata, fmt.Errorf("invalid maven metadata at %s: %w", artifactURL, err)
+	}
+	return metadata, nil
+}
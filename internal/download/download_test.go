@@ -0,0 +1,118 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var content = []byte("server jar contents")
+
+func hexSum(sum []byte) string {
+	return hex.EncodeToString(sum)
+}
+
+func hashesOf(data []byte) Hashes {
+	s1, s256, s512, m5 := sha1.Sum(data), sha256.Sum256(data), sha512.Sum512(data), md5.Sum(data)
+	return Hashes{SHA1: hexSum(s1[:]), SHA256: hexSum(s256[:]), SHA512: hexSum(s512[:]), MD5: hexSum(m5[:])}
+}
+
+// newMirror serves content at /good, other bytes at /bad and fails at /missing.
+func newMirror(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good":
+			w.Write(content)
+		case "/bad":
+			w.Write([]byte("tampered contents"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFileVerifiesHashes(t *testing.T) {
+	srv := newMirror(t)
+	all := hashesOf(content)
+	for name, want := range map[string]Hashes{
+		"all":    all,
+		"sha1":   {SHA1: all.SHA1},
+		"sha256": {SHA256: strings.ToUpper(all.SHA256)},
+		"sha512": {SHA512: all.SHA512},
+		"md5":    {MD5: all.MD5},
+		"none":   {},
+	} {
+		path := filepath.Join(t.TempDir(), "mods", "server.jar")
+		if err := File(context.Background(), srv.Client(), path, []string{srv.URL + "/good"}, want); err != nil {
+			t.Errorf("%s: File() = %v", name, err)
+			continue
+		}
+		if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+			t.Errorf("%s: downloaded %q, want %q", name, data, content)
+		}
+	}
+}
+
+func TestFileRejectsMismatch(t *testing.T) {
+	srv := newMirror(t)
+	all := hashesOf(content)
+	for name, want := range map[string]Hashes{
+		"sha1":   {SHA1: all.SHA1},
+		"sha256": {SHA256: all.SHA256},
+		"sha512": {SHA512: all.SHA512},
+		"md5":    {MD5: all.MD5},
+	} {
+		dir := t.TempDir()
+		err := File(context.Background(), srv.Client(), filepath.Join(dir, "server.jar"), []string{srv.URL + "/bad"}, want)
+		if err == nil || !strings.Contains(err.Error(), name+" mismatch") {
+			t.Errorf("%s: File() = %v, want a %s mismatch", name, err, name)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: %d files left behind after a failed download", name, len(entries))
+		}
+	}
+}
+
+func TestFileFallsBackToLaterURLs(t *testing.T) {
+	srv := newMirror(t)
+	path := filepath.Join(t.TempDir(), "server.jar")
+	urls := []string{srv.URL + "/missing", srv.URL + "/bad", srv.URL + "/good"}
+	if err := File(context.Background(), srv.Client(), path, urls, hashesOf(content)); err != nil {
+		t.Fatal(err)
+	}
+	// The tampered download from the second URL must not leave bytes behind in the file.
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, content) {
+		t.Errorf("downloaded %q, want %q", data, content)
+	}
+
+	if err := File(context.Background(), srv.Client(), path, nil, Hashes{}); err == nil {
+		t.Error("File() without URLs succeeded")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	srv := newMirror(t)
+	var buf bytes.Buffer
+	if err := Copy(context.Background(), srv.Client(), srv.URL+"/good", &buf, hashesOf(content)); err != nil || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Copy() = %q, %v", buf.Bytes(), err)
+	}
+	if err := Copy(context.Background(), srv.Client(), srv.URL+"/bad", &bytes.Buffer{}, hashesOf(content)); err == nil {
+		t.Error("Copy() accepted a mismatching download")
+	}
+	if err := Copy(context.Background(), srv.Client(), srv.URL+"/missing", &bytes.Buffer{}, Hashes{}); err == nil {
+		t.Error("Copy() accepted a 404")
+	}
+}
@@ -0,0 +1,133 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+)
+
+// FabricSource installs Fabric through its meta API, which builds a self-contained server launcher.
+type FabricSource struct {
+	Meta string
+}
+
+// fabricVersion is an entry of the Fabric and Quilt meta version lists.
+type fabricVersion struct {
+	Version string `json:"version"`
+	Stable  bool   `json:"stable"`
+}
+
+// Versions lists the stable Minecraft versions Fabric supports, newest first.
+func (s FabricSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	return stableGameVersions(ctx, env, strings.TrimSuffix(s.Meta, "/")+"/v2/versions/game")
+}
+
+// Install downloads the server launcher for spec.MinecraftVersion and the requested or newest stable loader.
+// The launcher fetches the vanilla server jar itself on first start.
+func (s FabricSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	meta := strings.TrimSuffix(s.Meta, "/")
+	loader := spec.Version
+	if loader == "" {
+		var err error
+		if loader, err = firstStable(ctx, env, meta+"/v2/versions/loader"); err != nil {
+			return Result{}, err
+		}
+	}
+	installer, err := firstStable(ctx, env, meta+"/v2/versions/installer")
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Fabric meta generates the launcher on request and publishes no checksum for it.
+	url := fmt.Sprintf("%s/v2/versions/loader/%s/%s/%s/server/jar", meta, spec.MinecraftVersion, loader, installer)
+	const jar = "fabric-server-launch.jar"
+	if err := download.File(ctx, env.Client, filepath.Join(dir, jar), []string{url}, download.Hashes{}); err != nil {
+		return Result{}, err
+	}
+	return Result{Software: Fabric, MinecraftVersion: spec.MinecraftVersion, Version: loader, Jar: jar}, nil
+}
+
+// QuiltSource installs Quilt by running the Quilt installer.
+type QuiltSource struct {
+	Meta  string
+	Maven string // Hosts the installer
+}
+
+// Versions lists the stable Minecraft versions Quilt supports, newest first.
+func (s QuiltSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	return stableGameVersions(ctx, env, strings.TrimSuffix(s.Meta, "/")+"/v3/versions/game")
+}
+
+// Install runs the newest Quilt installer for spec.MinecraftVersion and the requested or newest loader.
+func (s QuiltSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	loader := spec.Version
+	if loader == "" {
+		var loaders []fabricVersion
+		if err := download.JSON(ctx, env.Client, strings.TrimSuffix(s.Meta, "/")+"/v3/versions/loader", &loaders); err != nil {
+			return Result{}, err
+		}
+		// Quilt marks no loader as stable; pre-releases carry a suffix.
+		for _, l := range loaders {
+			if !strings.Contains(l.Version, "-") {
+				loader = l.Version
+				break
+			}
+		}
+		if loader == "" {
+			return Result{}, fmt.Errorf("no Quilt loader release available")
+		}
+	}
+
+	repo := strings.TrimSuffix(s.Maven, "/") + "/org/quiltmc/quilt-installer"
+	metadata, err := download.Maven(ctx, env.Client, repo)
+	if err != nil {
+		return Result{}, err
+	}
+	version := metadata.Versioning.Release
+	if version == "" {
+		return Result{}, fmt.Errorf("no Quilt installer release listed")
+	}
+
+	const installer = "quilt-installer.jar"
+	url := fmt.Sprintf("%s/%s/quilt-installer-%s.jar", repo, version, version)
+	if err := downloadMavenArtifact(ctx, env, url, filepath.Join(dir, installer)); err != nil {
+		return Result{}, err
+	}
+	cmd := []string{"java", "-jar", installer, "install", "server", spec.MinecraftVersion, loader, "--download-server", "--install-dir=."}
+	if err := runInstaller(ctx, env, spec, dir, cmd, installer); err != nil {
+		return Result{}, err
+	}
+	return Result{Software: Quilt, MinecraftVersion: spec.MinecraftVersion, Version: loader, Jar: "quilt-server-launch.jar"}, nil
+}
+
+// stableGameVersions reads a Fabric-style game version list. The lists are already ordered newest first.
+func stableGameVersions(ctx context.Context, env Env, url string) ([]string, error) {
+	var all []fabricVersion
+	if err := download.JSON(ctx, env.Client, url, &all); err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, v := range all {
+		if v.Stable {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions, nil
+}
+
+// firstStable returns the first stable entry of a Fabric-style version list.
+func firstStable(ctx context.Context, env Env, url string) (string, error) {
+	var all []fabricVersion
+	if err := download.JSON(ctx, env.Client, url, &all); err != nil {
+		return "", err
+	}
+	for _, v := range all {
+		if v.Stable {
+			return v.Version, nil
+		}
+	}
+	return "", fmt.Errorf("no stable version listed at %s", url)
+}
@@ -0,0 +1,202 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+)
+
+// ForgeSource installs Forge by running its installer.
+type ForgeSource struct {
+	Maven      string
+	Promotions string // promotions_slim.json, maps "<minecraft>-recommended"/"-latest" to Forge versions
+}
+
+// forgePromotions returns the promoted Forge versions by key.
+func (s ForgeSource) forgePromotions(ctx context.Context, env Env) (map[string]string, error) {
+	var promotions struct {
+		Promos map[string]string `json:"promos"`
+	}
+	if err := download.JSON(ctx, env.Client, s.Promotions, &promotions); err != nil {
+		return nil, err
+	}
+	return promotions.Promos, nil
+}
+
+// Versions lists the Minecraft versions with a promoted Forge build, newest first.
+func (s ForgeSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	promos, err := s.forgePromotions(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var versions []string
+	for key := range promos {
+		version, _, ok := strings.Cut(key, "-")
+		if ok && !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	sortNewestFirst(versions)
+	return versions, nil
+}
+
+// Install runs the requested Forge installer, or the recommended (else latest) one for spec.MinecraftVersion.
+func (s ForgeSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	forge := spec.Version
+	if forge == "" {
+		promos, err := s.forgePromotions(ctx, env)
+		if err != nil {
+			return Result{}, err
+		}
+		if forge = promos[spec.MinecraftVersion+"-recommended"]; forge == "" {
+			forge = promos[spec.MinecraftVersion+"-latest"]
+		}
+		if forge == "" {
+			return Result{}, fmt.Errorf("no Forge build for minecraft %s", spec.MinecraftVersion)
+		}
+	}
+
+	version := spec.MinecraftVersion + "-" + forge
+	url := fmt.Sprintf("%s/net/minecraftforge/forge/%s/forge-%s-installer.jar", strings.TrimSuffix(s.Maven, "/"), version, version)
+	result, err := installForgeLike(ctx, env, spec, dir, url, "forge-installer.jar")
+	if err != nil {
+		return Result{}, err
+	}
+	result.Software, result.Version = Forge, forge
+	return result, nil
+}
+
+// NeoForgeSource installs NeoForge by running its installer.
+type NeoForgeSource struct {
+	Maven string
+}
+
+// neoForgeMinecraftVersion maps a NeoForge version to its Minecraft version: 21.1.77 is for 1.21.1, 21.0.10 for 1.21.
+func neoForgeMinecraftVersion(version string) string {
+	parts := versionParts(version)
+	if len(parts) < 2 {
+		return ""
+	}
+	if parts[1] == 0 {
+		return fmt.Sprintf("1.%d", parts[0])
+	}
+	return fmt.Sprintf("1.%d.%d", parts[0], parts[1])
+}
+
+// Versions lists the Minecraft versions NeoForge has been released for, newest first.
+func (s NeoForgeSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	metadata, err := download.Maven(ctx, env.Client, strings.TrimSuffix(s.Maven, "/")+"/net/neoforged/neoforge")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var versions []string
+	for _, v := range metadata.Versioning.Versions {
+		if mc := neoForgeMinecraftVersion(v); mc != "" && !seen[mc] {
+			seen[mc] = true
+			versions = append(versions, mc)
+		}
+	}
+	sortNewestFirst(versions)
+	return versions, nil
+}
+
+// Install runs the requested NeoForge installer, or the newest one for spec.MinecraftVersion.
+func (s NeoForgeSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	repo := strings.TrimSuffix(s.Maven, "/")
+
+	// NeoForge for 1.20.1 was still published under the Forge artifact name, with Forge-style versions.
+	artifact := "neoforge"
+	if spec.MinecraftVersion == "1.20.1" {
+		artifact = "forge"
+	}
+
+	neoForge := spec.Version
+	if neoForge == "" {
+		metadata, err := download.Maven(ctx, env.Client, repo+"/net/neoforged/"+artifact)
+		if err != nil {
+			return Result{}, err
+		}
+		// Versions are listed oldest first; prefer the newest non-beta build.
+		for _, v := range metadata.Versioning.Versions {
+			if artifact == "forge" {
+				if !strings.HasPrefix(v, "1.20.1-") {
+					continue
+				}
+				v = strings.TrimPrefix(v, "1.20.1-")
+			} else if neoForgeMinecraftVersion(v) != spec.MinecraftVersion {
+				continue
+			}
+			if neoForge == "" || strings.Contains(neoForge, "-") || !strings.Contains(v, "-") {
+				neoForge = v
+			}
+		}
+		if neoForge == "" {
+			return Result{}, fmt.Errorf("no NeoForge build for minecraft %s", spec.MinecraftVersion)
+		}
+	}
+
+	version := neoForge
+	if artifact == "forge" {
+		version = spec.MinecraftVersion + "-" + neoForge
+	}
+	url := fmt.Sprintf("%s/net/neoforged/%s/%s/%s-%s-installer.jar", repo, artifact, version, artifact, version)
+	result, err := installForgeLike(ctx, env, spec, dir, url, "neoforge-installer.jar")
+	if err != nil {
+		return Result{}, err
+	}
+	result.Software, result.Version = NeoForge, neoForge
+	return result, nil
+}
+
+// installForgeLike downloads a Forge or NeoForge installer, runs it in dir and works out how to launch the result.
+func installForgeLike(ctx context.Context, env Env, spec Spec, dir, installerURL, installer string) (Result, error) {
+	if err := downloadMavenArtifact(ctx, env, installerURL, filepath.Join(dir, installer)); err != nil {
+		return Result{}, err
+	}
+	if err := runInstaller(ctx, env, spec, dir, []string{"java", "-jar", installer, "--installServer"}, installer); err != nil {
+		return Result{}, err
+	}
+	os.Remove(filepath.Join(dir, installer+".log"))
+
+	result := Result{MinecraftVersion: spec.MinecraftVersion}
+	// Since 1.17 the installers generate run.sh, which reads its JVM flags from user_jvm_args.txt.
+	if _, err := os.Stat(filepath.Join(dir, "run.sh")); err == nil {
+		result.RunScript = "run.sh"
+		return result, nil
+	}
+	// Older installers produce a server jar instead.
+	jars, _ := filepath.Glob(filepath.Join(dir, "forge-*.jar"))
+	if len(jars) == 0 {
+		return Result{}, fmt.Errorf("installer produced neither run.sh nor a server jar")
+	}
+	result.Jar = filepath.Base(jars[0])
+	return result, nil
+}
+
+// downloadMavenArtifact downloads a Maven artifact to path, verified against the .sha1 file published next to it.
+func downloadMavenArtifact(ctx context.Context, env Env, url, path string) error {
+	sum, err := download.MavenSHA1(ctx, env.Client, url)
+	if err != nil {
+		return err
+	}
+	return download.File(ctx, env.Client, path, []string{url}, download.Hashes{SHA1: sum})
+}
+
+// runInstaller runs an installer jar in a container of spec's Java version and removes it afterwards.
+func runInstaller(ctx context.Context, env Env, spec Spec, dir string, cmd []string, installer string) error {
+	if env.Runner == nil {
+		return fmt.Errorf("no container runner configured to run %s", installer)
+	}
+	image := fmt.Sprintf("eclipse-temurin:%s-jdk", spec.JavaVersion)
+	if err := env.Runner.Run(ctx, image, dir, cmd); err != nil {
+		return fmt.Errorf("%s failed: %w", installer, err)
+	}
+	return os.Remove(filepath.Join(dir, installer))
+}
@@ -0,0 +1,205 @@
+// Package installer resolves and installs Minecraft server software (Vanilla, Paper, Purpur,
+// Fabric, Quilt, Forge and NeoForge) into a directory.
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Software identifies a kind of server software. The values match Template.ServerType.
+type Software string
+
+const (
+	Vanilla  Software = "vanilla"
+	Paper    Software = "paper"
+	Purpur   Software = "purpur"
+	Fabric   Software = "fabric"
+	Quilt    Software = "quilt"
+	Forge    Software = "forge"
+	NeoForge Software = "neoforge"
+)
+
+// ErrUnknownSoftware is returned for server software the installer has no source for.
+var ErrUnknownSoftware = errors.New("unsupported server software")
+
+// Spec describes what to install.
+type Spec struct {
+	Software         Software
+	MinecraftVersion string // Latest release when empty
+	Version          string // Loader version, or Paper/Purpur build; latest when empty
+	JavaVersion      string // Java runtime installers run with; derived from the Minecraft version when empty
+}
+
+// Result describes an installed server.
+type Result struct {
+	Software         Software
+	MinecraftVersion string
+	Version          string // Loader version or build that was installed, if the software has one
+	Jar              string // Server jar started with java -jar
+	RunScript        string // Launch script that reads its JVM flags from user_jvm_args.txt, used instead of Jar
+}
+
+// StartupCommand returns the start script for the installed server.
+func (r Result) StartupCommand(minMemoryMB, maxMemoryMB int) string {
+	memory := fmt.Sprintf("-Xmx%dM -Xms%dM", maxMemoryMB, minMemoryMB)
+	if r.RunScript != "" {
+		return fmt.Sprintf("echo \"%s\" > user_jvm_args.txt\nexec sh %s nogui\n", memory, r.RunScript)
+	}
+	return fmt.Sprintf("java %s -jar %s nogui\n", memory, r.Jar)
+}
+
+// Runner runs a command to completion in a throwaway container of the given image, with dir mounted
+// as its working directory. Loader installers that must execute Java run through it.
+type Runner interface {
+	Run(ctx context.Context, image, dir string, cmd []string) error
+}
+
+// Env is what sources use to reach upstream servers and run installers.
+type Env struct {
+	Client *http.Client
+	Runner Runner
+}
+
+// Source installs one kind of server software from its upstream version manifest.
+// Sources take their base URLs as fields, so a local mirror can stand in for the upstream APIs.
+type Source interface {
+	// Versions lists the Minecraft versions the source can install, newest first.
+	Versions(ctx context.Context, env Env) ([]string, error)
+	// Install installs spec into dir. spec.MinecraftVersion and spec.JavaVersion are always set.
+	Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error)
+}
+
+// DefaultSources returns the sources for the public upstream APIs.
+func DefaultSources() map[Software]Source {
+	return map[Software]Source{
+		Vanilla:  VanillaSource{ManifestURL: "https://piston-meta.mojang.com/mc/game/version_manifest_v2.json"},
+		Paper:    PaperSource{API: "https://fill.papermc.io", Project: "paper"},
+		Purpur:   PurpurSource{API: "https://api.purpurmc.org"},
+		Fabric:   FabricSource{Meta: "https://meta.fabricmc.net"},
+		Quilt:    QuiltSource{Meta: "https://meta.quiltmc.org", Maven: "https://maven.quiltmc.org/repository/release"},
+		Forge:    ForgeSource{Maven: "https://maven.minecraftforge.net", Promotions: "https://files.minecraftforge.net/net/minecraftforge/forge/promotions_slim.json"},
+		NeoForge: NeoForgeSource{Maven: "https://maven.neoforged.net/releases"},
+	}
+}
+
+// Installer installs server software from a set of sources.
+type Installer struct {
+	sources map[Software]Source
+	env     Env
+}
+
+// New creates a new Installer.
+func New(sources map[Software]Source, runner Runner) *Installer {
+	return &Installer{sources: sources, env: Env{Client: &http.Client{}, Runner: runner}}
+}
+
+// Software lists the kinds of server software the installer has a source for.
+func (i *Installer) Software() []Software {
+	software := make([]Software, 0, len(i.sources))
+	for s := range i.sources {
+		software = append(software, s)
+	}
+	sort.Slice(software, func(a, b int) bool { return software[a] < software[b] })
+	return software
+}
+
+// Versions lists the Minecraft versions software can be installed for, newest first.
+func (i *Installer) Versions(ctx context.Context, software Software) ([]string, error) {
+	source, ok := i.sources[software]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSoftware, software)
+	}
+	return source.Versions(ctx, i.env)
+}
+
+// Install installs spec into dir, which must exist.
+func (i *Installer) Install(ctx context.Context, spec Spec, dir string) (Result, error) {
+	source, ok := i.sources[spec.Software]
+	if !ok {
+		return Result{}, fmt.Errorf("%w: %q", ErrUnknownSoftware, spec.Software)
+	}
+	if spec.MinecraftVersion == "" || spec.MinecraftVersion == "latest" {
+		versions, err := source.Versions(ctx, i.env)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to list %s versions: %w", spec.Software, err)
+		}
+		if len(versions) == 0 {
+			return Result{}, fmt.Errorf("no %s versions available", spec.Software)
+		}
+		spec.MinecraftVersion = versions[0]
+	}
+	if spec.JavaVersion == "" {
+		spec.JavaVersion = JavaVersionFor(spec.MinecraftVersion)
+	}
+
+	result, err := source.Install(ctx, i.env, spec, dir)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to install %s %s: %w", spec.Software, spec.MinecraftVersion, err)
+	}
+	return result, nil
+}
+
+// JavaVersionFor returns the Java major version a Minecraft version needs.
+func JavaVersionFor(minecraftVersion string) string {
+	parts := versionParts(minecraftVersion)
+	if len(parts) > 0 && parts[0] >= 26 {
+		return "25" // Year-based releases, starting with 26.1
+	}
+	if len(parts) < 2 || parts[0] != 1 {
+		return "21" // Snapshots and unknown versions get the newest runtime
+	}
+	minor, patch := parts[1], 0
+	if len(parts) > 2 {
+		patch = parts[2]
+	}
+
+	switch {
+	case minor < 17:
+		return "8"
+	case minor == 17:
+		return "16"
+	case minor < 20 || (minor == 20 && patch < 5):
+		return "17"
+	default:
+		return "21"
+	}
+}
+
+// versionParts returns the leading numeric components of a dotted version, e.g. 1.20.4-pre1 -> [1 20 4].
+func versionParts(version string) []int {
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		digits := field
+		if end := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+			digits = field[:end]
+		}
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if len(digits) < len(field) {
+			break
+		}
+	}
+	return parts
+}
+
+// sortNewestFirst sorts dotted versions from newest to oldest.
+func sortNewestFirst(versions []string) {
+	sort.SliceStable(versions, func(a, b int) bool {
+		pa, pb := versionParts(versions[a]), versionParts(versions[b])
+		for i := 0; i < len(pa) && i < len(pb); i++ {
+			if pa[i] != pb[i] {
+				return pa[i] > pb[i]
+			}
+		}
+		return len(pa) > len(pb)
+	})
+}
@@ -0,0 +1,258 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newMirror serves routes as a stand-in for the upstream APIs. A route's value is served as JSON, with
+// "{mirror}" in strings replaced by the mirror's URL, unless it is []byte.
+func newMirror(t *testing.T, routes map[string]any) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if data, ok := body.([]byte); ok {
+			w.Write(data)
+			return
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.ReplaceAll(string(data), "{mirror}", srv.URL)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newInstaller(sources map[Software]Source) *Installer {
+	return New(sources, nil)
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func vanillaMirror(t *testing.T, jar []byte, sum string) *httptest.Server {
+	return newMirror(t, map[string]any{
+		"/manifest.json": map[string]any{"versions": []map[string]string{
+			{"id": "24w14a", "type": "snapshot", "url": "{mirror}/v/24w14a.json"},
+			{"id": "1.20.4", "type": "release", "url": "{mirror}/v/1.20.4.json"},
+			{"id": "1.20.3", "type": "release", "url": "{mirror}/v/1.20.3.json"},
+		}},
+		"/v/1.20.4.json": map[string]any{"downloads": map[string]any{
+			"server": map[string]string{"sha1": sum, "url": "{mirror}/server-1.20.4.jar"},
+		}},
+		"/v/1.20.3.json":     map[string]any{"downloads": map[string]any{}},
+		"/server-1.20.4.jar": jar,
+	})
+}
+
+func TestVanilla(t *testing.T) {
+	jar := []byte("vanilla server")
+	srv := vanillaMirror(t, jar, sha1Hex(jar))
+	i := newInstaller(map[Software]Source{Vanilla: VanillaSource{ManifestURL: srv.URL + "/manifest.json"}})
+	ctx := context.Background()
+
+	versions, err := i.Versions(ctx, Vanilla)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.20.4", "1.20.3"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Versions() = %v, want %v", versions, want)
+	}
+
+	dir := t.TempDir()
+	result, err := i.Install(ctx, Spec{Software: Vanilla}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Software: Vanilla, MinecraftVersion: "1.20.4", Jar: "server.jar"}); result != want {
+		t.Errorf("Install() = %+v, want %+v", result, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "server.jar")); got != string(jar) {
+		t.Errorf("server.jar = %q", got)
+	}
+
+	if _, err := i.Install(ctx, Spec{Software: Vanilla, MinecraftVersion: "1.20.3"}, t.TempDir()); err == nil {
+		t.Error("Install() of a version without a server download succeeded")
+	}
+	if _, err := i.Install(ctx, Spec{Software: Vanilla, MinecraftVersion: "1.7.10"}, t.TempDir()); err == nil {
+		t.Error("Install() of a version missing from the manifest succeeded")
+	}
+}
+
+func TestVanillaHashMismatch(t *testing.T) {
+	srv := vanillaMirror(t, []byte("tampered server"), sha1Hex([]byte("vanilla server")))
+	i := newInstaller(map[Software]Source{Vanilla: VanillaSource{ManifestURL: srv.URL + "/manifest.json"}})
+
+	dir := t.TempDir()
+	if _, err := i.Install(context.Background(), Spec{Software: Vanilla}, dir); err == nil || !strings.Contains(err.Error(), "sha1 mismatch") {
+		t.Fatalf("Install() = %v, want a sha1 mismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "server.jar")); !os.IsNotExist(err) {
+		t.Error("a jar that failed verification was left in place")
+	}
+}
+
+func TestPaper(t *testing.T) {
+	stable, experimental := []byte("paper build 497"), []byte("paper build 498")
+	build := func(id int, channel string, jar []byte) map[string]any {
+		return map[string]any{
+			"id":      id,
+			"channel": channel,
+			"downloads": map[string]any{"server:default": map[string]any{
+				"name":      "paper.jar",
+				"url":       "{mirror}/builds/" + channel + ".jar",
+				"checksums": map[string]string{"sha256": sha256Hex(jar)},
+			}},
+		}
+	}
+	srv := newMirror(t, map[string]any{
+		"/v3/projects/paper": map[string]any{"versions": map[string][]string{
+			"1.20": {"1.20.4", "1.20"},
+			"1.21": {"1.21.1", "1.21", "1.21.10"},
+		}},
+		"/v3/projects/paper/versions/1.20.4/builds": []map[string]any{
+			build(495, "STABLE", []byte("old")),
+			build(497, "STABLE", stable),
+			build(498, "ALPHA", experimental),
+		},
+		"/builds/STABLE.jar": stable,
+		"/builds/ALPHA.jar":  experimental,
+	})
+	i := newInstaller(map[Software]Source{Paper: PaperSource{API: srv.URL, Project: "paper"}})
+	ctx := context.Background()
+
+	versions, err := i.Versions(ctx, Paper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.21.10", "1.21.1", "1.21", "1.20.4", "1.20"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Versions() = %v, want %v", versions, want)
+	}
+
+	dir := t.TempDir()
+	result, err := i.Install(ctx, Spec{Software: Paper, MinecraftVersion: "1.20.4"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Software: Paper, MinecraftVersion: "1.20.4", Version: "497", Jar: "paper.jar"}); result != want {
+		t.Errorf("Install() = %+v, want the newest stable build %+v", result, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "paper.jar")); got != string(stable) {
+		t.Errorf("paper.jar = %q", got)
+	}
+
+	result, err = i.Install(ctx, Spec{Software: Paper, MinecraftVersion: "1.20.4", Version: "498"}, t.TempDir())
+	if err != nil || result.Version != "498" {
+		t.Errorf("Install() of build 498 = %+v, %v", result, err)
+	}
+	if _, err := i.Install(ctx, Spec{Software: Paper, MinecraftVersion: "1.20.4", Version: "1"}, t.TempDir()); err == nil {
+		t.Error("Install() of a missing build succeeded")
+	}
+}
+
+func TestFabric(t *testing.T) {
+	launcher := []byte("fabric launcher")
+	srv := newMirror(t, map[string]any{
+		"/v2/versions/game": []map[string]any{
+			{"version": "24w14a", "stable": false},
+			{"version": "1.20.4", "stable": true},
+			{"version": "1.20.3", "stable": true},
+		},
+		"/v2/versions/loader": []map[string]any{
+			{"version": "0.16.0-beta.1", "stable": false},
+			{"version": "0.15.7", "stable": true},
+		},
+		"/v2/versions/installer": []map[string]any{
+			{"version": "1.0.1", "stable": true},
+		},
+		"/v2/versions/loader/1.20.4/0.15.7/1.0.1/server/jar": launcher,
+	})
+	i := newInstaller(map[Software]Source{Fabric: FabricSource{Meta: srv.URL}})
+	ctx := context.Background()
+
+	versions, err := i.Versions(ctx, Fabric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.20.4", "1.20.3"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Versions() = %v, want %v", versions, want)
+	}
+
+	dir := t.TempDir()
+	result, err := i.Install(ctx, Spec{Software: Fabric}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{Software: Fabric, MinecraftVersion: "1.20.4", Version: "0.15.7", Jar: "fabric-server-launch.jar"}
+	if result != want {
+		t.Errorf("Install() = %+v, want %+v", result, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "fabric-server-launch.jar")); got != string(launcher) {
+		t.Errorf("fabric-server-launch.jar = %q", got)
+	}
+	if _, err := i.Install(ctx, Spec{Software: Fabric, Version: "0.14.0"}, t.TempDir()); err == nil {
+		t.Error("Install() of a loader the mirror doesn't have succeeded")
+	}
+}
+
+func TestUnknownSoftware(t *testing.T) {
+	i := newInstaller(map[Software]Source{})
+	if _, err := i.Install(context.Background(), Spec{Software: Forge}, t.TempDir()); err == nil {
+		t.Error("Install() without a source succeeded")
+	}
+}
+
+func TestJavaVersionFor(t *testing.T) {
+	tests := map[string]string{
+		"1.12.2":  "8",
+		"1.16.5":  "8",
+		"1.17.1":  "16",
+		"1.18.2":  "17",
+		"1.20.4":  "17",
+		"1.20.5":  "21",
+		"1.21.1":  "21",
+		"26.1":    "25",
+		"24w14a":  "21",
+		"unknown": "21",
+	}
+	for version, want := range tests {
+		if got := JavaVersionFor(version); got != want {
+			t.Errorf("JavaVersionFor(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
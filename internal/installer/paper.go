@@ -0,0 +1,126 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+)
+
+// PaperSource installs a PaperMC project (Paper, Folia, ...) through the Fill v3 API.
+type PaperSource struct {
+	API     string
+	Project string
+}
+
+// Versions lists the versions of the project, newest first.
+func (s PaperSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	var project struct {
+		Versions map[string][]string `json:"versions"` // Version group -> versions
+	}
+	if err := download.JSON(ctx, env.Client, fmt.Sprintf("%s/v3/projects/%s", strings.TrimSuffix(s.API, "/"), s.Project), &project); err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, group := range project.Versions {
+		versions = append(versions, group...)
+	}
+	sortNewestFirst(versions)
+	return versions, nil
+}
+
+// Install downloads the requested build, or the newest stable build, of spec.MinecraftVersion.
+func (s PaperSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	var builds []struct {
+		ID        int    `json:"id"`
+		Channel   string `json:"channel"`
+		Downloads map[string]struct {
+			Name      string `json:"name"`
+			URL       string `json:"url"`
+			Checksums struct {
+				SHA256 string `json:"sha256"`
+			} `json:"checksums"`
+		} `json:"downloads"`
+	}
+	url := fmt.Sprintf("%s/v3/projects/%s/versions/%s/builds", strings.TrimSuffix(s.API, "/"), s.Project, spec.MinecraftVersion)
+	if err := download.JSON(ctx, env.Client, url, &builds); err != nil {
+		return Result{}, err
+	}
+
+	best := -1
+	for n, build := range builds {
+		switch {
+		case spec.Version != "":
+			if strconv.Itoa(build.ID) == spec.Version {
+				best = n
+			}
+		case build.Channel == "STABLE" && (best < 0 || build.ID > builds[best].ID):
+			best = n
+		}
+	}
+	if best < 0 {
+		if spec.Version != "" {
+			return Result{}, fmt.Errorf("build %s not found", spec.Version)
+		}
+		return Result{}, fmt.Errorf("no stable build available")
+	}
+
+	build := builds[best]
+	server, ok := build.Downloads["server:default"]
+	if !ok {
+		return Result{}, fmt.Errorf("build %d has no server download", build.ID)
+	}
+	jar := s.Project + ".jar"
+	if err := download.File(ctx, env.Client, filepath.Join(dir, jar), []string{server.URL}, download.Hashes{SHA256: server.Checksums.SHA256}); err != nil {
+		return Result{}, err
+	}
+	return Result{Software: Software(s.Project), MinecraftVersion: spec.MinecraftVersion, Version: strconv.Itoa(build.ID), Jar: jar}, nil
+}
+
+// PurpurSource installs Purpur through its v2 API.
+type PurpurSource struct {
+	API string
+}
+
+// Versions lists the versions Purpur is built for, newest first.
+func (s PurpurSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	var project struct {
+		Versions []string `json:"versions"`
+	}
+	if err := download.JSON(ctx, env.Client, strings.TrimSuffix(s.API, "/")+"/v2/purpur", &project); err != nil {
+		return nil, err
+	}
+	sortNewestFirst(project.Versions)
+	return project.Versions, nil
+}
+
+// Install downloads the requested build, or the latest build, of spec.MinecraftVersion.
+func (s PurpurSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	build := spec.Version
+	if build == "" {
+		build = "latest"
+	}
+	base := fmt.Sprintf("%s/v2/purpur/%s/%s", strings.TrimSuffix(s.API, "/"), spec.MinecraftVersion, build)
+
+	var info struct {
+		Build  string `json:"build"`
+		Result string `json:"result"`
+		MD5    string `json:"md5"`
+	}
+	if err := download.JSON(ctx, env.Client, base, &info); err != nil {
+		return Result{}, err
+	}
+	if info.Result != "SUCCESS" {
+		return Result{}, fmt.Errorf("build %s did not succeed", info.Build)
+	}
+
+	// Download by build number so the jar matches the checksum even if a newer build lands meanwhile.
+	url := fmt.Sprintf("%s/v2/purpur/%s/%s/download", strings.TrimSuffix(s.API, "/"), spec.MinecraftVersion, info.Build)
+	if err := download.File(ctx, env.Client, filepath.Join(dir, "purpur.jar"), []string{url}, download.Hashes{MD5: info.MD5}); err != nil {
+		return Result{}, err
+	}
+	return Result{Software: Purpur, MinecraftVersion: spec.MinecraftVersion, Version: info.Build, Jar: "purpur.jar"}, nil
+}
@@ -0,0 +1,70 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+)
+
+// VanillaSource installs the official server jar listed in Mojang's version manifest.
+type VanillaSource struct {
+	ManifestURL string
+}
+
+type vanillaManifest struct {
+	Versions []struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"versions"`
+}
+
+// Versions lists the release versions in the manifest. The manifest is already ordered newest first.
+func (s VanillaSource) Versions(ctx context.Context, env Env) ([]string, error) {
+	var manifest vanillaManifest
+	if err := download.JSON(ctx, env.Client, s.ManifestURL, &manifest); err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, v := range manifest.Versions {
+		if v.Type == "release" {
+			versions = append(versions, v.ID)
+		}
+	}
+	return versions, nil
+}
+
+// Install downloads server.jar for spec.MinecraftVersion.
+func (s VanillaSource) Install(ctx context.Context, env Env, spec Spec, dir string) (Result, error) {
+	var manifest vanillaManifest
+	if err := download.JSON(ctx, env.Client, s.ManifestURL, &manifest); err != nil {
+		return Result{}, err
+	}
+	for _, v := range manifest.Versions {
+		if v.ID != spec.MinecraftVersion {
+			continue
+		}
+		var meta struct {
+			Downloads struct {
+				Server *struct {
+					SHA1 string `json:"sha1"`
+					URL  string `json:"url"`
+				} `json:"server"`
+			} `json:"downloads"`
+		}
+		if err := download.JSON(ctx, env.Client, v.URL, &meta); err != nil {
+			return Result{}, err
+		}
+		if meta.Downloads.Server == nil {
+			return Result{}, fmt.Errorf("minecraft %s has no server download", v.ID)
+		}
+		server := meta.Downloads.Server
+		if err := download.File(ctx, env.Client, filepath.Join(dir, "server.jar"), []string{server.URL}, download.Hashes{SHA1: server.SHA1}); err != nil {
+			return Result{}, err
+		}
+		return Result{Software: Vanilla, MinecraftVersion: v.ID, Jar: "server.jar"}, nil
+	}
+	return Result{}, fmt.Errorf("minecraft version %s not found", spec.MinecraftVersion)
+}
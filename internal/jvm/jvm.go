@@ -0,0 +1,298 @@
+// Package jvm generates the start scripts servers are launched with from their JVM profile.
+package jvm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// Profile names.
+const (
+	Aikar   = "aikar"
+	ZGC     = "zgc"
+	Minimal = "minimal"
+)
+
+// DefaultProfile is the profile new servers start with.
+const DefaultProfile = Aikar
+
+var (
+	// ErrInvalidSettings is returned for unknown profiles and arguments that can't be passed to java.
+	ErrInvalidSettings = errors.New("invalid JVM settings")
+	// ErrUnmanagedLaunch is returned for servers started by a script of their own, whose JVM flags can't be set.
+	ErrUnmanagedLaunch = errors.New("the server is started by its own script, so its JVM flags can't be managed")
+)
+
+// profile is a Profile together with the flags it adds.
+type profile struct {
+	models.JVMProfile
+	fullHeap bool // Start with the whole heap committed (-Xms = -Xmx)
+	flags    func(maxMemoryMB, javaVersion int) []string
+}
+
+var profiles = []profile{
+	{
+		JVMProfile: models.JVMProfile{
+			Name:        Aikar,
+			Description: "G1 tuned for Minecraft's allocation pattern (Aikar's flags). Works well for most servers.",
+		},
+		fullHeap: true,
+		flags:    aikarFlags,
+	},
+	{
+		JVMProfile: models.JVMProfile{
+			Name:           ZGC,
+			Description:    "Generational ZGC. Sub-millisecond pauses for large heaps, at the cost of some throughput.",
+			MinJavaVersion: 21,
+		},
+		fullHeap: true,
+		flags:    zgcFlags,
+	},
+	{
+		JVMProfile: models.JVMProfile{
+			Name:        Minimal,
+			Description: "Only the heap size, leaving everything else to the JVM's defaults.",
+		},
+		flags: func(int, int) []string { return nil },
+	},
+}
+
+// Profiles lists the available profiles.
+func Profiles() []models.JVMProfile {
+	var list []models.JVMProfile
+	for _, p := range profiles {
+		list = append(list, p.JVMProfile)
+	}
+	return list
+}
+
+// DefaultSettings returns the settings new servers start with, with extraArgs added after the profile's flags.
+func DefaultSettings(extraArgs []string) models.JVMSettings {
+	return models.JVMSettings{Profile: DefaultProfile, ExtraArgs: extraArgs}
+}
+
+func findProfile(name string) (profile, bool) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return profile{}, false
+}
+
+// aikarFlags are the G1 flags from https://docs.papermc.io/paper/aikars-flags, with the settings
+// recommended for heaps above 12GB.
+func aikarFlags(maxMemoryMB, _ int) []string {
+	newSize, maxNewSize, regionSize, reserve, occupancy := "30", "40", "8M", "20", "15"
+	if maxMemoryMB > 12*1024 {
+		newSize, maxNewSize, regionSize, reserve, occupancy = "40", "50", "16M", "15", "20"
+	}
+	return []string{
+		"-XX:+UseG1GC",
+		"-XX:+ParallelRefProcEnabled",
+		"-XX:MaxGCPauseMillis=200",
+		"-XX:+UnlockExperimentalVMOptions",
+		"-XX:+DisableExplicitGC",
+		"-XX:+AlwaysPreTouch",
+		"-XX:G1NewSizePercent=" + newSize,
+		"-XX:G1MaxNewSizePercent=" + maxNewSize,
+		"-XX:G1HeapRegionSize=" + regionSize,
+		"-XX:G1ReservePercent=" + reserve,
+		"-XX:G1HeapWastePercent=5",
+		"-XX:G1MixedGCCountTarget=4",
+		"-XX:InitiatingHeapOccupancyPercent=" + occupancy,
+		"-XX:G1MixedGCLiveThresholdPercent=90",
+		"-XX:G1RSetUpdatingPauseTimePercent=5",
+		"-XX:SurvivorRatio=32",
+		"-XX:+PerfDisableSharedMem",
+		"-XX:MaxTenuringThreshold=1",
+		"-Dusing.aikars.flags=https://mcflags.emc.gs",
+		"-Daikars.new.flags=true",
+	}
+}
+
+func zgcFlags(_, javaVersion int) []string {
+	flags := []string{"-XX:+UseZGC"}
+	// Generational mode is opt-in on Java 21 and 22 and the only mode from Java 23 on.
+	if javaVersion < 23 {
+		flags = append(flags, "-XX:+ZGenerational")
+	}
+	return append(flags,
+		"-XX:+AlwaysPreTouch",
+		"-XX:+DisableExplicitGC",
+		"-XX:+PerfDisableSharedMem",
+	)
+}
+
+// propertyKeyPattern matches the system property names accepted in settings.
+var propertyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// Validate checks that settings name a profile that runs on javaVersion, and that their arguments are
+// ones java can be given. A javaVersion of 0 skips the version check.
+func Validate(settings models.JVMSettings, javaVersion int) error {
+	p, ok := findProfile(settings.Profile)
+	if !ok {
+		return fmt.Errorf("%w: unknown profile %q", ErrInvalidSettings, settings.Profile)
+	}
+	if javaVersion > 0 && javaVersion < p.MinJavaVersion {
+		return fmt.Errorf("%w: the %s profile needs Java %d or newer, but the server runs Java %d", ErrInvalidSettings, p.Name, p.MinJavaVersion, javaVersion)
+	}
+	for _, arg := range settings.ExtraArgs {
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("%w: %q is not a JVM option", ErrInvalidSettings, arg)
+		case strings.HasPrefix(arg, "-Xmx"), strings.HasPrefix(arg, "-Xms"):
+			return fmt.Errorf("%w: the heap size is set from the server's memory limit", ErrInvalidSettings)
+		case arg == "-jar", arg == "-cp", arg == "-classpath", strings.HasPrefix(arg, "--class-path"):
+			return fmt.Errorf("%w: %s is set by the server's launch command", ErrInvalidSettings, arg)
+		case strings.ContainsAny(arg, "\n\r\x00"):
+			return fmt.Errorf("%w: arguments can't contain line breaks", ErrInvalidSettings)
+		}
+	}
+	for key, value := range settings.SystemProperties {
+		if !propertyKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: %q is not a valid system property name", ErrInvalidSettings, key)
+		}
+		if strings.ContainsAny(value, "\n\r\x00") {
+			return fmt.Errorf("%w: system property %s can't contain line breaks", ErrInvalidSettings, key)
+		}
+	}
+	return nil
+}
+
+// Args returns the JVM arguments for settings: the heap size, the profile's flags, the extra arguments
+// and the system properties, in that order so later ones can override earlier ones.
+func Args(settings models.JVMSettings, maxMemoryMB, javaVersion int) ([]string, error) {
+	if err := Validate(settings, javaVersion); err != nil {
+		return nil, err
+	}
+	p, _ := findProfile(settings.Profile)
+
+	minMemoryMB := min(1024, maxMemoryMB)
+	if p.fullHeap {
+		minMemoryMB = maxMemoryMB
+	}
+	args := []string{fmt.Sprintf("-Xms%dM", minMemoryMB), fmt.Sprintf("-Xmx%dM", maxMemoryMB)}
+	args = append(args, p.flags(maxMemoryMB, javaVersion)...)
+	args = append(args, settings.ExtraArgs...)
+
+	keys := make([]string, 0, len(settings.SystemProperties))
+	for key := range settings.SystemProperties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "-D"+key+"="+settings.SystemProperties[key])
+	}
+	return args, nil
+}
+
+// Launch is how a server's Java process is started.
+type Launch struct {
+	Jar       string // Server jar started with java -jar
+	RunScript string // Launch script that reads its JVM flags from user_jvm_args.txt, used instead of Jar
+}
+
+// Managed reports whether the launch is one whose JVM flags can be generated.
+func (l Launch) Managed() bool {
+	return l.Jar != "" || l.RunScript != ""
+}
+
+var (
+	jarPattern       = regexp.MustCompile(`\bjava\b.*\s-jar\s+('[^']*'|\S+)`)
+	runScriptPattern = regexp.MustCompile(`(?m)^(?:exec\s+)?sh\s+('[^']*'|\S+\.sh)\b`)
+)
+
+// ParseLaunch recognizes the launch of a start script or template startup command: a java -jar command line
+// or a launch script run with sh. Anything else, such as a server's own start script, is unmanaged.
+func ParseLaunch(command string) Launch {
+	if m := jarPattern.FindStringSubmatch(command); m != nil {
+		return Launch{Jar: strings.Trim(m[1], "'")}
+	}
+	if m := runScriptPattern.FindStringSubmatch(command); m != nil {
+		if script := strings.Trim(m[1], "'"); script != "start.sh" {
+			return Launch{RunScript: script}
+		}
+	}
+	return Launch{}
+}
+
+// ReadLaunch recognizes the launch of the start.sh in a server's data directory.
+func ReadLaunch(dataPath string) (Launch, error) {
+	dataDir, err := sandbox.Open(dataPath)
+	if err != nil {
+		return Launch{}, err
+	}
+	defer dataDir.Close()
+	script, err := dataDir.ReadFile("start.sh")
+	if err != nil {
+		return Launch{}, fmt.Errorf("could not read start.sh: %w", err)
+	}
+	return ParseLaunch(string(script)), nil
+}
+
+// WriteStartScript regenerates start.sh in a server's data directory from its JVM settings. For launch
+// scripts the flags go to the user_jvm_args.txt file the script reads.
+func WriteStartScript(dataPath string, launch Launch, settings models.JVMSettings, maxMemoryMB, javaVersion int) error {
+	if !launch.Managed() {
+		return ErrUnmanagedLaunch
+	}
+	args, err := Args(settings, maxMemoryMB, javaVersion)
+	if err != nil {
+		return err
+	}
+	dataDir, err := sandbox.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+
+	header := fmt.Sprintf("# Generated from the %s JVM profile. Change the server's JVM settings instead of editing this file.\n", settings.Profile)
+	var script string
+	if launch.RunScript != "" {
+		var argFile strings.Builder
+		argFile.WriteString(header)
+		for _, arg := range args {
+			argFile.WriteString(argFileQuote(arg) + "\n")
+		}
+		if err := dataDir.WriteFile("user_jvm_args.txt", []byte(argFile.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write user_jvm_args.txt: %w", err)
+		}
+		script = fmt.Sprintf("exec sh %s nogui\n", shellQuote(launch.RunScript))
+	} else {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shellQuote(arg)
+		}
+		script = fmt.Sprintf("exec java %s -jar %s nogui\n", strings.Join(quoted, " "), shellQuote(launch.Jar))
+	}
+
+	if err := dataDir.WriteFile("start.sh", []byte("#!/bin/sh\n"+header+script), 0755); err != nil {
+		return fmt.Errorf("failed to write start.sh: %w", err)
+	}
+	return nil
+}
+
+// shellSafe matches arguments that need no quoting in a shell script.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./\-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// argFileQuote quotes an argument for a java @argfile such as user_jvm_args.txt.
+func argFileQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'#\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -18,4 +19,7 @@ func Init() {
 
 	// Add a hook to include the caller's file and line number
 	log.Logger = log.With().Caller().Logger()
+
+	// Events logged with .Ctx(ctx) carry the trace and span they belong to
+	log.Logger = log.Logger.Hook(tracing.LogHook{})
 }
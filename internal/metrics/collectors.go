@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// ServerLister is the part of the server service the server collector needs.
+type ServerLister interface {
+	GetAllServers(ctx context.Context) ([]models.Server, error)
+}
+
+// ClientCounter is the part of the websocket hub the hub collector needs.
+type ClientCounter interface {
+	ClientCount() int
+}
+
+// knownStatuses are exported as a state set so dashboards can graph every state, including zeroes.
+var knownStatuses = []string{"online", "offline", "starting", "stopping"}
+
+var serverLabels = []string{"server_id", "server_name"}
+
+// serverCollector reads server state from the database on every scrape.
+type serverCollector struct {
+	servers ServerLister
+
+	status     *prometheus.Desc
+	players    *prometheus.Desc
+	playersMax *prometheus.Desc
+	cpu        *prometheus.Desc
+	ram        *prometheus.Desc
+	storage    *prometheus.Desc
+	tps        *prometheus.Desc
+	mspt       *prometheus.Desc
+	heapUsed   *prometheus.Desc
+}
+
+// RegisterServers exports per-server gauges backed by the given lister.
+func RegisterServers(servers ServerLister) {
+	desc := func(name, help string, extra ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "server", name), help, append(append([]string{}, serverLabels...), extra...), nil)
+	}
+	registry.MustRegister(&serverCollector{
+		servers:    servers,
+		status:     desc("status", "1 if the server is in the given status, 0 otherwise.", "status"),
+		players:    desc("players", "Players currently online."),
+		playersMax: desc("players_max", "Maximum player slots."),
+		cpu:        desc("cpu_percent", "Container CPU usage in percent."),
+		ram:        desc("ram_percent", "Container memory usage in percent of its limit."),
+		storage:    desc("storage_percent", "Data directory usage in percent of the storage allowance."),
+		tps:        desc("tps", "Ticks per second reported by the server."),
+		mspt:       desc("mspt", "Milliseconds per tick reported by the server."),
+		heapUsed:   desc("heap_used_bytes", "JVM heap in use."),
+	})
+}
+
+// Describe implements prometheus.Collector.
+func (c *serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{c.status, c.players, c.playersMax, c.cpu, c.ram, c.storage, c.tps, c.mspt, c.heapUsed} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *serverCollector) Collect(ch chan<- prometheus.Metric) {
+	servers, err := c.servers.GetAllServers(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("Metrics: Failed to list servers for scrape")
+		return
+	}
+
+	gauge := func(d *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+	}
+
+	for _, s := range servers {
+		for _, status := range knownStatuses {
+			v := 0.0
+			if s.Status == status {
+				v = 1
+			}
+			gauge(c.status, v, s.ID, s.Name, status)
+		}
+		gauge(c.players, float64(s.Players.Current), s.ID, s.Name)
+		gauge(c.playersMax, float64(s.Players.Max), s.ID, s.Name)
+		gauge(c.cpu, s.Resources.CPU, s.ID, s.Name)
+		gauge(c.ram, s.Resources.RAM, s.ID, s.Name)
+		gauge(c.storage, float64(s.Resources.Storage), s.ID, s.Name)
+
+		if game := s.Resources.Game; game != nil {
+			if game.TPS != nil {
+				gauge(c.tps, *game.TPS, s.ID, s.Name)
+			}
+			if game.MSPT != nil {
+				gauge(c.mspt, *game.MSPT, s.ID, s.Name)
+			}
+			if game.HeapUsedMB != nil {
+				gauge(c.heapUsed, *game.HeapUsedMB*1024*1024, s.ID, s.Name)
+			}
+		}
+	}
+}
+
+// RegisterWebsocketHub exports the number of connected websocket clients.
+func RegisterWebsocketHub(hub ClientCounter) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Websocket clients currently connected to the hub.",
+	}, func() float64 {
+		return float64(hub.ClientCount())
+	}))
+}
@@ -0,0 +1,164 @@
+// Package metrics exposes ender-deploy state in the Prometheus exposition format.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const namespace = "enderdeploy"
+
+// registry is separate from the default registerer so only our metrics are exported.
+var registry = prometheus.NewRegistry()
+
+var (
+	backupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backups_total",
+		Help:      "Backup operations by operation (create, restore) and result (success, failure).",
+	}, []string{"operation", "result"})
+
+	serverRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "server_restarts_total",
+		Help:      "Server restarts requested through the API or a schedule.",
+	}, []string{"server_id"})
+
+	serverCrashesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "server_crashes_total",
+		Help:      "Times a server's container stopped while it was expected to be running.",
+	}, []string{"server_id"})
+
+	schedulerRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_runs_total",
+		Help:      "Scheduled task executions by task type and outcome (success, failure).",
+	}, []string{"task_type", "outcome"})
+
+	websocketOverflowsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_overflows_total",
+		Help:      "Messages that found a websocket client's queue full, by what was done (drop, disconnect).",
+	}, []string{"policy"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		backupsTotal,
+		serverRestartsTotal,
+		serverCrashesTotal,
+		schedulerRunsTotal,
+		websocketOverflowsTotal,
+		httpRequestDuration,
+	)
+}
+
+// resultLabel maps an error to the "success"/"failure" label value.
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveBackup records the outcome of a backup operation ("create" or "restore").
+func ObserveBackup(operation string, err error) {
+	backupsTotal.WithLabelValues(operation, resultLabel(err)).Inc()
+}
+
+// ObserveServerRestart records a restart of a server.
+func ObserveServerRestart(serverID string) {
+	serverRestartsTotal.WithLabelValues(serverID).Inc()
+}
+
+// ObserveServerCrash records a server's container stopping unexpectedly.
+func ObserveServerCrash(serverID string) {
+	serverCrashesTotal.WithLabelValues(serverID).Inc()
+}
+
+// ObserveSchedulerRun records the outcome of a scheduled task.
+func ObserveSchedulerRun(taskType string, err error) {
+	schedulerRunsTotal.WithLabelValues(taskType, resultLabel(err)).Inc()
+}
+
+// ObserveWebsocketOverflow records a message that didn't fit in a websocket client's queue.
+func ObserveWebsocketOverflow(policy string) {
+	websocketOverflowsTotal.WithLabelValues(policy).Inc()
+}
+
+// Handler returns the HTTP handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RequireScrapeToken guards the metrics endpoint with a bearer token that is separate from user JWTs.
+// If no token is configured the endpoint is disabled altogether.
+func RequireScrapeToken(token string) func(http.Handler) http.Handler {
+	if token == "" {
+		log.Warn().Msg("METRICS_TOKEN not set; the /metrics endpoint is disabled.")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			authHeader := r.Header.Get("Authorization")
+			if len(authHeader) <= 7 || !strings.EqualFold(authHeader[:7], "bearer ") {
+				http.Error(w, "Missing scrape token", http.StatusUnauthorized)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(authHeader[7:])), []byte(token)) != 1 {
+				http.Error(w, "Invalid scrape token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware records request latency per chi route pattern.
+// Websocket upgrades are skipped since their "latency" is the lifetime of the connection.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
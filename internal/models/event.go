@@ -9,5 +9,6 @@ type Event struct {
 	Level     string    `json:"level"` // e.g., "info", "warn", "error"
 	Message   string    `json:"message"`
 	ServerID  *string   `json:"serverId,omitempty"` // Nullable for system-wide events
+	TraceID   string    `json:"traceId,omitempty"`  // Trace that produced the event, if it was traced
 	CreatedAt time.Time `json:"createdAt"`
 }
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// FileUpload is a chunked upload of a file into a server's data directory. Chunks are sent in order,
+// each starting at Offset, so an interrupted upload resumes from there.
+type FileUpload struct {
+	ID        string    `json:"id"`
+	ServerID  string    `json:"serverId"`
+	Path      string    `json:"path"` // Where the file goes once complete, relative to the data directory
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"` // Bytes received so far
+	Overwrite bool      `json:"overwrite"`
+	Complete  bool      `json:"complete"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// FileSearchResult is a file whose name or content matched a search.
+type FileSearchResult struct {
+	Path  string `json:"path"` // Relative to the data directory
+	IsDir bool   `json:"isDir"`
+	Size  int64  `json:"size"`
+	Line  int    `json:"line,omitempty"` // Line of the first content match
+	Text  string `json:"text,omitempty"` // That line, shortened if long
+}
+
+// FileVersion is a previous version of a server file, kept when the file was overwritten through the file
+// manager.
+type FileVersion struct {
+	ID        string    `json:"id"`
+	ServerID  string    `json:"serverId"`
+	Path      string    `json:"path"`   // Relative to the data directory
+	Author    string    `json:"author"` // Who replaced this version, if known
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"` // When this version was replaced
+}
+
+// ConfigIssue is a problem found in a config file: a syntax error, or a setting whose value the server
+// would reject.
+type ConfigIssue struct {
+	Line    int    `json:"line,omitempty"`   // 1-based; 0 if unknown
+	Column  int    `json:"column,omitempty"` // 1-based; 0 if unknown
+	Key     string `json:"key,omitempty"`    // Dotted path of the setting, for values that don't fit its schema
+	Message string `json:"message"`
+}
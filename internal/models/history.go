@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// HistoryQuery describes a time range and bucket width for resource history.
+type HistoryQuery struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// MetricStats holds the aggregate of one metric within a history bucket.
+type MetricStats struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+// HistoryBucket is one step-wide bucket of resource history for a server.
+// Metric fields are nil when no sample in the bucket had a value for them.
+type HistoryBucket struct {
+	Timestamp  time.Time    `json:"timestamp"` // Start of the bucket
+	Samples    int64        `json:"samples"`
+	CPU        *MetricStats `json:"cpu,omitempty"`
+	RAM        *MetricStats `json:"ram,omitempty"`
+	Players    *MetricStats `json:"players,omitempty"`
+	TPS        *MetricStats `json:"tps,omitempty"`
+	MSPT       *MetricStats `json:"mspt,omitempty"`
+	HeapUsedMB *MetricStats `json:"heapUsedMB,omitempty"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running operation executed in the background by the job workers.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`   // e.g., "server.create", "backup.restore"
+	Status     string          `json:"status"` // One of the Job* status constants
+	ServerID   *string         `json:"serverId,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"` // Input of the operation
+	Result     json.RawMessage `json:"result,omitempty"`  // Output of the operation once it succeeded
+	Error      string          `json:"error,omitempty"`
+	Progress   JobProgress     `json:"progress"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job has reached a final status.
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// JobProgress describes how far a running job has come.
+type JobProgress struct {
+	Stage   string `json:"stage,omitempty"` // e.g., "unzipping", "pulling image"
+	Current int64  `json:"current"`
+	Total   int64  `json:"total"`          // 0 if unknown
+	Unit    string `json:"unit,omitempty"` // e.g., "bytes", "files", "layers"
+}
@@ -0,0 +1,16 @@
+package models
+
+// JVMSettings are the JVM flags a server is started with. The heap size is not among them:
+// -Xms and -Xmx are computed from the server's MaxMemoryMB.
+type JVMSettings struct {
+	Profile          string            `json:"profile"`                    // Name of a JVMProfile
+	ExtraArgs        []string          `json:"extraArgs,omitempty"`        // Passed to java after the profile's flags
+	SystemProperties map[string]string `json:"systemProperties,omitempty"` // Passed to java as -Dkey=value
+}
+
+// JVMProfile is a named set of JVM flags servers can be started with.
+type JVMProfile struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	MinJavaVersion int    `json:"minJavaVersion,omitempty"` // Oldest Java major version the flags work on
+}
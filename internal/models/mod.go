@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Mod is a mod or plugin jar installed on a server.
+type Mod struct {
+	FileName  string     `json:"fileName"`  // Name of the jar without the .disabled suffix
+	Directory string     `json:"directory"` // "mods" or "plugins"
+	Enabled   bool       `json:"enabled"`
+	Size      int64      `json:"size"`
+	Modified  time.Time  `json:"modified"`
+	Mods      []ModInfo  `json:"mods"` // What the jar declares; empty for libraries without metadata
+	Issues    []ModIssue `json:"issues,omitempty"`
+}
+
+// ModInfo is the metadata a jar declares in fabric.mod.json, quilt.mod.json, mods.toml or plugin.yml.
+type ModInfo struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name,omitempty"`
+	Version      string          `json:"version,omitempty"`
+	Loader       string          `json:"loader"`               // "fabric", "quilt", "forge", "neoforge" or "bukkit"
+	ClientOnly   bool            `json:"clientOnly,omitempty"` // Crashes or does nothing on a dedicated server
+	Provides     []string        `json:"provides,omitempty"`   // Additional IDs, including those of bundled jars
+	Dependencies []ModDependency `json:"dependencies,omitempty"`
+}
+
+// ModDependency is a dependency a mod declares.
+type ModDependency struct {
+	ID           string `json:"id"`
+	VersionRange string `json:"versionRange,omitempty"`
+	Required     bool   `json:"required"`
+}
+
+// ModIssue is a problem found with an installed jar.
+type ModIssue struct {
+	Type    string `json:"type"` // "missing_dependency", "duplicate_id", "client_only" or "invalid_jar"
+	ModID   string `json:"modId,omitempty"`
+	Message string `json:"message"`
+}
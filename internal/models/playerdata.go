@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// PlayerDataSummary is a player a world has saved data for.
+type PlayerDataSummary struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name,omitempty"` // From the server's user cache, if the player is in it
+	Modified  time.Time `json:"modified"`
+	HasBackup bool      `json:"hasBackup"`       // The game's copy of the previous save, <uuid>.dat_old, exists
+	Error     string    `json:"error,omitempty"` // Why the file couldn't be read
+}
+
+// PlayerData is the state of a player saved in a world's playerdata/<uuid>.dat.
+type PlayerData struct {
+	PlayerDataSummary
+	Dimension  string          `json:"dimension"`
+	Position   PlayerPosition  `json:"position"`
+	GameMode   string          `json:"gameMode"` // "survival", "creative", "adventure" or "spectator"
+	Health     float32         `json:"health"`
+	FoodLevel  int32           `json:"foodLevel"`
+	XPLevel    int32           `json:"xpLevel"`
+	XPProgress float32         `json:"xpProgress"` // Progress towards the next level, from 0 to 1
+	XPTotal    int32           `json:"xpTotal"`
+	Inventory  []InventoryItem `json:"inventory"`
+	EnderChest []InventoryItem `json:"enderChest"`
+}
+
+// PlayerPosition is a position in a dimension.
+type PlayerPosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// InventoryItem is a stack of items in a slot. Slots 0-8 are the hotbar, 9-35 the rest of the inventory,
+// 100-103 armour from boots to helmet and -106 the off hand.
+type InventoryItem struct {
+	Slot   int8   `json:"slot"`
+	ID     string `json:"id"`
+	Count  int32  `json:"count"`
+	Tagged bool   `json:"tagged"` // Carries enchantments, a name or other data, kept unless the slot's item changes
+}
+
+// PlayerDataUpdate is a change to a player's saved state. Fields left out are not changed, and a given
+// inventory replaces the whole inventory.
+type PlayerDataUpdate struct {
+	Dimension  *string          `json:"dimension,omitempty"`
+	Position   *PlayerPosition  `json:"position,omitempty"`
+	GameMode   *string          `json:"gameMode,omitempty"`
+	Health     *float32         `json:"health,omitempty"`
+	FoodLevel  *int32           `json:"foodLevel,omitempty"`
+	XPLevel    *int32           `json:"xpLevel,omitempty"`
+	XPProgress *float32         `json:"xpProgress,omitempty"`
+	XPTotal    *int32           `json:"xpTotal,omitempty"`
+	Inventory  *[]InventoryItem `json:"inventory,omitempty"`
+	EnderChest *[]InventoryItem `json:"enderChest,omitempty"`
+}
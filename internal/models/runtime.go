@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// JavaRuntime is a container image servers run on.
+type JavaRuntime struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image"`
+	JavaVersion int       `json:"javaVersion"`           // Java major version, e.g. 21
+	VersionInfo string    `json:"versionInfo,omitempty"` // Runtime line of `java -version`, identifies the vendor and build
+	Builtin     bool      `json:"builtin"`               // Built-in Temurin images can't be removed
+	CreatedAt   time.Time `json:"createdAt,omitzero"`    // Zero for built-in runtimes
+}
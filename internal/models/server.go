@@ -14,12 +14,17 @@ type Server struct {
 	Port              int            `json:"port"`
 	MinecraftVersion  string         `json:"minecraftVersion"`
 	JavaVersion       string         `json:"javaVersion"`
+	ServerType        string         `json:"serverType,omitempty"`    // Server software, e.g. "paper"; empty for uploaded servers
+	LoaderVersion     string         `json:"loaderVersion,omitempty"` // Loader version or build of the server software, if known
+	RuntimeID         string         `json:"runtimeId,omitempty"`     // Java runtime the container runs; empty for the built-in one matching JavaVersion
 	Players           PlayerInfo     `json:"players"`
 	Resources         ResourceUsage  `json:"resources"`
 	IPAddress         string         `json:"ipAddress"`
 	Modpack           *ModpackInfo   `json:"modpack,omitempty"`
 	TemplateID        string         `json:"templateId,omitempty"`
+	TemplateVersion   int            `json:"templateVersion,omitempty"` // Version of the template the server was created from
 	MaxMemoryMB       int            `json:"maxMemoryMB"`
+	JVM               JVMSettings    `json:"jvm"`
 	DockerContainerID string         `json:"-"` // Internal use, not exposed to client
 	RCONPassword      string         `json:"-"` // Internal use, added for server-specific RCON
 	DataPath          string         `json:"-"` // Internal use, not exposed to client
@@ -35,9 +40,22 @@ type PlayerInfo struct {
 
 // ResourceUsage holds CPU, RAM, and Storage percentages.
 type ResourceUsage struct {
-	CPU     float64 `json:"cpu"` // As percentage
-	RAM     float64 `json:"ram"` // As percentage
-	Storage int     `json:"storage"`
+	CPU     float64      `json:"cpu"` // As percentage
+	RAM     float64      `json:"ram"` // As percentage
+	Storage int          `json:"storage"`
+	Game    *GameMetrics `json:"game,omitempty"` // Nil until the metrics collector has sampled the server
+}
+
+// GameMetrics holds tick and JVM measurements read from inside the Minecraft process.
+// Fields are nil when the server doesn't expose them (e.g. no TPS command on vanilla).
+type GameMetrics struct {
+	TPS        *float64 `json:"tps,omitempty"`
+	MSPT       *float64 `json:"mspt,omitempty"` // Milliseconds per tick
+	HeapUsedMB *float64 `json:"heapUsedMB,omitempty"`
+	HeapMaxMB  *float64 `json:"heapMaxMB,omitempty"`
+	GCCount    *int64   `json:"gcCount,omitempty"`  // Total collections since JVM start
+	GCTimeMs   *int64   `json:"gcTimeMs,omitempty"` // Total time spent in GC since JVM start
+	Source     string   `json:"source,omitempty"`   // "spark", "paper", "forge" or "" if only JVM stats were read
 }
 
 // ModpackInfo holds details about a server's modpack.
@@ -54,6 +72,7 @@ type FileInfo struct {
 	Name     string    `json:"name"`
 	Size     int64     `json:"size"`
 	IsDir    bool      `json:"isDir"`
+	Mode     string    `json:"mode"` // Permission bits in octal, e.g. "0644"
 	Modified time.Time `json:"modified"`
 }
 
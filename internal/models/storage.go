@@ -0,0 +1,10 @@
+package models
+
+// StorageRoot is a directory server data directories can be kept in, such as a mount of a separate disk.
+type StorageRoot struct {
+	Path       string `json:"path"`
+	Default    bool   `json:"default"` // New servers are created here
+	Servers    int    `json:"servers"` // Number of servers stored here
+	TotalBytes uint64 `json:"totalBytes"`
+	FreeBytes  uint64 `json:"freeBytes"`
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // Template represents a blueprint for creating a new Minecraft server.
@@ -17,6 +18,8 @@ type Template struct {
 	MinMemoryMB      int    `json:"minMemoryMB"`
 	MaxMemoryMB      int    `json:"maxMemoryMB"`
 	IconURL          string `json:"iconURL,omitempty"`
+	Version          int    `json:"version"`             // Version this template describes; the latest unless asked for another
+	Changelog        string `json:"changelog,omitempty"` // What changed in this version
 
 	// New direct properties
 	Difficulty string `json:"difficulty,omitempty"`
@@ -120,3 +123,12 @@ func (t *Template) PrepareForAPI() {
 		json.Unmarshal([]byte(t.BannedIPsJSON), &t.BannedIPs)
 	}
 }
+
+// TemplateVersion describes one immutable version of a template.
+type TemplateVersion struct {
+	TemplateID    string    `json:"templateId"`
+	Version       int       `json:"version"`
+	Changelog     string    `json:"changelog,omitempty"`
+	ArchiveSHA256 string    `json:"archiveSha256,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TerminalSession is the audit record of an interactive shell opened in a server's container.
+type TerminalSession struct {
+	ID            string     `json:"id"`
+	ServerID      string     `json:"serverId"`
+	UserID        string     `json:"userId,omitempty"`
+	Username      string     `json:"username,omitempty"`
+	StartedAt     time.Time  `json:"startedAt"`
+	EndedAt       *time.Time `json:"endedAt,omitempty"`   // Nil while the session is open
+	EndReason     string     `json:"endReason,omitempty"` // "exited", "closed", "idle" or "shutdown"
+	ExitCode      *int       `json:"exitCode,omitempty"`  // Exit code of the shell, if it exited
+	RecordingSize int64      `json:"recordingSize"`       // Size of the asciicast recording in bytes
+	Truncated     bool       `json:"truncated,omitempty"` // The recording stopped at its size limit
+}
@@ -7,6 +7,18 @@ type User struct {
 	ID           string    `json:"id"`
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` // Never expose this to the client
+	PasswordHash string    `json:"-"`            // Never expose this to the client
+	SFTPReadOnly bool      `json:"sftpReadOnly"` // SFTP sessions, whether opened with the password or a key, can't change files
 	CreatedAt    time.Time `json:"createdAt"`
 }
+
+// SSHKey is a public key a user can log in to the SFTP server with.
+type SSHKey struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"publicKey"`   // In authorized_keys format
+	Fingerprint string    `json:"fingerprint"` // SHA256 fingerprint, as ssh-keygen -l shows it
+	ReadOnly    bool      `json:"readOnly"`    // Sessions opened with the key can't change files
+	CreatedAt   time.Time `json:"createdAt"`
+}
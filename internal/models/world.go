@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// World is a Minecraft world stored in a server's data directory.
+type World struct {
+	Name       string           `json:"name"`
+	Active     bool             `json:"active"` // The world level-name points to, which the server loads
+	Dimensions []WorldDimension `json:"dimensions"`
+	Size       int64            `json:"size"`
+	Level      *WorldLevel      `json:"level,omitempty"`      // Nil until the world has a level.dat
+	LevelError string           `json:"levelError,omitempty"` // Why level.dat couldn't be read
+}
+
+// WorldDimension is a dimension of a world and the directory, relative to the data directory, holding it.
+// Bukkit-based servers keep the Nether and the End in directories of their own next to the world.
+type WorldDimension struct {
+	Name string `json:"name"` // "overworld", "the_nether" or "the_end"
+	Path string `json:"path"`
+}
+
+// WorldLevel is what a world's level.dat records about it.
+type WorldLevel struct {
+	LevelName  string            `json:"levelName,omitempty"`
+	Version    string            `json:"version,omitempty"` // Minecraft version the world was last saved with
+	Seed       int64             `json:"seed,string"`       // A string, since seeds don't fit in a JavaScript number
+	GameType   string            `json:"gameType"`          // "survival", "creative", "adventure" or "spectator"
+	Hardcore   bool              `json:"hardcore"`
+	Difficulty string            `json:"difficulty,omitempty"`
+	Spawn      WorldPosition     `json:"spawn"`
+	LastPlayed time.Time         `json:"lastPlayed"`
+	GameRules  map[string]string `json:"gameRules"`
+}
+
+// WorldPosition is a block position.
+type WorldPosition struct {
+	X int32 `json:"x"`
+	Y int32 `json:"y"`
+	Z int32 `json:"z"`
+}
@@ -0,0 +1,106 @@
+package modinfo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+)
+
+// platformIDs are dependency IDs satisfied by the game, the loader or the server itself rather than by a jar.
+var platformIDs = map[string]bool{
+	"minecraft":    true,
+	"java":         true,
+	"fabricloader": true,
+	"quilt_loader": true,
+	"forge":        true,
+	"neoforge":     true,
+	"javafml":      true,
+	"lowcodefml":   true,
+	"mcp":          true,
+}
+
+// Check sets the issues of each jar: client-only mods, mod IDs declared by more than one enabled jar and
+// required dependencies no enabled jar in the same directory provides. Disabled jars are not checked and
+// provide nothing. Issues found earlier, such as unreadable jars, are kept.
+func Check(jars []models.Mod) {
+	providers := map[string]map[string][]string{} // Directory -> ID -> file names
+	declared := map[string]map[string][]string{}  // Directory -> primary ID -> file names
+	for _, jar := range jars {
+		if !jar.Enabled {
+			continue
+		}
+		if providers[jar.Directory] == nil {
+			providers[jar.Directory] = map[string][]string{}
+			declared[jar.Directory] = map[string][]string{}
+		}
+		for _, info := range jar.Mods {
+			declared[jar.Directory][info.ID] = appendUnique(declared[jar.Directory][info.ID], jar.FileName)
+			for _, id := range append([]string{info.ID}, info.Provides...) {
+				providers[jar.Directory][id] = appendUnique(providers[jar.Directory][id], jar.FileName)
+			}
+		}
+	}
+
+	for i := range jars {
+		jar := &jars[i]
+		if !jar.Enabled {
+			continue
+		}
+		for _, info := range jar.Mods {
+			if info.ClientOnly {
+				jar.Issues = append(jar.Issues, models.ModIssue{
+					Type:    "client_only",
+					ModID:   info.ID,
+					Message: fmt.Sprintf("%s is a client-only mod and will crash or do nothing on a dedicated server", displayName(info)),
+				})
+			}
+			if files := declared[jar.Directory][info.ID]; len(files) > 1 {
+				jar.Issues = append(jar.Issues, models.ModIssue{
+					Type:    "duplicate_id",
+					ModID:   info.ID,
+					Message: fmt.Sprintf("%s is also declared by %s", info.ID, strings.Join(without(files, jar.FileName), ", ")),
+				})
+			}
+			for _, dep := range info.Dependencies {
+				if !dep.Required || platformIDs[dep.ID] || len(providers[jar.Directory][dep.ID]) > 0 {
+					continue
+				}
+				message := fmt.Sprintf("%s requires %s, which is not installed", displayName(info), dep.ID)
+				if dep.VersionRange != "" && dep.VersionRange != "*" {
+					message = fmt.Sprintf("%s requires %s %s, which is not installed", displayName(info), dep.ID, dep.VersionRange)
+				}
+				jar.Issues = append(jar.Issues, models.ModIssue{Type: "missing_dependency", ModID: dep.ID, Message: message})
+			}
+		}
+	}
+}
+
+func displayName(info models.ModInfo) string {
+	if info.Name != "" {
+		return info.Name
+	}
+	return info.ID
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	list = append(list, s)
+	sort.Strings(list)
+	return list
+}
+
+func without(list []string, s string) []string {
+	var out []string
+	for _, existing := range list {
+		if existing != s {
+			out = append(out, existing)
+		}
+	}
+	return out
+}
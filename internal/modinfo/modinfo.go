@@ -0,0 +1,380 @@
+// Package modinfo reads the metadata of mod and plugin jars and checks a set of them for problems
+// that keep a dedicated server from starting.
+package modinfo
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// maxNestingDepth bounds how deep bundled jars (jar-in-jar) are opened.
+const maxNestingDepth = 3
+
+// maxNestedJarSize bounds the size of a bundled jar read into memory.
+const maxNestedJarSize = 64 << 20
+
+// Inspect reads the mod and plugin metadata declared in a jar. A jar without any is a plain library
+// and yields no entries.
+func Inspect(r io.ReaderAt, size int64) ([]models.ModInfo, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid jar: %w", err)
+	}
+	return inspect(archive, 0)
+}
+
+func inspect(archive *zip.Reader, depth int) ([]models.ModInfo, error) {
+	var infos []models.ModInfo
+	var nested []string
+
+	if f := findFile(archive, "fabric.mod.json"); f != nil {
+		info, jars, err := readFabric(f)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+		nested = append(nested, jars...)
+	}
+	if f := findFile(archive, "quilt.mod.json"); f != nil {
+		info, jars, err := readQuilt(f)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+		nested = append(nested, jars...)
+	}
+	for _, name := range []string{"META-INF/neoforge.mods.toml", "META-INF/mods.toml"} {
+		if f := findFile(archive, name); f != nil {
+			loader := "forge"
+			if strings.HasPrefix(name, "META-INF/neoforge") {
+				loader = "neoforge"
+			}
+			forgeInfos, err := readForge(archive, f, loader)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, forgeInfos...)
+			nested = append(nested, jarJarFiles(archive)...)
+			break
+		}
+	}
+	for _, name := range []string{"paper-plugin.yml", "plugin.yml"} {
+		if f := findFile(archive, name); f != nil {
+			info, err := readPlugin(f, name == "paper-plugin.yml")
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+			break
+		}
+	}
+
+	// IDs of bundled jars count as provided by the mod that bundles them.
+	if len(infos) > 0 && depth < maxNestingDepth {
+		for _, name := range nested {
+			infos[0].Provides = append(infos[0].Provides, nestedIDs(archive, name, depth+1)...)
+		}
+	}
+	return infos, nil
+}
+
+// nestedIDs returns every ID declared by a jar bundled inside archive. Unreadable bundled jars are skipped.
+func nestedIDs(archive *zip.Reader, name string, depth int) []string {
+	f := findFile(archive, name)
+	if f == nil || f.UncompressedSize64 > maxNestedJarSize {
+		return nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	inner, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	infos, err := inspect(inner, depth)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+		ids = append(ids, info.Provides...)
+	}
+	return ids
+}
+
+// fabricModJSON is the subset of fabric.mod.json that matters on a server.
+type fabricModJSON struct {
+	ID          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Version     string                     `json:"version"`
+	Environment string                     `json:"environment"`
+	Provides    []string                   `json:"provides"`
+	Depends     map[string]json.RawMessage `json:"depends"`
+	Jars        []struct {
+		File string `json:"file"`
+	} `json:"jars"`
+}
+
+func readFabric(f *zip.File) (models.ModInfo, []string, error) {
+	var meta fabricModJSON
+	if err := decodeFile(f, func(r io.Reader) error { return json.NewDecoder(r).Decode(&meta) }); err != nil {
+		return models.ModInfo{}, nil, err
+	}
+	info := models.ModInfo{
+		ID:         meta.ID,
+		Name:       meta.Name,
+		Version:    meta.Version,
+		Loader:     "fabric",
+		ClientOnly: meta.Environment == "client",
+		Provides:   meta.Provides,
+	}
+	for id, raw := range meta.Depends {
+		info.Dependencies = append(info.Dependencies, models.ModDependency{ID: id, VersionRange: fabricVersionRange(raw), Required: true})
+	}
+	sortDependencies(info.Dependencies)
+
+	var jars []string
+	for _, jar := range meta.Jars {
+		jars = append(jars, jar.File)
+	}
+	return info, jars, nil
+}
+
+// fabricVersionRange renders a fabric.mod.json version predicate, which is a string or a list of alternatives.
+func fabricVersionRange(raw json.RawMessage) string {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single
+	}
+	var alternatives []string
+	if json.Unmarshal(raw, &alternatives) == nil {
+		return strings.Join(alternatives, " || ")
+	}
+	return ""
+}
+
+// quiltModJSON is the subset of quilt.mod.json that matters on a server.
+type quiltModJSON struct {
+	QuiltLoader struct {
+		ID       string `json:"id"`
+		Version  string `json:"version"`
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Provides []json.RawMessage `json:"provides"`
+		Depends  []json.RawMessage `json:"depends"`
+		Jars     []string          `json:"jars"`
+	} `json:"quilt_loader"`
+	Minecraft struct {
+		Environment string `json:"environment"`
+	} `json:"minecraft"`
+}
+
+// quiltReference is an entry of quilt_loader.provides or .depends, which may also be a bare ID string.
+type quiltReference struct {
+	ID       string          `json:"id"`
+	Versions json.RawMessage `json:"versions"`
+	Optional bool            `json:"optional"`
+}
+
+func readQuilt(f *zip.File) (models.ModInfo, []string, error) {
+	var meta quiltModJSON
+	if err := decodeFile(f, func(r io.Reader) error { return json.NewDecoder(r).Decode(&meta) }); err != nil {
+		return models.ModInfo{}, nil, err
+	}
+	loader := meta.QuiltLoader
+	info := models.ModInfo{
+		ID:         loader.ID,
+		Name:       loader.Metadata.Name,
+		Version:    loader.Version,
+		Loader:     "quilt",
+		ClientOnly: meta.Minecraft.Environment == "client",
+	}
+	for _, raw := range loader.Provides {
+		info.Provides = append(info.Provides, parseQuiltReference(raw).ID)
+	}
+	for _, raw := range loader.Depends {
+		ref := parseQuiltReference(raw)
+		if ref.ID == "" {
+			continue // A list of alternatives; too loose to check
+		}
+		info.Dependencies = append(info.Dependencies, models.ModDependency{ID: ref.ID, VersionRange: fabricVersionRange(ref.Versions), Required: !ref.Optional})
+	}
+	return info, loader.Jars, nil
+}
+
+func parseQuiltReference(raw json.RawMessage) quiltReference {
+	var ref quiltReference
+	if json.Unmarshal(raw, &ref.ID) == nil {
+		return ref
+	}
+	json.Unmarshal(raw, &ref)
+	return ref
+}
+
+// forgeModsTOML is the subset of mods.toml and neoforge.mods.toml that matters on a server.
+type forgeModsTOML struct {
+	ClientSideOnly bool `toml:"clientSideOnly"`
+	Mods           []struct {
+		ModID       string `toml:"modId"`
+		Version     string `toml:"version"`
+		DisplayName string `toml:"displayName"`
+	} `toml:"mods"`
+	Dependencies map[string][]struct {
+		ModID        string `toml:"modId"`
+		Mandatory    *bool  `toml:"mandatory"` // Forge
+		Type         string `toml:"type"`      // NeoForge: "required", "optional", "incompatible" or "discouraged"
+		VersionRange string `toml:"versionRange"`
+		Side         string `toml:"side"`
+	} `toml:"dependencies"`
+}
+
+func readForge(archive *zip.Reader, f *zip.File, loader string) ([]models.ModInfo, error) {
+	var meta forgeModsTOML
+	if err := decodeFile(f, func(r io.Reader) error {
+		_, err := toml.NewDecoder(r).Decode(&meta)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	var infos []models.ModInfo
+	for _, mod := range meta.Mods {
+		info := models.ModInfo{
+			ID:         mod.ModID,
+			Name:       mod.DisplayName,
+			Version:    mod.Version,
+			Loader:     loader,
+			ClientOnly: meta.ClientSideOnly,
+		}
+		// The version is usually filled in from the jar manifest at build time.
+		if info.Version == "${file.jarVersion}" {
+			info.Version = manifestAttribute(archive, "Implementation-Version")
+		}
+		for _, dep := range meta.Dependencies[mod.ModID] {
+			required := dep.Type == "" || strings.EqualFold(dep.Type, "required")
+			if dep.Mandatory != nil {
+				required = *dep.Mandatory
+			}
+			if strings.EqualFold(dep.Side, "CLIENT") || strings.EqualFold(dep.Type, "incompatible") || strings.EqualFold(dep.Type, "discouraged") {
+				continue
+			}
+			info.Dependencies = append(info.Dependencies, models.ModDependency{ID: dep.ModID, VersionRange: dep.VersionRange, Required: required})
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// jarJarFiles lists the jars bundled with Forge's and NeoForge's jar-in-jar system.
+func jarJarFiles(archive *zip.Reader) []string {
+	var jars []string
+	for _, f := range archive.File {
+		if strings.HasPrefix(f.Name, "META-INF/jarjar/") && strings.HasSuffix(f.Name, ".jar") {
+			jars = append(jars, f.Name)
+		}
+	}
+	return jars
+}
+
+// manifestAttribute reads a main attribute of META-INF/MANIFEST.MF.
+func manifestAttribute(archive *zip.Reader, name string) string {
+	f := findFile(archive, "META-INF/MANIFEST.MF")
+	if f == nil {
+		return ""
+	}
+	var value string
+	decodeFile(f, func(r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if line == "" {
+				break // End of the main section
+			}
+			if key, v, ok := strings.Cut(line, ": "); ok && key == name {
+				value = v
+				break
+			}
+		}
+		return nil
+	})
+	return value
+}
+
+// pluginYAML is the subset of plugin.yml and paper-plugin.yml that matters on a server.
+type pluginYAML struct {
+	Name         string   `yaml:"name"`
+	Version      string   `yaml:"version"`
+	Depend       []string `yaml:"depend"`
+	SoftDepend   []string `yaml:"softdepend"`
+	Dependencies struct {
+		Server map[string]struct {
+			Required *bool `yaml:"required"`
+		} `yaml:"server"`
+	} `yaml:"dependencies"` // paper-plugin.yml
+}
+
+func readPlugin(f *zip.File, paper bool) (models.ModInfo, error) {
+	var meta pluginYAML
+	if err := decodeFile(f, func(r io.Reader) error { return yaml.NewDecoder(r).Decode(&meta) }); err != nil {
+		return models.ModInfo{}, err
+	}
+	info := models.ModInfo{ID: meta.Name, Name: meta.Name, Version: meta.Version, Loader: "bukkit"}
+	for _, name := range meta.Depend {
+		info.Dependencies = append(info.Dependencies, models.ModDependency{ID: name, Required: true})
+	}
+	for _, name := range meta.SoftDepend {
+		info.Dependencies = append(info.Dependencies, models.ModDependency{ID: name})
+	}
+	if paper {
+		info.Loader = "paper"
+		for name, dep := range meta.Dependencies.Server {
+			info.Dependencies = append(info.Dependencies, models.ModDependency{ID: name, Required: dep.Required == nil || *dep.Required})
+		}
+		sortDependencies(info.Dependencies)
+	}
+	return info, nil
+}
+
+// decodeFile opens a file in an archive and hands it to decode.
+func decodeFile(f *zip.File, decode func(io.Reader) error) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := decode(r); err != nil {
+		return fmt.Errorf("invalid %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+func findFile(archive *zip.Reader, name string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func sortDependencies(deps []models.ModDependency) {
+	sort.Slice(deps, func(a, b int) bool { return deps[a].ID < deps[b].ID })
+}
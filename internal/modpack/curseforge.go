@@ -0,0 +1,229 @@
+package modpack
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+)
+
+// curseForgeManifest is the manifest.json of a CurseForge modpack.
+type curseForgeManifest struct {
+	ManifestType string `json:"manifestType"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Author       string `json:"author"`
+	Minecraft    struct {
+		Version    string `json:"version"`
+		ModLoaders []struct {
+			ID      string `json:"id"`
+			Primary bool   `json:"primary"`
+		} `json:"modLoaders"`
+	} `json:"minecraft"`
+	Files []struct {
+		ProjectID int  `json:"projectID"`
+		FileID    int  `json:"fileID"`
+		Required  bool `json:"required"`
+	} `json:"files"`
+	Overrides string `json:"overrides"`
+}
+
+// curseForgeFile is a file as returned by the CurseForge API.
+type curseForgeFile struct {
+	ID          int    `json:"id"`
+	ModID       int    `json:"modId"`
+	DisplayName string `json:"displayName"`
+	FileName    string `json:"fileName"`
+	DownloadURL string `json:"downloadUrl"`
+	Hashes      []struct {
+		Value string `json:"value"`
+		Algo  int    `json:"algo"`
+	} `json:"hashes"`
+}
+
+// curseForgeClassMods is the CurseForge class of mods. Other classes (resource packs, shaders) are client content.
+const curseForgeClassMods = 6
+
+// readCurseForge reads a CurseForge pack and resolves its files through the CurseForge API.
+func (i *Importer) readCurseForge(ctx context.Context, archive *zip.Reader) (pack, error) {
+	f := findFile(archive, "manifest.json")
+	r, err := f.Open()
+	if err != nil {
+		return pack{}, err
+	}
+	defer r.Close()
+
+	var manifest curseForgeManifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return pack{}, fmt.Errorf("invalid manifest.json: %w", err)
+	}
+	if manifest.ManifestType != "minecraftModpack" {
+		return pack{}, fmt.Errorf("unsupported CurseForge manifest type %q", manifest.ManifestType)
+	}
+	if manifest.Minecraft.Version == "" {
+		return pack{}, fmt.Errorf("manifest.json does not declare a Minecraft version")
+	}
+
+	p := pack{
+		name:             manifest.Name,
+		version:          manifest.Version,
+		minecraftVersion: manifest.Minecraft.Version,
+	}
+	if manifest.Author != "" {
+		p.summary = "By " + manifest.Author
+	}
+	if manifest.Overrides != "" {
+		dir, err := cleanPath(manifest.Overrides)
+		if err != nil {
+			return pack{}, err
+		}
+		p.overrides = []string{dir}
+	}
+
+	for _, modLoader := range manifest.Minecraft.ModLoaders {
+		if p.loader != "" && !modLoader.Primary {
+			continue
+		}
+		// Loader IDs look like "forge-47.2.0" or "fabric-0.15.7".
+		name, version, ok := strings.Cut(modLoader.ID, "-")
+		if !ok {
+			return pack{}, fmt.Errorf("unrecognised mod loader %q", modLoader.ID)
+		}
+		switch installer.Software(name) {
+		case installer.Forge, installer.NeoForge, installer.Fabric, installer.Quilt:
+		default:
+			return pack{}, fmt.Errorf("unsupported mod loader %q", modLoader.ID)
+		}
+		p.loader = installer.Software(name)
+		p.loaderVersion = strings.TrimPrefix(version, manifest.Minecraft.Version+"-")
+	}
+	if p.loader == "" {
+		return pack{}, fmt.Errorf("manifest.json does not declare a mod loader")
+	}
+
+	var fileIDs []int
+	for _, file := range manifest.Files {
+		if file.Required {
+			fileIDs = append(fileIDs, file.FileID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return p, nil
+	}
+
+	if i.sources.CurseForgeAPIKey == "" {
+		return pack{}, fmt.Errorf("importing CurseForge packs requires a CurseForge API key")
+	}
+	files, err := i.curseForgeFiles(ctx, fileIDs)
+	if err != nil {
+		return pack{}, err
+	}
+	classes, err := i.curseForgeClasses(ctx, files)
+	if err != nil {
+		return pack{}, err
+	}
+
+	for _, id := range fileIDs {
+		file, ok := files[id]
+		if !ok {
+			return pack{}, fmt.Errorf("CurseForge file %d was not found", id)
+		}
+		if classes[file.ModID] != curseForgeClassMods {
+			continue
+		}
+		if file.DownloadURL == "" {
+			return pack{}, fmt.Errorf("the author of %s does not allow third-party downloads; add it to the pack overrides instead", file.DisplayName)
+		}
+		name, err := cleanPath("mods/" + file.FileName)
+		if err != nil {
+			return pack{}, err
+		}
+
+		var want download.Hashes
+		for _, h := range file.Hashes {
+			switch h.Algo {
+			case 1:
+				want.SHA1 = h.Value
+			case 2:
+				want.MD5 = h.Value
+			}
+		}
+		if want.Empty() {
+			return pack{}, fmt.Errorf("CurseForge file %s has no hash", file.FileName)
+		}
+		p.files = append(p.files, packFile{path: name, urls: []string{file.DownloadURL}, hash: want})
+	}
+	return p, nil
+}
+
+// curseForgeFiles looks up files by ID.
+func (i *Importer) curseForgeFiles(ctx context.Context, ids []int) (map[int]curseForgeFile, error) {
+	var resp struct {
+		Data []curseForgeFile `json:"data"`
+	}
+	if err := i.curseForgePost(ctx, "/v1/mods/files", map[string][]int{"fileIds": ids}, &resp); err != nil {
+		return nil, err
+	}
+	files := make(map[int]curseForgeFile, len(resp.Data))
+	for _, f := range resp.Data {
+		files[f.ID] = f
+	}
+	return files, nil
+}
+
+// curseForgeClasses returns the class of each project the files belong to.
+func (i *Importer) curseForgeClasses(ctx context.Context, files map[int]curseForgeFile) (map[int]int, error) {
+	var modIDs []int
+	for _, f := range files {
+		modIDs = append(modIDs, f.ModID)
+	}
+	var resp struct {
+		Data []struct {
+			ID      int `json:"id"`
+			ClassID int `json:"classId"`
+		} `json:"data"`
+	}
+	if err := i.curseForgePost(ctx, "/v1/mods", map[string][]int{"modIds": modIDs}, &resp); err != nil {
+		return nil, err
+	}
+	classes := make(map[int]int, len(resp.Data))
+	for _, m := range resp.Data {
+		classes[m.ID] = m.ClassID
+	}
+	return classes, nil
+}
+
+// curseForgePost sends body as JSON to an endpoint of the CurseForge API and decodes the response into v.
+func (i *Importer) curseForgePost(ctx context.Context, endpoint string, body, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(i.sources.CurseForgeAPI, "/") + endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("x-api-key", i.sources.CurseForgeAPIKey)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("CurseForge API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CurseForge API %s: %s", endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid CurseForge API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package modpack unpacks Modrinth (.mrpack) and CurseForge modpack archives into a server directory.
+package modpack
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+)
+
+// Sources are the upstream endpoints the importer talks to. Any of them can point at a local mirror.
+type Sources struct {
+	CurseForgeAPI    string   // CurseForge core API, resolves the files of a CurseForge manifest
+	CurseForgeAPIKey string   // Required to import CurseForge packs
+	ModrinthHosts    []string // Hosts .mrpack files may download from; empty allows any host
+}
+
+// DefaultSources returns the public upstream endpoints.
+func DefaultSources() Sources {
+	return Sources{
+		CurseForgeAPI: "https://api.curseforge.com",
+		// The hosts the .mrpack format allows downloads from.
+		ModrinthHosts: []string{"cdn.modrinth.com", "github.com", "raw.githubusercontent.com", "gitlab.com"},
+	}
+}
+
+// ProgressFunc receives progress updates while a pack is imported.
+type ProgressFunc func(stage string, current, total int64, unit string)
+
+// Importer unpacks modpacks.
+type Importer struct {
+	sources Sources
+	client  *http.Client
+}
+
+// NewImporter creates a new Importer that downloads from sources.
+func NewImporter(sources Sources) *Importer {
+	return &Importer{sources: sources, client: &http.Client{}}
+}
+
+// pack is the format-independent description of a modpack.
+type pack struct {
+	name             string
+	version          string
+	summary          string
+	minecraftVersion string
+	loader           installer.Software
+	loaderVersion    string
+	files            []packFile
+	overrides        []string // Override directories inside the archive, highest precedence first
+}
+
+// packFile is a file the pack downloads rather than bundles.
+type packFile struct {
+	path string // Relative to the server directory
+	urls []string
+	hash download.Hashes
+}
+
+// Result describes an unpacked modpack. The pack's mod loader still has to be installed.
+type Result struct {
+	Name             string
+	Version          string
+	Summary          string
+	MinecraftVersion string
+	Loader           installer.Software
+	LoaderVersion    string
+	Mods             []string // File names of the jars in mods/
+}
+
+// Unpack reads the modpack in archive and writes its overrides and server-side files to dir.
+func (i *Importer) Unpack(ctx context.Context, archive *zip.Reader, dir string, progress ProgressFunc) (Result, error) {
+	if progress == nil {
+		progress = func(string, int64, int64, string) {}
+	}
+
+	var p pack
+	var err error
+	switch {
+	case findFile(archive, "modrinth.index.json") != nil:
+		p, err = readMrpack(archive, i.sources.ModrinthHosts)
+	case findFile(archive, "manifest.json") != nil:
+		p, err = i.readCurseForge(ctx, archive)
+	default:
+		return Result{}, fmt.Errorf("archive is neither a Modrinth nor a CurseForge modpack")
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	written := map[string]bool{}
+
+	// Overrides are bundled in the pack and win over downloaded files with the same path.
+	for _, overrides := range p.overrides {
+		if err := extractOverrides(archive, overrides, dir, written); err != nil {
+			return Result{}, err
+		}
+	}
+
+	total := int64(len(p.files))
+	progress("downloading files", 0, total, "files")
+	for n, f := range p.files {
+		if !written[f.path] {
+			if err := download.File(ctx, i.client, filepath.Join(dir, filepath.FromSlash(f.path)), f.urls, f.hash); err != nil {
+				return Result{}, err
+			}
+			written[f.path] = true
+		}
+		progress("downloading files", int64(n+1), total, "files")
+	}
+
+	mods := []string{}
+	for name := range written {
+		if path.Dir(name) == "mods" && strings.HasSuffix(name, ".jar") {
+			mods = append(mods, path.Base(name))
+		}
+	}
+	sort.Strings(mods)
+
+	return Result{
+		Name:             p.name,
+		Version:          p.version,
+		Summary:          p.summary,
+		MinecraftVersion: p.minecraftVersion,
+		Loader:           p.loader,
+		LoaderVersion:    p.loaderVersion,
+		Mods:             mods,
+	}, nil
+}
+
+// extractOverrides extracts the contents of overrides in archive to dir, skipping paths already written.
+func extractOverrides(archive *zip.Reader, overrides, dir string, written map[string]bool) error {
+	prefix := overrides + "/"
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, prefix) || f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := cleanPath(strings.TrimPrefix(f.Name, prefix))
+		if err != nil {
+			return err
+		}
+		if written[name] {
+			continue
+		}
+
+		if err := extractFile(f, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", f.Name, err)
+		}
+		written[name] = true
+	}
+	return nil
+}
+
+// extractFile writes the contents of f to path.
+func extractFile(f *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// cleanPath normalises a path from a pack and rejects paths that would escape the server directory.
+func cleanPath(p string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(p, "\\", "/"))
+	if clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(clean, ":") {
+		return "", fmt.Errorf("illegal path in modpack: %q", p)
+	}
+	return clean, nil
+}
+
+// findFile returns the file called name in archive, or nil.
+func findFile(archive *zip.Reader, name string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package modpack
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+)
+
+// mirror serves mod jars under /files/ and answers the CurseForge API for the files it knows.
+type mirror struct {
+	*httptest.Server
+	files map[string][]byte // By name under /files/
+
+	// CurseForge API data.
+	cfFiles   []curseForgeFile
+	cfClasses map[int]int // Mod ID -> class
+}
+
+func newMirror(t *testing.T, files map[string][]byte) *mirror {
+	t.Helper()
+	m := &mirror{files: files, cfClasses: map[int]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := m.files[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+	mux.HandleFunc("POST /v1/mods/files", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			FileIDs []int `json:"fileIds"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var data []curseForgeFile
+		for _, f := range m.cfFiles {
+			for _, id := range req.FileIDs {
+				if f.ID == id {
+					data = append(data, f)
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	})
+	mux.HandleFunc("POST /v1/mods", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ModIDs []int `json:"modIds"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var data []map[string]int
+		for _, id := range req.ModIDs {
+			data = append(data, map[string]int{"id": id, "classId": m.cfClasses[id]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// cfFile describes a file the way the CurseForge API does, with its SHA-1 if sum isn't empty.
+func cfFile(t *testing.T, id, modID int, name, downloadURL, sum string) curseForgeFile {
+	t.Helper()
+	hashes := []map[string]any{}
+	if sum != "" {
+		hashes = append(hashes, map[string]any{"value": sum, "algo": 1})
+	}
+	var f curseForgeFile
+	err := json.Unmarshal([]byte(jsonString(t, map[string]any{
+		"id": id, "modId": modID, "displayName": name, "fileName": name, "downloadUrl": downloadURL, "hashes": hashes,
+	})), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (m *mirror) url(name string) string {
+	return m.URL + "/files/" + name
+}
+
+func (m *mirror) sources() Sources {
+	u, _ := url.Parse(m.URL)
+	return Sources{CurseForgeAPI: m.URL, CurseForgeAPIKey: "test-key", ModrinthHosts: []string{u.Host}}
+}
+
+// archive builds a zip archive in memory.
+func archive(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func jsonString(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha512Hex(data []byte) string {
+	sum := sha512.Sum512(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// mrpack builds a .mrpack whose index lists files, with the overrides given.
+func mrpack(t *testing.T, files []map[string]any, overrides map[string]string) *zip.Reader {
+	t.Helper()
+	contents := map[string]string{
+		"modrinth.index.json": jsonString(t, map[string]any{
+			"formatVersion": 1,
+			"game":          "minecraft",
+			"versionId":     "1.2.0",
+			"name":          "Test Pack",
+			"summary":       "A pack for tests",
+			"files":         files,
+			"dependencies":  map[string]string{"minecraft": "1.20.1", "fabric-loader": "0.15.7"},
+		}),
+	}
+	for name, content := range overrides {
+		contents[name] = content
+	}
+	return archive(t, contents)
+}
+
+func TestUnpackMrpack(t *testing.T) {
+	lithium, clientMod := []byte("lithium jar"), []byte("client-only jar")
+	m := newMirror(t, map[string][]byte{"lithium.jar": lithium, "sodium.jar": clientMod})
+
+	pack := mrpack(t, []map[string]any{
+		{
+			"path":      "mods/lithium.jar",
+			"hashes":    map[string]string{"sha1": sha1Hex(lithium), "sha512": sha512Hex(lithium)},
+			"env":       map[string]string{"client": "required", "server": "required"},
+			"downloads": []string{m.url("lithium.jar")},
+		},
+		{
+			"path":      "mods/sodium.jar",
+			"hashes":    map[string]string{"sha1": sha1Hex(clientMod)},
+			"env":       map[string]string{"client": "required", "server": "unsupported"},
+			"downloads": []string{m.url("sodium.jar")},
+		},
+		{
+			// Overridden by server-overrides, so never downloaded.
+			"path":      "config/lithium.properties",
+			"hashes":    map[string]string{"sha1": sha1Hex([]byte("missing"))},
+			"downloads": []string{m.url("missing")},
+		},
+	}, map[string]string{
+		"overrides/config/lithium.properties":        "common",
+		"server-overrides/config/lithium.properties": "server",
+		"overrides/config/other.toml":                "other",
+		"client-overrides/options.txt":               "client",
+	})
+
+	dir := t.TempDir()
+	result, err := NewImporter(m.sources()).Unpack(context.Background(), pack, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Result{
+		Name:             "Test Pack",
+		Version:          "1.2.0",
+		Summary:          "A pack for tests",
+		MinecraftVersion: "1.20.1",
+		Loader:           installer.Fabric,
+		LoaderVersion:    "0.15.7",
+		Mods:             []string{"lithium.jar"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Unpack() = %+v, want %+v", result, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "mods", "lithium.jar")); got != string(lithium) {
+		t.Errorf("mods/lithium.jar = %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "config", "lithium.properties")); got != "server" {
+		t.Errorf("config/lithium.properties = %q, want the server override", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "config", "other.toml")); got != "other" {
+		t.Errorf("config/other.toml = %q", got)
+	}
+	for _, name := range []string{"mods/sodium.jar", "options.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("client-only file %s was unpacked", name)
+		}
+	}
+}
+
+func TestUnpackMrpackHashMismatch(t *testing.T) {
+	m := newMirror(t, map[string][]byte{"lithium.jar": []byte("tampered jar")})
+	pack := mrpack(t, []map[string]any{{
+		"path":      "mods/lithium.jar",
+		"hashes":    map[string]string{"sha512": sha512Hex([]byte("lithium jar"))},
+		"downloads": []string{m.url("lithium.jar")},
+	}}, nil)
+
+	dir := t.TempDir()
+	_, err := NewImporter(m.sources()).Unpack(context.Background(), pack, dir, nil)
+	if err == nil || !strings.Contains(err.Error(), "sha512 mismatch") {
+		t.Fatalf("Unpack() = %v, want a sha512 mismatch", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "mods"))
+	if len(entries) != 0 {
+		t.Errorf("mods/ holds %d files after a failed download, want none", len(entries))
+	}
+}
+
+func TestUnpackMrpackRejectsUnsafePacks(t *testing.T) {
+	m := newMirror(t, map[string][]byte{"lithium.jar": []byte("lithium jar")})
+	for name, file := range map[string]map[string]any{
+		"escaping path": {
+			"path":      "../../etc/cron.d/job",
+			"hashes":    map[string]string{"sha1": sha1Hex([]byte("x"))},
+			"downloads": []string{m.url("lithium.jar")},
+		},
+		"foreign host": {
+			"path":      "mods/lithium.jar",
+			"hashes":    map[string]string{"sha1": sha1Hex([]byte("x"))},
+			"downloads": []string{"https://example.com/lithium.jar"},
+		},
+		"no hash": {
+			"path":      "mods/lithium.jar",
+			"downloads": []string{m.url("lithium.jar")},
+		},
+	} {
+		pack := mrpack(t, []map[string]any{file}, nil)
+		if _, err := NewImporter(m.sources()).Unpack(context.Background(), pack, t.TempDir(), nil); err == nil {
+			t.Errorf("%s: Unpack() succeeded", name)
+		}
+	}
+}
+
+func TestUnpackCurseForge(t *testing.T) {
+	jei, shaders := []byte("jei jar"), []byte("shader pack")
+	m := newMirror(t, map[string][]byte{"jei.jar": jei, "shaders.zip": shaders})
+	m.cfFiles = []curseForgeFile{
+		cfFile(t, 100, 10, "jei.jar", m.url("jei.jar"), sha1Hex(jei)),
+		cfFile(t, 200, 20, "shaders.zip", m.url("shaders.zip"), sha1Hex(shaders)),
+	}
+	m.cfClasses[10] = curseForgeClassMods
+	m.cfClasses[20] = 6552 // Shaders, client content
+
+	pack := archive(t, map[string]string{
+		"manifest.json": jsonString(t, map[string]any{
+			"manifestType": "minecraftModpack",
+			"name":         "Forge Pack",
+			"version":      "3.0",
+			"author":       "Tester",
+			"minecraft": map[string]any{
+				"version":    "1.20.1",
+				"modLoaders": []map[string]any{{"id": "forge-47.2.0", "primary": true}},
+			},
+			"files": []map[string]any{
+				{"projectID": 10, "fileID": 100, "required": true},
+				{"projectID": 20, "fileID": 200, "required": true},
+				{"projectID": 30, "fileID": 300, "required": false},
+			},
+			"overrides": "overrides",
+		}),
+		"overrides/config/jei.toml": "jei config",
+	})
+
+	dir := t.TempDir()
+	result, err := NewImporter(m.sources()).Unpack(context.Background(), pack, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{
+		Name:             "Forge Pack",
+		Version:          "3.0",
+		Summary:          "By Tester",
+		MinecraftVersion: "1.20.1",
+		Loader:           installer.Forge,
+		LoaderVersion:    "47.2.0",
+		Mods:             []string{"jei.jar"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Unpack() = %+v, want %+v", result, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "mods", "jei.jar")); got != string(jei) {
+		t.Errorf("mods/jei.jar = %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "config", "jei.toml")); got != "jei config" {
+		t.Errorf("config/jei.toml = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mods", "shaders.zip")); !os.IsNotExist(err) {
+		t.Error("client content was unpacked")
+	}
+}
+
+func TestUnpackCurseForgeHashMismatch(t *testing.T) {
+	m := newMirror(t, map[string][]byte{"jei.jar": []byte("tampered jar")})
+	m.cfFiles = []curseForgeFile{cfFile(t, 100, 10, "jei.jar", m.url("jei.jar"), sha1Hex([]byte("jei jar")))}
+	m.cfClasses[10] = curseForgeClassMods
+
+	pack := archive(t, map[string]string{
+		"manifest.json": jsonString(t, map[string]any{
+			"manifestType": "minecraftModpack",
+			"minecraft": map[string]any{
+				"version":    "1.20.1",
+				"modLoaders": []map[string]any{{"id": "forge-47.2.0", "primary": true}},
+			},
+			"files": []map[string]any{{"projectID": 10, "fileID": 100, "required": true}},
+		}),
+	})
+	_, err := NewImporter(m.sources()).Unpack(context.Background(), pack, t.TempDir(), nil)
+	if err == nil || !strings.Contains(err.Error(), "sha1 mismatch") {
+		t.Fatalf("Unpack() = %v, want a sha1 mismatch", err)
+	}
+}
@@ -0,0 +1,117 @@
+package modpack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+)
+
+// mrpackIndex is the modrinth.index.json of a .mrpack archive.
+type mrpackIndex struct {
+	FormatVersion int    `json:"formatVersion"`
+	Game          string `json:"game"`
+	VersionID     string `json:"versionId"`
+	Name          string `json:"name"`
+	Summary       string `json:"summary"`
+	Files         []struct {
+		Path   string            `json:"path"`
+		Hashes map[string]string `json:"hashes"`
+		Env    *struct {
+			Client string `json:"client"`
+			Server string `json:"server"`
+		} `json:"env"`
+		Downloads []string `json:"downloads"`
+	} `json:"files"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// mrpackLoaders maps the dependency keys of modrinth.index.json to loaders.
+var mrpackLoaders = map[string]installer.Software{
+	"forge":         installer.Forge,
+	"neoforge":      installer.NeoForge,
+	"fabric-loader": installer.Fabric,
+	"quilt-loader":  installer.Quilt,
+}
+
+// readMrpack reads a Modrinth pack. Downloads must come from one of allowedHosts, unless it is empty.
+func readMrpack(archive *zip.Reader, allowedHosts []string) (pack, error) {
+	f := findFile(archive, "modrinth.index.json")
+	r, err := f.Open()
+	if err != nil {
+		return pack{}, err
+	}
+	defer r.Close()
+
+	var index mrpackIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return pack{}, fmt.Errorf("invalid modrinth.index.json: %w", err)
+	}
+	if index.FormatVersion != 1 {
+		return pack{}, fmt.Errorf("unsupported .mrpack format version %d", index.FormatVersion)
+	}
+	if index.Game != "minecraft" {
+		return pack{}, fmt.Errorf("unsupported .mrpack game %q", index.Game)
+	}
+
+	p := pack{
+		name:             index.Name,
+		version:          index.VersionID,
+		summary:          index.Summary,
+		minecraftVersion: index.Dependencies["minecraft"],
+		// server-overrides are applied on top of the common overrides.
+		overrides: []string{"server-overrides", "overrides"},
+	}
+	if p.minecraftVersion == "" {
+		return pack{}, fmt.Errorf("modrinth.index.json does not declare a Minecraft version")
+	}
+	for key, loader := range mrpackLoaders {
+		if version, ok := index.Dependencies[key]; ok {
+			p.loader, p.loaderVersion = loader, version
+		}
+	}
+	if p.loader == "" {
+		return pack{}, fmt.Errorf("modrinth.index.json does not declare a supported mod loader")
+	}
+
+	for _, file := range index.Files {
+		if file.Env != nil && file.Env.Server == "unsupported" {
+			continue // Client-only
+		}
+		path, err := cleanPath(file.Path)
+		if err != nil {
+			return pack{}, err
+		}
+		want := download.Hashes{SHA1: file.Hashes["sha1"], SHA512: file.Hashes["sha512"]}
+		if want.SHA1 == "" && want.SHA512 == "" {
+			return pack{}, fmt.Errorf("%s has no sha1 or sha512 hash", file.Path)
+		}
+		for _, download := range file.Downloads {
+			if !hostAllowed(download, allowedHosts) {
+				return pack{}, fmt.Errorf("%s downloads from a host that is not allowed: %s", file.Path, download)
+			}
+		}
+		p.files = append(p.files, packFile{path: path, urls: file.Downloads, hash: want})
+	}
+	return p, nil
+}
+
+// hostAllowed reports whether rawURL is an HTTP(S) URL on one of hosts. An empty list allows any host.
+func hostAllowed(rawURL string, hosts []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	if len(hosts) == 0 {
+		return true
+	}
+	for _, host := range hosts {
+		if u.Host == host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package monitoring
+
+import (
+	"context"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryCompactor periodically downsamples resource history and enforces retention.
+type HistoryCompactor struct {
+	historySvc services.HistoryServiceProvider
+	ticker     *time.Ticker
+	done       chan bool
+}
+
+// NewHistoryCompactor creates a new HistoryCompactor.
+func NewHistoryCompactor(historySvc services.HistoryServiceProvider) *HistoryCompactor {
+	return &HistoryCompactor{
+		historySvc: historySvc,
+		done:       make(chan bool),
+	}
+}
+
+// Run starts the compaction loop.
+func (hc *HistoryCompactor) Run() {
+	log.Info().Msg("Starting background history compactor...")
+	hc.ticker = time.NewTicker(1 * time.Minute)
+	defer hc.ticker.Stop()
+
+	// Run once immediately on start to catch up after downtime
+	hc.compact()
+
+	for {
+		select {
+		case <-hc.done:
+			log.Info().Msg("Stopping background history compactor.")
+			return
+		case <-hc.ticker.C:
+			hc.compact()
+		}
+	}
+}
+
+// Stop halts the compaction loop.
+func (hc *HistoryCompactor) Stop() {
+	hc.done <- true
+}
+
+func (hc *HistoryCompactor) compact() {
+	start := time.Now()
+	if err := hc.historySvc.CompactHistory(context.Background(), start); err != nil {
+		log.Error().Err(err).Msg("HistoryCompactor: Failed to compact resource history")
+		return
+	}
+	log.Debug().Dur("took", time.Since(start)).Msg("HistoryCompactor: Resource history compacted")
+}
@@ -0,0 +1,394 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// MetricsCollector periodically samples TPS, MSPT and JVM stats from running servers.
+type MetricsCollector struct {
+	serverSvc services.ServerServiceProvider
+	eventSvc  services.EventServiceProvider
+	interval  time.Duration
+	ticker    *time.Ticker
+	done      chan bool
+
+	mu          sync.Mutex
+	sources     map[string]string    // Server ID -> tick source that last worked
+	noSource    map[string]time.Time // Server ID -> when no tick source worked
+	collecting  map[string]bool      // Server IDs whose collection hasn't returned yet
+	lowTpsAlert map[string]time.Time // Server ID -> last low TPS alert
+}
+
+// NewMetricsCollector creates a new MetricsCollector that samples every interval.
+func NewMetricsCollector(serverSvc services.ServerServiceProvider, eventSvc services.EventServiceProvider, interval time.Duration) *MetricsCollector {
+	return &MetricsCollector{
+		serverSvc:   serverSvc,
+		eventSvc:    eventSvc,
+		interval:    interval,
+		done:        make(chan bool),
+		sources:     make(map[string]string),
+		noSource:    make(map[string]time.Time),
+		collecting:  make(map[string]bool),
+		lowTpsAlert: make(map[string]time.Time),
+	}
+}
+
+// Run starts the periodic collection.
+func (mc *MetricsCollector) Run() {
+	log.Info().Dur("interval", mc.interval).Msg("Starting background metrics collector...")
+	mc.ticker = time.NewTicker(mc.interval)
+	defer mc.ticker.Stop()
+
+	for {
+		select {
+		case <-mc.done:
+			log.Info().Msg("Stopping background metrics collector.")
+			return
+		case <-mc.ticker.C:
+			mc.collectAll()
+		}
+	}
+}
+
+// Stop halts the periodic collection.
+func (mc *MetricsCollector) Stop() {
+	mc.done <- true
+}
+
+// collectAll samples every online server and clears stale samples from servers that aren't.
+func (mc *MetricsCollector) collectAll() {
+	servers, err := mc.serverSvc.GetAllServers(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("MetricsCollector: Failed to query servers")
+		return
+	}
+
+	for _, s := range servers {
+		server := s
+		if server.Status == "online" {
+			// A server too slow to answer within an interval is skipped rather than asked again.
+			mc.mu.Lock()
+			busy := mc.collecting[server.ID]
+			mc.collecting[server.ID] = true
+			mc.mu.Unlock()
+			if !busy {
+				go func() {
+					defer func() {
+						mc.mu.Lock()
+						delete(mc.collecting, server.ID)
+						mc.mu.Unlock()
+					}()
+					mc.collectSingleServer(server)
+				}()
+			}
+			continue
+		}
+		// Plugins and mods only change while a server is down, so its tick source is looked for again.
+		mc.mu.Lock()
+		delete(mc.sources, server.ID)
+		delete(mc.noSource, server.ID)
+		mc.mu.Unlock()
+		if server.Resources.Game != nil {
+			if err := mc.serverSvc.UpdateServerGameMetrics(context.Background(), server.ID, nil); err != nil {
+				log.Warn().Err(err).Str("server_id", server.ID).Msg("MetricsCollector: Failed to clear metrics for offline server")
+			}
+		}
+	}
+}
+
+func (mc *MetricsCollector) collectSingleServer(server models.Server) {
+	metrics := &models.GameMetrics{}
+
+	mc.collectTickMetrics(server, metrics)
+	mc.collectJVMMetrics(server, metrics)
+
+	if err := mc.serverSvc.UpdateServerGameMetrics(context.Background(), server.ID, metrics); err != nil {
+		log.Error().Err(err).Str("server_name", server.Name).Msg("MetricsCollector: Failed to store game metrics")
+		return
+	}
+
+	mc.checkAndAlertForLowTPS(server, metrics)
+}
+
+// tickSource describes one way of asking a server for its TPS and MSPT.
+type tickSource struct {
+	name     string
+	commands []string
+	parse    func(outputs []string) (tps, mspt *float64)
+}
+
+// tickSourceRetry is how long a server that understood none of the tick sources is left alone before they
+// are tried again.
+const tickSourceRetry = 10 * time.Minute
+
+// tickSources are tried in order; spark is the most precise and works on any loader.
+var tickSources = []tickSource{
+	{name: "spark", commands: []string{"spark tps"}, parse: parseSparkTPS},
+	{name: "paper", commands: []string{"tps", "mspt"}, parse: parsePaperTPS},
+	{name: "forge", commands: []string{"forge tps"}, parse: parseForgeTPS},
+}
+
+// collectTickMetrics fills TPS and MSPT using the first source the server understands.
+func (mc *MetricsCollector) collectTickMetrics(server models.Server, metrics *models.GameMetrics) {
+	mc.mu.Lock()
+	cached := mc.sources[server.ID]
+	noSourceSince, noSource := mc.noSource[server.ID]
+	mc.mu.Unlock()
+
+	// Vanilla servers have no tick source, and would otherwise be sent every unknown command each tick.
+	if noSource && time.Since(noSourceSince) < tickSourceRetry {
+		return
+	}
+
+	// Try the source that worked last time first, so we don't spam unknown commands every tick.
+	ordered := make([]tickSource, 0, len(tickSources))
+	for _, src := range tickSources {
+		if src.name == cached {
+			ordered = append([]tickSource{src}, ordered...)
+		} else {
+			ordered = append(ordered, src)
+		}
+	}
+
+	for _, src := range ordered {
+		outputs := make([]string, 0, len(src.commands))
+		for _, cmd := range src.commands {
+			out, err := mc.serverSvc.SendRconCommand(context.Background(), server.ID, cmd)
+			if err != nil {
+				log.Debug().Err(err).Str("server_id", server.ID).Str("command", cmd).Msg("MetricsCollector: Tick command failed")
+				return // The command couldn't be sent over RCON, so the other sources can't be either
+			}
+			outputs = append(outputs, stripFormatting(out))
+		}
+
+		tps, mspt := src.parse(outputs)
+		if tps == nil && mspt == nil {
+			continue
+		}
+		metrics.TPS = tps
+		metrics.MSPT = mspt
+		metrics.Source = src.name
+
+		mc.mu.Lock()
+		mc.sources[server.ID] = src.name
+		delete(mc.noSource, server.ID)
+		mc.mu.Unlock()
+		return
+	}
+
+	mc.mu.Lock()
+	delete(mc.sources, server.ID)
+	mc.noSource[server.ID] = time.Now()
+	mc.mu.Unlock()
+}
+
+// jvmStatsCommand finds the Minecraft JVM inside the container and dumps its GC and capacity counters.
+const jvmStatsCommand = `pid=$(jcmd -l 2>/dev/null | awk '$2 !~ /JCmd/ {print $1; exit}'); [ -n "$pid" ] && jstat -gc "$pid" && jstat -gccapacity "$pid"`
+
+// collectJVMMetrics fills heap and GC stats by running jstat inside the container.
+func (mc *MetricsCollector) collectJVMMetrics(server models.Server, metrics *models.GameMetrics) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := mc.serverSvc.ExecuteTerminalCommand(ctx, server.ID, jvmStatsCommand)
+	if err != nil {
+		log.Debug().Err(err).Str("server_id", server.ID).Msg("MetricsCollector: Could not read JVM stats")
+		return
+	}
+
+	fields := parseJstatOutput(out)
+	if len(fields) == 0 {
+		return
+	}
+
+	if used, ok := sumFields(fields, "S0U", "S1U", "EU", "OU"); ok {
+		mb := used / 1024
+		metrics.HeapUsedMB = &mb
+	}
+	if max, ok := sumFields(fields, "NGCMX", "OGCMX"); ok {
+		mb := max / 1024
+		metrics.HeapMaxMB = &mb
+	}
+	// CGC (concurrent cycles) only exists on newer JDKs, so it's summed separately.
+	if count, ok := sumFields(fields, "YGC", "FGC"); ok {
+		if cgc, ok := fields["CGC"]; ok {
+			count += cgc
+		}
+		n := int64(count)
+		metrics.GCCount = &n
+	}
+	if gct, ok := fields["GCT"]; ok {
+		ms := int64(gct * 1000)
+		metrics.GCTimeMs = &ms
+	}
+}
+
+// checkAndAlertForLowTPS raises an event when a server is lagging.
+func (mc *MetricsCollector) checkAndAlertForLowTPS(server models.Server, metrics *models.GameMetrics) {
+	const lowTpsThreshold = 15.0
+	const alertCooldown = 15 * time.Minute
+
+	if metrics.TPS == nil || *metrics.TPS >= lowTpsThreshold {
+		return
+	}
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if lastAlertTime, ok := mc.lowTpsAlert[server.ID]; ok && time.Since(lastAlertTime) < alertCooldown {
+		return
+	}
+
+	msg := fmt.Sprintf("Low TPS (%.1f) detected on server '%s'.", *metrics.TPS, server.Name)
+	mc.eventSvc.CreateEvent(context.Background(), "system.alert.tps", "warn", msg, &server.ID)
+	mc.lowTpsAlert[server.ID] = time.Now()
+}
+
+// --- Output parsers ---
+
+var (
+	formattingCodeRe = regexp.MustCompile(`§[0-9a-fk-orA-FK-OR]`)
+	numberRe         = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	msptTripletRe    = regexp.MustCompile(`(\d+(?:\.\d+)?)/(\d+(?:\.\d+)?)/(\d+(?:\.\d+)?)`)
+	sparkDurationsRe = regexp.MustCompile(`(\d+(?:\.\d+)?)/(\d+(?:\.\d+)?)/(\d+(?:\.\d+)?)/(\d+(?:\.\d+)?)`)
+	forgeOverallRe   = regexp.MustCompile(`Overall\s*:.*?Mean tick time: (\d+(?:\.\d+)?) ms\. Mean TPS: (\d+(?:\.\d+)?)`)
+	forgeOverallNew  = regexp.MustCompile(`Overall: (\d+(?:\.\d+)?) TPS \((\d+(?:\.\d+)?) ms/tick\)`)
+)
+
+// stripFormatting removes Minecraft § color codes from command output.
+func stripFormatting(s string) string {
+	return formattingCodeRe.ReplaceAllString(s, "")
+}
+
+// tpsWindow is the window TPS is read over. Spark and Paper both report it, and it is the closest they
+// come to Forge, which only reports the mean of its recent ticks.
+const tpsWindow = "1m"
+
+// tpsInWindow reads the TPS over window from a "TPS from last 1m, 5m, 15m: 20.0, 20.0, 20.0" report,
+// whichever windows it lists and whether the values follow on the same line or the next.
+func tpsInWindow(s, window string) *float64 {
+	const marker = "TPS from last "
+	idx := strings.Index(s, marker)
+	if idx < 0 {
+		return nil
+	}
+	s = s[idx+len(marker):]
+	colon := strings.Index(s, ":")
+	if colon < 0 {
+		return nil
+	}
+	windows := strings.Split(s[:colon], ",")
+	values := numberRe.FindAllString(s[colon+1:], len(windows))
+	for i, w := range windows {
+		if strings.TrimSpace(w) == window && i < len(values) {
+			return parseFloatPtr(values[i])
+		}
+	}
+	return nil
+}
+
+func parseFloatPtr(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// parseSparkTPS reads `spark tps`:
+//
+//	TPS from last 5s, 10s, 1m, 5m, 15m:
+//	 *20.0, 20.0, 20.0, 20.0, 20.0
+//	Tick durations (min/med/95%ile/max ms) from last 10s, 1m:
+//	 0.5/1.2/3.4/10.1;  0.4/1.1/3.0/12.9
+func parseSparkTPS(outputs []string) (tps, mspt *float64) {
+	out := outputs[0]
+	if !strings.Contains(out, "TPS from last") {
+		return nil, nil
+	}
+	tps = tpsInWindow(out, tpsWindow)
+	if idx := strings.Index(out, "Tick durations"); idx >= 0 {
+		if m := sparkDurationsRe.FindStringSubmatch(out[idx:]); m != nil {
+			mspt = parseFloatPtr(m[2]) // median of the shortest window
+		}
+	}
+	return tps, mspt
+}
+
+// parsePaperTPS reads Paper's `tps` and `mspt`:
+//
+//	TPS from last 1m, 5m, 15m: 20.0, 20.0, 20.0
+//	Server tick times (avg/min/max) from last 5s, 10s, 1m:
+//	◴ 1.2/0.5/3.1, 1.3/0.5/4.0, 1.2/0.4/9.8
+func parsePaperTPS(outputs []string) (tps, mspt *float64) {
+	if strings.Contains(outputs[0], "TPS from last") {
+		tps = tpsInWindow(outputs[0], tpsWindow)
+	}
+	if len(outputs) > 1 && strings.Contains(outputs[1], "tick times") {
+		if m := msptTripletRe.FindStringSubmatch(outputs[1]); m != nil {
+			mspt = parseFloatPtr(m[1]) // average over the last 5s
+		}
+	}
+	return tps, mspt
+}
+
+// parseForgeTPS reads `forge tps`, handling both the legacy and the 1.20+ output format. Before 1.13 the
+// legacy format has a space before the colon:
+//
+//	Overall: Mean tick time: 2.345 ms. Mean TPS: 20.000
+//	Overall: 20.000 TPS (2.345 ms/tick)
+func parseForgeTPS(outputs []string) (tps, mspt *float64) {
+	out := outputs[0]
+	if m := forgeOverallRe.FindStringSubmatch(out); m != nil {
+		return parseFloatPtr(m[2]), parseFloatPtr(m[1])
+	}
+	if m := forgeOverallNew.FindStringSubmatch(out); m != nil {
+		return parseFloatPtr(m[1]), parseFloatPtr(m[2])
+	}
+	return nil, nil
+}
+
+// parseJstatOutput turns the header/value line pairs printed by jstat into a column map.
+func parseJstatOutput(out string) map[string]float64 {
+	fields := make(map[string]float64)
+	lines := strings.Split(out, "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		header := strings.Fields(lines[i])
+		values := strings.Fields(lines[i+1])
+		if len(header) == 0 || len(header) != len(values) {
+			continue
+		}
+		if _, err := strconv.ParseFloat(header[0], 64); err == nil {
+			continue // This is a value line, not a header
+		}
+		for j, name := range header {
+			if v, err := strconv.ParseFloat(values[j], 64); err == nil {
+				fields[name] = v
+			}
+		}
+		i++
+	}
+	return fields
+}
+
+// sumFields adds up the named jstat columns, reporting false if any is missing.
+func sumFields(fields map[string]float64, names ...string) (float64, bool) {
+	var total float64
+	for _, name := range names {
+		v, ok := fields[name]
+		if !ok {
+			return 0, false
+		}
+		total += v
+	}
+	return total, true
+}
@@ -0,0 +1,228 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+)
+
+// Output samples as the servers print them, formatting codes included.
+const (
+	sparkOutput = "§8[§e⚡§8] §7TPS from last 5s, 10s, 1m, 5m, 15m:\n" +
+		"§8[§e⚡§8] §a*20.0§7, §a19.8§7, §a18.7§7, §e16.2§7, §c9.5\n" +
+		"§8[§e⚡§8] \n" +
+		"§8[§e⚡§8] §7Tick durations (min/med/95%ile/max ms) from last 10s, 1m:\n" +
+		"§8[§e⚡§8] §a1.2§7/§a2.5§7/§a4.9§7/§e52.3§7;  §a1.1§7/§a2.4§7/§a5.2§7/§c140.1\n" +
+		"§8[§e⚡§8] \n" +
+		"§8[§e⚡§8] §7CPU usage from last 10s, 1m, 15m:\n" +
+		"§8[§e⚡§8] §a12%§7, §a10%§7, §a9%  §7(system)"
+	paperTPSOutput  = "§6TPS from last 1m, 5m, 15m: §a*20.0§r, §a19.97§r, §e17.5"
+	paperMSPTOutput = "§6Server tick times §e(§7avg§e/§7min§e/§7max§e)§6 from last 5s§7,§6 10s§7,§6 1m§e:\n" +
+		"§6◴ §a3.4§7/§a1.1§7/§a12.0§e, §a3.6§7/§a1.0§7/§a15.2§e, §a3.3§7/§a0.9§7/§e48.9"
+	forgeLegacyOutput = "Dim  0 (overworld) : Mean tick time: 1.874 ms. Mean TPS: 20.000\n" +
+		"Dim -1 (the_nether) : Mean tick time: 0.112 ms. Mean TPS: 20.000\n" +
+		"Overall : Mean tick time: 2.345 ms. Mean TPS: 19.870"
+	forge116Output = "Dim minecraft:overworld (minecraft:overworld): Mean tick time: 1.874 ms. Mean TPS: 20.000\n" +
+		"Overall: Mean tick time: 2.345 ms. Mean TPS: 19.870"
+	forgeNewOutput = "minecraft:overworld: 20.000 TPS (1.874 ms/tick)\n" +
+		"minecraft:the_nether: 20.000 TPS (0.112 ms/tick)\n" +
+		"Overall: 19.870 TPS (2.345 ms/tick)"
+	unknownCommand = "§cUnknown or incomplete command, see below for error§r\n§7spark tps§c§o<--[HERE]"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func TestTickParsers(t *testing.T) {
+	tests := []struct {
+		name      string
+		parse     func([]string) (tps, mspt *float64)
+		outputs   []string
+		tps, mspt *float64
+	}{
+		{"spark", parseSparkTPS, []string{sparkOutput}, ptr(18.7), ptr(2.5)},
+		{"spark without durations", parseSparkTPS, []string{"TPS from last 5s, 10s, 1m, 5m, 15m:\n 20.0, 20.0, 19.5, 20.0, 20.0"}, ptr(19.5), nil},
+		{"spark unknown command", parseSparkTPS, []string{unknownCommand}, nil, nil},
+		{"spark truncated", parseSparkTPS, []string{"TPS from last 5s, 10s, 1m, 5m, 15m:\n 20.0, 20.0"}, nil, nil},
+		{"spark empty", parseSparkTPS, []string{""}, nil, nil},
+		{"paper", parsePaperTPS, []string{paperTPSOutput, paperMSPTOutput}, ptr(20.0), ptr(3.4)},
+		{"paper without mspt", parsePaperTPS, []string{paperTPSOutput, unknownCommand}, ptr(20.0), nil},
+		{"paper only mspt", parsePaperTPS, []string{unknownCommand, paperMSPTOutput}, nil, ptr(3.4)},
+		{"paper unknown commands", parsePaperTPS, []string{unknownCommand, unknownCommand}, nil, nil},
+		{"paper no colon", parsePaperTPS, []string{"TPS from last 1m", ""}, nil, nil},
+		{"forge 1.12", parseForgeTPS, []string{forgeLegacyOutput}, ptr(19.87), ptr(2.345)},
+		{"forge 1.16", parseForgeTPS, []string{forge116Output}, ptr(19.87), ptr(2.345)},
+		{"forge 1.20", parseForgeTPS, []string{forgeNewOutput}, ptr(19.87), ptr(2.345)},
+		{"forge unknown command", parseForgeTPS, []string{unknownCommand}, nil, nil},
+		{"forge dimensions only", parseForgeTPS, []string{"minecraft:overworld: 20.000 TPS (1.874 ms/tick)"}, nil, nil},
+	}
+	for _, tt := range tests {
+		outputs := make([]string, len(tt.outputs))
+		for i, out := range tt.outputs {
+			outputs[i] = stripFormatting(out)
+		}
+		tps, mspt := tt.parse(outputs)
+		if !reflect.DeepEqual(tps, tt.tps) || !reflect.DeepEqual(mspt, tt.mspt) {
+			t.Errorf("%s: got tps %v, mspt %v; want %v, %v", tt.name, deref(tps), deref(mspt), deref(tt.tps), deref(tt.mspt))
+		}
+	}
+}
+
+// deref makes optional values readable in failure messages.
+func deref(v *float64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+const (
+	jstatGC = "    S0C         S1C         S0U         S1U          EC           EU           OC           OU          MC         MU       CCSC      CCSU     YGC     YGCT     FGC    FGCT     CGC    CGCT       GCT   \n" +
+		"        0.0         0.0         0.0      2048.0     188416.0      98304.0     808960.0     412672.0    123456.0   120000.5  15360.0   14000.2     42     0.512     1     0.250    10     0.120     0.882\n"
+	jstatCapacity = "    NGCMN        NGCMX         NGC          S0C     S1C              EC         OGCMN        OGCMX         OGC           OC         MCMN       MCMX        MC         CCSMN     CCSMX     CCSC      YGC    FGC   CGC  \n" +
+		"        0.0    1048576.0     188416.0        0.0  2048.0     188416.0          0.0    3145728.0     808960.0     808960.0        0.0  1179648.0   123456.0       0.0 1048576.0   15360.0     42     1    10\n"
+	// Java 8 has no concurrent collector columns.
+	jstatJava8 = " S0C    S1C    S0U    S1U      EC       EU        OC         OU       MC     MU    CCSC   CCSU   YGC     YGCT    FGC    FGCT     GCT   \n" +
+		"512.0  512.0   0.0   128.0   4096.0   1024.0   10240.0     2048.0   4864.0 4600.2 512.0  450.1      7    0.030   0      0.000    0.030\n"
+)
+
+func TestParseJstatOutput(t *testing.T) {
+	fields := parseJstatOutput(jstatGC + jstatCapacity)
+	for name, want := range map[string]float64{"EU": 98304, "OU": 412672, "YGC": 42, "CGC": 10, "GCT": 0.882, "NGCMX": 1048576, "OGCMX": 3145728} {
+		if got, ok := fields[name]; !ok || got != want {
+			t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+		}
+	}
+
+	if fields := parseJstatOutput(jstatJava8); fields["YGC"] != 7 || len(fields) != 17 {
+		t.Errorf("Java 8 output parsed to %v", fields)
+	}
+	for name, out := range map[string]string{
+		"empty":           "",
+		"process missing": "sh: jcmd: not found\n",
+		"mismatched":      "S0C S1C\n1.0\n",
+	} {
+		if fields := parseJstatOutput(out); len(fields) != 0 {
+			t.Errorf("%s: parsed %v, want nothing", name, fields)
+		}
+	}
+}
+
+// fakeServers answers commands with canned output and records what it was sent.
+type fakeServers struct {
+	services.ServerServiceProvider
+	rcon     map[string]string
+	rconErr  error
+	terminal string
+	sent     []string
+}
+
+func (f *fakeServers) SendRconCommand(ctx context.Context, serverID, command string) (string, error) {
+	f.sent = append(f.sent, command)
+	if f.rconErr != nil {
+		return "", f.rconErr
+	}
+	if out, ok := f.rcon[command]; ok {
+		return out, nil
+	}
+	return unknownCommand, nil
+}
+
+func (f *fakeServers) ExecuteTerminalCommand(ctx context.Context, serverID, command string) (string, error) {
+	return f.terminal, nil
+}
+
+func TestCollectJVMMetrics(t *testing.T) {
+	mc := NewMetricsCollector(&fakeServers{terminal: jstatGC + jstatCapacity}, nil, time.Second)
+	var metrics models.GameMetrics
+	mc.collectJVMMetrics(models.Server{ID: "srv1"}, &metrics)
+
+	if metrics.HeapUsedMB == nil || *metrics.HeapUsedMB != (2048+98304+412672)/1024.0 {
+		t.Errorf("HeapUsedMB = %v", deref(metrics.HeapUsedMB))
+	}
+	if metrics.HeapMaxMB == nil || *metrics.HeapMaxMB != 4096 {
+		t.Errorf("HeapMaxMB = %v, want 4096", deref(metrics.HeapMaxMB))
+	}
+	if metrics.GCCount == nil || *metrics.GCCount != 53 {
+		t.Errorf("GCCount = %v, want 53", metrics.GCCount)
+	}
+	if metrics.GCTimeMs == nil || *metrics.GCTimeMs != 882 {
+		t.Errorf("GCTimeMs = %v, want 882", metrics.GCTimeMs)
+	}
+}
+
+func TestCollectTickMetricsFindsSource(t *testing.T) {
+	servers := &fakeServers{rcon: map[string]string{"tps": paperTPSOutput, "mspt": paperMSPTOutput}}
+	mc := NewMetricsCollector(servers, nil, time.Second)
+	server := models.Server{ID: "srv1"}
+
+	var metrics models.GameMetrics
+	mc.collectTickMetrics(server, &metrics)
+	if metrics.Source != "paper" || deref(metrics.TPS) != 20.0 || deref(metrics.MSPT) != 3.4 {
+		t.Errorf("collectTickMetrics() = %+v", metrics)
+	}
+	if want := []string{"spark tps", "tps", "mspt"}; !reflect.DeepEqual(servers.sent, want) {
+		t.Errorf("sent %q, want %q", servers.sent, want)
+	}
+
+	// The source that worked is asked first from then on.
+	servers.sent = nil
+	mc.collectTickMetrics(server, &models.GameMetrics{})
+	if want := []string{"tps", "mspt"}; !reflect.DeepEqual(servers.sent, want) {
+		t.Errorf("sent %q on the next tick, want %q", servers.sent, want)
+	}
+}
+
+func TestCollectTickMetricsBacksOffWithoutSource(t *testing.T) {
+	servers := &fakeServers{}
+	mc := NewMetricsCollector(servers, nil, time.Second)
+	server := models.Server{ID: "srv1"}
+
+	var metrics models.GameMetrics
+	mc.collectTickMetrics(server, &metrics)
+	if metrics.TPS != nil || metrics.MSPT != nil || metrics.Source != "" {
+		t.Errorf("a server without a tick source reported %+v", metrics)
+	}
+	if len(servers.sent) != 4 {
+		t.Errorf("sent %q, want every source's commands once", servers.sent)
+	}
+
+	servers.sent = nil
+	mc.collectTickMetrics(server, &models.GameMetrics{})
+	if len(servers.sent) != 0 {
+		t.Errorf("sent %q within the retry interval", servers.sent)
+	}
+
+	// Once the retry interval has passed, the sources are tried again.
+	mc.mu.Lock()
+	mc.noSource[server.ID] = time.Now().Add(-tickSourceRetry)
+	mc.mu.Unlock()
+	servers.rcon = map[string]string{"spark tps": sparkOutput}
+	metrics = models.GameMetrics{}
+	mc.collectTickMetrics(server, &metrics)
+	if metrics.Source != "spark" {
+		t.Errorf("Source = %q after the retry interval, want spark", metrics.Source)
+	}
+}
+
+func TestCollectTickMetricsWithoutRcon(t *testing.T) {
+	servers := &fakeServers{rconErr: errors.New("rcon is not enabled")}
+	mc := NewMetricsCollector(servers, nil, time.Second)
+	server := models.Server{ID: "srv1"}
+
+	mc.collectTickMetrics(server, &models.GameMetrics{})
+	if len(servers.sent) != 1 {
+		t.Errorf("sent %q after RCON failed, want only the first command", servers.sent)
+	}
+	// A server RCON can't reach yet isn't one without a tick source.
+	mc.mu.Lock()
+	_, backedOff := mc.noSource[server.ID]
+	mc.mu.Unlock()
+	if backedOff {
+		t.Error("an RCON failure was taken for a server without a tick source")
+	}
+}
@@ -1,14 +1,18 @@
 package monitoring
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
 	"github.com/isdelr/ender-deploy-be/internal/models"
 	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Scheduler checks for and executes scheduled tasks.
@@ -87,12 +91,16 @@ func (s *Scheduler) checkAndRunSchedules() {
 
 // executeTask performs the action defined by the schedule.
 func (s *Scheduler) executeTask(schedule models.Schedule) {
-	log.Info().Str("task_name", schedule.Name).Str("server_id", schedule.ServerID).Msg("Scheduler: Executing task")
+	ctx, span := tracing.Start(context.Background(), "scheduler."+schedule.TaskType,
+		attribute.String("schedule.id", schedule.ID), attribute.String("server.id", schedule.ServerID))
 	var err error
+	defer func() { tracing.End(span, err) }()
+
+	log.Info().Ctx(ctx).Str("task_name", schedule.Name).Str("server_id", schedule.ServerID).Msg("Scheduler: Executing task")
 
 	switch schedule.TaskType {
 	case "start", "stop", "restart":
-		err = s.serverSvc.PerformServerAction(schedule.ServerID, schedule.TaskType)
+		err = s.serverSvc.PerformServerAction(ctx, schedule.ServerID, schedule.TaskType)
 	case "backup":
 		var payload struct {
 			Name string `json:"name"`
@@ -105,7 +113,8 @@ func (s *Scheduler) executeTask(schedule models.Schedule) {
 		} else {
 			payload.Name = "Scheduled Backup"
 		}
-		go s.backupSvc.CreateBackup(schedule.ServerID, payload.Name) // Run backup in its own goroutine
+		// executeTask has its own goroutine already, and the backup's spans need the task's to still be open.
+		_, err = s.backupSvc.CreateBackup(ctx, schedule.ServerID, payload.Name)
 	case "command":
 		var payload struct {
 			Command string `json:"command"`
@@ -113,18 +122,20 @@ func (s *Scheduler) executeTask(schedule models.Schedule) {
 		if schedule.Payload == nil || json.Unmarshal(schedule.Payload, &payload) != nil || payload.Command == "" {
 			err = fmt.Errorf("invalid or missing command in payload for schedule %s", schedule.ID)
 		} else {
-			_, err = s.serverSvc.SendCommandToServer(schedule.ServerID, payload.Command)
+			// Nothing confirms a command typed into the console ran, so schedules need RCON.
+			_, err = s.serverSvc.SendRconCommand(ctx, schedule.ServerID, payload.Command)
 		}
 	default:
 		err = fmt.Errorf("unknown task type '%s' for schedule %s", schedule.TaskType, schedule.ID)
 	}
 
+	metrics.ObserveSchedulerRun(schedule.TaskType, err)
 	if err != nil {
-		log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("Scheduler: Error executing task")
+		log.Error().Ctx(ctx).Err(err).Str("schedule_id", schedule.ID).Msg("Scheduler: Error executing task")
 		msg := fmt.Sprintf("Scheduled task '%s' failed to execute: %v", schedule.Name, err)
-		s.eventSvc.CreateEvent("schedule.execute.fail", "error", msg, &schedule.ServerID)
+		s.eventSvc.CreateEvent(ctx, "schedule.execute.fail", "error", msg, &schedule.ServerID)
 	} else {
 		msg := fmt.Sprintf("Scheduled task '%s' executed successfully.", schedule.Name)
-		s.eventSvc.CreateEvent("schedule.execute.success", "info", msg, &schedule.ServerID)
+		s.eventSvc.CreateEvent(ctx, "schedule.execute.success", "info", msg, &schedule.ServerID)
 	}
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/docker/docker/client"
 	"github.com/isdelr/ender-deploy-be/internal/docker"
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
 	"github.com/isdelr/ender-deploy-be/internal/models"
 	"github.com/isdelr/ender-deploy-be/internal/services"
 	"github.com/rs/zerolog/log"
@@ -66,7 +67,7 @@ func (su *StatUpdater) Stop() {
 
 // updateAllServerStats fetches all servers from the DB and updates their stats if they are online.
 func (su *StatUpdater) updateAllServerStats() {
-	servers, err := su.serverSvc.GetAllServers()
+	servers, err := su.serverSvc.GetAllServers(context.Background())
 	if err != nil {
 		log.Error().Err(err).Msg("StatUpdater: Failed to query servers")
 		return
@@ -88,6 +89,10 @@ func (su *StatUpdater) updateSingleServer(server *models.Server) {
 		return
 	}
 
+	if server.Status == "online" && su.detectCrash(ctx, server) {
+		return
+	}
+
 	stats, err := su.docker.GetContainerStats(ctx, server.DockerContainerID)
 
 	if err != nil {
@@ -126,12 +131,40 @@ func (su *StatUpdater) updateSingleServer(server *models.Server) {
 
 	// This part is now only reached if stats were successfully retrieved
 	// OR if the container was found to be missing and needed its status corrected.
-	err = su.serverSvc.UpdateServerStats(*server)
+	err = su.serverSvc.UpdateServerStats(context.Background(), *server)
 	if err != nil {
 		log.Error().Err(err).Str("server_name", server.Name).Msg("StatUpdater: Failed to update server stats in DB")
 	}
 }
 
+// detectCrash marks an online server offline if its container has exited on its own.
+// It reports whether a crash was handled.
+func (su *StatUpdater) detectCrash(ctx context.Context, server *models.Server) bool {
+	info, err := su.docker.InspectContainer(ctx, server.DockerContainerID)
+	if err != nil || info.State == nil || info.State.Running {
+		return false
+	}
+
+	// The server may have been stopped through the API since we listed it.
+	current, err := su.serverSvc.GetServerByID(context.Background(), server.ID)
+	if err != nil || current.Status != "online" {
+		return false
+	}
+
+	log.Warn().Str("server_name", server.Name).Str("server_id", server.ID).Int("exit_code", info.State.ExitCode).Msg("StatUpdater: Container exited unexpectedly, marking as offline")
+	metrics.ObserveServerCrash(server.ID)
+	msg := fmt.Sprintf("Server '%s' stopped unexpectedly (exit code %d).", server.Name, info.State.ExitCode)
+	su.eventSvc.CreateEvent(context.Background(), "server.crash", "error", msg, &server.ID)
+
+	server.Status = "offline"
+	server.Resources = models.ResourceUsage{}
+	server.Players.Current = 0
+	if err := su.serverSvc.UpdateServerStats(context.Background(), *server); err != nil {
+		log.Error().Err(err).Str("server_name", server.Name).Msg("StatUpdater: Failed to update crashed server in DB")
+	}
+	return true
+}
+
 func (su *StatUpdater) checkAndAlertForHighCPU(server *models.Server) {
 	const highCpuThreshold = 90.0
 	const alertCooldown = 15 * time.Minute
@@ -145,7 +178,7 @@ func (su *StatUpdater) checkAndAlertForHighCPU(server *models.Server) {
 		}
 		// If CPU is high and no recent alert was sent, create one.
 		msg := fmt.Sprintf("High CPU usage (%.1f%%) detected on server '%s'.", server.Resources.CPU, server.Name)
-		su.eventSvc.CreateEvent("system.alert.cpu", "warn", msg, &server.ID)
+		su.eventSvc.CreateEvent(context.Background(), "system.alert.cpu", "warn", msg, &server.ID)
 		su.highCpuAlert[server.ID] = time.Now()
 	}
 }
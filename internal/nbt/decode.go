@@ -0,0 +1,309 @@
+package nbt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf16"
+
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// Limits that keep a corrupt or hostile file from exhausting memory or the stack. The depth is the one
+// the game itself enforces.
+const (
+	maxDepth       = 512
+	maxArrayLength = 1 << 24
+)
+
+// ErrInvalid is returned for data that is not valid NBT.
+var ErrInvalid = errors.New("invalid NBT data")
+
+// ReadFile reads the NBT file name in root, which may be gzip, zlib or uncompressed.
+func ReadFile(root *sandbox.Root, name string) (Compound, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	_, compound, _, err := Read(f)
+	return compound, err
+}
+
+// Read decodes a root compound, detecting whether r is gzip, zlib or uncompressed. It returns the root's
+// name, which is usually empty, and the compression found so the data can be written back the same way.
+func Read(r io.Reader) (string, Compound, Compression, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return "", nil, None, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	var name string
+	var root Compound
+	switch {
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", nil, None, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		defer zr.Close()
+		name, root, err = Decode(zr)
+		return name, root, Gzip, err
+	case magic[0] == 0x78:
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return "", nil, None, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		defer zr.Close()
+		name, root, err = Decode(zr)
+		return name, root, Zlib, err
+	}
+	name, root, err = Decode(br)
+	return name, root, None, err
+}
+
+// Decode decodes an uncompressed root compound from r and returns its name.
+func Decode(r io.Reader) (string, Compound, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	t, err := d.byte()
+	if err != nil {
+		return "", nil, d.wrap(err)
+	}
+	if t != TagCompound {
+		return "", nil, fmt.Errorf("%w: root is a %s, not a TAG_Compound", ErrInvalid, tagName(t))
+	}
+	name, err := d.string()
+	if err != nil {
+		return "", nil, d.wrap(err)
+	}
+	root, err := d.compound(0)
+	if err != nil {
+		return "", nil, d.wrap(err)
+	}
+	return name, root, nil
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	buf [8]byte
+}
+
+// wrap marks read errors as invalid data, since a truncated file is a corrupt one.
+func (d *decoder) wrap(err error) error {
+	if errors.Is(err, ErrInvalid) {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %v", ErrInvalid, err)
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	_, err := io.ReadFull(d.r, d.buf[:n])
+	return d.buf[:n], err
+}
+
+func (d *decoder) byte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+func (d *decoder) uint16() (uint16, error) {
+	b, err := d.read(2)
+	return binary.BigEndian.Uint16(b), err
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	b, err := d.read(4)
+	return binary.BigEndian.Uint32(b), err
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	b, err := d.read(8)
+	return binary.BigEndian.Uint64(b), err
+}
+
+// length reads the length of an array or list.
+func (d *decoder) length() (int, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if int32(n) < 0 || n > maxArrayLength {
+		return 0, fmt.Errorf("%w: length %d out of range", ErrInvalid, int32(n))
+	}
+	return int(n), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint16()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return "", err
+	}
+	return decodeMUTF8(b), nil
+}
+
+func (d *decoder) compound(depth int) (Compound, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: nested deeper than %d", ErrInvalid, maxDepth)
+	}
+	c := Compound{}
+	for {
+		t, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if t == TagEnd {
+			return c, nil
+		}
+		name, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		if c[name], err = d.payload(t, depth+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *decoder) payload(t byte, depth int) (any, error) {
+	switch t {
+	case TagByte:
+		b, err := d.byte()
+		return int8(b), err
+	case TagShort:
+		v, err := d.uint16()
+		return int16(v), err
+	case TagInt:
+		v, err := d.uint32()
+		return int32(v), err
+	case TagLong:
+		v, err := d.uint64()
+		return int64(v), err
+	case TagFloat:
+		v, err := d.uint32()
+		return math.Float32frombits(v), err
+	case TagDouble:
+		v, err := d.uint64()
+		return math.Float64frombits(v), err
+	case TagByteArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return nil, err
+		}
+		a := make([]int8, n)
+		for i, v := range b {
+			a[i] = int8(v)
+		}
+		return a, nil
+	case TagString:
+		return d.string()
+	case TagList:
+		return d.list(depth)
+	case TagCompound:
+		return d.compound(depth)
+	case TagIntArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		a := make([]int32, 0, min(n, 4096))
+		for range n {
+			v, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, int32(v))
+		}
+		return a, nil
+	case TagLongArray:
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		a := make([]int64, 0, min(n, 4096))
+		for range n {
+			v, err := d.uint64()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, int64(v))
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrInvalid, tagName(t))
+}
+
+func (d *decoder) list(depth int) (List, error) {
+	if depth > maxDepth {
+		return List{}, fmt.Errorf("%w: nested deeper than %d", ErrInvalid, maxDepth)
+	}
+	t, err := d.byte()
+	if err != nil {
+		return List{}, err
+	}
+	n, err := d.length()
+	if err != nil {
+		return List{}, err
+	}
+	if t == TagEnd && n > 0 {
+		return List{}, fmt.Errorf("%w: non-empty list of TAG_End", ErrInvalid)
+	}
+	l := List{Type: t, Items: make([]any, 0, min(n, 4096))}
+	for range n {
+		v, err := d.payload(t, depth+1)
+		if err != nil {
+			return List{}, err
+		}
+		l.Items = append(l.Items, v)
+	}
+	return l, nil
+}
+
+// decodeMUTF8 decodes Java's modified UTF-8, in which NUL is two bytes and characters outside the Basic
+// Multilingual Plane are surrogate pairs of three bytes each. Malformed sequences become U+FFFD.
+func decodeMUTF8(b []byte) string {
+	ascii := true
+	for _, c := range b {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return string(b)
+	}
+
+	units := make([]uint16, 0, len(b))
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			i++
+		case c&0xe0 == 0xc0 && i+1 < len(b) && b[i+1]&0xc0 == 0x80:
+			units = append(units, uint16(c&0x1f)<<6|uint16(b[i+1]&0x3f))
+			i += 2
+		case c&0xf0 == 0xe0 && i+2 < len(b) && b[i+1]&0xc0 == 0x80 && b[i+2]&0xc0 == 0x80:
+			units = append(units, uint16(c&0x0f)<<12|uint16(b[i+1]&0x3f)<<6|uint16(b[i+2]&0x3f))
+			i += 3
+		default:
+			units = append(units, 0xfffd)
+			i++
+		}
+	}
+	return string(utf16.Decode(units))
+}
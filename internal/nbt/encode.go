@@ -0,0 +1,260 @@
+package nbt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// WriteFile writes root to the file name in dir with the given compression. The file is replaced atomically,
+// so a failed write leaves the previous contents in place.
+func WriteFile(dir *sandbox.Root, name string, root Compound, compression Compression) error {
+	return dir.WriteFileFrom(name, 0644, func(w io.Writer) error {
+		return Write(w, "", root, compression)
+	})
+}
+
+// Write encodes root under name to w with the given compression.
+func Write(w io.Writer, name string, root Compound, compression Compression) error {
+	switch compression {
+	case None:
+		return Encode(w, name, root)
+	case Gzip:
+		zw := gzip.NewWriter(w)
+		if err := Encode(zw, name, root); err != nil {
+			return err
+		}
+		return zw.Close()
+	case Zlib:
+		zw := zlib.NewWriter(w)
+		if err := Encode(zw, name, root); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return fmt.Errorf("unknown compression %d", compression)
+}
+
+// Encode encodes root under name to w without compression. The keys of compounds are written in sorted
+// order, so equal values always encode to the same bytes.
+func Encode(w io.Writer, name string, root Compound) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.byte(TagCompound)
+	e.string(name)
+	e.compound(root, 0)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// TypeOf returns the tag type of a value, or false if it is not one of the types tags decode to.
+func TypeOf(v any) (byte, bool) {
+	switch v.(type) {
+	case int8:
+		return TagByte, true
+	case int16:
+		return TagShort, true
+	case int32:
+		return TagInt, true
+	case int64:
+		return TagLong, true
+	case float32:
+		return TagFloat, true
+	case float64:
+		return TagDouble, true
+	case []int8:
+		return TagByteArray, true
+	case string:
+		return TagString, true
+	case List:
+		return TagList, true
+	case Compound:
+		return TagCompound, true
+	case []int32:
+		return TagIntArray, true
+	case []int64:
+		return TagLongArray, true
+	}
+	return 0, false
+}
+
+// encoder writes tags, keeping the first error so callers check once at the end.
+type encoder struct {
+	w   *bufio.Writer
+	buf [8]byte
+	err error
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) byte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	binary.BigEndian.PutUint16(e.buf[:2], v)
+	e.write(e.buf[:2])
+}
+
+func (e *encoder) uint32(v uint32) {
+	binary.BigEndian.PutUint32(e.buf[:4], v)
+	e.write(e.buf[:4])
+}
+
+func (e *encoder) uint64(v uint64) {
+	binary.BigEndian.PutUint64(e.buf[:8], v)
+	e.write(e.buf[:8])
+}
+
+func (e *encoder) length(n int) {
+	if n > maxArrayLength {
+		e.fail(fmt.Errorf("length %d is too large", n))
+		return
+	}
+	e.uint32(uint32(n))
+}
+
+func (e *encoder) string(s string) {
+	b := encodeMUTF8(s)
+	if len(b) > math.MaxUint16 {
+		e.fail(fmt.Errorf("string of %d bytes is too long", len(b)))
+		return
+	}
+	e.uint16(uint16(len(b)))
+	e.write(b)
+}
+
+func (e *encoder) compound(c Compound, depth int) {
+	if depth > maxDepth {
+		e.fail(fmt.Errorf("nested deeper than %d", maxDepth))
+		return
+	}
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		t, ok := TypeOf(c[key])
+		if !ok {
+			e.fail(fmt.Errorf("%s: %T can't be encoded as NBT", key, c[key]))
+			return
+		}
+		e.byte(t)
+		e.string(key)
+		e.payload(c[key], depth+1)
+	}
+	e.byte(TagEnd)
+}
+
+func (e *encoder) payload(v any, depth int) {
+	switch v := v.(type) {
+	case int8:
+		e.byte(byte(v))
+	case int16:
+		e.uint16(uint16(v))
+	case int32:
+		e.uint32(uint32(v))
+	case int64:
+		e.uint64(uint64(v))
+	case float32:
+		e.uint32(math.Float32bits(v))
+	case float64:
+		e.uint64(math.Float64bits(v))
+	case []int8:
+		e.length(len(v))
+		b := make([]byte, len(v))
+		for i, x := range v {
+			b[i] = byte(x)
+		}
+		e.write(b)
+	case string:
+		e.string(v)
+	case List:
+		e.list(v, depth)
+	case Compound:
+		e.compound(v, depth)
+	case []int32:
+		e.length(len(v))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case []int64:
+		e.length(len(v))
+		for _, x := range v {
+			e.uint64(uint64(x))
+		}
+	default:
+		e.fail(fmt.Errorf("%T can't be encoded as NBT", v))
+	}
+}
+
+func (e *encoder) list(l List, depth int) {
+	if depth > maxDepth {
+		e.fail(fmt.Errorf("nested deeper than %d", maxDepth))
+		return
+	}
+	if l.Type == TagEnd && len(l.Items) > 0 {
+		e.fail(fmt.Errorf("non-empty list of TAG_End"))
+		return
+	}
+	for i, item := range l.Items {
+		if t, ok := TypeOf(item); !ok || t != l.Type {
+			e.fail(fmt.Errorf("item %d of a list of %s is a %T", i, tagName(l.Type), item))
+			return
+		}
+	}
+	e.byte(l.Type)
+	e.length(len(l.Items))
+	for _, item := range l.Items {
+		e.payload(item, depth+1)
+	}
+}
+
+// encodeMUTF8 encodes s as Java's modified UTF-8.
+func encodeMUTF8(s string) []byte {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return []byte(s)
+	}
+
+	b := make([]byte, 0, len(s)+8)
+	for _, u := range utf16.Encode([]rune(s)) {
+		switch {
+		case u != 0 && u < 0x80:
+			b = append(b, byte(u))
+		case u < 0x800:
+			b = append(b, 0xc0|byte(u>>6), 0x80|byte(u&0x3f))
+		default:
+			b = append(b, 0xe0|byte(u>>12), 0x80|byte(u>>6&0x3f), 0x80|byte(u&0x3f))
+		}
+	}
+	return b
+}
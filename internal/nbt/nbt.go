@@ -0,0 +1,88 @@
+// Package nbt reads and writes Minecraft's Named Binary Tag format, the big-endian encoding of the Java
+// edition used by level.dat, player data and region files.
+//
+// Tags decode to Go values: TAG_Byte to int8, TAG_Short to int16, TAG_Int to int32, TAG_Long to int64,
+// TAG_Float to float32, TAG_Double to float64, TAG_Byte_Array to []int8, TAG_String to string,
+// TAG_List to List, TAG_Compound to Compound, TAG_Int_Array to []int32 and TAG_Long_Array to []int64.
+package nbt
+
+import "fmt"
+
+// Tag types.
+const (
+	TagEnd byte = iota
+	TagByte
+	TagShort
+	TagInt
+	TagLong
+	TagFloat
+	TagDouble
+	TagByteArray
+	TagString
+	TagList
+	TagCompound
+	TagIntArray
+	TagLongArray
+)
+
+// Compression is how NBT data is compressed.
+type Compression byte
+
+// Compressions. Files such as level.dat and player data are gzip; chunks in region files are usually zlib.
+const (
+	None Compression = iota
+	Gzip
+	Zlib
+)
+
+// Compound is a TAG_Compound.
+type Compound map[string]any
+
+// List is a TAG_List. Type is the tag type of its items, kept so an empty list round-trips.
+type List struct {
+	Type  byte
+	Items []any
+}
+
+// Get returns the value of key in c if it has type T.
+func Get[T any](c Compound, key string) (T, bool) {
+	v, ok := c[key].(T)
+	return v, ok
+}
+
+// Path follows keys through nested compounds and returns the value at the end if it has type T.
+func Path[T any](c Compound, keys ...string) (T, bool) {
+	var zero T
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := c[key].(Compound)
+		if !ok {
+			return zero, false
+		}
+		c = next
+	}
+	return Get[T](c, keys[len(keys)-1])
+}
+
+// Number returns the value of key in c as an int64 if it is any integer tag.
+func Number(c Compound, key string) (int64, bool) {
+	switch v := c[key].(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// tagName names a tag type in errors.
+func tagName(t byte) string {
+	names := []string{"End", "Byte", "Short", "Int", "Long", "Float", "Double", "Byte_Array", "String", "List", "Compound", "Int_Array", "Long_Array"}
+	if int(t) < len(names) {
+		return "TAG_" + names[t]
+	}
+	return fmt.Sprintf("unknown tag type %d", t)
+}
@@ -0,0 +1,228 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// everyTag holds a value of every tag type, with edge cases where the encoding has them.
+func everyTag() Compound {
+	return Compound{
+		"byte":      int8(-128),
+		"short":     int16(math.MaxInt16),
+		"int":       int32(math.MinInt32),
+		"long":      int64(math.MaxInt64),
+		"float":     float32(3.25),
+		"double":    math.Inf(-1),
+		"byteArray": []int8{-1, 0, 1, 127},
+		"string":    "Spawn é世\U0001F30D\x00",
+		"list":      List{Type: TagString, Items: []any{"a", "b"}},
+		"emptyList": List{Type: TagCompound, Items: []any{}},
+		"nestedList": List{Type: TagList, Items: []any{
+			List{Type: TagInt, Items: []any{int32(1), int32(2)}},
+			List{Type: TagEnd, Items: []any{}},
+		}},
+		"compound": Compound{
+			"inner": Compound{"x": int32(-4), "y": int16(64)},
+			"":      "empty name",
+		},
+		"intArray":  []int32{math.MinInt32, 0, math.MaxInt32},
+		"longArray": []int64{math.MinInt64, 0, math.MaxInt64},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, compression := range []Compression{None, Gzip, Zlib} {
+		var buf bytes.Buffer
+		if err := Write(&buf, "Data", everyTag(), compression); err != nil {
+			t.Fatalf("compression %d: Write() = %v", compression, err)
+		}
+		name, root, found, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("compression %d: Read() = %v", compression, err)
+		}
+		if name != "Data" || found != compression {
+			t.Errorf("compression %d: Read() = name %q, compression %d; want %q, %d", compression, name, found, "Data", compression)
+		}
+		if !reflect.DeepEqual(root, everyTag()) {
+			t.Errorf("compression %d: decoded %#v, want %#v", compression, root, everyTag())
+		}
+	}
+}
+
+func TestEncodeIsDeterministic(t *testing.T) {
+	var a, b bytes.Buffer
+	if err := Encode(&a, "", everyTag()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&b, "", everyTag()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("equal values encoded to different bytes")
+	}
+}
+
+func TestEncodeRejectsUnknownTypes(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, "", Compound{"int": 1}); err == nil {
+		t.Error("Encode accepted an int, which has no tag type")
+	}
+}
+
+func TestDecodeRejectsInvalidData(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, "", everyTag()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for name, input := range map[string][]byte{
+		"truncated":    data[:len(data)/2],
+		"not compound": {TagInt, 0, 0, 0, 0, 0, 1},
+		"huge array":   {TagCompound, 0, 0, TagIntArray, 0, 1, 'a', 0x7f, 0xff, 0xff, 0xff},
+	} {
+		if _, _, _, err := Read(bytes.NewReader(input)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: Read() = %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	root, err := sandbox.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	if err := WriteFile(root, "level.dat", everyTag(), Gzip); err != nil {
+		t.Fatal(err)
+	}
+	level, err := ReadFile(root, "level.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(level, everyTag()) {
+		t.Errorf("ReadFile() = %#v, want %#v", level, everyTag())
+	}
+}
+
+// regionChunk is a chunk to put into a test region file.
+type regionChunk struct {
+	x, z        int
+	compression byte
+	data        []byte // Stored in a .mcc file if compression has externalChunk set
+	timestamp   uint32
+}
+
+// writeRegion lays out a region file the way the game does, with each chunk padded to whole sectors.
+func writeRegion(t *testing.T, dir, name string, regionX, regionZ int, chunks []regionChunk) {
+	t.Helper()
+	header := make([]byte, 2*sectorSize)
+	var body []byte
+	for _, c := range chunks {
+		payload := c.data
+		if c.compression&externalChunk != 0 {
+			mcc := filepath.Join(dir, fmt.Sprintf("c.%d.%d.mcc", regionX*regionWidth+c.x, regionZ*regionWidth+c.z))
+			if err := os.WriteFile(mcc, c.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			payload = nil
+		}
+		sector := make([]byte, 5, sectorSize)
+		binary.BigEndian.PutUint32(sector, uint32(len(payload)+1))
+		sector[4] = c.compression
+		sector = append(sector, payload...)
+		sectors := (len(sector) + sectorSize - 1) / sectorSize
+		sector = append(sector, make([]byte, sectors*sectorSize-len(sector))...)
+
+		offset := 2 + len(body)/sectorSize
+		i := c.z*regionWidth + c.x
+		binary.BigEndian.PutUint32(header[i*4:], uint32(offset)<<8|uint32(sectors))
+		binary.BigEndian.PutUint32(header[sectorSize+i*4:], c.timestamp)
+		body = append(body, sector...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), append(header, body...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encoded(t *testing.T, root Compound, compression Compression) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, "", root, compression); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRegion(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "region"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(x, z int) Compound {
+		return Compound{"xPos": int32(x), "zPos": int32(z), "Status": "minecraft:full"}
+	}
+	writeRegion(t, filepath.Join(dir, "region"), "r.-1.2.mca", -1, 2, []regionChunk{
+		{x: 0, z: 0, compression: chunkZlib, data: encoded(t, chunk(-32, 64), Zlib), timestamp: 1700000000},
+		{x: 31, z: 5, compression: chunkGzip, data: encoded(t, chunk(-1, 69), Gzip)},
+		{x: 3, z: 31, compression: chunkUncompressed, data: encoded(t, chunk(-29, 95), None)},
+		{x: 7, z: 7, compression: chunkZlib | externalChunk, data: encoded(t, chunk(-25, 71), Zlib)},
+		{x: 8, z: 8, compression: chunkLZ4, data: []byte{0}},
+	})
+
+	root, err := sandbox.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	region, err := OpenRegion(root, "region/r.-1.2.mca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer region.Close()
+
+	if x, z := region.Position(); x != -1 || z != 2 {
+		t.Errorf("Position() = %d, %d; want -1, 2", x, z)
+	}
+	if got := len(region.Chunks()); got != 5 {
+		t.Errorf("Chunks() lists %d chunks, want 5", got)
+	}
+	if got := region.Timestamp(0, 0); !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Timestamp(0, 0) = %v", got)
+	}
+	for _, pos := range []ChunkPos{{0, 0}, {31, 5}, {3, 31}, {7, 7}} {
+		got, err := region.ReadChunk(pos.X, pos.Z)
+		if err != nil {
+			t.Errorf("ReadChunk(%d, %d) = %v", pos.X, pos.Z, err)
+			continue
+		}
+		want := chunk(-1*regionWidth+pos.X, 2*regionWidth+pos.Z)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadChunk(%d, %d) = %v, want %v", pos.X, pos.Z, got, want)
+		}
+	}
+	if _, err := region.ReadChunk(1, 1); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("ReadChunk of a missing chunk = %v, want ErrChunkNotFound", err)
+	}
+	if _, err := region.ReadChunk(8, 8); err == nil {
+		t.Error("ReadChunk of an LZ4 chunk succeeded")
+	}
+	if _, err := region.ReadChunk(32, 0); err == nil {
+		t.Error("ReadChunk outside the region succeeded")
+	}
+
+	if _, err := OpenRegion(root, "region/level.dat"); err == nil {
+		t.Error("OpenRegion accepted a file not named like a region")
+	}
+}
@@ -0,0 +1,164 @@
+package nbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// Layout of Anvil region files: a table of chunk locations and one of timestamps, each a sector long,
+// followed by the chunks. A region holds 32×32 chunks.
+const (
+	sectorSize    = 4096
+	regionWidth   = 32
+	regionChunks  = regionWidth * regionWidth
+	maxChunkBytes = 256 * sectorSize // A chunk's sector count is a single byte
+)
+
+// Compression types of chunks in region files. Chunks too large for the region are kept in a .mcc file
+// of their own, marked by externalChunk.
+const (
+	chunkGzip         = 1
+	chunkZlib         = 2
+	chunkUncompressed = 3
+	chunkLZ4          = 4
+	externalChunk     = 128
+)
+
+// ErrChunkNotFound is returned for chunks that have not been generated.
+var ErrChunkNotFound = errors.New("chunk not found")
+
+// Region is an open Anvil region file (.mca).
+type Region struct {
+	f          *os.File
+	root       *sandbox.Root
+	name       string
+	x, z       int // Region coordinates, from the file name
+	locations  [regionChunks]uint32
+	timestamps [regionChunks]uint32
+}
+
+// OpenRegion opens the region file name in root, which must be named r.<x>.<z>.mca. Chunks kept in files of
+// their own are read from next to it.
+func OpenRegion(root *sandbox.Root, name string) (*Region, error) {
+	name = sandbox.Clean(name)
+	r := &Region{root: root, name: name}
+	if _, err := fmt.Sscanf(path.Base(name), "r.%d.%d.mca", &r.x, &r.z); err != nil {
+		return nil, fmt.Errorf("%s is not named like a region file", path.Base(name))
+	}
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 2*sectorSize)
+	_, err = io.ReadFull(f, header)
+	if err == io.EOF {
+		// An empty file is a region without chunks.
+		r.f = f
+		return r, nil
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: region header: %v", ErrInvalid, err)
+	}
+	for i := range regionChunks {
+		r.locations[i] = binary.BigEndian.Uint32(header[i*4:])
+		r.timestamps[i] = binary.BigEndian.Uint32(header[sectorSize+i*4:])
+	}
+	r.f = f
+	return r, nil
+}
+
+// Close closes the region file.
+func (r *Region) Close() error {
+	return r.f.Close()
+}
+
+// Position returns the region's coordinates.
+func (r *Region) Position() (x, z int) {
+	return r.x, r.z
+}
+
+// ChunkPos is the position of a chunk within its region, from 0 to 31 on each axis.
+type ChunkPos struct {
+	X, Z int
+}
+
+// Chunks lists the chunks the region holds.
+func (r *Region) Chunks() []ChunkPos {
+	var chunks []ChunkPos
+	for i, location := range r.locations {
+		if location != 0 {
+			chunks = append(chunks, ChunkPos{X: i % regionWidth, Z: i / regionWidth})
+		}
+	}
+	return chunks
+}
+
+// Timestamp returns when a chunk was last saved, or the zero time if it is not in the region.
+func (r *Region) Timestamp(x, z int) time.Time {
+	if !inRegion(x, z) || r.timestamps[z*regionWidth+x] == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(r.timestamps[z*regionWidth+x]), 0)
+}
+
+// ReadChunk decodes the chunk at x, z within the region.
+func (r *Region) ReadChunk(x, z int) (Compound, error) {
+	if !inRegion(x, z) {
+		return nil, fmt.Errorf("chunk %d, %d is outside the region", x, z)
+	}
+	location := r.locations[z*regionWidth+x]
+	if location == 0 {
+		return nil, fmt.Errorf("%w: %d, %d", ErrChunkNotFound, x, z)
+	}
+	offset, sectors := int64(location>>8)*sectorSize, int64(location&0xff)*sectorSize
+
+	var header [5]byte
+	if _, err := r.f.ReadAt(header[:], offset); err != nil {
+		return nil, fmt.Errorf("%w: chunk %d, %d: %v", ErrInvalid, x, z, err)
+	}
+	length := int64(binary.BigEndian.Uint32(header[:4]))
+	compression := header[4]
+	if length < 1 || length-1 > maxChunkBytes || length+4 > sectors {
+		return nil, fmt.Errorf("%w: chunk %d, %d has length %d", ErrInvalid, x, z, length)
+	}
+
+	var data io.Reader
+	if compression&externalChunk != 0 {
+		name := fmt.Sprintf("c.%d.%d.mcc", r.x*regionWidth+x, r.z*regionWidth+z)
+		f, err := r.root.Open(path.Join(path.Dir(r.name), name))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d, %d is stored in %s: %w", x, z, name, err)
+		}
+		defer f.Close()
+		data = f
+		compression &^= externalChunk
+	} else {
+		b := make([]byte, length-1)
+		if _, err := r.f.ReadAt(b, offset+5); err != nil {
+			return nil, fmt.Errorf("%w: chunk %d, %d: %v", ErrInvalid, x, z, err)
+		}
+		data = bytes.NewReader(b)
+	}
+
+	switch compression {
+	case chunkGzip, chunkZlib, chunkUncompressed:
+		_, root, _, err := Read(data)
+		return root, err
+	case chunkLZ4:
+		return nil, fmt.Errorf("chunk %d, %d is LZ4 compressed, which is not supported", x, z)
+	}
+	return nil, fmt.Errorf("%w: chunk %d, %d has unknown compression %d", ErrInvalid, x, z, compression)
+}
+
+func inRegion(x, z int) bool {
+	return x >= 0 && x < regionWidth && z >= 0 && z < regionWidth
+}
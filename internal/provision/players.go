@@ -0,0 +1,170 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// Player is a Minecraft account.
+type Player struct {
+	Name string
+	UUID string
+}
+
+// PlayerResolver looks up the accounts of player names. Names without an account are left out of the result.
+type PlayerResolver interface {
+	ResolvePlayers(ctx context.Context, names []string) ([]Player, error)
+}
+
+// MojangResolver resolves names through Mojang's profile API, for servers in online mode.
+type MojangResolver struct {
+	API    string // Base URL of the profile lookup API
+	Client *http.Client
+}
+
+// NewMojangResolver creates a MojangResolver for the public API.
+func NewMojangResolver() *MojangResolver {
+	return &MojangResolver{API: "https://api.minecraftservices.com", Client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// mojangBatchSize is the most names the bulk lookup accepts at once.
+const mojangBatchSize = 10
+
+// ResolvePlayers looks names up in batches.
+func (r *MojangResolver) ResolvePlayers(ctx context.Context, names []string) ([]Player, error) {
+	var players []Player
+	for start := 0; start < len(names); start += mojangBatchSize {
+		batch := names[start:min(start+mojangBatchSize, len(names))]
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.API+"/minecraft/profile/lookup/bulk/byname", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := r.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("player lookup failed: %w", err)
+		}
+		var profiles []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("player lookup failed: %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&profiles)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid player lookup response: %w", err)
+		}
+		for _, profile := range profiles {
+			id, err := uuid.Parse(profile.ID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UUID %q for player %s", profile.ID, profile.Name)
+			}
+			players = append(players, Player{Name: profile.Name, UUID: id.String()})
+		}
+	}
+	return players, nil
+}
+
+// OfflineResolver gives every name the UUID an offline-mode server derives from it.
+type OfflineResolver struct{}
+
+// ResolvePlayers resolves every name.
+func (OfflineResolver) ResolvePlayers(_ context.Context, names []string) ([]Player, error) {
+	players := make([]Player, len(names))
+	for i, name := range names {
+		players[i] = Player{Name: name, UUID: OfflineUUID(name)}
+	}
+	return players, nil
+}
+
+// OfflineUUID returns the UUID of a player on an offline-mode server: the version 3 UUID of
+// "OfflinePlayer:<name>", as computed by Java's UUID.nameUUIDFromBytes.
+func OfflineUUID(name string) string {
+	sum := md5.Sum([]byte("OfflinePlayer:" + name))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return uuid.UUID(sum).String()
+}
+
+// Entries of the server's player list files.
+type (
+	opEntry struct {
+		UUID                string `json:"uuid"`
+		Name                string `json:"name"`
+		Level               int    `json:"level"`
+		BypassesPlayerLimit bool   `json:"bypassesPlayerLimit"`
+	}
+	whitelistEntry struct {
+		UUID string `json:"uuid"`
+		Name string `json:"name"`
+	}
+	banEntry struct {
+		UUID    string `json:"uuid,omitempty"`
+		Name    string `json:"name,omitempty"`
+		IP      string `json:"ip,omitempty"`
+		Created string `json:"created"`
+		Source  string `json:"source"`
+		Expires string `json:"expires"`
+		Reason  string `json:"reason"`
+	}
+)
+
+// newBan returns a permanent ban in the format the server writes.
+func newBan() banEntry {
+	return banEntry{
+		Created: time.Now().Format("2006-01-02 15:04:05 -0700"),
+		Source:  "Server",
+		Expires: "forever",
+		Reason:  "Banned by an operator.",
+	}
+}
+
+// mergeList adds entries to the JSON list name in root, keeping the entries already in it. key identifies an
+// entry, so one that is already listed is not added again.
+func mergeList[T any](root *sandbox.Root, name string, entries []T, key func(T) string) error {
+	var list []T
+	data, err := root.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("could not read %s: %w", name, err)
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, entry := range list {
+		listed[strings.ToLower(key(entry))] = true
+	}
+	for _, entry := range entries {
+		if k := strings.ToLower(key(entry)); !listed[k] {
+			listed[k] = true
+			list = append(list, entry)
+		}
+	}
+
+	data, err = json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return root.WriteFile(name, append(data, '\n'), 0644)
+}
@@ -0,0 +1,120 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+// Properties is a server.properties file. Lines are kept in order, comments included, so setting a
+// key changes only its own line.
+type Properties struct {
+	lines []string
+	index map[string]int // Line of each key
+}
+
+// ReadProperties reads the properties file name in root. A missing file reads as empty.
+func ReadProperties(root *sandbox.Root, name string) (*Properties, error) {
+	data, err := root.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ParseProperties(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseProperties(data), nil
+}
+
+// ParseProperties parses the content of a properties file.
+func ParseProperties(data []byte) *Properties {
+	p := &Properties{index: map[string]int{}}
+	if len(data) == 0 {
+		return p
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if key, _, ok := parsePropertyLine(line); ok {
+			p.index[key] = len(p.lines)
+		}
+		p.lines = append(p.lines, line)
+	}
+	return p
+}
+
+func parsePropertyLine(line string) (key, value string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!") {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(trimmed, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value), ok
+}
+
+// Get returns the value of key and whether it is set.
+func (p *Properties) Get(key string) (string, bool) {
+	i, ok := p.index[key]
+	if !ok {
+		return "", false
+	}
+	_, value, _ := parsePropertyLine(p.lines[i])
+	return value, true
+}
+
+// Set sets key to value, appending it if the file doesn't have it yet.
+func (p *Properties) Set(key, value string) error {
+	if key == "" || strings.ContainsAny(key, "=:#! \t\r\n") {
+		return fmt.Errorf("%q is not a valid property name", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("property %s can't contain line breaks", key)
+	}
+	line := key + "=" + value
+	if i, ok := p.index[key]; ok {
+		p.lines[i] = line
+		return nil
+	}
+	p.index[key] = len(p.lines)
+	p.lines = append(p.lines, line)
+	return nil
+}
+
+// SetAll sets every key of values, in sorted order so new keys are appended deterministically.
+func (p *Properties) SetAll(values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := p.Set(key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes the properties to the file name in root.
+func (p *Properties) Write(root *sandbox.Root, name string) error {
+	return root.WriteFile(name, p.Bytes(), 0644)
+}
+
+// Bytes returns the content of the properties file.
+func (p *Properties) Bytes() []byte {
+	return []byte(strings.Join(p.lines, "\n") + "\n")
+}
+
+// SetProperties sets values in the properties file name in root, creating it if needed.
+func SetProperties(root *sandbox.Root, name string, values map[string]string) error {
+	props, err := ReadProperties(root, name)
+	if err != nil {
+		return err
+	}
+	if err := props.SetAll(values); err != nil {
+		return err
+	}
+	return props.Write(root, name)
+}
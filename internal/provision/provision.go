@@ -0,0 +1,254 @@
+// Package provision applies the configuration stored in a template to the files of a server created from it:
+// server.properties, the operator, whitelist and ban lists, datapacks and the resource pack.
+package provision
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/download"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+)
+
+// ProgressFunc receives progress updates while a server is provisioned.
+type ProgressFunc func(stage string, current, total int64, unit string)
+
+// opLevel is the permission level of the operators a template lists: full access.
+const opLevel = 4
+
+// difficulties are the values server.properties accepts for difficulty.
+var difficulties = map[string]bool{"peaceful": true, "easy": true, "normal": true, "hard": true}
+
+// Provisioner applies template configuration to server files.
+type Provisioner struct {
+	resolver PlayerResolver
+	client   *http.Client
+}
+
+// New creates a Provisioner that looks up the players of online-mode servers with resolver.
+func New(resolver PlayerResolver) *Provisioner {
+	return &Provisioner{resolver: resolver, client: &http.Client{}}
+}
+
+// Apply renders template's configuration into the server files in dataDir. The template's properties,
+// difficulty and resource pack are merged into the server.properties from its archive, followed by
+// overrides, which always win. Its operators, whitelist and bans are added to the server's lists and
+// its datapacks installed into the world.
+func (p *Provisioner) Apply(ctx context.Context, dataDir *sandbox.Root, template models.Template, overrides map[string]string, progress ProgressFunc) error {
+	props, err := ReadProperties(dataDir, "server.properties")
+	if err != nil {
+		return fmt.Errorf("could not read server.properties: %w", err)
+	}
+	if err := props.SetAll(template.Properties); err != nil {
+		return fmt.Errorf("invalid template properties: %w", err)
+	}
+	if template.Difficulty != "" {
+		difficulty := strings.ToLower(template.Difficulty)
+		if !difficulties[difficulty] {
+			return fmt.Errorf("invalid difficulty %q", template.Difficulty)
+		}
+		props.Set("difficulty", difficulty)
+	}
+	if _, ok := template.Properties["white-list"]; !ok && len(template.Whitelist) > 0 {
+		props.Set("white-list", "true")
+	}
+	if len(template.ResourcePacks) > 0 {
+		progress("configuring resource pack", 0, 0, "")
+		if err := p.setResourcePack(ctx, props, template.ResourcePacks); err != nil {
+			return err
+		}
+	}
+	if err := props.SetAll(overrides); err != nil {
+		return err
+	}
+	if err := props.Write(dataDir, "server.properties"); err != nil {
+		return fmt.Errorf("could not write server.properties: %w", err)
+	}
+
+	resolver := p.resolver
+	if mode, _ := props.Get("online-mode"); mode == "false" {
+		resolver = OfflineResolver{}
+	}
+	if err := p.writePlayerLists(ctx, dataDir, template, resolver, progress); err != nil {
+		return err
+	}
+
+	levelName, _ := props.Get("level-name")
+	if levelName == "" {
+		levelName = "world"
+	}
+	return p.installDatapacks(ctx, dataDir, path.Join(path.Base(sandbox.Clean(levelName)), "datapacks"), template.Datapacks, progress)
+}
+
+// setResourcePack points the server at the first of packs, with its SHA-1 so clients can verify and
+// cache it. server.properties holds a single pack, so any others are ignored.
+func (p *Provisioner) setResourcePack(ctx context.Context, props *Properties, packs []string) error {
+	packURL := packs[0]
+	if !isHTTPURL(packURL) {
+		return fmt.Errorf("resource pack %q is not an http(s) URL", packURL)
+	}
+	if len(packs) > 1 {
+		log.Warn().Ctx(ctx).Str("resource_pack", packURL).Int("ignored", len(packs)-1).Msg("server.properties holds a single resource pack; using the first")
+	}
+	if err := props.Set("resource-pack", packURL); err != nil {
+		return err
+	}
+
+	// Without the hash clients still download the pack, they just re-download it on every join.
+	sum, err := p.sha1Of(ctx, packURL)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("resource_pack", packURL).Msg("Could not hash resource pack; clients won't be able to cache it")
+		return nil
+	}
+	return props.Set("resource-pack-sha1", sum)
+}
+
+func (p *Provisioner) sha1Of(ctx context.Context, url string) (string, error) {
+	resp, err := download.Get(ctx, p.client, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writePlayerLists adds the template's operators, whitelist and bans to the server's ops.json, whitelist.json,
+// banned-players.json and banned-ips.json. Every player name must resolve to an account.
+func (p *Provisioner) writePlayerLists(ctx context.Context, dataDir *sandbox.Root, template models.Template, resolver PlayerResolver, progress ProgressFunc) error {
+	var names []string
+	seen := map[string]bool{}
+	for _, list := range [][]string{template.Ops, template.Whitelist, template.BannedPlayers} {
+		for _, name := range list {
+			if key := strings.ToLower(name); !seen[key] {
+				seen[key] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	accounts := map[string]Player{}
+	if len(names) > 0 {
+		progress("looking up players", 0, int64(len(names)), "players")
+		players, err := resolver.ResolvePlayers(ctx, names)
+		if err != nil {
+			return err
+		}
+		for _, player := range players {
+			accounts[strings.ToLower(player.Name)] = player
+		}
+		var missing []string
+		for _, name := range names {
+			if _, ok := accounts[strings.ToLower(name)]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("no Minecraft account found for %s", strings.Join(missing, ", "))
+		}
+	}
+	account := func(name string) Player { return accounts[strings.ToLower(name)] }
+
+	if len(template.Ops) > 0 {
+		var ops []opEntry
+		for _, name := range template.Ops {
+			player := account(name)
+			ops = append(ops, opEntry{UUID: player.UUID, Name: player.Name, Level: opLevel})
+		}
+		if err := mergeList(dataDir, "ops.json", ops, func(e opEntry) string { return e.UUID }); err != nil {
+			return err
+		}
+	}
+
+	if len(template.Whitelist) > 0 {
+		var whitelist []whitelistEntry
+		for _, name := range template.Whitelist {
+			player := account(name)
+			whitelist = append(whitelist, whitelistEntry{UUID: player.UUID, Name: player.Name})
+		}
+		if err := mergeList(dataDir, "whitelist.json", whitelist, func(e whitelistEntry) string { return e.UUID }); err != nil {
+			return err
+		}
+	}
+
+	if len(template.BannedPlayers) > 0 {
+		var bans []banEntry
+		for _, name := range template.BannedPlayers {
+			player := account(name)
+			ban := newBan()
+			ban.UUID, ban.Name = player.UUID, player.Name
+			bans = append(bans, ban)
+		}
+		if err := mergeList(dataDir, "banned-players.json", bans, func(e banEntry) string { return e.UUID }); err != nil {
+			return err
+		}
+	}
+
+	if len(template.BannedIPs) > 0 {
+		var bans []banEntry
+		for _, ip := range template.BannedIPs {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid banned IP %q", ip)
+			}
+			ban := newBan()
+			ban.IP = ip
+			bans = append(bans, ban)
+		}
+		if err := mergeList(dataDir, "banned-ips.json", bans, func(e banEntry) string { return e.IP }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installDatapacks downloads the datapacks given by URL into the directory dir of dataDir. Datapacks given by
+// file name must already be there, shipped in the template's archive.
+func (p *Provisioner) installDatapacks(ctx context.Context, dataDir *sandbox.Root, dir string, datapacks []string, progress ProgressFunc) error {
+	for i, datapack := range datapacks {
+		progress("installing datapacks", int64(i), int64(len(datapacks)), "datapacks")
+		if !isHTTPURL(datapack) {
+			if _, err := dataDir.Stat(path.Join(dir, path.Base(filepath.ToSlash(datapack)))); err != nil {
+				return fmt.Errorf("datapack %q is neither a URL nor included in the template", datapack)
+			}
+			continue
+		}
+
+		u, _ := url.Parse(datapack)
+		name := path.Base(u.Path)
+		if name == "." || name == "/" || name == ".." {
+			return fmt.Errorf("datapack URL %q doesn't name a file", datapack)
+		}
+		if err := dataDir.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("could not create datapacks directory: %w", err)
+		}
+		err := dataDir.WriteFileFrom(path.Join(dir, name), 0644, func(w io.Writer) error {
+			return download.Copy(ctx, p.client, datapack, w, download.Hashes{})
+		})
+		if err != nil {
+			return fmt.Errorf("could not install datapack: %w", err)
+		}
+	}
+	if len(datapacks) > 0 {
+		progress("installing datapacks", int64(len(datapacks)), int64(len(datapacks)), "datapacks")
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
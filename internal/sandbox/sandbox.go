@@ -0,0 +1,379 @@
+// Package sandbox confines file access to a directory tree, such as the data directory of a server.
+//
+// It is built on os.Root, so neither "..", absolute paths nor symlinks, whether they exist up front or are
+// swapped in while an operation runs, can lead outside the tree. Symlinks that stay inside it are followed.
+// Regular files with more than one hard link are refused, since a link made from inside a container may
+// point at a file that belongs to the host.
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEscape is returned for names that lead outside the root, whether through ".." or a symlink.
+	ErrEscape = errors.New("path escapes from the sandbox")
+	// ErrHardLink is returned when opening a regular file that has other hard links.
+	ErrHardLink = errors.New("file has more than one hard link")
+)
+
+// Root is a directory tree that all access goes through. Names are slash-separated and relative to the
+// root; a leading slash is ignored and the root itself is ".".
+type Root struct {
+	root *os.Root
+	dir  string
+}
+
+// Open opens the directory dir, which must exist, as a sandbox.
+func Open(dir string) (*Root, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(abs)
+	if err != nil {
+		return nil, err
+	}
+	return &Root{root: root, dir: abs}, nil
+}
+
+// Close closes the root. Files opened from it stay open.
+func (r *Root) Close() error {
+	return r.root.Close()
+}
+
+// Dir returns the absolute path of the root directory.
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// Clean returns the canonical form of a name: slash-separated, relative, without "." or ".." elements and
+// "." for the root. A ".." that would climb above the root is dropped, like one at the root of a file system.
+func Clean(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Open opens a file for reading.
+func (r *Root) Open(name string) (*os.File, error) {
+	return r.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates a file.
+func (r *Root) Create(name string) (*os.File, error) {
+	return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+// OpenFile opens a file like os.OpenFile.
+func (r *Root) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	// Truncating on open would empty a hard-linked file before it can be refused, so it waits for the check.
+	f, err := r.root.OpenFile(Clean(name), flag&^os.O_TRUNC, perm)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Mode().IsRegular() && links(info) > 1 {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrHardLink}
+	}
+	if flag&os.O_TRUNC != 0 && info.Mode().IsRegular() {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// ReadFile reads a whole file.
+func (r *Root) ReadFile(name string) ([]byte, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// WriteFile replaces a file with data. The data is written next to the file first, so readers never see it
+// half written, and the file keeps its permissions if it already exists. A symlink at name is replaced by
+// the file, and refused if it leads outside the root.
+func (r *Root) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return r.WriteFileFrom(name, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteFileFrom is WriteFile for content produced by write.
+func (r *Root) WriteFileFrom(name string, perm os.FileMode, write func(w io.Writer) error) error {
+	name = Clean(name)
+	info, err := r.Stat(name)
+	switch {
+	case err == nil && info.IsDir():
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	case err == nil:
+		perm = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	tmp, err := r.CreateTemp(path.Dir(name), "."+path.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := path.Join(path.Dir(name), filepath.Base(tmp.Name()))
+	defer r.root.Remove(tmpName)
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return r.Rename(tmpName, name)
+}
+
+// CreateTemp creates a new file in the directory dir, named by replacing the last "*" in pattern with a
+// random string, and opens it for writing. The file's name relative to the root is path.Join(dir,
+// filepath.Base(f.Name())).
+func (r *Root) CreateTemp(dir, pattern string) (*os.File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	if strings.Contains(prefix+suffix, "/") {
+		return nil, fmt.Errorf("pattern %q contains a path separator", pattern)
+	}
+	for range 100 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		f, err := r.OpenFile(path.Join(Clean(dir), prefix+hex.EncodeToString(b)+suffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: fs.ErrExist}
+}
+
+// Stat describes a file, following symlinks that stay inside the root.
+func (r *Root) Stat(name string) (os.FileInfo, error) {
+	info, err := r.root.Stat(Clean(name))
+	return info, wrap(err)
+}
+
+// Lstat describes a file without following a symlink at the end of name.
+func (r *Root) Lstat(name string) (os.FileInfo, error) {
+	info, err := r.root.Lstat(Clean(name))
+	return info, wrap(err)
+}
+
+// ReadDir lists a directory, sorted by name.
+func (r *Root) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(r.root.FS(), Clean(name))
+	return entries, wrap(err)
+}
+
+// WalkDir walks the tree at name like fs.WalkDir, with slash-separated names relative to the root.
+// Symlinks found along the way are reported but not followed.
+func (r *Root) WalkDir(name string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(r.root.FS(), Clean(name), fn)
+}
+
+// FS returns the tree as an fs.FS.
+func (r *Root) FS() fs.FS {
+	return r.root.FS()
+}
+
+// Mkdir creates a directory.
+func (r *Root) Mkdir(name string, perm os.FileMode) error {
+	return wrap(r.root.Mkdir(Clean(name), perm))
+}
+
+// MkdirAll creates a directory and any missing parents. Existing directories, and symlinks to directories
+// inside the root, are left as they are.
+func (r *Root) MkdirAll(name string, perm os.FileMode) error {
+	name = Clean(name)
+	if name == "." {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(name, "/") {
+		current = path.Join(current, part)
+		err := r.root.Mkdir(current, perm)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return wrap(err)
+		}
+		info, statErr := r.root.Stat(current)
+		if statErr != nil {
+			return wrap(statErr)
+		}
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: current, Err: errors.New("not a directory")}
+		}
+	}
+	return nil
+}
+
+// Remove removes a file or an empty directory. A symlink is removed, not its target.
+func (r *Root) Remove(name string) error {
+	return wrap(r.root.Remove(Clean(name)))
+}
+
+// RemoveAll removes a file or directory with everything in it. Symlinks are removed, not followed. It
+// returns nil if name doesn't exist, and refuses to remove the root itself.
+func (r *Root) RemoveAll(name string) error {
+	name = Clean(name)
+	if name == "." {
+		return &fs.PathError{Op: "removeall", Path: name, Err: errors.New("can't remove the sandbox root")}
+	}
+	info, err := r.root.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return wrap(err)
+	}
+	if info.IsDir() {
+		entries, err := r.ReadDir(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, entry := range entries {
+			if err := r.RemoveAll(path.Join(name, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return wrap(err)
+	}
+	return nil
+}
+
+// Rename renames or moves a file or directory. A symlink at the end of either name is the entry itself,
+// not its target, and an existing file at newname is replaced.
+func (r *Root) Rename(oldname, newname string) error {
+	oldname, newname = Clean(oldname), Clean(newname)
+	if oldname == "." || newname == "." {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("can't rename the sandbox root")}
+	}
+	oldDir, err := r.openDir(path.Dir(oldname))
+	if err != nil {
+		return err
+	}
+	defer oldDir.Close()
+	newDir, err := r.openDir(path.Dir(newname))
+	if err != nil {
+		return err
+	}
+	defer newDir.Close()
+	if err := renameat(oldDir, path.Base(oldname), newDir, path.Base(newname)); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Readlink returns the target of a symlink. The target is not resolved, so it may point anywhere.
+func (r *Root) Readlink(name string) (string, error) {
+	name = Clean(name)
+	dir, err := r.openDir(path.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	defer dir.Close()
+	target, err := readlinkat(dir, path.Base(name))
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// Symlink creates newname as a symlink to oldname. Symlinks are only followed where they stay inside the
+// root, so the target is not checked.
+func (r *Root) Symlink(oldname, newname string) error {
+	newname = Clean(newname)
+	if newname == "." {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	dir, err := r.openDir(path.Dir(newname))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := symlinkat(oldname, dir, path.Base(newname)); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Chmod changes the permission bits of a file or directory. A symlink's target is changed, provided it is
+// inside the root.
+func (r *Root) Chmod(name string, mode os.FileMode) error {
+	f, err := r.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Chmod(mode)
+}
+
+// Chtimes changes the access and modification times of a file or directory. A symlink's target is changed,
+// provided it is inside the root.
+func (r *Root) Chtimes(name string, atime, mtime time.Time) error {
+	f, err := r.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := futimes(f, atime, mtime); err != nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return nil
+}
+
+// openDir opens a directory of the tree, so entries can be named relative to it.
+func (r *Root) openDir(name string) (*os.File, error) {
+	f, err := r.root.Open(name)
+	if err != nil {
+		return nil, wrap(err)
+	}
+	if info, err := f.Stat(); err != nil || !info.IsDir() {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("not a directory")}
+	}
+	return f, nil
+}
+
+// wrap makes os.Root's errors for names that escape the root match ErrEscape.
+func wrap(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && pathErr.Err.Error() == "path escapes from parent" {
+		return &fs.PathError{Op: pathErr.Op, Path: pathErr.Path, Err: ErrEscape}
+	}
+	return err
+}
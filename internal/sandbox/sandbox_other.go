@@ -0,0 +1,35 @@
+//go:build !unix
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// links returns the number of hard links to a file, which isn't known on this platform.
+func links(info os.FileInfo) uint64 {
+	return 1
+}
+
+// renameat renames oldname in the directory oldDir to newname in newDir. Without *at system calls the
+// directories are named by path, so a symlink swapped in for one of them after it was opened isn't noticed.
+func renameat(oldDir *os.File, oldname string, newDir *os.File, newname string) error {
+	return os.Rename(filepath.Join(oldDir.Name(), oldname), filepath.Join(newDir.Name(), newname))
+}
+
+// readlinkat returns the target of the symlink name in dir.
+func readlinkat(dir *os.File, name string) (string, error) {
+	return os.Readlink(filepath.Join(dir.Name(), name))
+}
+
+// symlinkat creates name in dir as a symlink to target.
+func symlinkat(target string, dir *os.File, name string) error {
+	return os.Symlink(target, filepath.Join(dir.Name(), name))
+}
+
+// futimes sets the access and modification times of an open file, by its name.
+func futimes(f *os.File, atime, mtime time.Time) error {
+	return os.Chtimes(f.Name(), atime, mtime)
+}
@@ -0,0 +1,273 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestRoot opens a sandbox in a fresh directory next to another one that stands for the host.
+func newTestRoot(t *testing.T) (root *Root, outside string) {
+	t.Helper()
+	base := t.TempDir()
+	dir, outside := filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, d := range []string{dir, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("host"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return root, outside
+}
+
+func TestClean(t *testing.T) {
+	tests := map[string]string{
+		"":                 ".",
+		"/":                ".",
+		".":                ".",
+		"a/b":              "a/b",
+		"/a/b/":            "a/b",
+		"a/./b":            "a/b",
+		"a/../b":           "b",
+		"../../etc/passwd": "etc/passwd",
+		"/../outside":      "outside",
+	}
+	for name, want := range tests {
+		if got := Clean(name); got != want {
+			t.Errorf("Clean(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDotDotAndAbsolutePathsStayInside(t *testing.T) {
+	root, outside := newTestRoot(t)
+	rel, err := filepath.Rel(root.Dir(), filepath.Join(outside, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{rel, filepath.Join(outside, "secret"), "../outside/secret"}
+	for _, name := range names {
+		if _, err := root.ReadFile(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("ReadFile(%q) = %v, want it to look inside the root and find nothing", name, err)
+		}
+	}
+	for _, name := range names {
+		if err := root.MkdirAll(path.Dir(Clean(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.WriteFile(name, []byte("sandbox"), 0644); err != nil {
+			t.Fatalf("WriteFile(%q) = %v", name, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "host" {
+		t.Errorf("the file outside the root holds %q, want it untouched", data)
+	}
+	if err := root.RemoveAll("/.."); err == nil {
+		t.Error("RemoveAll(\"/..\") removed the root")
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	root, outside := newTestRoot(t)
+	if err := root.WriteFile("data/level.dat", []byte("world"), 0644); err == nil {
+		t.Fatal("WriteFile created a file in a missing directory")
+	}
+	if err := root.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteFile("data/level.dat", []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links that stay inside are followed.
+	if err := root.Symlink("data/level.dat", "inside"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := root.ReadFile("inside"); err != nil || string(data) != "world" {
+		t.Errorf("ReadFile through an inside symlink = %q, %v; want %q", data, err, "world")
+	}
+	if err := root.Symlink("data", "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := root.ReadFile("dir/level.dat"); err != nil || string(data) != "world" {
+		t.Errorf("ReadFile through an inside directory symlink = %q, %v; want %q", data, err, "world")
+	}
+
+	// Links that lead outside are refused, whether to a file or through a directory.
+	if err := root.Symlink(filepath.Join(outside, "secret"), "file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Symlink("../outside", "escape"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", "escape/secret"} {
+		if _, err := root.ReadFile(name); !errors.Is(err, ErrEscape) {
+			t.Errorf("ReadFile(%q) = %v, want ErrEscape", name, err)
+		}
+		if _, err := root.Create(name); !errors.Is(err, ErrEscape) {
+			t.Errorf("Create(%q) = %v, want ErrEscape", name, err)
+		}
+	}
+	if err := root.WriteFile("escape/secret", []byte("sandbox"), 0644); !errors.Is(err, ErrEscape) {
+		t.Errorf("WriteFile through a symlink leading outside = %v, want ErrEscape", err)
+	}
+	if err := root.Chmod("file", 0777); !errors.Is(err, ErrEscape) {
+		t.Errorf("Chmod through a symlink leading outside = %v, want ErrEscape", err)
+	}
+
+	// Removing a link leaves its target alone.
+	if err := root.RemoveAll("escape"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "host" {
+		t.Errorf("the file outside the root holds %q, want it untouched", data)
+	}
+	if info, err := os.Stat(filepath.Join(outside, "secret")); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("the file outside the root changed mode: %v, %v", info, err)
+	}
+}
+
+// TestRenameWithSymlinkSwappedIn swaps a directory for a symlink leading outside while files are renamed
+// through it. Run with -race.
+func TestRenameWithSymlinkSwappedIn(t *testing.T) {
+	root, outside := newTestRoot(t)
+	dir := root.Dir()
+	if err := root.MkdirAll("a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteFile("a/secret", []byte("sandbox"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swapped in between calls, the symlink is refused.
+	if err := os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "a.real")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Rename("a/secret", "moved"); !errors.Is(err, ErrEscape) {
+		t.Errorf("Rename through a symlink leading outside = %v, want ErrEscape", err)
+	}
+	if err := root.Rename("moved", "a/secret"); err == nil {
+		t.Error("Rename into a symlink leading outside succeeded")
+	}
+	os.Remove(filepath.Join(dir, "a"))
+	if err := os.Rename(filepath.Join(dir, "a.real"), filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swapped in while renames run, it never lets one reach outside.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "a.real"))
+			os.Symlink(outside, filepath.Join(dir, "a"))
+			os.Remove(filepath.Join(dir, "a"))
+			os.Rename(filepath.Join(dir, "a.real"), filepath.Join(dir, "a"))
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		if err := root.Rename("a/secret", "moved"); err == nil {
+			if err := root.Rename("moved", "a/secret"); err != nil && !errors.Is(err, ErrEscape) && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("moving the file back: %v", err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if data, err := os.ReadFile(filepath.Join(outside, "secret")); err != nil || string(data) != "host" {
+		t.Fatalf("the file outside the root is %q, %v; want it untouched", data, err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("the directory outside the root holds %d entries, want 1", len(entries))
+	}
+}
+
+func TestHardLinks(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		open func(r *Root, name string) error
+	}{
+		{"Open", func(r *Root, name string) error {
+			f, err := r.Open(name)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"OpenFile", func(r *Root, name string) error {
+			f, err := r.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"Create", func(r *Root, name string) error {
+			f, err := r.Create(name)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+		{"OpenFile with O_TRUNC", func(r *Root, name string) error {
+			f, err := r.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+			if err == nil {
+				f.Close()
+			}
+			return err
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, outside := newTestRoot(t)
+			if err := os.Link(filepath.Join(outside, "secret"), filepath.Join(root.Dir(), "link")); err != nil {
+				t.Skipf("can't make hard links here: %v", err)
+			}
+			if err := tt.open(root, "link"); !errors.Is(err, ErrHardLink) {
+				t.Errorf("got %v, want ErrHardLink", err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(outside, "secret")); string(data) != "host" {
+				t.Errorf("the linked file outside the root holds %q, want it untouched", data)
+			}
+		})
+	}
+}
+
+func TestCreateTruncatesSingleLinkFiles(t *testing.T) {
+	root, _ := newTestRoot(t)
+	if err := root.WriteFile("server.properties", []byte("motd=hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := root.Create("server.properties")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("motd=bye")
+	f.Close()
+	if data, _ := root.ReadFile("server.properties"); string(data) != "motd=bye" {
+		t.Errorf("file holds %q after Create, want %q", data, "motd=bye")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// links returns the number of hard links to a file.
+func links(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// renameat renames oldname in the directory oldDir to newname in newDir. Working from open directories
+// means a symlink swapped in for either of them after they were opened can't redirect the rename.
+func renameat(oldDir *os.File, oldname string, newDir *os.File, newname string) error {
+	return unix.Renameat(int(oldDir.Fd()), oldname, int(newDir.Fd()), newname)
+}
+
+// readlinkat returns the target of the symlink name in dir.
+func readlinkat(dir *os.File, name string) (string, error) {
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := unix.Readlinkat(int(dir.Fd()), name, buf)
+		if err != nil {
+			return "", err
+		}
+		if n < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+// symlinkat creates name in dir as a symlink to target.
+func symlinkat(target string, dir *os.File, name string) error {
+	return unix.Symlinkat(target, int(dir.Fd()), name)
+}
+
+// futimes sets the access and modification times of an open file.
+func futimes(f *os.File, atime, mtime time.Time) error {
+	return unix.Futimes(int(f.Fd()), []unix.Timeval{unix.NsecToTimeval(atime.UnixNano()), unix.NsecToTimeval(mtime.UnixNano())})
+}
@@ -3,26 +3,30 @@ package services
 
 import (
 	"archive/zip"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
 	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
 	"github.com/rs/zerolog/log"
 )
 
 // BackupServiceProvider defines the interface for backup services.
 type BackupServiceProvider interface {
-	CreateBackup(serverID, name string) (models.Backup, error)
-	GetBackupsForServer(serverID string) ([]models.Backup, error)
-	DeleteBackup(backupID string) error
-	RestoreBackup(backupID string) error
-	GetBackupByID(backupID string) (models.Backup, error)
+	CreateBackup(ctx context.Context, serverID, name string) (models.Backup, error)
+	GetBackupsForServer(ctx context.Context, serverID string) ([]models.Backup, error)
+	DeleteBackup(ctx context.Context, backupID string) error
+	RestoreBackup(ctx context.Context, backupID string) error
+	GetBackupByID(ctx context.Context, backupID string) (models.Backup, error)
 }
 
 // BackupService provides business logic for backup management.
@@ -45,29 +49,19 @@ func NewBackupService(db *sql.DB, serverService ServerServiceProvider, eventServ
 }
 
 // CreateBackup creates a new backup for a server. This version uses RCON for downtime-free backups.
-func (s *BackupService) CreateBackup(serverID, name string) (models.Backup, error) {
-	server, err := s.serverService.GetServerByID(serverID)
+func (s *BackupService) CreateBackup(ctx context.Context, serverID, name string) (_ models.Backup, err error) {
+	defer func() { metrics.ObserveBackup("create", err) }()
+
+	server, err := s.serverService.GetServerByID(ctx, serverID)
 	if err != nil {
 		return models.Backup{}, fmt.Errorf("could not find server: %w", err)
 	}
 
-	// If server is online, use RCON to safely save the world state first.
-	if server.Status == "online" {
-		log.Info().Str("server_id", serverID).Msg("Server is online, performing RCON save for backup.")
-		// 1. Turn off auto-saving to prevent file changes during backup
-		if _, err := s.serverService.SendCommandToServer(serverID, "save-off"); err != nil {
-			log.Warn().Err(err).Str("server_id", serverID).Msg("Failed to send 'save-off' before backup. Continuing anyway.")
-		}
-		// 2. Ensure save is turned back on when the function exits
-		defer s.serverService.SendCommandToServer(serverID, "save-on")
-
-		// 3. Force a save to flush all changes to disk
-		if _, err := s.serverService.SendCommandToServer(serverID, "save-all"); err != nil {
-			return models.Backup{}, fmt.Errorf("failed to save world via RCON before backup: %w", err)
-		}
-		// 4. Give the server a moment to write everything to disk
-		time.Sleep(5 * time.Second)
+	resume, err := saveWorld(ctx, s.serverService, server)
+	if err != nil {
+		return models.Backup{}, fmt.Errorf("failed to save world via RCON before backup: %w", err)
 	}
+	defer resume()
 
 	backup := models.Backup{
 		ID:       uuid.New().String(),
@@ -75,6 +69,12 @@ func (s *BackupService) CreateBackup(serverID, name string) (models.Backup, erro
 		Name:     name,
 	}
 
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.Backup{}, err
+	}
+	defer dataDir.Close()
+
 	backupFileName := fmt.Sprintf("%s_%s.zip", serverID, time.Now().Format("20060102150405"))
 	backup.Path = filepath.Join(s.backupPath, backupFileName)
 
@@ -87,32 +87,52 @@ func (s *BackupService) CreateBackup(serverID, name string) (models.Backup, erro
 	zipWriter := zip.NewWriter(backupFile)
 	defer zipWriter.Close()
 
-	err = filepath.Walk(server.DataPath, func(path string, info os.FileInfo, err error) error {
+	totalFiles, err := countFiles(dataDir)
+	if err != nil {
+		os.Remove(backup.Path)
+		return models.Backup{}, fmt.Errorf("failed to scan server data: %w", err)
+	}
+	var archivedFiles int64
+	reportProgress(ctx, "archiving", 0, totalFiles, "files")
+
+	err = dataDir.WalkDir(".", func(relPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		relPath, err := filepath.Rel(server.DataPath, path)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 		if relPath == "." {
 			return nil
 		}
-		if info.IsDir() {
+		if d.IsDir() {
 			_, err = zipWriter.Create(relPath + "/")
 			return err
 		}
-		writer, err := zipWriter.Create(relPath)
+		// Symlinks and other special files are not backed up, nor are hard-linked files, which may be
+		// shared with files outside the server.
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fileToZip, err := dataDir.Open(relPath)
+		if errors.Is(err, sandbox.ErrHardLink) {
+			log.Warn().Ctx(ctx).Str("server_id", server.ID).Str("path", relPath).Msg("Leaving hard-linked file out of backup")
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		fileToZip, err := os.Open(path)
+		defer fileToZip.Close()
+		writer, err := zipWriter.Create(relPath)
 		if err != nil {
 			return err
 		}
-		defer fileToZip.Close()
-		_, err = io.Copy(writer, fileToZip)
-		return err
+		if _, err = io.Copy(writer, fileToZip); err != nil {
+			return err
+		}
+		archivedFiles++
+		reportProgress(ctx, "archiving", archivedFiles, totalFiles, "files")
+		return nil
 	})
 
 	if err != nil {
@@ -130,26 +150,26 @@ func (s *BackupService) CreateBackup(serverID, name string) (models.Backup, erro
 	}
 	backup.Size = fi.Size()
 
-	stmt, err := s.db.Prepare("INSERT INTO backups (id, server_id, name, path, size, created_at) VALUES (?, ?, ?, ?, ?, ?)")
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO backups (id, server_id, name, path, size, created_at) VALUES (?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return models.Backup{}, err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(backup.ID, backup.ServerID, backup.Name, backup.Path, backup.Size, time.Now())
+	_, err = stmt.ExecContext(ctx, backup.ID, backup.ServerID, backup.Name, backup.Path, backup.Size, time.Now())
 	if err != nil {
 		os.Remove(backup.Path)
 		return models.Backup{}, err
 	}
 
-	s.eventService.CreateEvent("backup.create", "info", fmt.Sprintf("Backup '%s' created for server '%s'.", backup.Name, server.Name), &server.ID)
+	s.eventService.CreateEvent(ctx, "backup.create", "info", fmt.Sprintf("Backup '%s' created for server '%s'.", backup.Name, server.Name), &server.ID)
 
 	return backup, nil
 }
 
 // GetBackupsForServer retrieves all backups for a given server.
-func (s *BackupService) GetBackupsForServer(serverID string) ([]models.Backup, error) {
-	rows, err := s.db.Query("SELECT id, server_id, name, path, size, created_at FROM backups WHERE server_id = ? ORDER BY created_at DESC", serverID)
+func (s *BackupService) GetBackupsForServer(ctx context.Context, serverID string) ([]models.Backup, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, server_id, name, path, size, created_at FROM backups WHERE server_id = ? ORDER BY created_at DESC", serverID)
 	if err != nil {
 		return nil, err
 	}
@@ -167,129 +187,138 @@ func (s *BackupService) GetBackupsForServer(serverID string) ([]models.Backup, e
 }
 
 // DeleteBackup deletes a backup from the filesystem and database.
-func (s *BackupService) DeleteBackup(backupID string) error {
-	backup, err := s.GetBackupByID(backupID)
+func (s *BackupService) DeleteBackup(ctx context.Context, backupID string) error {
+	backup, err := s.GetBackupByID(ctx, backupID)
 	if err != nil {
 		return err
 	}
-	server, err := s.serverService.GetServerByID(backup.ServerID)
+	server, err := s.serverService.GetServerByID(ctx, backup.ServerID)
 	if err != nil {
 		// Log but don't fail, we should still be able to delete the backup record
-		log.Warn().Str("server_id", backup.ServerID).Str("backup_id", backup.ID).Msg("Could not find server for backup during deletion")
+		log.Warn().Ctx(ctx).Str("server_id", backup.ServerID).Str("backup_id", backup.ID).Msg("Could not find server for backup during deletion")
 	}
 
 	if err := os.Remove(backup.Path); err != nil && !os.IsNotExist(err) {
-		log.Warn().Err(err).Str("backup_path", backup.Path).Msg("Could not delete backup file from filesystem")
+		log.Warn().Ctx(ctx).Err(err).Str("backup_path", backup.Path).Msg("Could not delete backup file from filesystem")
 	}
 
-	_, err = s.db.Exec("DELETE FROM backups WHERE id = ?", backupID)
+	_, err = s.db.ExecContext(ctx, "DELETE FROM backups WHERE id = ?", backupID)
 	if err == nil && server.ID != "" {
 		msg := fmt.Sprintf("Backup '%s' for server '%s' was deleted.", backup.Name, server.Name)
-		s.eventService.CreateEvent("backup.delete", "warn", msg, &server.ID)
+		s.eventService.CreateEvent(ctx, "backup.delete", "warn", msg, &server.ID)
 	}
 	return err
 }
 
 // RestoreBackup restores a server to a previous state from a backup.
-func (s *BackupService) RestoreBackup(backupID string) error {
-	backup, err := s.GetBackupByID(backupID)
+func (s *BackupService) RestoreBackup(ctx context.Context, backupID string) (err error) {
+	defer func() { metrics.ObserveBackup("restore", err) }()
+
+	backup, err := s.GetBackupByID(ctx, backupID)
 	if err != nil {
 		return err
 	}
 
-	server, err := s.serverService.GetServerByID(backup.ServerID)
+	server, err := s.serverService.GetServerByID(ctx, backup.ServerID)
 	if err != nil {
 		return fmt.Errorf("could not find server for backup: %w", err)
 	}
 
+	zipReader, err := zip.OpenReader(backup.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup archive: %w", err)
+	}
+	defer zipReader.Close()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	msg := fmt.Sprintf("Restoration from backup '%s' started for server '%s'.", backup.Name, server.Name)
-	s.eventService.CreateEvent("backup.restore.start", "warn", msg, &server.ID)
+	s.eventService.CreateEvent(ctx, "backup.restore.start", "warn", msg, &server.ID)
+
+	// From here on the data directory is being replaced. Stopping halfway would leave the server
+	// with neither its old nor its restored files, so cancellation is no longer honoured.
+	ctx = context.WithoutCancel(ctx)
 
 	if server.Status == "online" || server.Status == "starting" {
-		if err := s.serverService.PerformServerAction(server.ID, "stop"); err != nil {
+		reportProgress(ctx, "stopping server", 0, 0, "")
+		// The stop action only returns once the container has exited.
+		if err := s.serverService.PerformServerAction(ctx, server.ID, "stop"); err != nil {
 			return fmt.Errorf("failed to stop server before restoring backup: %w", err)
 		}
-		// Wait for the server to stop gracefully. A fixed delay is simple but might be unreliable.
-		// A better method would be to poll the server status.
-		time.Sleep(10 * time.Second)
 	}
 
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+
 	// Clean out the server's data directory
-	dir, err := os.ReadDir(server.DataPath)
+	dir, err := dataDir.ReadDir(".")
 	if err != nil {
 		return fmt.Errorf("failed to read server data directory: %w", err)
 	}
 	for _, d := range dir {
-		os.RemoveAll(filepath.Join(server.DataPath, d.Name()))
+		dataDir.RemoveAll(d.Name())
 	}
 
 	// Unzip the backup into the data directory
-	zipReader, err := zip.OpenReader(backup.Path)
-	if err != nil {
-		return fmt.Errorf("failed to open backup archive: %w", err)
-	}
-	defer zipReader.Close()
+	totalFiles := int64(len(zipReader.File))
+	reportProgress(ctx, "restoring", 0, totalFiles, "files")
 
-	for _, f := range zipReader.File {
-		fpath := filepath.Join(server.DataPath, f.Name)
+	for i, f := range zipReader.File {
+		reportProgress(ctx, "restoring", int64(i+1), totalFiles, "files")
 
 		// Prevent ZipSlip vulnerability
-		if !strings.HasPrefix(fpath, filepath.Clean(server.DataPath)+string(os.PathSeparator)) {
-			return fmt.Errorf("invalid file path in zip: %s", fpath)
-		}
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
-		}
-
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			return err
+		name, ok := zipEntryName(f)
+		if !ok {
+			return fmt.Errorf("invalid file path in zip: %s", f.Name)
 		}
-
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
+		if err := extractZipEntry(dataDir, f, name, nil); err != nil {
 			return err
 		}
 	}
 
 	// After restoring, ensure the EULA is accepted to prevent startup issues.
-	eulaPath := filepath.Join(server.DataPath, "eula.txt")
-	if err := os.WriteFile(eulaPath, []byte("eula=true\n"), 0644); err != nil {
+	if err := dataDir.WriteFile("eula.txt", []byte("eula=true\n"), 0644); err != nil {
 		// Log a warning but don't fail the entire restore process for this.
 		// The server might still start if the EULA was already true in the backup.
-		log.Warn().Err(err).Str("server_id", server.ID).Msg("Failed to automatically accept EULA after restore.")
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", server.ID).Msg("Failed to automatically accept EULA after restore.")
 	}
 
 	// Start the server again
-	if err := s.serverService.PerformServerAction(server.ID, "start"); err != nil {
+	reportProgress(ctx, "starting server", 0, 0, "")
+	if err := s.serverService.PerformServerAction(ctx, server.ID, "start"); err != nil {
 		return fmt.Errorf("failed to start server after restoring backup: %w", err)
 	}
 
 	msg = fmt.Sprintf("Server '%s' successfully restored from backup '%s'.", server.Name, backup.Name)
-	s.eventService.CreateEvent("backup.restore.finish", "info", msg, &server.ID)
+	s.eventService.CreateEvent(ctx, "backup.restore.finish", "info", msg, &server.ID)
 
 	return nil
 }
 
+// countFiles returns the number of regular files in root.
+func countFiles(root *sandbox.Root) (int64, error) {
+	var n int64
+	err := root.WalkDir(".", func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
 // GetBackupByID retrieves a single backup by its ID.
-func (s *BackupService) GetBackupByID(backupID string) (models.Backup, error) {
+func (s *BackupService) GetBackupByID(ctx context.Context, backupID string) (models.Backup, error) {
 	var backup models.Backup
-	row := s.db.QueryRow("SELECT id, server_id, name, path, size, created_at FROM backups WHERE id = ?", backupID)
+	row := s.db.QueryRowContext(ctx, "SELECT id, server_id, name, path, size, created_at FROM backups WHERE id = ?", backupID)
 	err := row.Scan(&backup.ID, &backup.ServerID, &backup.Name, &backup.Path, &backup.Size, &backup.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -299,3 +328,33 @@ func (s *BackupService) GetBackupByID(backupID string) (models.Backup, error) {
 	}
 	return backup, nil
 }
+
+// saveWorld flushes an online server's world to disk and turns auto-saving off, so its files can be copied
+// consistently. The returned function turns auto-saving back on; it must be called even if copying fails.
+func saveWorld(ctx context.Context, servers ServerServiceProvider, server models.Server) (resume func(), err error) {
+	if server.Status != "online" {
+		return func() {}, nil
+	}
+	log.Info().Ctx(ctx).Str("server_id", server.ID).Msg("Server is online, saving the world over RCON before copying it.")
+	// 1. Turn off auto-saving to prevent file changes during the copy
+	if _, err := servers.SendRconCommand(ctx, server.ID, "save-off"); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", server.ID).Msg("Failed to send 'save-off'. Continuing anyway.")
+	}
+	// 2. Saving is turned back on even if the copy was cancelled
+	resume = func() { servers.SendRconCommand(context.WithoutCancel(ctx), server.ID, "save-on") }
+
+	// 3. Force a save to flush all changes to disk
+	reportProgress(ctx, "saving world", 0, 0, "")
+	if _, err := servers.SendRconCommand(ctx, server.ID, "save-all"); err != nil {
+		resume()
+		return nil, err
+	}
+	// 4. Give the server a moment to write everything to disk
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+		resume()
+		return nil, ctx.Err()
+	}
+	return resume, nil
+}
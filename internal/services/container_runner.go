@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/docker"
+	"github.com/rs/zerolog/log"
+)
+
+// ContainerRunner runs one-off commands, such as loader installers, in throwaway containers.
+type ContainerRunner struct {
+	docker *docker.Client
+}
+
+// NewContainerRunner creates a new ContainerRunner.
+func NewContainerRunner(docker *docker.Client) *ContainerRunner {
+	return &ContainerRunner{docker: docker}
+}
+
+// Run runs cmd to completion in a container of image with dir mounted as its working directory.
+// The container is removed afterwards; if cmd fails, the error carries the tail of its output.
+func (r *ContainerRunner) Run(ctx context.Context, image, dir string, cmd []string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeBind, Source: absDir, Target: "/data"}},
+	}
+	_, err = r.run(ctx, image, "/data", cmd, hostConfig)
+	return err
+}
+
+// Output runs cmd to completion in a container of image and returns what it printed.
+func (r *ContainerRunner) Output(ctx context.Context, image string, cmd []string) (string, error) {
+	return r.run(ctx, image, "", cmd, &container.HostConfig{})
+}
+
+// maxRunOutput bounds how much of a throwaway container's output is kept.
+const maxRunOutput = 64 << 10
+
+func (r *ContainerRunner) run(ctx context.Context, image, workingDir string, cmd []string, hostConfig *container.HostConfig) (string, error) {
+	if err := ensureImageExists(ctx, r.docker, image); err != nil {
+		return "", err
+	}
+
+	containerConfig := &container.Config{
+		Image:      image,
+		WorkingDir: workingDir,
+		Cmd:        cmd,
+		Tty:        true, // Keeps the log stream unmultiplexed
+		Labels: map[string]string{
+			"com.ender-deploy.installer": "true",
+		},
+	}
+
+	resp, err := r.docker.CreateContainer(ctx, containerConfig, hostConfig, "enderdeploy_run_"+uuid.New().String())
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	defer func() {
+		if err := r.docker.RemoveContainer(context.WithoutCancel(ctx), resp.ID); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Str("container_id", resp.ID).Msg("Failed to remove throwaway container")
+		}
+	}()
+
+	if err := r.docker.StartContainer(ctx, resp.ID); err != nil {
+		return "", fmt.Errorf("failed to start container: %w", err)
+	}
+	exitCode, err := r.docker.WaitContainer(ctx, resp.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed waiting for container: %w", err)
+	}
+	output := r.output(ctx, resp.ID)
+	if exitCode != 0 {
+		return output, fmt.Errorf("exited with code %d: %s", exitCode, lastLines(output, 10))
+	}
+	return output, nil
+}
+
+// output returns a container's output, or its last maxRunOutput bytes if it printed more.
+func (r *ContainerRunner) output(ctx context.Context, id string) string {
+	logs, err := r.docker.GetContainerLogs(ctx, id, false)
+	if err != nil {
+		return "no output available"
+	}
+	defer logs.Close()
+
+	var out []byte
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := logs.Read(buf)
+		out = append(out, buf[:n]...)
+		if len(out) > maxRunOutput {
+			out = append(out[:0], out[len(out)-maxRunOutput:]...)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return strings.ReplaceAll(string(out), "\r\n", "\n")
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
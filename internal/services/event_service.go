@@ -1,51 +1,63 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"github.com/isdelr/ender-deploy-be/internal/websocket"
 )
 
 // EventServiceProvider defines the interface for event services.
 type EventServiceProvider interface {
-	CreateEvent(eventType, level, message string, serverID *string) error
+	CreateEvent(ctx context.Context, eventType, level, message string, serverID *string) error
 	GetRecentEvents(limit int) ([]models.Event, error)
 }
 
 // EventService provides business logic for event management.
 type EventService struct {
-	db *sql.DB
+	db  *sql.DB
+	hub *websocket.Hub
 }
 
-// NewEventService creates a new EventService.
-func NewEventService(db *sql.DB) *EventService {
-	return &EventService{db: db}
+// NewEventService creates a new EventService. New events are published to websocket clients through hub.
+func NewEventService(db *sql.DB, hub *websocket.Hub) *EventService {
+	return &EventService{db: db, hub: hub}
 }
 
 // CreateEvent logs a new event to the database.
-func (s *EventService) CreateEvent(eventType, level, message string, serverID *string) error {
+func (s *EventService) CreateEvent(ctx context.Context, eventType, level, message string, serverID *string) error {
 	event := models.Event{
 		ID:       uuid.New().String(),
 		Type:     eventType,
 		Level:    level,
 		Message:  message,
 		ServerID: serverID,
+		TraceID:  tracing.TraceID(ctx),
 	}
 
-	stmt, err := s.db.Prepare("INSERT INTO events (id, type, level, message, server_id) VALUES (?, ?, ?, ?, ?)")
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO events (id, type, level, message, server_id, trace_id) VALUES (?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(event.ID, event.Type, event.Level, event.Message, event.ServerID)
-	return err
+	_, err = stmt.ExecContext(ctx, event.ID, event.Type, event.Level, event.Message, event.ServerID, sql.NullString{String: event.TraceID, Valid: event.TraceID != ""})
+	if err != nil {
+		return err
+	}
+
+	event.CreatedAt = time.Now().UTC()
+	s.hub.Publish(websocket.EventsTopic, "event", event)
+	return nil
 }
 
 // GetRecentEvents retrieves the most recent events from the database.
 func (s *EventService) GetRecentEvents(limit int) ([]models.Event, error) {
-	rows, err := s.db.Query("SELECT id, type, level, message, server_id, created_at FROM events ORDER BY created_at DESC LIMIT ?", limit)
+	rows, err := s.db.Query("SELECT id, type, level, message, server_id, trace_id, created_at FROM events ORDER BY created_at DESC LIMIT ?", limit)
 	if err != nil {
 		return nil, err
 	}
@@ -54,9 +66,11 @@ func (s *EventService) GetRecentEvents(limit int) ([]models.Event, error) {
 	var events []models.Event
 	for rows.Next() {
 		var event models.Event
-		if err := rows.Scan(&event.ID, &event.Type, &event.Level, &event.Message, &event.ServerID, &event.CreatedAt); err != nil {
+		var traceID sql.NullString
+		if err := rows.Scan(&event.ID, &event.Type, &event.Level, &event.Message, &event.ServerID, &traceID, &event.CreatedAt); err != nil {
 			return nil, err
 		}
+		event.TraceID = traceID.String
 		events = append(events, event)
 	}
 	return events, nil
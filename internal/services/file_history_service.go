@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrFileVersionNotFound is returned for unknown file versions.
+var ErrFileVersionNotFound = errors.New("file version not found")
+
+// maxVersionedFileSize is the size above which files are overwritten without keeping their previous
+// version. History is meant for configs, not worlds or jars.
+const maxVersionedFileSize = 10 * 1024 * 1024
+
+// FileHistoryServiceProvider defines the interface for the history of server files. Before a file is
+// overwritten, its content is kept as a version that can be compared with later ones and restored.
+type FileHistoryServiceProvider interface {
+	Snapshot(ctx context.Context, serverID string, root *sandbox.Root, name string) error
+	ListVersions(ctx context.Context, serverID, name string) ([]models.FileVersion, error)
+	GetVersion(ctx context.Context, serverID, versionID string) (models.FileVersion, error)
+	ReadVersion(ctx context.Context, version models.FileVersion) ([]byte, error)
+	DeleteServerHistory(ctx context.Context, serverID string) error
+}
+
+// FileHistoryService keeps previous versions of server files in a directory per server, outside the
+// servers' data directories.
+type FileHistoryService struct {
+	db    *sql.DB
+	dir   string
+	limit int
+}
+
+// NewFileHistoryService creates a new FileHistoryService that keeps versions in dir, at most limit per file.
+func NewFileHistoryService(db *sql.DB, dir string, limit int) *FileHistoryService {
+	return &FileHistoryService{db: db, dir: dir, limit: max(limit, 1)}
+}
+
+// Snapshot keeps the current content of a file as a version, credited to the user in ctx. Files that don't
+// exist yet, aren't regular files or are too big to keep are skipped.
+func (s *FileHistoryService) Snapshot(ctx context.Context, serverID string, root *sandbox.Root, name string) error {
+	name = sandbox.Clean(name)
+	f, err := root.Open(name)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, sandbox.ErrHardLink) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if info.Size() > maxVersionedFileSize {
+		log.Info().Ctx(ctx).Str("server_id", serverID).Str("path", name).Msg("File is too big to keep its previous version")
+		return nil
+	}
+
+	version := models.FileVersion{
+		ID:        uuid.New().String(),
+		ServerID:  serverID,
+		Path:      name,
+		Size:      info.Size(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		version.Author = claims.Username
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, serverID), 0755); err != nil {
+		return fmt.Errorf("could not create history directory: %w", err)
+	}
+	out, err := os.OpenFile(s.versionPath(version), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		_, err = s.db.ExecContext(ctx, "INSERT INTO file_versions (id, server_id, path, author, size, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			version.ID, version.ServerID, version.Path, version.Author, version.Size, version.CreatedAt)
+	}
+	if err != nil {
+		os.Remove(s.versionPath(version))
+		return fmt.Errorf("could not keep previous version of %s: %w", name, err)
+	}
+
+	s.prune(ctx, serverID, name)
+	return nil
+}
+
+// prune deletes the oldest versions of a file beyond the limit.
+func (s *FileHistoryService) prune(ctx context.Context, serverID, name string) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM file_versions WHERE server_id = ? AND path = ? ORDER BY created_at DESC LIMIT -1 OFFSET ?", serverID, name, s.limit)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Str("path", name).Msg("Failed to prune file history")
+		return
+	}
+	var stale []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range stale {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM file_versions WHERE id = ?", id); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Str("version_id", id).Msg("Failed to prune file version")
+			continue
+		}
+		os.Remove(s.versionPath(models.FileVersion{ID: id, ServerID: serverID}))
+	}
+}
+
+// ListVersions lists the kept versions of a file, newest first.
+func (s *FileHistoryService) ListVersions(ctx context.Context, serverID, name string) ([]models.FileVersion, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, server_id, path, author, size, created_at FROM file_versions WHERE server_id = ? AND path = ? ORDER BY created_at DESC", serverID, sandbox.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.FileVersion{}
+	for rows.Next() {
+		version, err := scanFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// GetVersion returns a version of one of a server's files.
+func (s *FileHistoryService) GetVersion(ctx context.Context, serverID, versionID string) (models.FileVersion, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT id, server_id, path, author, size, created_at FROM file_versions WHERE id = ? AND server_id = ?", versionID, serverID)
+	version, err := scanFileVersion(row)
+	if err == sql.ErrNoRows {
+		return models.FileVersion{}, fmt.Errorf("%w: %s", ErrFileVersionNotFound, versionID)
+	}
+	return version, err
+}
+
+// ReadVersion reads the content of a version.
+func (s *FileHistoryService) ReadVersion(ctx context.Context, version models.FileVersion) ([]byte, error) {
+	data, err := os.ReadFile(s.versionPath(version))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: the content of %s is missing", ErrFileVersionNotFound, version.ID)
+	}
+	return data, err
+}
+
+// DeleteServerHistory deletes the history of all of a server's files.
+func (s *FileHistoryService) DeleteServerHistory(ctx context.Context, serverID string) error {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM file_versions WHERE server_id = ?", serverID); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.dir, serverID))
+}
+
+func (s *FileHistoryService) versionPath(version models.FileVersion) string {
+	return filepath.Join(s.dir, version.ServerID, version.ID)
+}
+
+func scanFileVersion(row interface{ Scan(...any) error }) (models.FileVersion, error) {
+	var version models.FileVersion
+	var author sql.NullString
+	err := row.Scan(&version.ID, &version.ServerID, &version.Path, &author, &version.Size, &version.CreatedAt)
+	version.Author = author.String
+	return version, err
+}
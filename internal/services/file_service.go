@@ -0,0 +1,978 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/diff"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrFileNotFound is returned for paths that don't exist.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileExists is returned when an operation would replace an existing file without being asked to.
+	ErrFileExists = errors.New("file already exists")
+	// ErrInvalidFileOperation is returned for operations that make no sense, such as deleting the data directory itself.
+	ErrInvalidFileOperation = errors.New("invalid file operation")
+	// ErrUploadNotFound is returned for unknown or expired chunked uploads.
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadOffset is returned for chunks that don't continue an upload where it left off.
+	ErrUploadOffset = errors.New("chunk doesn't start at the upload's offset")
+	// ErrInvalidConfig is returned for config files that don't parse or have settings the server would reject.
+	ErrInvalidConfig = errors.New("invalid config file")
+)
+
+// ConfigValidationError lists the problems that kept a config file from being written.
+type ConfigValidationError struct {
+	Path   string
+	Issues []models.ConfigIssue
+}
+
+func (e *ConfigValidationError) Error() string {
+	issue := e.Issues[0]
+	msg := fmt.Sprintf("%s: %s", e.Path, issue.Message)
+	switch {
+	case issue.Line > 0 && issue.Column > 0:
+		msg = fmt.Sprintf("%s:%d:%d: %s", e.Path, issue.Line, issue.Column, issue.Message)
+	case issue.Line > 0:
+		msg = fmt.Sprintf("%s:%d: %s", e.Path, issue.Line, issue.Message)
+	}
+	if len(e.Issues) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Issues)-1)
+	}
+	return msg
+}
+
+func (e *ConfigValidationError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Limits of the file manager.
+const (
+	uploadExpiry          = 24 * time.Hour // Chunked uploads untouched for this long are discarded
+	maxSearchResults      = 200
+	maxSearchFileSize     = 10 * 1024 * 1024 // Larger files are matched by name only
+	maxSearchLineLength   = 200
+	binaryDetectionLength = 8000
+)
+
+// FileServiceProvider defines the interface for the file manager. Paths are relative to a server's data
+// directory, which no path can lead out of.
+type FileServiceProvider interface {
+	OpenFile(ctx context.Context, serverID, name string) (*os.File, os.FileInfo, error)
+	UploadFile(ctx context.Context, serverID, name string, overwrite bool, r io.Reader) (models.FileInfo, error)
+	CreateUpload(ctx context.Context, serverID, name string, size int64, overwrite bool) (models.FileUpload, error)
+	GetUpload(ctx context.Context, serverID, uploadID string) (models.FileUpload, error)
+	WriteUploadChunk(ctx context.Context, serverID, uploadID string, offset int64, r io.Reader) (models.FileUpload, error)
+	CancelUpload(ctx context.Context, serverID, uploadID string) error
+	DeleteFiles(ctx context.Context, serverID string, names []string) error
+	MoveFile(ctx context.Context, serverID, from, to string) error
+	MakeDirectory(ctx context.Context, serverID, name string) error
+	ChangeMode(ctx context.Context, serverID, name, mode string) error
+	ArchiveFiles(ctx context.Context, serverID string, names []string, destination string) (models.FileInfo, error)
+	ExtractArchive(ctx context.Context, serverID, name, destination string) error
+	SearchFiles(ctx context.Context, serverID, dir, query string, content bool) ([]models.FileSearchResult, error)
+	ListFileVersions(ctx context.Context, serverID, name string) ([]models.FileVersion, error)
+	ReadFileVersion(ctx context.Context, serverID, versionID string) (models.FileVersion, []byte, error)
+	DiffFileVersion(ctx context.Context, serverID, versionID, to string) (string, error)
+	RevertFile(ctx context.Context, serverID, versionID string) (models.FileInfo, error)
+}
+
+// FileService manages the files in the data directories of servers.
+type FileService struct {
+	db            *sql.DB
+	serverService ServerServiceProvider
+	eventService  EventServiceProvider
+	history       FileHistoryServiceProvider
+	uploadPath    string
+}
+
+// NewFileService creates a new FileService. Chunked uploads are staged in uploadPath until they are complete,
+// and the files they replace are kept in history.
+func NewFileService(db *sql.DB, serverService ServerServiceProvider, eventService EventServiceProvider, history FileHistoryServiceProvider, uploadPath string) *FileService {
+	return &FileService{db: db, serverService: serverService, eventService: eventService, history: history, uploadPath: uploadPath}
+}
+
+// openServer looks up a server and opens its data directory.
+func (s *FileService) openServer(ctx context.Context, serverID string) (models.Server, *sandbox.Root, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.Server{}, nil, err
+	}
+	root, err := openDataDir(server)
+	if err != nil {
+		return models.Server{}, nil, err
+	}
+	return server, root, nil
+}
+
+// OpenFile opens a regular file of a server for reading.
+func (s *FileService) OpenFile(ctx context.Context, serverID, name string) (*os.File, os.FileInfo, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer root.Close()
+
+	f, err := root.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrFileNotFound, name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fmt.Errorf("%w: %s is not a file", ErrInvalidFileOperation, name)
+	}
+
+	s.audit(ctx, server, "file.download", "info", fmt.Sprintf("'%s' was downloaded from server '%s'", sandbox.Clean(name), server.Name))
+	return f, info, nil
+}
+
+// UploadFile writes r to a file. The file only appears once it is complete, and replaces an existing one,
+// keeping its permissions, only if overwrite is set.
+func (s *FileService) UploadFile(ctx context.Context, serverID, name string, overwrite bool, r io.Reader) (models.FileInfo, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	defer root.Close()
+
+	name = sandbox.Clean(name)
+	if err := checkReplaceable(root, name, overwrite); err != nil {
+		return models.FileInfo{}, err
+	}
+	if err := root.MkdirAll(path.Dir(name), 0755); err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := s.history.Snapshot(ctx, server.ID, root, name); err != nil {
+		return models.FileInfo{}, err
+	}
+	err = root.WriteFileFrom(name, 0644, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+	if err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not write upload: %w", err)
+	}
+
+	s.audit(ctx, server, "file.upload", "info", fmt.Sprintf("'%s' was uploaded to server '%s'", name, server.Name))
+	return statFileInfo(root, name)
+}
+
+// CreateUpload starts a chunked upload of a file of size bytes.
+func (s *FileService) CreateUpload(ctx context.Context, serverID, name string, size int64, overwrite bool) (models.FileUpload, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return models.FileUpload{}, err
+	}
+	defer root.Close()
+
+	if size < 0 {
+		return models.FileUpload{}, fmt.Errorf("%w: size can't be negative", ErrInvalidFileOperation)
+	}
+	name = sandbox.Clean(name)
+	if err := checkReplaceable(root, name, overwrite); err != nil {
+		return models.FileUpload{}, err
+	}
+	s.expireUploads(ctx)
+
+	upload := models.FileUpload{
+		ID:        uuid.New().String(),
+		ServerID:  server.ID,
+		Path:      name,
+		Size:      size,
+		Overwrite: overwrite,
+		CreatedAt: time.Now().UTC(),
+	}
+	upload.UpdatedAt = upload.CreatedAt
+	f, err := os.Create(s.partPath(upload.ID))
+	if err != nil {
+		return models.FileUpload{}, fmt.Errorf("could not stage upload: %w", err)
+	}
+	f.Close()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO file_uploads (id, server_id, path, size, received, overwrite, created_at, updated_at) VALUES (?, ?, ?, ?, 0, ?, ?, ?)",
+		upload.ID, upload.ServerID, upload.Path, upload.Size, upload.Overwrite, upload.CreatedAt, upload.UpdatedAt)
+	if err != nil {
+		os.Remove(s.partPath(upload.ID))
+		return models.FileUpload{}, err
+	}
+
+	// An empty file is complete as soon as it is announced.
+	if size == 0 {
+		return s.completeUpload(ctx, server, root, upload)
+	}
+	return upload, nil
+}
+
+// GetUpload returns the state of a chunked upload, so a client can resume it from its offset.
+func (s *FileService) GetUpload(ctx context.Context, serverID, uploadID string) (models.FileUpload, error) {
+	var upload models.FileUpload
+	err := s.db.QueryRowContext(ctx, "SELECT id, server_id, path, size, received, overwrite, created_at, updated_at FROM file_uploads WHERE id = ? AND server_id = ?", uploadID, serverID).
+		Scan(&upload.ID, &upload.ServerID, &upload.Path, &upload.Size, &upload.Offset, &upload.Overwrite, &upload.CreatedAt, &upload.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.FileUpload{}, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+	}
+	return upload, err
+}
+
+// WriteUploadChunk appends a chunk starting at offset to an upload. Once all bytes are in, the file is moved
+// into place and the upload is complete.
+func (s *FileService) WriteUploadChunk(ctx context.Context, serverID, uploadID string, offset int64, r io.Reader) (models.FileUpload, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return models.FileUpload{}, err
+	}
+	defer root.Close()
+
+	upload, err := s.GetUpload(ctx, serverID, uploadID)
+	if err != nil {
+		return models.FileUpload{}, err
+	}
+	if offset != upload.Offset {
+		return upload, fmt.Errorf("%w: expected %d, got %d", ErrUploadOffset, upload.Offset, offset)
+	}
+
+	f, err := os.OpenFile(s.partPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return models.FileUpload{}, fmt.Errorf("upload is no longer staged: %w", err)
+	}
+	// Anything past the recorded offset is a chunk that was cut off and will be sent again.
+	if err := f.Truncate(upload.Offset); err != nil {
+		f.Close()
+		return models.FileUpload{}, err
+	}
+	if _, err := f.Seek(upload.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return models.FileUpload{}, err
+	}
+	// One byte more than is missing is read to notice chunks that run past the announced size.
+	n, err := io.Copy(f, io.LimitReader(r, upload.Size-upload.Offset+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if upload.Offset+n > upload.Size {
+		return upload, fmt.Errorf("%w: chunk runs past the upload's size of %d bytes", ErrInvalidFileOperation, upload.Size)
+	}
+	// A chunk cut off by the client still counts for what arrived.
+	upload.Offset += n
+	upload.UpdatedAt = time.Now().UTC()
+	if _, dbErr := s.db.ExecContext(ctx, "UPDATE file_uploads SET received = ?, updated_at = ? WHERE id = ?", upload.Offset, upload.UpdatedAt, upload.ID); dbErr != nil {
+		return models.FileUpload{}, dbErr
+	}
+	if err != nil {
+		return upload, fmt.Errorf("could not write chunk: %w", err)
+	}
+
+	if upload.Offset == upload.Size {
+		return s.completeUpload(ctx, server, root, upload)
+	}
+	return upload, nil
+}
+
+// completeUpload moves a fully received upload into place.
+func (s *FileService) completeUpload(ctx context.Context, server models.Server, root *sandbox.Root, upload models.FileUpload) (models.FileUpload, error) {
+	// The target is checked again, since the directory may have changed since the upload started.
+	if err := checkReplaceable(root, upload.Path, upload.Overwrite); err != nil {
+		return upload, err
+	}
+	if err := root.MkdirAll(path.Dir(upload.Path), 0755); err != nil {
+		return upload, fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := s.history.Snapshot(ctx, server.ID, root, upload.Path); err != nil {
+		return upload, err
+	}
+	part, err := os.Open(s.partPath(upload.ID))
+	if err != nil {
+		return upload, fmt.Errorf("upload is no longer staged: %w", err)
+	}
+	err = root.WriteFileFrom(upload.Path, 0644, func(w io.Writer) error {
+		_, err := io.Copy(w, part)
+		return err
+	})
+	part.Close()
+	if err != nil {
+		return upload, fmt.Errorf("could not move upload into place: %w", err)
+	}
+
+	os.Remove(s.partPath(upload.ID))
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM file_uploads WHERE id = ?", upload.ID); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("upload_id", upload.ID).Msg("Failed to remove completed upload")
+	}
+	upload.Complete = true
+	s.audit(ctx, server, "file.upload", "info", fmt.Sprintf("'%s' was uploaded to server '%s'", upload.Path, server.Name))
+	return upload, nil
+}
+
+// CancelUpload discards a chunked upload.
+func (s *FileService) CancelUpload(ctx context.Context, serverID, uploadID string) error {
+	upload, err := s.GetUpload(ctx, serverID, uploadID)
+	if err != nil {
+		return err
+	}
+	os.Remove(s.partPath(upload.ID))
+	_, err = s.db.ExecContext(ctx, "DELETE FROM file_uploads WHERE id = ?", upload.ID)
+	return err
+}
+
+// expireUploads discards chunked uploads that have not received a chunk in a while.
+func (s *FileService) expireUploads(ctx context.Context) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM file_uploads WHERE updated_at < ?", time.Now().UTC().Add(-uploadExpiry))
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Msg("Failed to look up expired uploads")
+		return
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			expired = append(expired, id)
+		}
+	}
+	rows.Close()
+	for _, id := range expired {
+		os.Remove(s.partPath(id))
+		s.db.ExecContext(ctx, "DELETE FROM file_uploads WHERE id = ?", id)
+	}
+}
+
+func (s *FileService) partPath(uploadID string) string {
+	return filepath.Join(s.uploadPath, "file-"+uploadID+".part")
+}
+
+// DeleteFiles deletes files and directories, with everything in them.
+func (s *FileService) DeleteFiles(ctx context.Context, serverID string, names []string) error {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if len(names) == 0 {
+		return fmt.Errorf("%w: no paths given", ErrInvalidFileOperation)
+	}
+	targets := make([]string, len(names))
+	for i, name := range names {
+		targets[i] = sandbox.Clean(name)
+		if targets[i] == "." {
+			return fmt.Errorf("%w: the server directory itself can't be deleted", ErrInvalidFileOperation)
+		}
+		if _, err := root.Lstat(targets[i]); err != nil {
+			return fmt.Errorf("%w: %s", ErrFileNotFound, name)
+		}
+	}
+	for _, target := range targets {
+		if err := root.RemoveAll(target); err != nil {
+			return fmt.Errorf("could not delete %s: %w", target, err)
+		}
+		s.audit(ctx, server, "file.delete", "warn", fmt.Sprintf("'%s' was deleted from server '%s'", target, server.Name))
+	}
+	return nil
+}
+
+// MoveFile renames or moves a file or directory. The destination must not exist.
+func (s *FileService) MoveFile(ctx context.Context, serverID, from, to string) error {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	from, to = sandbox.Clean(from), sandbox.Clean(to)
+	if from == "." || to == "." {
+		return fmt.Errorf("%w: the server directory itself can't be moved", ErrInvalidFileOperation)
+	}
+	if _, err := root.Lstat(from); err != nil {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, from)
+	}
+	if _, err := root.Lstat(to); err == nil {
+		return fmt.Errorf("%w: %s", ErrFileExists, to)
+	}
+	if strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("%w: a directory can't be moved into itself", ErrInvalidFileOperation)
+	}
+	if err := root.MkdirAll(path.Dir(to), 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := root.Rename(from, to); err != nil {
+		return fmt.Errorf("could not move %s: %w", from, err)
+	}
+
+	s.audit(ctx, server, "file.move", "info", fmt.Sprintf("'%s' was moved to '%s' on server '%s'", from, to, server.Name))
+	return nil
+}
+
+// MakeDirectory creates a directory and any missing parents.
+func (s *FileService) MakeDirectory(ctx context.Context, serverID, name string) error {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	name = sandbox.Clean(name)
+	if info, err := root.Lstat(name); err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrFileExists, name)
+	}
+	if err := root.MkdirAll(name, 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	s.audit(ctx, server, "file.mkdir", "info", fmt.Sprintf("Directory '%s' was created on server '%s'", name, server.Name))
+	return nil
+}
+
+// ChangeMode sets the permission bits of a file or directory from an octal string such as "755". Special bits
+// such as setuid are not accepted.
+func (s *FileService) ChangeMode(ctx context.Context, serverID, name, mode string) error {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return fmt.Errorf("%w: %q is not an octal mode between 000 and 777", ErrInvalidFileOperation, mode)
+	}
+	name = sandbox.Clean(name)
+	info, err := root.Lstat(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, name)
+	}
+	// Chmod follows symlinks, so it would change the link's target instead.
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: the mode of a symlink can't be changed", ErrInvalidFileOperation)
+	}
+	if err := root.Chmod(name, os.FileMode(perm)); err != nil {
+		return fmt.Errorf("could not change mode: %w", err)
+	}
+
+	s.audit(ctx, server, "file.chmod", "info", fmt.Sprintf("Mode of '%s' on server '%s' was set to %04o", name, server.Name, perm))
+	return nil
+}
+
+// ArchiveFiles zips files and directories into a new archive at destination. Each is stored under its own name
+// at the root of the archive. Symlinks and files with other hard links are left out.
+func (s *FileService) ArchiveFiles(ctx context.Context, serverID string, names []string, destination string) (models.FileInfo, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	defer root.Close()
+
+	if len(names) == 0 {
+		return models.FileInfo{}, fmt.Errorf("%w: no paths given", ErrInvalidFileOperation)
+	}
+	dest := sandbox.Clean(destination)
+	if !strings.HasSuffix(strings.ToLower(dest), ".zip") {
+		return models.FileInfo{}, fmt.Errorf("%w: the archive must be a .zip", ErrInvalidFileOperation)
+	}
+	if _, err := root.Lstat(dest); err == nil {
+		return models.FileInfo{}, fmt.Errorf("%w: %s", ErrFileExists, dest)
+	}
+
+	sources := map[string]string{} // Name in the archive to name in the data directory
+	var total int64
+	for _, name := range names {
+		name = sandbox.Clean(name)
+		entry := path.Base(name)
+		if name == "." {
+			entry = ""
+		}
+		if _, taken := sources[entry]; taken {
+			return models.FileInfo{}, fmt.Errorf("%w: more than one path is named %q", ErrInvalidFileOperation, entry)
+		}
+		if _, err := root.Stat(name); err != nil {
+			return models.FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, name)
+		}
+		sources[entry] = name
+		root.WalkDir(name, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					total += info.Size()
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := root.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not create directory: %w", err)
+	}
+	tmp, err := root.CreateTemp(path.Dir(dest), ".archive-*")
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	tmpName := path.Join(path.Dir(dest), filepath.Base(tmp.Name()))
+	defer root.Remove(tmpName)
+
+	var written int64
+	zw := zip.NewWriter(tmp)
+	for entry, source := range sources {
+		err := root.WalkDir(source, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// The archive being written is in the data directory too.
+			if name == tmpName || !d.Type().IsRegular() && !d.IsDir() {
+				return nil
+			}
+			header, err := archiveHeader(d, path.Join(entry, relativeTo(source, name)))
+			if err != nil || header == nil {
+				return err
+			}
+			if d.IsDir() {
+				_, err = zw.CreateHeader(header)
+				return err
+			}
+			f, err := root.Open(name)
+			if errors.Is(err, sandbox.ErrHardLink) {
+				log.Warn().Ctx(ctx).Str("server_id", server.ID).Str("path", name).Msg("Leaving hard-linked file out of archive")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(&progressWriter{ctx: ctx, w: w, stage: "archiving", current: &written, total: total}, f)
+			return err
+		})
+		if err != nil {
+			tmp.Close()
+			return models.FileInfo{}, fmt.Errorf("could not archive %s: %w", source, err)
+		}
+	}
+	err = zw.Close()
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not write archive: %w", err)
+	}
+	if err := root.Rename(tmpName, dest); err != nil {
+		return models.FileInfo{}, err
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + sandbox.Clean(name) + "'"
+	}
+	s.audit(ctx, server, "file.archive", "info", fmt.Sprintf("%s on server '%s' were archived to '%s'", strings.Join(quoted, ", "), server.Name, dest))
+	return statFileInfo(root, dest)
+}
+
+// archiveHeader returns the zip header for a directory entry stored as name, or nil for the top of the
+// archive.
+func archiveHeader(d fs.DirEntry, name string) (*zip.FileHeader, error) {
+	if name == "" || name == "." {
+		return nil, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return nil, err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	if d.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+	return header, nil
+}
+
+// relativeTo returns name relative to dir, where name is dir or inside it.
+func relativeTo(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+}
+
+// ExtractArchive unzips an archive into the directory destination, replacing files that are already there.
+// Entries that would land outside the destination and symlinks are skipped.
+func (s *FileService) ExtractArchive(ctx context.Context, serverID, name, destination string) error {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	name = sandbox.Clean(name)
+	f, err := root.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("%w: %s is not a zip archive: %v", ErrInvalidFileOperation, name, err)
+	}
+	dest := sandbox.Clean(destination)
+	if err := root.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	total := int64(len(zr.File))
+	for i, f := range zr.File {
+		reportProgress(ctx, "extracting", int64(i), total, "files")
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, ok := zipEntryName(f)
+		if !ok || f.Mode()&os.ModeSymlink != 0 {
+			log.Warn().Ctx(ctx).Str("entry", f.Name).Str("archive", name).Msg("Skipping unsafe archive entry")
+			continue
+		}
+		if err := extractZipEntry(root, f, path.Join(dest, entry), nil); err != nil {
+			return fmt.Errorf("could not extract %s: %w", f.Name, err)
+		}
+	}
+	reportProgress(ctx, "extracting", total, total, "files")
+
+	s.audit(ctx, server, "file.extract", "info", fmt.Sprintf("'%s' was extracted to '%s' on server '%s'", name, dest, server.Name))
+	return nil
+}
+
+// SearchFiles searches the directory dir, recursively, for files whose name contains query, or with content
+// set whose text does. The search is case-insensitive, skips binary and large files when matching content, and
+// doesn't follow symlinks.
+func (s *FileService) SearchFiles(ctx context.Context, serverID, dir, query string, content bool) ([]models.FileSearchResult, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	if query == "" {
+		return nil, fmt.Errorf("%w: the search query is empty", ErrInvalidFileOperation)
+	}
+	dir = sandbox.Clean(dir)
+	if _, err := root.Stat(dir); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, dir)
+	}
+
+	needle := strings.ToLower(query)
+	results := []models.FileSearchResult{}
+	err = root.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped rather than ending the search.
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(results) >= maxSearchResults {
+			return fs.SkipAll
+		}
+		if name == dir {
+			return nil
+		}
+		result := models.FileSearchResult{Path: name, IsDir: d.IsDir()}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			result.Size = info.Size()
+		}
+
+		switch {
+		case !content && strings.Contains(strings.ToLower(d.Name()), needle):
+			results = append(results, result)
+		case content && d.Type().IsRegular() && result.Size <= maxSearchFileSize:
+			if line, text, ok := searchFileContent(root, name, needle); ok {
+				result.Line, result.Text = line, text
+				results = append(results, result)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "name"
+	if content {
+		kind = "content"
+	}
+	s.audit(ctx, server, "file.search", "info", fmt.Sprintf("Files of server '%s' were searched by %s for %q", server.Name, kind, query))
+	return results, nil
+}
+
+// searchFileContent returns the first line of a text file containing needle, which must be lower case.
+func searchFileContent(root *sandbox.Root, name, needle string) (int, string, bool) {
+	f, err := root.Open(name)
+	if err != nil {
+		return 0, "", false
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(binaryDetectionLength); bytes.IndexByte(head, 0) >= 0 {
+		return 0, "", false
+	}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.Contains(strings.ToLower(text), needle) {
+			text = strings.TrimSpace(text)
+			if len(text) > maxSearchLineLength {
+				text = text[:maxSearchLineLength] + "…"
+			}
+			return line, text, true
+		}
+	}
+	return 0, "", false
+}
+
+// ListFileVersions lists the kept previous versions of a file, newest first.
+func (s *FileService) ListFileVersions(ctx context.Context, serverID, name string) ([]models.FileVersion, error) {
+	if _, err := s.serverService.GetServerByID(ctx, serverID); err != nil {
+		return nil, err
+	}
+	return s.history.ListVersions(ctx, serverID, name)
+}
+
+// ReadFileVersion returns a previous version of a file along with its content.
+func (s *FileService) ReadFileVersion(ctx context.Context, serverID, versionID string) (models.FileVersion, []byte, error) {
+	version, err := s.history.GetVersion(ctx, serverID, versionID)
+	if err != nil {
+		return models.FileVersion{}, nil, err
+	}
+	data, err := s.history.ReadVersion(ctx, version)
+	if err != nil {
+		return models.FileVersion{}, nil, err
+	}
+	return version, data, nil
+}
+
+// DiffFileVersion returns the unified diff from a previous version of a file to a later version of the same
+// file, or to the file as it is now if to is empty.
+func (s *FileService) DiffFileVersion(ctx context.Context, serverID, versionID, to string) (string, error) {
+	_, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+
+	from, fromData, err := s.ReadFileVersion(ctx, serverID, versionID)
+	if err != nil {
+		return "", err
+	}
+	var toLabel string
+	var toData []byte
+	if to != "" {
+		var version models.FileVersion
+		version, toData, err = s.ReadFileVersion(ctx, serverID, to)
+		if err != nil {
+			return "", err
+		}
+		if version.Path != from.Path {
+			return "", fmt.Errorf("%w: the versions are of different files", ErrInvalidFileOperation)
+		}
+		toLabel = versionLabel(version)
+	} else {
+		toData, err = root.ReadFile(from.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		toLabel = from.Path + "\t(current)"
+	}
+
+	if isBinary(fromData) || isBinary(toData) {
+		if bytes.Equal(fromData, toData) {
+			return "", nil
+		}
+		return fmt.Sprintf("Binary files %s and %s differ\n", versionLabel(from), toLabel), nil
+	}
+	return diff.Unified(versionLabel(from), toLabel, fromData, toData), nil
+}
+
+// RevertFile restores a previous version of a file. The content it replaces is kept as a version in turn,
+// so a revert can be undone.
+func (s *FileService) RevertFile(ctx context.Context, serverID, versionID string) (models.FileInfo, error) {
+	server, root, err := s.openServer(ctx, serverID)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	defer root.Close()
+
+	version, data, err := s.ReadFileVersion(ctx, serverID, versionID)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	if err := root.MkdirAll(path.Dir(version.Path), 0755); err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not create directory: %w", err)
+	}
+	if err := s.history.Snapshot(ctx, server.ID, root, version.Path); err != nil {
+		return models.FileInfo{}, err
+	}
+	if err := root.WriteFile(version.Path, data, 0644); err != nil {
+		return models.FileInfo{}, fmt.Errorf("could not revert file: %w", err)
+	}
+
+	msg := fmt.Sprintf("'%s' on server '%s' was reverted to its version from %s", version.Path, server.Name, version.CreatedAt.Format(time.RFC3339))
+	s.audit(ctx, server, "file.revert", "warn", msg)
+	return statFileInfo(root, version.Path)
+}
+
+// versionLabel names a version in diffs by its path and when it was replaced.
+func versionLabel(version models.FileVersion) string {
+	return version.Path + "\t" + version.CreatedAt.Format(time.RFC3339)
+}
+
+// isBinary reports whether data looks like a binary file rather than text.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binaryDetectionLength)], 0) >= 0
+}
+
+// audit records a file operation as an event, naming the user who made it if known.
+func (s *FileService) audit(ctx context.Context, server models.Server, eventType, level, msg string) {
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		msg += " by " + claims.Username
+	}
+	s.eventService.CreateEvent(ctx, eventType, level, msg+".", &server.ID)
+}
+
+// openDataDir opens the data directory of a server as a sandbox. All access to a server's files goes through
+// one, so neither paths from users and archives nor symlinks placed by the server can reach the host.
+func openDataDir(server models.Server) (*sandbox.Root, error) {
+	root, err := sandbox.Open(server.DataPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open server directory: %w", err)
+	}
+	return root, nil
+}
+
+// checkReplaceable checks that a file may be written at name: it must not exist unless overwrite is set, and
+// must not be a directory.
+func checkReplaceable(root *sandbox.Root, name string, overwrite bool) error {
+	if name == "." {
+		return fmt.Errorf("%w: a file name is required", ErrInvalidFileOperation)
+	}
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrFileExists, name)
+	}
+	if !overwrite {
+		return fmt.Errorf("%w: %s", ErrFileExists, name)
+	}
+	return nil
+}
+
+// zipEntryName returns the name of an archive entry with forward slashes, and whether it stays inside the
+// directory it is extracted to.
+func zipEntryName(f *zip.File) (string, bool) {
+	name := strings.ReplaceAll(f.Name, "\\", "/")
+	return name, filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// extractZipEntry writes an archive entry to name in root, creating its directory. Entries that are neither
+// files nor directories are skipped, and a symlink already at name is replaced rather than written through.
+// track, if not nil, wraps the writer the entry is copied to, such as to report progress.
+func extractZipEntry(root *sandbox.Root, f *zip.File, name string, track func(io.Writer) io.Writer) error {
+	if f.FileInfo().IsDir() {
+		return root.MkdirAll(name, 0755)
+	}
+	if !f.Mode().IsRegular() {
+		return nil
+	}
+	if err := root.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	if info, err := root.Lstat(name); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := root.Remove(name); err != nil {
+			return err
+		}
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// Entries keep their permissions, such as the execute bit of start scripts, but stay writable.
+	perm := f.Mode().Perm() | 0600
+	if f.Mode().Perm() == 0 {
+		perm = 0644
+	}
+	out, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if track != nil {
+		w = track(out)
+	}
+	_, err = io.Copy(w, rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// statFileInfo describes a file for the file manager.
+func statFileInfo(root *sandbox.Root, name string) (models.FileInfo, error) {
+	info, err := root.Stat(name)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	return fileInfo(info), nil
+}
+
+func fileInfo(info os.FileInfo) models.FileInfo {
+	return models.FileInfo{
+		Name:     info.Name(),
+		Size:     info.Size(),
+		IsDir:    info.IsDir(),
+		Mode:     fmt.Sprintf("%04o", info.Mode().Perm()),
+		Modified: info.ModTime(),
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"database/sql"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+)
+
+// gameMetricsColumns lists the servers table columns holding the latest game metrics sample,
+// in the order gameMetricsRow.dest expects them.
+const gameMetricsColumns = "tps, mspt, heap_used_mb, heap_max_mb, gc_count, gc_time_ms, metrics_source"
+
+// gameMetricsRow is the nullable DB representation of models.GameMetrics.
+type gameMetricsRow struct {
+	tps, mspt, heapUsedMB, heapMaxMB sql.NullFloat64
+	gcCount, gcTimeMs                sql.NullInt64
+	source                           sql.NullString
+}
+
+// newGameMetricsRow converts a metrics sample into nullable values ready for an INSERT or UPDATE.
+func newGameMetricsRow(m *models.GameMetrics) gameMetricsRow {
+	var row gameMetricsRow
+	if m == nil {
+		return row
+	}
+	if m.TPS != nil {
+		row.tps = sql.NullFloat64{Float64: *m.TPS, Valid: true}
+	}
+	if m.MSPT != nil {
+		row.mspt = sql.NullFloat64{Float64: *m.MSPT, Valid: true}
+	}
+	if m.HeapUsedMB != nil {
+		row.heapUsedMB = sql.NullFloat64{Float64: *m.HeapUsedMB, Valid: true}
+	}
+	if m.HeapMaxMB != nil {
+		row.heapMaxMB = sql.NullFloat64{Float64: *m.HeapMaxMB, Valid: true}
+	}
+	if m.GCCount != nil {
+		row.gcCount = sql.NullInt64{Int64: *m.GCCount, Valid: true}
+	}
+	if m.GCTimeMs != nil {
+		row.gcTimeMs = sql.NullInt64{Int64: *m.GCTimeMs, Valid: true}
+	}
+	row.source = sql.NullString{String: m.Source, Valid: true}
+	return row
+}
+
+// dest returns scan destinations matching gameMetricsColumns.
+func (r *gameMetricsRow) dest() []interface{} {
+	return []interface{}{&r.tps, &r.mspt, &r.heapUsedMB, &r.heapMaxMB, &r.gcCount, &r.gcTimeMs, &r.source}
+}
+
+// toModel converts the scanned row back into a GameMetrics, or nil if nothing was ever sampled.
+func (r *gameMetricsRow) toModel() *models.GameMetrics {
+	if !r.source.Valid {
+		return nil
+	}
+	m := &models.GameMetrics{Source: r.source.String}
+	if r.tps.Valid {
+		m.TPS = &r.tps.Float64
+	}
+	if r.mspt.Valid {
+		m.MSPT = &r.mspt.Float64
+	}
+	if r.heapUsedMB.Valid {
+		m.HeapUsedMB = &r.heapUsedMB.Float64
+	}
+	if r.heapMaxMB.Valid {
+		m.HeapMaxMB = &r.heapMaxMB.Float64
+	}
+	if r.gcCount.Valid {
+		m.GCCount = &r.gcCount.Int64
+	}
+	if r.gcTimeMs.Valid {
+		m.GCTimeMs = &r.gcTimeMs.Int64
+	}
+	return m
+}
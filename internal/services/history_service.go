@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+)
+
+// HistoryServiceProvider defines the interface for resource history services.
+type HistoryServiceProvider interface {
+	GetServerHistory(ctx context.Context, serverID string, query models.HistoryQuery) ([]models.HistoryBucket, error)
+	CompactHistory(ctx context.Context, now time.Time) error
+}
+
+// HistoryService stores resource history in tiers of decreasing resolution.
+// Raw samples live in resource_history; 1-minute and 1-hour rollups live in resource_rollups.
+type HistoryService struct {
+	db *sql.DB
+}
+
+// NewHistoryService creates a new HistoryService.
+func NewHistoryService(db *sql.DB) *HistoryService {
+	return &HistoryService{db: db}
+}
+
+// historyTier is one storage resolution of resource history.
+type historyTier struct {
+	resolution int64 // Bucket width in seconds, 0 for raw samples
+	retention  time.Duration
+}
+
+// historyTiers are ordered from finest to coarsest. Each tier is rolled up into the next one.
+var historyTiers = []historyTier{
+	{resolution: 0, retention: 24 * time.Hour},
+	{resolution: 60, retention: 7 * 24 * time.Hour},
+	{resolution: 3600, retention: 365 * 24 * time.Hour},
+}
+
+// historyMetric maps a rollup column prefix to its raw resource_history column.
+type historyMetric struct {
+	prefix string
+	column string
+}
+
+var historyMetrics = []historyMetric{
+	{"cpu", "cpu_usage"},
+	{"ram", "ram_usage"},
+	{"players", "players_current"},
+	{"tps", "tps"},
+	{"mspt", "mspt"},
+	{"heap_used", "heap_used_mb"},
+}
+
+// sqliteTimeFormat matches how SQLite's CURRENT_TIMESTAMP stores resource_history timestamps.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+func sqliteTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(sqliteTimeFormat)
+}
+
+// GetServerHistory returns min/avg/max buckets for a server, reading from whichever tiers cover the range.
+func (s *HistoryService) GetServerHistory(ctx context.Context, serverID string, query models.HistoryQuery) ([]models.HistoryBucket, error) {
+	step := int64(query.Step / time.Second)
+	if step < 1 {
+		return nil, fmt.Errorf("step must be at least one second")
+	}
+	from, to := query.From.Unix(), query.To.Unix()
+	if from >= to {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	source, args, err := s.historySource(ctx, serverID, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+	if source == "" {
+		return []models.HistoryBucket{}, nil
+	}
+
+	q := fmt.Sprintf(`
+	SELECT (ts / ?) * ? AS bucket, SUM(samples), %s
+	FROM (%s)
+	GROUP BY bucket
+	ORDER BY bucket ASC`, aggregateColumns(), source)
+	rows, err := s.db.QueryContext(ctx, q, append([]interface{}{step, step}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.HistoryBucket{}
+	for rows.Next() {
+		var bucketStart, samples int64
+		stats := make([]sql.NullFloat64, len(historyMetrics)*4)
+		dest := []interface{}{&bucketStart, &samples}
+		for i := range stats {
+			dest = append(dest, &stats[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		bucket := models.HistoryBucket{Timestamp: time.Unix(bucketStart, 0).UTC(), Samples: samples}
+		metrics := []**models.MetricStats{&bucket.CPU, &bucket.RAM, &bucket.Players, &bucket.TPS, &bucket.MSPT, &bucket.HeapUsedMB}
+		for i, target := range metrics {
+			*target = metricStats(stats[i*4], stats[i*4+1], stats[i*4+2], stats[i*4+3])
+		}
+		history = append(history, bucket)
+	}
+	return history, rows.Err()
+}
+
+// metricStats builds the min/avg/max of a metric from its aggregated min, max, sum and count.
+func metricStats(min, max, sum, count sql.NullFloat64) *models.MetricStats {
+	if !min.Valid || !max.Valid || !sum.Valid || count.Float64 <= 0 {
+		return nil
+	}
+	return &models.MetricStats{Min: min.Float64, Avg: sum.Float64 / count.Float64, Max: max.Float64}
+}
+
+// historySource plans which tier serves which part of [from, to) and returns a UNION ALL
+// of the matching selects. The coarsest tier that still resolves step is preferred; finer tiers
+// fill in data that hasn't been rolled up yet and coarser tiers fill in data that has expired.
+func (s *HistoryService) historySource(ctx context.Context, serverID string, from, to, step int64) (string, []interface{}, error) {
+	type span struct {
+		tier       historyTier
+		start, end int64
+	}
+
+	var spans []span
+	for _, tier := range historyTiers {
+		start, end, ok, err := s.tierBounds(ctx, tier, serverID)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			continue
+		}
+		if tier.resolution == 0 {
+			end = to // Raw samples keep arriving, so the raw tier is never "behind"
+		}
+		spans = append(spans, span{tier: tier, start: start, end: end})
+	}
+	if len(spans) == 0 {
+		return "", nil, nil
+	}
+
+	chosen := 0
+	for i, sp := range spans {
+		if sp.tier.resolution <= step {
+			chosen = i
+		}
+	}
+
+	var selects []string
+	var args []interface{}
+	add := func(tier historyTier, lo, hi int64) {
+		if lo < from {
+			lo = from
+		}
+		if hi > to {
+			hi = to
+		}
+		if lo >= hi {
+			return
+		}
+		q, a := tierSelect(tier, serverID, lo, hi)
+		selects = append(selects, q)
+		args = append(args, a...)
+	}
+
+	add(spans[chosen].tier, spans[chosen].start, spans[chosen].end)
+	// Coarser tiers cover what the chosen tier has already expired.
+	upper := spans[chosen].start
+	for i := chosen + 1; i < len(spans); i++ {
+		add(spans[i].tier, spans[i].start, upper)
+		upper = spans[i].start
+	}
+	// Finer tiers cover what the chosen tier hasn't rolled up yet.
+	lower := spans[chosen].end
+	for i := chosen - 1; i >= 0; i-- {
+		add(spans[i].tier, lower, spans[i].end)
+		lower = spans[i].end
+	}
+
+	return strings.Join(selects, " UNION ALL "), args, nil
+}
+
+// tierBounds returns the first and one-past-last timestamp stored in a tier.
+func (s *HistoryService) tierBounds(ctx context.Context, tier historyTier, serverID string) (int64, int64, bool, error) {
+	var start, end sql.NullInt64
+	var err error
+	if tier.resolution == 0 {
+		err = s.db.QueryRowContext(ctx, `
+		SELECT CAST(strftime('%s', MIN(timestamp)) AS INTEGER), CAST(strftime('%s', MAX(timestamp)) AS INTEGER)
+		FROM resource_history WHERE server_id = ?`, serverID).Scan(&start, &end)
+	} else {
+		err = s.db.QueryRowContext(ctx, `
+		SELECT MIN(bucket_start), MAX(bucket_start)
+		FROM resource_rollups WHERE server_id = ? AND resolution = ?`, serverID, tier.resolution).Scan(&start, &end)
+	}
+	if err != nil || !start.Valid || !end.Valid {
+		return 0, 0, false, err
+	}
+	if tier.resolution == 0 {
+		return start.Int64, end.Int64 + 1, true, nil
+	}
+	return start.Int64, end.Int64 + tier.resolution, true, nil
+}
+
+// tierSelect returns a SELECT over one tier within [lo, hi) that exposes the common rollup shape:
+// server_id, ts, samples and <metric>_min/_max/_sum/_count for every history metric.
+// An empty serverID selects every server.
+func tierSelect(tier historyTier, serverID string, lo, hi int64) (string, []interface{}) {
+	var cols []string
+	var q string
+	var args []interface{}
+
+	if tier.resolution == 0 {
+		for _, m := range historyMetrics {
+			cols = append(cols, fmt.Sprintf("%[1]s AS %[2]s_min, %[1]s AS %[2]s_max, %[1]s AS %[2]s_sum, (%[1]s IS NOT NULL) AS %[2]s_count", m.column, m.prefix))
+		}
+		q = fmt.Sprintf(`SELECT server_id, CAST(strftime('%%s', timestamp) AS INTEGER) AS ts, 1 AS samples, %s
+		FROM resource_history WHERE timestamp >= ? AND timestamp < ?`, strings.Join(cols, ", "))
+		args = []interface{}{sqliteTime(lo), sqliteTime(hi)}
+	} else {
+		for _, m := range historyMetrics {
+			cols = append(cols, fmt.Sprintf("%[1]s_min, %[1]s_max, %[1]s_sum, %[1]s_count", m.prefix))
+		}
+		q = fmt.Sprintf(`SELECT server_id, bucket_start AS ts, samples, %s
+		FROM resource_rollups WHERE resolution = ? AND bucket_start >= ? AND bucket_start < ?`, strings.Join(cols, ", "))
+		args = []interface{}{tier.resolution, lo, hi}
+	}
+
+	if serverID != "" {
+		q += " AND server_id = ?"
+		args = append(args, serverID)
+	}
+	return q, args
+}
+
+// aggregateColumns merges the rollup columns of several rows into one.
+func aggregateColumns() string {
+	var cols []string
+	for _, m := range historyMetrics {
+		cols = append(cols, fmt.Sprintf("MIN(%[1]s_min), MAX(%[1]s_max), SUM(%[1]s_sum), SUM(%[1]s_count)", m.prefix))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// CompactHistory rolls each tier up into the next coarser one and prunes data past its retention.
+// It is idempotent, so running it again over the same period rewrites the same buckets.
+func (s *HistoryService) CompactHistory(ctx context.Context, now time.Time) error {
+	for i := 1; i < len(historyTiers); i++ {
+		if err := s.rollUp(ctx, historyTiers[i-1], historyTiers[i], now); err != nil {
+			return fmt.Errorf("failed to roll up history into %ds buckets: %w", historyTiers[i].resolution, err)
+		}
+	}
+
+	for _, tier := range historyTiers {
+		cutoff := now.Add(-tier.retention).Unix()
+		var err error
+		if tier.resolution == 0 {
+			_, err = s.db.ExecContext(ctx, "DELETE FROM resource_history WHERE timestamp < ?", sqliteTime(cutoff))
+		} else {
+			_, err = s.db.ExecContext(ctx, "DELETE FROM resource_rollups WHERE resolution = ? AND bucket_start < ?", tier.resolution, cutoff)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to prune history: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollUp aggregates complete buckets of src into dst, starting from the last bucket dst already has.
+func (s *HistoryService) rollUp(ctx context.Context, src, dst historyTier, now time.Time) error {
+	var watermark sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, "SELECT MAX(bucket_start) FROM resource_rollups WHERE resolution = ?", dst.resolution).Scan(&watermark); err != nil {
+		return err
+	}
+	lo := watermark.Int64 // Re-aggregate the last bucket in case it was written before it was complete
+	hi := (now.Unix() / dst.resolution) * dst.resolution
+	if lo >= hi {
+		return nil
+	}
+
+	var cols []string
+	for _, m := range historyMetrics {
+		cols = append(cols, fmt.Sprintf("%[1]s_min, %[1]s_max, %[1]s_sum, %[1]s_count", m.prefix))
+	}
+
+	source, args := tierSelect(src, "", lo, hi)
+	q := fmt.Sprintf(`
+	INSERT OR REPLACE INTO resource_rollups (server_id, resolution, bucket_start, samples, %s)
+	SELECT server_id, ?, (ts / ?) * ? AS bucket, SUM(samples), %s
+	FROM (%s)
+	GROUP BY server_id, bucket`, strings.Join(cols, ", "), aggregateColumns(), source)
+	_, err := s.db.ExecContext(ctx, q, append([]interface{}{dst.resolution, dst.resolution, dst.resolution}, args...)...)
+	return err
+}
+
+// queryAggregateHistory sums the per-server averages of every server into step-wide buckets.
+// Summing raw samples would overcount servers that reported more often within a bucket.
+func queryAggregateHistory(ctx context.Context, db *sql.DB, from, to time.Time, step time.Duration) ([]models.ResourceDataPoint, error) {
+	stepSecs := int64(step / time.Second)
+	source, args := tierSelect(historyTiers[0], "", from.Unix(), to.Unix())
+	q := fmt.Sprintf(`
+	SELECT bucket, SUM(cpu), SUM(ram), SUM(players)
+	FROM (
+		SELECT server_id, (ts / ?) * ? AS bucket,
+		       SUM(cpu_sum) / SUM(cpu_count) AS cpu,
+		       SUM(ram_sum) / SUM(ram_count) AS ram,
+		       ROUND(SUM(players_sum) * 1.0 / SUM(players_count)) AS players
+		FROM (%s)
+		GROUP BY server_id, bucket
+	)
+	GROUP BY bucket
+	ORDER BY bucket ASC`, source)
+	rows, err := db.QueryContext(ctx, q, append([]interface{}{stepSecs, stepSecs}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.ResourceDataPoint
+	for rows.Next() {
+		var bucket int64
+		var cpu, ram, players sql.NullFloat64
+		if err := rows.Scan(&bucket, &cpu, &ram, &players); err != nil {
+			return nil, err
+		}
+		history = append(history, models.ResourceDataPoint{
+			Timestamp:      time.Unix(bucket, 0).UTC(),
+			CPUUsage:       cpu.Float64,
+			RAMUsage:       ram.Float64,
+			PlayersCurrent: sql.NullInt64{Int64: int64(players.Float64), Valid: players.Valid},
+		})
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,501 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"github.com/isdelr/ender-deploy-be/internal/websocket"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// JobHandler executes one job and returns the value stored as its result.
+// Handlers must stop when ctx is cancelled and must be safe to run again from the start,
+// since jobs interrupted by a shutdown or crash are resumed by running them again.
+type JobHandler func(ctx context.Context, job models.Job) (interface{}, error)
+
+// JobCleanup releases what a job holds on to when it is cancelled or fails without its handler running.
+type JobCleanup func(job models.Job)
+
+var (
+	// ErrJobInterrupted is the cancellation cause of jobs stopped by a shutdown. They are queued
+	// again and resumed the next time the workers start.
+	ErrJobInterrupted = errors.New("job interrupted by shutdown")
+	// ErrJobNotFound is returned for unknown job IDs.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when cancelling a job that has already finished.
+	ErrJobFinished = errors.New("job has already finished")
+
+	errJobCancelled = errors.New("job cancelled")
+)
+
+const (
+	// maxJobAttempts bounds how often an interrupted job is resumed before it is given up on.
+	maxJobAttempts = 3
+	// jobProgressInterval throttles how often progress is persisted and broadcast.
+	jobProgressInterval = 500 * time.Millisecond
+	// jobPollInterval is how often idle workers look for queued jobs they weren't woken for.
+	jobPollInterval = 5 * time.Second
+)
+
+const jobColumns = "id, type, status, server_id, payload, result, error, progress_stage, progress_current, progress_total, progress_unit, attempts, created_at, started_at, finished_at"
+
+// JobServiceProvider defines the interface for job services.
+type JobServiceProvider interface {
+	EnqueueJob(ctx context.Context, jobType string, serverID *string, payload interface{}) (models.Job, error)
+	GetJobByID(ctx context.Context, id string) (models.Job, error)
+	GetJobs(ctx context.Context, serverID string, limit int) ([]models.Job, error)
+	CancelJob(ctx context.Context, id string) (models.Job, error)
+}
+
+// JobService persists jobs and runs them on a pool of workers.
+// Jobs for the same server run one at a time, in the order they were queued.
+type JobService struct {
+	db       *sql.DB
+	hub      *websocket.Hub
+	workers  int
+	handlers map[string]JobHandler
+	cleanups map[string]JobCleanup
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex // Serializes claiming jobs and guards running
+	running map[string]context.CancelCauseFunc
+}
+
+// NewJobService creates a new JobService with the given number of workers.
+func NewJobService(db *sql.DB, hub *websocket.Hub, workers int) *JobService {
+	if workers < 1 {
+		workers = 1
+	}
+	return &JobService{
+		db:       db,
+		hub:      hub,
+		workers:  workers,
+		handlers: make(map[string]JobHandler),
+		cleanups: make(map[string]JobCleanup),
+		wake:     make(chan struct{}, workers),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		running:  make(map[string]context.CancelCauseFunc),
+	}
+}
+
+// RegisterHandler sets the handler for a job type. It must be called before Run.
+func (s *JobService) RegisterHandler(jobType string, handler JobHandler) {
+	s.handlers[jobType] = handler
+}
+
+// RegisterCleanup sets what runs for a job of the type that is cancelled while queued or given up on before
+// its handler runs. It must be called before Run.
+func (s *JobService) RegisterCleanup(jobType string, cleanup JobCleanup) {
+	s.cleanups[jobType] = cleanup
+}
+
+// Run resumes jobs interrupted by the previous shutdown and starts the workers.
+// It blocks until Stop is called and every worker has returned.
+func (s *JobService) Run() {
+	log.Info().Int("workers", s.workers).Msg("Starting job workers...")
+	defer close(s.done)
+
+	res, err := s.db.Exec("UPDATE jobs SET status = ? WHERE status = ?", models.JobQueued, models.JobRunning)
+	if err != nil {
+		log.Error().Err(err).Msg("JobService: Failed to requeue interrupted jobs")
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Info().Int64("jobs", n).Msg("JobService: Resuming jobs interrupted by the last shutdown")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	wg.Wait()
+	log.Info().Msg("Stopped job workers.")
+}
+
+// Stop interrupts running jobs, which are resumed on the next start, and waits for the workers to exit.
+func (s *JobService) Stop() {
+	close(s.stop)
+
+	s.mu.Lock()
+	for _, cancel := range s.running {
+		cancel(ErrJobInterrupted)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		log.Warn().Msg("JobService: Timed out waiting for jobs to stop; they will be resumed on the next start.")
+	}
+}
+
+// EnqueueJob persists a new job and wakes a worker to run it.
+func (s *JobService) EnqueueJob(ctx context.Context, jobType string, serverID *string, payload interface{}) (models.Job, error) {
+	if _, ok := s.handlers[jobType]; !ok {
+		return models.Job{}, fmt.Errorf("unknown job type %q", jobType)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	id := uuid.New().String()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO jobs (id, type, status, server_id, payload, trace_parent) VALUES (?, ?, ?, ?, ?, ?)",
+		id, jobType, models.JobQueued, serverID, string(payloadJSON), tracing.Traceparent(ctx))
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	job, err := s.GetJobByID(ctx, id)
+	if err != nil {
+		return models.Job{}, err
+	}
+	s.broadcastJobUpdate(job)
+	s.signal()
+	return job, nil
+}
+
+// GetJobByID retrieves a single job by its ID.
+func (s *JobService) GetJobByID(ctx context.Context, id string) (models.Job, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", id)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return models.Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job, err
+}
+
+// GetJobs retrieves the most recent jobs, optionally only those of one server.
+func (s *JobService) GetJobs(ctx context.Context, serverID string, limit int) ([]models.Job, error) {
+	query := "SELECT " + jobColumns + " FROM jobs"
+	var args []interface{}
+	if serverID != "" {
+		query += " WHERE server_id = ?"
+		args = append(args, serverID)
+	}
+	query += " ORDER BY created_at DESC, rowid DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CancelJob cancels a queued job, or asks a running job to stop.
+// A running job is marked cancelled by its worker once its handler has returned.
+func (s *JobService) CancelJob(ctx context.Context, id string) (models.Job, error) {
+	s.mu.Lock()
+	if cancel, ok := s.running[id]; ok {
+		cancel(errJobCancelled)
+		s.mu.Unlock()
+		return s.GetJobByID(ctx, id)
+	}
+	res, err := s.db.ExecContext(ctx, "UPDATE jobs SET status = ?, finished_at = ? WHERE id = ? AND status = ?",
+		models.JobCancelled, time.Now().UTC(), id, models.JobQueued)
+	s.mu.Unlock()
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	job, err := s.GetJobByID(ctx, id)
+	if err != nil {
+		return models.Job{}, err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 && job.Finished() {
+		return job, ErrJobFinished
+	}
+	if n > 0 {
+		if cleanup := s.cleanups[job.Type]; cleanup != nil {
+			cleanup(job)
+		}
+	}
+	s.broadcastJobUpdate(job)
+	return job, nil
+}
+
+// signal wakes an idle worker without blocking.
+func (s *JobService) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs queued jobs until Stop is called.
+func (s *JobService) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+
+			job, traceParent, ctx, ok := s.claimNext()
+			if !ok {
+				break
+			}
+			s.execute(ctx, job, traceParent)
+			// Finishing a job may unblock the next job queued for the same server.
+			s.signal()
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimNext marks the oldest runnable job as running and returns it with a context that
+// CancelJob and Stop can cancel. A job is runnable if no other job of its server is running.
+func (s *JobService) claimNext() (models.Job, string, context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id string
+	var traceParent sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, trace_parent FROM jobs
+		WHERE status = ? AND (server_id IS NULL OR server_id NOT IN (
+			SELECT server_id FROM jobs WHERE status = ? AND server_id IS NOT NULL))
+		ORDER BY created_at ASC, rowid ASC
+		LIMIT 1`, models.JobQueued, models.JobRunning).Scan(&id, &traceParent)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("JobService: Failed to look for queued jobs")
+		}
+		return models.Job{}, "", nil, false
+	}
+
+	_, err = s.db.Exec("UPDATE jobs SET status = ?, attempts = attempts + 1, started_at = ?, error = NULL WHERE id = ?",
+		models.JobRunning, time.Now().UTC(), id)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", id).Msg("JobService: Failed to claim job")
+		return models.Job{}, "", nil, false
+	}
+	job, err := s.GetJobByID(context.Background(), id)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", id).Msg("JobService: Failed to load claimed job")
+		return models.Job{}, "", nil, false
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s.running[id] = cancel
+	return job, traceParent.String, ctx, true
+}
+
+// execute runs a claimed job and records how it ended.
+func (s *JobService) execute(ctx context.Context, job models.Job, traceParent string) {
+	defer func() {
+		s.mu.Lock()
+		if cancel, ok := s.running[job.ID]; ok {
+			cancel(nil)
+			delete(s.running, job.ID)
+		}
+		s.mu.Unlock()
+	}()
+
+	handler := s.handlers[job.Type]
+	if handler == nil {
+		s.finishJob(job, models.JobFailed, nil, fmt.Errorf("no handler registered for job type %q", job.Type))
+		return
+	}
+	if job.Attempts > maxJobAttempts {
+		if cleanup := s.cleanups[job.Type]; cleanup != nil {
+			cleanup(job)
+		}
+		s.finishJob(job, models.JobFailed, nil, fmt.Errorf("gave up after %d interrupted attempts", maxJobAttempts))
+		return
+	}
+
+	// Continue the trace of the request that queued the job, even across restarts.
+	ctx = tracing.WithTraceparent(ctx, traceParent)
+	ctx, span := tracing.Start(ctx, "job."+job.Type, attribute.String("job.id", job.ID), attribute.Int("job.attempt", job.Attempts))
+
+	tracker := &jobProgressTracker{service: s, job: job}
+	ctx = WithProgress(ctx, tracker.report)
+
+	log.Info().Ctx(ctx).Str("job_id", job.ID).Str("job_type", job.Type).Int("attempt", job.Attempts).Msg("Job started")
+	s.broadcastJobUpdate(job)
+
+	result, err := runJobHandler(ctx, handler, job)
+	tracing.End(span, err)
+	job = tracker.snapshot()
+
+	switch {
+	case err == nil:
+		s.finishJob(job, models.JobSucceeded, result, nil)
+	case errors.Is(context.Cause(ctx), ErrJobInterrupted):
+		log.Info().Ctx(ctx).Str("job_id", job.ID).Msg("Job interrupted by shutdown; it will be resumed on the next start")
+		if _, err := s.db.Exec("UPDATE jobs SET status = ? WHERE id = ?", models.JobQueued, job.ID); err != nil {
+			log.Error().Err(err).Str("job_id", job.ID).Msg("JobService: Failed to requeue interrupted job")
+		}
+	case errors.Is(context.Cause(ctx), errJobCancelled):
+		s.finishJob(job, models.JobCancelled, nil, nil)
+	default:
+		log.Error().Ctx(ctx).Err(err).Str("job_id", job.ID).Str("job_type", job.Type).Msg("Job failed")
+		s.finishJob(job, models.JobFailed, nil, err)
+	}
+}
+
+// runJobHandler calls handler, turning a panic into an error so it can't take down the worker.
+func runJobHandler(ctx context.Context, handler JobHandler, job models.Job) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// finishJob records the final status of a job and broadcasts it.
+func (s *JobService) finishJob(job models.Job, status string, result interface{}, jobErr error) {
+	var resultJSON, errMsg sql.NullString
+	if result != nil {
+		if b, err := json.Marshal(result); err == nil {
+			resultJSON = sql.NullString{String: string(b), Valid: true}
+		}
+	}
+	if jobErr != nil {
+		errMsg = sql.NullString{String: jobErr.Error(), Valid: true}
+	}
+
+	now := time.Now().UTC()
+	_, err := s.db.Exec(`
+		UPDATE jobs SET status = ?, result = ?, error = ?, finished_at = ?,
+			progress_stage = ?, progress_current = ?, progress_total = ?, progress_unit = ?
+		WHERE id = ?`,
+		status, resultJSON, errMsg, now,
+		job.Progress.Stage, job.Progress.Current, job.Progress.Total, job.Progress.Unit, job.ID)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", job.ID).Msg("JobService: Failed to record job result")
+		return
+	}
+
+	job.Status = status
+	job.Error = errMsg.String
+	job.FinishedAt = &now
+	if resultJSON.Valid {
+		job.Result = json.RawMessage(resultJSON.String)
+	}
+	s.broadcastJobUpdate(job)
+}
+
+// broadcastJobUpdate sends the current state of a job to all websocket clients.
+func (s *JobService) broadcastJobUpdate(job models.Job) {
+	msg := websocket.Message{
+		Action:  "job_update",
+		Payload: job,
+	}
+	jsonMsg, err := json.Marshal(msg)
+	if err != nil {
+		log.Error().Err(err).Msg("Error marshalling job update for broadcast")
+		return
+	}
+	s.hub.Broadcast <- jsonMsg
+	s.hub.Publish(websocket.JobTopic(job.ID), "job_update", job)
+}
+
+// jobProgressTracker persists and broadcasts a running job's progress, at most every
+// jobProgressInterval unless the stage changes.
+type jobProgressTracker struct {
+	service   *JobService
+	mu        sync.Mutex
+	job       models.Job
+	lastFlush time.Time
+}
+
+func (t *jobProgressTracker) report(stage string, current, total int64, unit string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stageChanged := stage != t.job.Progress.Stage
+	t.job.Progress = models.JobProgress{Stage: stage, Current: current, Total: total, Unit: unit}
+	if !stageChanged && time.Since(t.lastFlush) < jobProgressInterval {
+		return
+	}
+	t.lastFlush = time.Now()
+
+	_, err := t.service.db.Exec("UPDATE jobs SET progress_stage = ?, progress_current = ?, progress_total = ?, progress_unit = ? WHERE id = ?",
+		stage, current, total, unit, t.job.ID)
+	if err != nil {
+		log.Warn().Err(err).Str("job_id", t.job.ID).Msg("JobService: Failed to save job progress")
+	}
+	t.service.broadcastJobUpdate(t.job)
+}
+
+func (t *jobProgressTracker) snapshot() models.Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.job
+}
+
+// scanJob scans a single row into a Job struct.
+func scanJob(scanner interface{ Scan(...interface{}) error }) (models.Job, error) {
+	var job models.Job
+	var payload, result, jobErr, stage, unit sql.NullString
+	err := scanner.Scan(
+		&job.ID,
+		&job.Type,
+		&job.Status,
+		&job.ServerID,
+		&payload,
+		&result,
+		&jobErr,
+		&stage,
+		&job.Progress.Current,
+		&job.Progress.Total,
+		&unit,
+		&job.Attempts,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if payload.Valid {
+		job.Payload = json.RawMessage(payload.String)
+	}
+	if result.Valid {
+		job.Result = json.RawMessage(result.String)
+	}
+	job.Error = jobErr.String
+	job.Progress.Stage = stage.String
+	job.Progress.Unit = unit.String
+	return job, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/isdelr/ender-deploy-be/internal/database"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/websocket"
+)
+
+// newTestJobService returns a job service on a fresh database. Its workers aren't started, so queued jobs stay queued.
+func newTestJobService(t *testing.T) *JobService {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	hub := websocket.NewHub()
+	go hub.Run()
+	return NewJobService(db, hub, 1)
+}
+
+func TestCancelQueuedJobRunsCleanup(t *testing.T) {
+	s := newTestJobService(t)
+	s.RegisterHandler("test.spooled", func(ctx context.Context, job models.Job) (interface{}, error) {
+		t.Error("handler of a cancelled job ran")
+		return nil, nil
+	})
+	var cleaned []string
+	s.RegisterCleanup("test.spooled", func(job models.Job) {
+		cleaned = append(cleaned, job.ID)
+	})
+
+	ctx := context.Background()
+	job, err := s.EnqueueJob(ctx, "test.spooled", nil, map[string]string{"archive": "upload.zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, err := s.CancelJob(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != models.JobCancelled {
+		t.Errorf("CancelJob() status = %q, want %q", cancelled.Status, models.JobCancelled)
+	}
+	if len(cleaned) != 1 || cleaned[0] != job.ID {
+		t.Errorf("cleanup ran for %v, want once for %s", cleaned, job.ID)
+	}
+
+	// Cancelling again finds the job finished and must not clean up twice.
+	if _, err := s.CancelJob(ctx, job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("second CancelJob() = %v, want ErrJobFinished", err)
+	}
+	if len(cleaned) != 1 {
+		t.Errorf("cleanup ran %d times, want 1", len(cleaned))
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Job types for the long-running server, backup, template, runtime, world and file operations.
+const (
+	JobTypeCreateServer    = "server.create"
+	JobTypeUploadServer    = "server.upload"
+	JobTypeUpgradeServer   = "server.upgrade"
+	JobTypeChangeRuntime   = "server.runtime"
+	JobTypeCloneServer     = "server.clone"
+	JobTypeMoveServer      = "server.move"
+	JobTypeCreateBackup    = "backup.create"
+	JobTypeRestoreBackup   = "backup.restore"
+	JobTypeImportTemplate  = "template.import"
+	JobTypeInstallTemplate = "template.install"
+	JobTypeCaptureTemplate = "template.capture"
+	JobTypeRegisterRuntime = "runtime.register"
+	JobTypeImportWorld     = "world.import"
+	JobTypeResetWorld      = "world.reset"
+	JobTypeArchiveFiles    = "files.archive"
+	JobTypeExtractArchive  = "files.extract"
+)
+
+// CreateServerJobPayload is the payload of a server.create job.
+type CreateServerJobPayload struct {
+	Name            string `json:"name"`
+	TemplateID      string `json:"templateId"`
+	TemplateVersion int    `json:"templateVersion,omitempty"` // Latest when 0
+	RuntimeID       string `json:"runtimeId,omitempty"`
+}
+
+// UploadServerJobPayload is the payload of a server.upload job.
+type UploadServerJobPayload struct {
+	Name             string `json:"name"`
+	JavaVersion      string `json:"javaVersion"`
+	RuntimeID        string `json:"runtimeId,omitempty"`
+	ServerExecutable string `json:"serverExecutable"`
+	MaxMemoryMB      int    `json:"maxMemoryMB"`
+	Archive          string `json:"archive"` // File name of the spooled upload in the upload directory
+}
+
+// UpgradeServerJobPayload is the payload of a server.upgrade job.
+type UpgradeServerJobPayload struct {
+	Target UpgradeTarget `json:"target"`
+}
+
+// ChangeRuntimeJobPayload is the payload of a server.runtime job.
+type ChangeRuntimeJobPayload struct {
+	RuntimeID string `json:"runtimeId"`
+}
+
+// CloneServerJobPayload is the payload of a server.clone job.
+type CloneServerJobPayload struct {
+	Name          string `json:"name,omitempty"`
+	IncludeWorlds bool   `json:"includeWorlds"`
+	StorageRoot   string `json:"storageRoot,omitempty"`
+}
+
+// MoveServerJobPayload is the payload of a server.move job.
+type MoveServerJobPayload struct {
+	StorageRoot string `json:"storageRoot"`
+}
+
+// CreateBackupJobPayload is the payload of a backup.create job.
+type CreateBackupJobPayload struct {
+	Name string `json:"name"`
+}
+
+// RestoreBackupJobPayload is the payload of a backup.restore job.
+type RestoreBackupJobPayload struct {
+	BackupID string `json:"backupId"`
+}
+
+// ImportTemplateJobPayload is the payload of a template.import job.
+type ImportTemplateJobPayload struct {
+	TemplateID  string `json:"templateId"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	JavaVersion string `json:"javaVersion,omitempty"`
+	MaxMemoryMB int    `json:"maxMemoryMB"`
+	Archive     string `json:"archive"` // File name of the spooled modpack in the upload directory
+}
+
+// InstallTemplateJobPayload is the payload of a template.install job.
+type InstallTemplateJobPayload struct {
+	TemplateID       string `json:"templateId"`
+	Name             string `json:"name"`
+	Description      string `json:"description,omitempty"`
+	ServerType       string `json:"serverType"`
+	MinecraftVersion string `json:"minecraftVersion,omitempty"`
+	JavaVersion      string `json:"javaVersion,omitempty"`
+	MaxMemoryMB      int    `json:"maxMemoryMB"`
+}
+
+// CaptureTemplateJobPayload is the payload of a template.capture job, which saves a server as a template.
+type CaptureTemplateJobPayload struct {
+	TemplateID  string `json:"templateId"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// RegisterRuntimeJobPayload is the payload of a runtime.register job.
+type RegisterRuntimeJobPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image"`
+	JavaVersion int    `json:"javaVersion,omitempty"`
+}
+
+// ImportWorldJobPayload is the payload of a world.import job.
+type ImportWorldJobPayload struct {
+	Name    string `json:"name"`
+	Archive string `json:"archive"` // File name of the spooled world in the upload directory
+}
+
+// ResetWorldJobPayload is the payload of a world.reset job.
+type ResetWorldJobPayload struct {
+	Name     string `json:"name"`
+	KeepSeed bool   `json:"keepSeed"`
+	Seed     string `json:"seed,omitempty"`
+}
+
+// ArchiveFilesJobPayload is the payload of a files.archive job.
+type ArchiveFilesJobPayload struct {
+	Paths       []string `json:"paths"`
+	Destination string   `json:"destination"`
+	RequestedBy string   `json:"requestedBy,omitempty"` // Username recorded in the audit event
+}
+
+// ExtractArchiveJobPayload is the payload of a files.extract job.
+type ExtractArchiveJobPayload struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"`
+	RequestedBy string `json:"requestedBy,omitempty"` // Username recorded in the audit event
+}
+
+// RegisterServerJobs registers the handlers of the server and backup job types.
+// uploadPath is the directory uploads are spooled to until their server.upload job is done with them.
+func RegisterServerJobs(jobs *JobService, servers ServerServiceProvider, backups BackupServiceProvider, upgrades UpgradeServiceProvider, uploadPath string) {
+	jobs.RegisterHandler(JobTypeCreateServer, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p CreateServerJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		return servers.CreateServerFromTemplate(ctx, p.Name, p.TemplateID, p.TemplateVersion, p.RuntimeID)
+	})
+
+	jobs.RegisterHandler(JobTypeUploadServer, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p UploadServerJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		archivePath := filepath.Join(uploadPath, filepath.Base(p.Archive))
+		defer removeSpooledUpload(ctx, archivePath)
+
+		archive, err := os.Open(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("uploaded archive is no longer available: %w", err)
+		}
+		defer archive.Close()
+		return servers.CreateServerFromUpload(ctx, p.Name, p.JavaVersion, p.RuntimeID, p.ServerExecutable, p.MaxMemoryMB, archive)
+	})
+	jobs.RegisterCleanup(JobTypeUploadServer, spooledArchiveCleanup(uploadPath))
+
+	jobs.RegisterHandler(JobTypeUpgradeServer, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p UpgradeServerJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("upgrade job has no server")
+		}
+		return upgrades.UpgradeServer(ctx, *job.ServerID, p.Target)
+	})
+
+	jobs.RegisterHandler(JobTypeChangeRuntime, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ChangeRuntimeJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("runtime job has no server")
+		}
+		return servers.ChangeServerRuntime(ctx, *job.ServerID, p.RuntimeID)
+	})
+
+	jobs.RegisterHandler(JobTypeCloneServer, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p CloneServerJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("clone job has no server")
+		}
+		return servers.CloneServer(ctx, *job.ServerID, CloneServerOptions{Name: p.Name, IncludeWorlds: p.IncludeWorlds, StorageRoot: p.StorageRoot})
+	})
+
+	jobs.RegisterHandler(JobTypeMoveServer, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p MoveServerJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("move job has no server")
+		}
+		return servers.MoveServer(ctx, *job.ServerID, p.StorageRoot)
+	})
+
+	jobs.RegisterHandler(JobTypeCreateBackup, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p CreateBackupJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("backup job has no server")
+		}
+		return backups.CreateBackup(ctx, *job.ServerID, p.Name)
+	})
+
+	jobs.RegisterHandler(JobTypeRestoreBackup, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p RestoreBackupJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		return nil, backups.RestoreBackup(ctx, p.BackupID)
+	})
+}
+
+// RegisterTemplateJobs registers the handlers of the template job types.
+func RegisterTemplateJobs(jobs *JobService, templates TemplateServiceProvider, servers ServerServiceProvider, uploadPath string) {
+	jobs.RegisterHandler(JobTypeImportTemplate, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ImportTemplateJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		archivePath := filepath.Join(uploadPath, filepath.Base(p.Archive))
+		defer removeSpooledUpload(ctx, archivePath)
+
+		if _, err := os.Stat(archivePath); err != nil {
+			return nil, fmt.Errorf("uploaded modpack is no longer available: %w", err)
+		}
+		template := models.Template{
+			ID:          p.TemplateID,
+			Name:        p.Name,
+			Description: p.Description,
+			JavaVersion: p.JavaVersion,
+			MaxMemoryMB: p.MaxMemoryMB,
+		}
+		return templates.ImportTemplate(ctx, template, archivePath)
+	})
+	jobs.RegisterCleanup(JobTypeImportTemplate, spooledArchiveCleanup(uploadPath))
+
+	jobs.RegisterHandler(JobTypeInstallTemplate, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p InstallTemplateJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		return templates.InstallTemplate(ctx, models.Template{
+			ID:               p.TemplateID,
+			Name:             p.Name,
+			Description:      p.Description,
+			ServerType:       p.ServerType,
+			MinecraftVersion: p.MinecraftVersion,
+			JavaVersion:      p.JavaVersion,
+			MaxMemoryMB:      p.MaxMemoryMB,
+		})
+	})
+
+	jobs.RegisterHandler(JobTypeCaptureTemplate, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p CaptureTemplateJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("capture job has no server")
+		}
+		server, err := servers.GetServerByID(ctx, *job.ServerID)
+		if err != nil {
+			return nil, fmt.Errorf("could not find server: %w", err)
+		}
+		resume, err := saveWorld(ctx, servers, server)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save world via RCON: %w", err)
+		}
+		defer resume()
+		return templates.CreateTemplateFromServer(ctx, models.Template{
+			ID:          p.TemplateID,
+			Name:        p.Name,
+			Description: p.Description,
+		}, server)
+	})
+}
+
+// RegisterRuntimeJobs registers the handler of the runtime.register job type.
+func RegisterRuntimeJobs(jobs *JobService, runtimes RuntimeServiceProvider) {
+	jobs.RegisterHandler(JobTypeRegisterRuntime, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p RegisterRuntimeJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		return runtimes.RegisterRuntime(ctx, models.JavaRuntime{
+			Name:        p.Name,
+			Description: p.Description,
+			Image:       p.Image,
+			JavaVersion: p.JavaVersion,
+		})
+	})
+}
+
+// RegisterWorldJobs registers the handlers of the world job types.
+func RegisterWorldJobs(jobs *JobService, worlds WorldServiceProvider, uploadPath string) {
+	jobs.RegisterHandler(JobTypeImportWorld, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ImportWorldJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("world import job has no server")
+		}
+		archivePath := filepath.Join(uploadPath, filepath.Base(p.Archive))
+		defer removeSpooledUpload(ctx, archivePath)
+
+		if _, err := os.Stat(archivePath); err != nil {
+			return nil, fmt.Errorf("uploaded world is no longer available: %w", err)
+		}
+		return worlds.ImportWorld(ctx, *job.ServerID, p.Name, archivePath)
+	})
+	jobs.RegisterCleanup(JobTypeImportWorld, spooledArchiveCleanup(uploadPath))
+
+	jobs.RegisterHandler(JobTypeResetWorld, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ResetWorldJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("world reset job has no server")
+		}
+		return nil, worlds.ResetWorld(ctx, *job.ServerID, p.Name, ResetWorldOptions{KeepSeed: p.KeepSeed, Seed: p.Seed})
+	})
+}
+
+// RegisterFileJobs registers the handlers of the file job types.
+func RegisterFileJobs(jobs *JobService, files FileServiceProvider) {
+	jobs.RegisterHandler(JobTypeArchiveFiles, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ArchiveFilesJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("archive job has no server")
+		}
+		return files.ArchiveFiles(withRequester(ctx, p.RequestedBy), *job.ServerID, p.Paths, p.Destination)
+	})
+
+	jobs.RegisterHandler(JobTypeExtractArchive, func(ctx context.Context, job models.Job) (interface{}, error) {
+		var p ExtractArchiveJobPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return nil, fmt.Errorf("invalid job payload: %w", err)
+		}
+		if job.ServerID == nil {
+			return nil, fmt.Errorf("extract job has no server")
+		}
+		return nil, files.ExtractArchive(withRequester(ctx, p.RequestedBy), *job.ServerID, p.Path, p.Destination)
+	})
+}
+
+// withRequester gives a job's context the user who queued it, so the events it records name them.
+func withRequester(ctx context.Context, username string) context.Context {
+	if username == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, auth.UserClaimsKey, &auth.Claims{Username: username})
+}
+
+// spooledArchiveCleanup deletes the upload named by a job's archive field when the job is given up on
+// before its handler, which would otherwise delete it, gets to run.
+func spooledArchiveCleanup(uploadPath string) JobCleanup {
+	return func(job models.Job) {
+		var p struct {
+			Archive string `json:"archive"`
+		}
+		if err := json.Unmarshal(job.Payload, &p); err != nil || p.Archive == "" {
+			return
+		}
+		removeSpooledUpload(context.Background(), filepath.Join(uploadPath, filepath.Base(p.Archive)))
+	}
+}
+
+// removeSpooledUpload deletes an upload once its job is done with it.
+// The upload is kept if the job was interrupted, since it is going to be resumed.
+func removeSpooledUpload(ctx context.Context, path string) {
+	if errors.Is(context.Cause(ctx), ErrJobInterrupted) {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to remove spooled upload")
+	}
+}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/modinfo"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrModNotFound is returned when a server has no jar with the given name.
+	ErrModNotFound = errors.New("mod not found")
+	// ErrModExists is returned when adding a jar whose name is already taken, enabled or not.
+	ErrModExists = errors.New("a mod with that file name is already installed")
+	// ErrInvalidMod is returned for uploads that are not jars and for unknown directories.
+	ErrInvalidMod = errors.New("invalid mod")
+)
+
+// modDirectories are the server directories jars are managed in.
+var modDirectories = []string{"mods", "plugins"}
+
+// disabledSuffix is appended to the name of a jar to keep the server from loading it.
+const disabledSuffix = ".disabled"
+
+// ModServiceProvider defines the interface for mod services.
+type ModServiceProvider interface {
+	ListMods(ctx context.Context, serverID string) ([]models.Mod, error)
+	AddMod(ctx context.Context, serverID, directory, fileName string, file io.Reader) (models.Mod, error)
+	RemoveMod(ctx context.Context, serverID, directory, fileName string) error
+	SetModEnabled(ctx context.Context, serverID, directory, fileName string, enabled bool) (models.Mod, error)
+}
+
+// ModService manages the mod and plugin jars of servers.
+type ModService struct {
+	serverService ServerServiceProvider
+	eventService  EventServiceProvider
+}
+
+// NewModService creates a new ModService.
+func NewModService(serverService ServerServiceProvider, eventService EventServiceProvider) *ModService {
+	return &ModService{serverService: serverService, eventService: eventService}
+}
+
+// ListMods lists the jars in a server's mods/ and plugins/ directories with their metadata and any
+// problems that would keep the server from starting.
+func (s *ModService) ListMods(ctx context.Context, serverID string) ([]models.Mod, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return nil, err
+	}
+	defer dataDir.Close()
+
+	mods := []models.Mod{}
+	for _, directory := range modDirectories {
+		entries, err := dataDir.ReadDir(directory)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s directory: %w", directory, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || !(strings.HasSuffix(name, ".jar") || strings.HasSuffix(name, ".jar"+disabledSuffix)) {
+				continue
+			}
+			mods = append(mods, inspectMod(ctx, dataDir, path.Join(directory, name), directory))
+		}
+	}
+	modinfo.Check(mods)
+	return mods, nil
+}
+
+// inspectMod describes the jar with the given name. A jar that can't be read is reported as an issue rather
+// than an error, so one broken file doesn't hide the rest.
+func inspectMod(ctx context.Context, dataDir *sandbox.Root, file, directory string) models.Mod {
+	name := path.Base(file)
+	mod := models.Mod{
+		FileName:  strings.TrimSuffix(name, disabledSuffix),
+		Directory: directory,
+		Enabled:   !strings.HasSuffix(name, disabledSuffix),
+		Mods:      []models.ModInfo{},
+	}
+
+	f, err := dataDir.Open(file)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("path", file).Msg("Could not open mod jar")
+		mod.Issues = append(mod.Issues, models.ModIssue{Type: "invalid_jar", Message: err.Error()})
+		return mod
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		mod.Issues = append(mod.Issues, models.ModIssue{Type: "invalid_jar", Message: err.Error()})
+		return mod
+	}
+	mod.Size, mod.Modified = info.Size(), info.ModTime()
+
+	infos, err := modinfo.Inspect(f, info.Size())
+	if err != nil {
+		mod.Issues = append(mod.Issues, models.ModIssue{Type: "invalid_jar", Message: err.Error()})
+		return mod
+	}
+	if infos != nil {
+		mod.Mods = infos
+	}
+	return mod
+}
+
+// AddMod installs a jar on a server. With an empty directory, plugins go to plugins/ and everything else to mods/.
+func (s *ModService) AddMod(ctx context.Context, serverID, directory, fileName string, file io.Reader) (models.Mod, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.Mod{}, err
+	}
+	if err := validateModFileName(fileName); err != nil {
+		return models.Mod{}, err
+	}
+	if directory != "" {
+		if err := validateModDirectory(directory); err != nil {
+			return models.Mod{}, err
+		}
+	}
+
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.Mod{}, err
+	}
+	defer dataDir.Close()
+
+	// Stage the upload inside the data directory so it can be inspected, then renamed into place.
+	staged, err := dataDir.CreateTemp(".", ".mod-upload-*")
+	if err != nil {
+		return models.Mod{}, fmt.Errorf("could not stage mod upload: %w", err)
+	}
+	stagedName := filepath.Base(staged.Name())
+	defer dataDir.Remove(stagedName)
+	size, err := io.Copy(staged, file)
+	if err != nil {
+		staged.Close()
+		return models.Mod{}, fmt.Errorf("could not stage mod upload: %w", err)
+	}
+	infos, err := modinfo.Inspect(staged, size)
+	staged.Close()
+	if err != nil {
+		return models.Mod{}, fmt.Errorf("%w: %v", ErrInvalidMod, err)
+	}
+
+	if directory == "" {
+		directory = "mods"
+		if isPlugin(infos) {
+			directory = "plugins"
+		}
+	}
+	name := path.Join(directory, fileName)
+	for _, existing := range []string{name, name + disabledSuffix} {
+		if _, err := dataDir.Lstat(existing); err == nil {
+			return models.Mod{}, fmt.Errorf("%w: %s/%s", ErrModExists, directory, fileName)
+		}
+	}
+	if err := dataDir.MkdirAll(directory, 0755); err != nil {
+		return models.Mod{}, fmt.Errorf("could not create %s directory: %w", directory, err)
+	}
+	if err := dataDir.Rename(stagedName, name); err != nil {
+		return models.Mod{}, fmt.Errorf("could not install mod: %w", err)
+	}
+
+	msg := fmt.Sprintf("'%s' was added to %s of server '%s'.", fileName, directory, server.Name)
+	s.eventService.CreateEvent(ctx, "mod.add", "info", msg, &server.ID)
+	return s.findMod(ctx, serverID, directory, fileName)
+}
+
+// RemoveMod deletes a jar from a server, whether it is enabled or not.
+func (s *ModService) RemoveMod(ctx context.Context, serverID, directory, fileName string) error {
+	server, dataDir, name, err := s.locateMod(ctx, serverID, directory, fileName)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+	if err := dataDir.Remove(name); err != nil {
+		return fmt.Errorf("could not remove mod: %w", err)
+	}
+
+	msg := fmt.Sprintf("'%s' was removed from %s of server '%s'.", fileName, directory, server.Name)
+	s.eventService.CreateEvent(ctx, "mod.remove", "warn", msg, &server.ID)
+	return nil
+}
+
+// SetModEnabled enables or disables a jar by removing or adding the .disabled suffix the loaders skip.
+// The change takes effect when the server is next started.
+func (s *ModService) SetModEnabled(ctx context.Context, serverID, directory, fileName string, enabled bool) (models.Mod, error) {
+	server, dataDir, name, err := s.locateMod(ctx, serverID, directory, fileName)
+	if err != nil {
+		return models.Mod{}, err
+	}
+	defer dataDir.Close()
+
+	target := path.Join(directory, fileName)
+	action := "enable"
+	if !enabled {
+		target += disabledSuffix
+		action = "disable"
+	}
+	if name != target {
+		if err := dataDir.Rename(name, target); err != nil {
+			return models.Mod{}, fmt.Errorf("could not %s mod: %w", action, err)
+		}
+		msg := fmt.Sprintf("'%s' was %sd on server '%s'.", fileName, action, server.Name)
+		s.eventService.CreateEvent(ctx, "mod."+action, "info", msg, &server.ID)
+	}
+	return s.findMod(ctx, serverID, directory, fileName)
+}
+
+// locateMod returns the server, its opened data directory and the current name of one of its jars, which may
+// carry the .disabled suffix. The caller closes the directory.
+func (s *ModService) locateMod(ctx context.Context, serverID, directory, fileName string) (models.Server, *sandbox.Root, string, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.Server{}, nil, "", err
+	}
+	if err := validateModDirectory(directory); err != nil {
+		return models.Server{}, nil, "", err
+	}
+	if err := validateModFileName(fileName); err != nil {
+		return models.Server{}, nil, "", err
+	}
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.Server{}, nil, "", err
+	}
+	name := path.Join(directory, fileName)
+	for _, candidate := range []string{name, name + disabledSuffix} {
+		if info, err := dataDir.Lstat(candidate); err == nil && info.Mode().IsRegular() {
+			return server, dataDir, candidate, nil
+		}
+	}
+	dataDir.Close()
+	return models.Server{}, nil, "", fmt.Errorf("%w: %s/%s", ErrModNotFound, directory, fileName)
+}
+
+// findMod lists a server's jars and returns one of them, so its issues are checked against the others.
+func (s *ModService) findMod(ctx context.Context, serverID, directory, fileName string) (models.Mod, error) {
+	mods, err := s.ListMods(ctx, serverID)
+	if err != nil {
+		return models.Mod{}, err
+	}
+	for _, mod := range mods {
+		if mod.Directory == directory && mod.FileName == fileName {
+			return mod, nil
+		}
+	}
+	return models.Mod{}, fmt.Errorf("%w: %s/%s", ErrModNotFound, directory, fileName)
+}
+
+// isPlugin reports whether a jar declares only Bukkit or Paper plugins.
+func isPlugin(infos []models.ModInfo) bool {
+	if len(infos) == 0 {
+		return false
+	}
+	for _, info := range infos {
+		if info.Loader != "bukkit" && info.Loader != "paper" {
+			return false
+		}
+	}
+	return true
+}
+
+func validateModDirectory(directory string) error {
+	for _, d := range modDirectories {
+		if directory == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: directory must be one of %s", ErrInvalidMod, strings.Join(modDirectories, ", "))
+}
+
+// validateModFileName accepts plain .jar file names, which keeps paths from escaping the mod directory.
+func validateModFileName(fileName string) error {
+	if fileName != filepath.Base(fileName) || strings.ContainsRune(fileName, '\\') || strings.HasPrefix(fileName, ".") || !strings.HasSuffix(fileName, ".jar") {
+		return fmt.Errorf("%w: %q is not a .jar file name", ErrInvalidMod, fileName)
+	}
+	return nil
+}
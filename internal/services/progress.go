@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"io"
+)
+
+// ProgressFunc receives progress updates from a long-running operation.
+type ProgressFunc func(stage string, current, total int64, unit string)
+
+type progressKey struct{}
+
+// WithProgress returns a context whose long-running operations report their progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress forwards a progress update to the ProgressFunc in ctx, if there is one.
+func reportProgress(ctx context.Context, stage string, current, total int64, unit string) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(stage, current, total, unit)
+	}
+}
+
+// progressWriter counts the bytes written through it, reports them and stops once ctx is done.
+type progressWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	stage   string
+	current *int64
+	total   int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if err := pw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(p)
+	*pw.current += int64(n)
+	reportProgress(pw.ctx, pw.stage, *pw.current, pw.total, "bytes")
+	return n, err
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+)
+
+var (
+	// ErrRuntimeNotFound is returned for unknown runtime IDs.
+	ErrRuntimeNotFound = errors.New("java runtime not found")
+	// ErrRuntimeInUse is returned when removing a runtime servers still run on.
+	ErrRuntimeInUse = errors.New("java runtime is in use")
+	// ErrRuntimeBuiltin is returned when removing a built-in runtime.
+	ErrRuntimeBuiltin = errors.New("built-in java runtimes can't be removed")
+	// ErrRuntimeTooOld is returned when a runtime's Java version is older than a server needs.
+	ErrRuntimeTooOld = errors.New("java runtime is too old")
+)
+
+// builtinJavaVersions are the Java versions with a built-in Eclipse Temurin runtime.
+var builtinJavaVersions = []int{8, 11, 16, 17, 21, 25}
+
+// builtinRuntime returns the built-in runtime of a Java version.
+func builtinRuntime(javaVersion int) models.JavaRuntime {
+	return models.JavaRuntime{
+		ID:          fmt.Sprintf("temurin-%d", javaVersion),
+		Name:        fmt.Sprintf("Eclipse Temurin %d", javaVersion),
+		Image:       fmt.Sprintf("eclipse-temurin:%d-jdk", javaVersion),
+		JavaVersion: javaVersion,
+		Builtin:     true,
+	}
+}
+
+// javaVersionPattern matches the version in the first line of `java -version`, e.g. `openjdk version "21.0.2"`.
+var javaVersionPattern = regexp.MustCompile(`version "([^"]+)"`)
+
+// RuntimeServiceProvider defines the interface for Java runtime services.
+type RuntimeServiceProvider interface {
+	GetRuntimes(ctx context.Context) ([]models.JavaRuntime, error)
+	GetRuntime(ctx context.Context, id string) (models.JavaRuntime, error)
+	ResolveRuntime(ctx context.Context, id, javaVersion string) (models.JavaRuntime, error)
+	RegisterRuntime(ctx context.Context, runtime models.JavaRuntime) (models.JavaRuntime, error)
+	DeleteRuntime(ctx context.Context, id string) error
+}
+
+// RuntimeService keeps the catalog of Java runtime images servers can run on: the built-in Temurin
+// images and custom images registered by admins.
+type RuntimeService struct {
+	db     *sql.DB
+	runner *ContainerRunner
+}
+
+// NewRuntimeService creates a new RuntimeService. runner is used to validate registered images.
+func NewRuntimeService(db *sql.DB, runner *ContainerRunner) *RuntimeService {
+	return &RuntimeService{db: db, runner: runner}
+}
+
+func scanRuntime(scanner interface{ Scan(...interface{}) error }) (models.JavaRuntime, error) {
+	var runtime models.JavaRuntime
+	var description, versionInfo sql.NullString
+	if err := scanner.Scan(&runtime.ID, &runtime.Name, &description, &runtime.Image, &runtime.JavaVersion, &versionInfo, &runtime.CreatedAt); err != nil {
+		return models.JavaRuntime{}, err
+	}
+	runtime.Description = description.String
+	runtime.VersionInfo = versionInfo.String
+	return runtime, nil
+}
+
+// GetRuntimes lists the built-in and registered runtimes, newest Java version first.
+func (s *RuntimeService) GetRuntimes(ctx context.Context) ([]models.JavaRuntime, error) {
+	var runtimes []models.JavaRuntime
+	for _, v := range builtinJavaVersions {
+		runtimes = append(runtimes, builtinRuntime(v))
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, description, image, java_version, version_info, created_at FROM java_runtimes ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		runtime, err := scanRuntime(rows)
+		if err != nil {
+			return nil, err
+		}
+		runtimes = append(runtimes, runtime)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(runtimes, func(a, b int) bool { return runtimes[a].JavaVersion > runtimes[b].JavaVersion })
+	return runtimes, nil
+}
+
+// GetRuntime retrieves a built-in or registered runtime by its ID.
+func (s *RuntimeService) GetRuntime(ctx context.Context, id string) (models.JavaRuntime, error) {
+	for _, v := range builtinJavaVersions {
+		if runtime := builtinRuntime(v); runtime.ID == id {
+			return runtime, nil
+		}
+	}
+
+	row := s.db.QueryRowContext(ctx, "SELECT id, name, description, image, java_version, version_info, created_at FROM java_runtimes WHERE id = ?", id)
+	runtime, err := scanRuntime(row)
+	if err == sql.ErrNoRows {
+		return models.JavaRuntime{}, fmt.Errorf("%w: %s", ErrRuntimeNotFound, id)
+	}
+	return runtime, err
+}
+
+// ResolveRuntime returns the runtime for a server that needs javaVersion. Without an id that is the built-in
+// runtime of javaVersion, or of the next newer version with one. A runtime chosen by id must provide at least
+// javaVersion; an empty javaVersion accepts any.
+func (s *RuntimeService) ResolveRuntime(ctx context.Context, id, javaVersion string) (models.JavaRuntime, error) {
+	required := javaMajor(javaVersion)
+	if id == "" {
+		for _, v := range builtinJavaVersions {
+			if v >= required && required > 0 {
+				return builtinRuntime(v), nil
+			}
+		}
+		return models.JavaRuntime{}, fmt.Errorf("%w: no built-in runtime for Java %q", ErrRuntimeNotFound, javaVersion)
+	}
+
+	runtime, err := s.GetRuntime(ctx, id)
+	if err != nil {
+		return models.JavaRuntime{}, err
+	}
+	if runtime.JavaVersion < required {
+		return models.JavaRuntime{}, fmt.Errorf("%w: %s provides Java %d, but Java %d is required", ErrRuntimeTooOld, runtime.Name, runtime.JavaVersion, required)
+	}
+	return runtime, nil
+}
+
+// RegisterRuntime validates a custom image and adds it to the catalog. The image is pulled and must be able to
+// run start scripts with /bin/sh and java; its Java version is read from `java -version` and, if runtime
+// already names one, must match it.
+func (s *RuntimeService) RegisterRuntime(ctx context.Context, runtime models.JavaRuntime) (models.JavaRuntime, error) {
+	if runtime.Name == "" {
+		return models.JavaRuntime{}, fmt.Errorf("runtime name is required")
+	}
+	named, err := reference.ParseNormalizedNamed(runtime.Image)
+	if err != nil {
+		return models.JavaRuntime{}, fmt.Errorf("invalid image reference %q: %w", runtime.Image, err)
+	}
+	runtime.Image = reference.FamiliarString(reference.TagNameOnly(named))
+
+	reportProgress(ctx, "checking java version", 0, 0, "")
+	output, err := s.runner.Output(ctx, runtime.Image, []string{"/bin/sh", "-c", "java -version 2>&1"})
+	if err != nil {
+		return models.JavaRuntime{}, fmt.Errorf("image %s can't run java: %w", runtime.Image, err)
+	}
+	match := javaVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return models.JavaRuntime{}, fmt.Errorf("could not read the Java version of %s from: %s", runtime.Image, lastLines(output, 3))
+	}
+	detected := javaMajor(match[1])
+	if runtime.JavaVersion != 0 && runtime.JavaVersion != detected {
+		return models.JavaRuntime{}, fmt.Errorf("image %s provides Java %d, not Java %d", runtime.Image, detected, runtime.JavaVersion)
+	}
+	runtime.JavaVersion = detected
+	runtime.VersionInfo = javaVersionInfo(output)
+
+	runtime.ID = uuid.New().String()
+	runtime.Builtin = false
+	runtime.CreatedAt = time.Now()
+	_, err = s.db.ExecContext(ctx, "INSERT INTO java_runtimes (id, name, description, image, java_version, version_info, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		runtime.ID, runtime.Name, runtime.Description, runtime.Image, runtime.JavaVersion, runtime.VersionInfo, runtime.CreatedAt)
+	if err != nil {
+		return models.JavaRuntime{}, fmt.Errorf("failed to save runtime: %w", err)
+	}
+	return runtime, nil
+}
+
+// javaVersionInfo picks the line of `java -version` output naming the runtime's vendor and build.
+func javaVersionInfo(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for i, line := range lines {
+		if javaVersionPattern.MatchString(line) {
+			if i+1 < len(lines) {
+				return strings.TrimSpace(lines[i+1])
+			}
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+// DeleteRuntime removes a registered runtime that no server runs on. The image itself is left in place.
+func (s *RuntimeService) DeleteRuntime(ctx context.Context, id string) error {
+	runtime, err := s.GetRuntime(ctx, id)
+	if err != nil {
+		return err
+	}
+	if runtime.Builtin {
+		return ErrRuntimeBuiltin
+	}
+
+	var servers int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM servers WHERE runtime_id = ?", id).Scan(&servers); err != nil {
+		return err
+	}
+	if servers > 0 {
+		return fmt.Errorf("%w by %d server(s)", ErrRuntimeInUse, servers)
+	}
+
+	_, err = s.db.ExecContext(ctx, "DELETE FROM java_runtimes WHERE id = ?", id)
+	return err
+}
+
+// javaMajor returns the major version of a Java version string such as "17", "21.0.2" or "1.8.0_392",
+// or 0 if it has none.
+func javaMajor(version string) int {
+	version = strings.TrimPrefix(version, "1.")
+	end := strings.IndexFunc(version, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		version = version[:end]
+	}
+	n, _ := strconv.Atoi(version)
+	return n
+}
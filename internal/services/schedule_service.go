@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -64,7 +65,7 @@ func (s *ScheduleService) CreateSchedule(schedule models.Schedule) (models.Sched
 		return models.Schedule{}, err
 	}
 
-	s.eventService.CreateEvent("schedule.create", "info", fmt.Sprintf("Schedule '%s' created for server.", schedule.Name), &schedule.ServerID)
+	s.eventService.CreateEvent(context.Background(), "schedule.create", "info", fmt.Sprintf("Schedule '%s' created for server.", schedule.Name), &schedule.ServerID)
 	return s.GetScheduleByID(schedule.ID)
 }
 
@@ -131,7 +132,7 @@ func (s *ScheduleService) UpdateSchedule(scheduleID string, schedule models.Sche
 		return models.Schedule{}, err
 	}
 
-	s.eventService.CreateEvent("schedule.update", "info", fmt.Sprintf("Schedule '%s' updated.", schedule.Name), &existing.ServerID)
+	s.eventService.CreateEvent(context.Background(), "schedule.update", "info", fmt.Sprintf("Schedule '%s' updated.", schedule.Name), &existing.ServerID)
 	return s.GetScheduleByID(scheduleID)
 }
 
@@ -144,7 +145,7 @@ func (s *ScheduleService) DeleteSchedule(scheduleID string) error {
 
 	_, err = s.db.Exec("DELETE FROM schedules WHERE id = ?", scheduleID)
 	if err == nil {
-		s.eventService.CreateEvent("schedule.delete", "warn", fmt.Sprintf("Schedule '%s' was deleted.", schedule.Name), &schedule.ServerID)
+		s.eventService.CreateEvent(context.Background(), "schedule.delete", "warn", fmt.Sprintf("Schedule '%s' was deleted.", schedule.Name), &schedule.ServerID)
 	}
 	return err
 }
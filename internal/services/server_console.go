@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// consoleOutputWait is how long a console command's output is collected for at most.
+	consoleOutputWait = 2 * time.Second
+	// consoleOutputQuiet ends the collection early once the server has been quiet this long after answering.
+	consoleOutputQuiet = 300 * time.Millisecond
+)
+
+// ErrNoConsoleInput is returned for containers created without stdin. They get it the next time the server starts.
+var ErrNoConsoleInput = errors.New("the server's container has no console input; restart the server to enable it")
+
+// ansiEscape matches the colour and cursor sequences servers write to their console.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// sendConsoleCommand types a command into the server process's stdin, the way an operator would at its console.
+// Unlike RCON this works while the server starts and whatever state its server.properties is in. The server
+// doesn't answer the command directly, so whatever it logs in the moment after is taken as the response.
+func (s *ServerService) sendConsoleCommand(ctx context.Context, server models.Server, command string) (response string, err error) {
+	ctx, span := tracing.Start(ctx, "console.execute", serverAttr(server.ID))
+	defer func() { tracing.End(span, err) }()
+
+	if strings.ContainsAny(command, "\r\n") {
+		return "", fmt.Errorf("console commands must be a single line")
+	}
+
+	info, err := s.docker.InspectContainer(ctx, server.DockerContainerID)
+	if err != nil {
+		return "", fmt.Errorf("could not inspect container: %w", err)
+	}
+	if !info.Config.OpenStdin {
+		return "", ErrNoConsoleInput
+	}
+
+	// Follow the output before typing, so none of the response is missed.
+	logCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logs, err := s.docker.FollowContainerLogs(logCtx, server.DockerContainerID, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("could not follow console output: %w", err)
+	}
+	defer logs.Close()
+
+	attach, err := s.docker.ContainerAttach(ctx, server.DockerContainerID, container.AttachOptions{Stream: true, Stdin: true})
+	if err != nil {
+		return "", fmt.Errorf("could not attach to console: %w", err)
+	}
+	_, err = attach.Conn.Write([]byte(command + "\n"))
+	attach.Close()
+	if err != nil {
+		return "", fmt.Errorf("could not write to console: %w", err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(logs)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-logCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var output []string
+	deadline := time.NewTimer(consoleOutputWait)
+	defer deadline.Stop()
+	quiet := time.NewTimer(consoleOutputWait)
+	defer quiet.Stop()
+collect:
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				break collect
+			}
+			line = strings.TrimRight(ansiEscape.ReplaceAllString(line, ""), "\r ")
+			// The TTY echoes the command back, sometimes behind the console's prompt.
+			if line == "" || strings.TrimLeft(line, "> ") == command {
+				continue
+			}
+			output = append(output, line)
+			quiet.Reset(consoleOutputQuiet)
+		case <-quiet.C:
+			break collect
+		case <-deadline.C:
+			break collect
+		}
+	}
+
+	log.Info().Ctx(ctx).Str("command", command).Str("server_name", server.Name).Int("lines", len(output)).Msg("Console command executed")
+	return strings.Join(output, "\n"), nil
+}
+
+// ensureConsoleInput recreates a stopped server's container with stdin open if it was created without it,
+// and returns the ID of the container to start.
+func (s *ServerService) ensureConsoleInput(ctx context.Context, server models.Server) (string, error) {
+	info, err := s.docker.InspectContainer(ctx, server.DockerContainerID)
+	if err != nil {
+		return "", err
+	}
+	if info.Config.OpenStdin || (info.State != nil && info.State.Running) {
+		return server.DockerContainerID, nil
+	}
+
+	containerID, err := s.replaceContainer(ctx, info, func(config *container.Config, _ *container.HostConfig) {
+		config.OpenStdin = true
+		config.AttachStdin = true
+		config.StdinOnce = false
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to recreate container with console input: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "UPDATE servers SET docker_container_id = ? WHERE id = ?", containerID, server.ID); err != nil {
+		return "", fmt.Errorf("failed to update container of server in DB: %w", err)
+	}
+	log.Info().Ctx(ctx).Str("server_id", server.ID).Str("container_id", containerID).Msg("Recreated container with console input")
+	return containerID, nil
+}
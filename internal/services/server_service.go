@@ -14,10 +14,12 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
@@ -26,11 +28,20 @@ import (
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
 	"github.com/gorcon/rcon"
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/configcheck"
 	"github.com/isdelr/ender-deploy-be/internal/docker"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+	"github.com/isdelr/ender-deploy-be/internal/jvm"
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
 	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/provision"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	"github.com/isdelr/ender-deploy-be/internal/websocket"
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/v3/mem"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -39,27 +50,35 @@ const (
 
 // ServerServiceProvider defines the interface for server services.
 type ServerServiceProvider interface {
-	GetAllServers() ([]models.Server, error)
-	GetServerByID(id string) (models.Server, error)
-	CreateServerFromTemplate(name, templateId string) (models.Server, error)
-	UpdateServer(id string, server models.Server) (models.Server, error)
-	DeleteServer(id string) error
-	PerformServerAction(id, action string) error
-	UpdateServerStats(server models.Server) error
-	SendCommandToServer(serverID, command string) (string, error)
-	StreamServerLogs(ctx context.Context, serverID string, sendChan chan []byte)
-	ListFiles(serverID, path string) ([]models.FileInfo, error)
-	GetFileContent(serverID, path string) ([]byte, error)
-	UpdateFileContent(serverID, path string, content []byte) error
-	GetServerSettings(serverID string) (models.ServerSettings, error)
-	UpdateServerSettings(serverID string, settings models.ServerSettings) error
-	GetDashboardStatistics() (models.DashboardStats, error)
-	GetResourceHistory(serverID string) ([]models.ResourceDataPoint, error)
-	GetOnlinePlayers(serverID string) ([]models.OnlinePlayer, error)
-	ManagePlayer(serverID, action, playerName, reason string) error
-	CreateServerFromUpload(name, javaVersion, serverExecutable string, maxMemoryMB int, fileReader io.Reader) (models.Server, error)
+	GetAllServers(ctx context.Context) ([]models.Server, error)
+	GetServerByID(ctx context.Context, id string) (models.Server, error)
+	CreateServerFromTemplate(ctx context.Context, name, templateId string, templateVersion int, runtimeID string) (models.Server, error)
+	CloneServer(ctx context.Context, id string, options CloneServerOptions) (models.Server, error)
+	MoveServer(ctx context.Context, id, storageRoot string) (models.Server, error)
+	GetStorageRoots(ctx context.Context) ([]models.StorageRoot, error)
+	UpdateServer(ctx context.Context, id string, server models.Server) (models.Server, error)
+	UpdateServerRuntime(ctx context.Context, id string, runtime ServerRuntime) (models.Server, error)
+	ChangeServerRuntime(ctx context.Context, id, runtimeID string) (models.Server, error)
+	DeleteServer(ctx context.Context, id string) error
+	PerformServerAction(ctx context.Context, id, action string) error
+	UpdateServerStats(ctx context.Context, server models.Server) error
+	UpdateServerGameMetrics(ctx context.Context, serverID string, metrics *models.GameMetrics) error
+	SendCommandToServer(ctx context.Context, serverID, command string) (string, error)
+	SendRconCommand(ctx context.Context, serverID, command string) (string, error)
+	StreamServerLogs(ctx context.Context, serverID string, send func(message []byte))
+	ListFiles(ctx context.Context, serverID, path string) ([]models.FileInfo, error)
+	GetFileContent(ctx context.Context, serverID, path string) ([]byte, error)
+	UpdateFileContent(ctx context.Context, serverID, path string, content []byte, checkSchema bool) error
+	GetServerSettings(ctx context.Context, serverID string) (models.ServerSettings, error)
+	UpdateServerSettings(ctx context.Context, serverID string, settings models.ServerSettings) error
+	GetJVMConfig(ctx context.Context, serverID string) (JVMConfig, error)
+	UpdateJVMSettings(ctx context.Context, serverID string, settings models.JVMSettings) (JVMConfig, error)
+	GetDashboardStatistics(ctx context.Context) (models.DashboardStats, error)
+	GetOnlinePlayers(ctx context.Context, serverID string) ([]models.OnlinePlayer, error)
+	ManagePlayer(ctx context.Context, serverID, action, playerName, reason string) error
+	CreateServerFromUpload(ctx context.Context, name, javaVersion, runtimeID, serverExecutable string, maxMemoryMB int, fileReader io.Reader) (models.Server, error)
 	ExecuteTerminalCommand(ctx context.Context, serverID, command string) (string, error)
-	GetSystemResourceStats() (map[string]int, error)
+	GetSystemResourceStats(ctx context.Context) (map[string]int, error)
 }
 
 // ServerService provides business logic for server management.
@@ -69,22 +88,38 @@ type ServerService struct {
 	hub             *websocket.Hub
 	templateService TemplateServiceProvider
 	eventService    EventServiceProvider
+	runtimeService  RuntimeServiceProvider
+	fileHistory     FileHistoryServiceProvider
+	provisioner     *provision.Provisioner
 	serverDataPath  string
+	storageRoots    []string // serverDataPath and the other roots servers can be moved to
 }
 
-// NewServerService creates a new ServerService.
-func NewServerService(db *sql.DB, docker *docker.Client, hub *websocket.Hub, templateService TemplateServiceProvider, eventService EventServiceProvider, serverDataPath string) *ServerService {
+// NewServerService creates a new ServerService. provisioner applies the configuration of templates to the servers created from them,
+// and fileHistory keeps the files replaced by edits. New servers are created in serverDataPath; extraStorageRoots are further
+// directories servers can be cloned or moved to.
+func NewServerService(db *sql.DB, docker *docker.Client, hub *websocket.Hub, templateService TemplateServiceProvider, eventService EventServiceProvider, runtimeService RuntimeServiceProvider, fileHistory FileHistoryServiceProvider, provisioner *provision.Provisioner, serverDataPath string, extraStorageRoots []string) *ServerService {
+	storageRoots := []string{filepath.Clean(serverDataPath)}
+	for _, root := range extraStorageRoots {
+		if root = filepath.Clean(root); !slices.Contains(storageRoots, root) {
+			storageRoots = append(storageRoots, root)
+		}
+	}
 	return &ServerService{
 		db:              db,
 		docker:          docker,
 		hub:             hub,
 		templateService: templateService,
 		eventService:    eventService,
+		runtimeService:  runtimeService,
+		fileHistory:     fileHistory,
+		provisioner:     provisioner,
 		serverDataPath:  serverDataPath,
+		storageRoots:    storageRoots,
 	}
 }
-func (s *ServerService) GetAllServers() ([]models.Server, error) {
-	rows, err := s.db.Query("SELECT id, name, status, port, minecraft_version, java_version, players_current, players_max, cpu_usage, ram_usage, storage_usage, ip_address, modpack_name, modpack_version, docker_container_id, data_path, rcon_password, max_memory_mb FROM servers")
+func (s *ServerService) GetAllServers(ctx context.Context) ([]models.Server, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, status, port, minecraft_version, java_version, server_type, loader_version, runtime_id, jvm_settings_json, players_current, players_max, cpu_usage, ram_usage, storage_usage, ip_address, modpack_name, modpack_version, docker_container_id, data_path, rcon_password, max_memory_mb, "+gameMetricsColumns+" FROM servers")
 	if err != nil {
 		return nil, err
 	}
@@ -93,23 +128,28 @@ func (s *ServerService) GetAllServers() ([]models.Server, error) {
 	var servers []models.Server
 	for rows.Next() {
 		var srv models.Server
-		var modpackName, modpackVersion, dockerContainerID, dataPath, rconPassword sql.NullString
+		var modpackName, modpackVersion, dockerContainerID, dataPath, rconPassword, serverType, loaderVersion, runtimeID, jvmSettings sql.NullString
 		var playersCurrent, playersMax, storageUsage, maxMemoryMB sql.NullInt64
 		var cpuUsage, ramUsage sql.NullFloat64
 		var port sql.NullInt32
 		var ipAddress sql.NullString
+		var game gameMetricsRow
 
-		err := rows.Scan(
-			&srv.ID, &srv.Name, &srv.Status, &port, &srv.MinecraftVersion, &srv.JavaVersion,
+		err := rows.Scan(append([]interface{}{
+			&srv.ID, &srv.Name, &srv.Status, &port, &srv.MinecraftVersion, &srv.JavaVersion, &serverType, &loaderVersion, &runtimeID, &jvmSettings,
 			&playersCurrent, &playersMax, &cpuUsage, &ramUsage, &storageUsage,
 			&ipAddress, &modpackName, &modpackVersion, &dockerContainerID, &dataPath, &rconPassword, &maxMemoryMB,
-		)
+		}, game.dest()...)...)
 		if err != nil {
 			return nil, err
 		}
 
 		// Safely assign values from nullable types to the struct
 		srv.Port = int(port.Int32)
+		srv.ServerType = serverType.String
+		srv.LoaderVersion = loaderVersion.String
+		srv.RuntimeID = runtimeID.String
+		srv.JVM = parseJVMSettings(jvmSettings)
 		srv.IPAddress = ipAddress.String
 		srv.Players.Current = int(playersCurrent.Int64)
 		srv.Players.Max = int(playersMax.Int64)
@@ -120,6 +160,7 @@ func (s *ServerService) GetAllServers() ([]models.Server, error) {
 		srv.DataPath = dataPath.String
 		srv.RCONPassword = rconPassword.String
 		srv.MaxMemoryMB = int(maxMemoryMB.Int64)
+		srv.Resources.Game = game.toModel()
 
 		if modpackName.Valid && modpackVersion.Valid {
 			srv.Modpack = &models.ModpackInfo{Name: modpackName.String, Version: modpackVersion.String}
@@ -130,23 +171,26 @@ func (s *ServerService) GetAllServers() ([]models.Server, error) {
 }
 
 // GetServerByID retrieves a single server by its ID.
-func (s *ServerService) GetServerByID(id string) (models.Server, error) {
+func (s *ServerService) GetServerByID(ctx context.Context, id string) (models.Server, error) {
 	var srv models.Server
-	var modpackName, modpackVersion, containerID, dataPath, templateID, ipAddress, rconPassword sql.NullString
+	var modpackName, modpackVersion, containerID, dataPath, templateID, ipAddress, rconPassword, serverType, loaderVersion, runtimeID, jvmSettings sql.NullString
 	// Use nullable types to scan from DB
-	var playersCurrent, playersMax, storageUsage, maxMemoryMB sql.NullInt64
+	var playersCurrent, playersMax, storageUsage, maxMemoryMB, templateVersion sql.NullInt64
 	var cpuUsage, ramUsage sql.NullFloat64
 	var port sql.NullInt32
+	var game gameMetricsRow
 
-	row := s.db.QueryRow(`
-	SELECT id, name, status, port, minecraft_version, java_version,
+	row := s.db.QueryRowContext(ctx, `
+	SELECT id, name, status, port, minecraft_version, java_version, server_type, loader_version, runtime_id, jvm_settings_json,
 	       players_current, players_max, cpu_usage, ram_usage, storage_usage,
-	       ip_address, modpack_name, modpack_version, docker_container_id, data_path, template_id, rcon_password, max_memory_mb
+	       ip_address, modpack_name, modpack_version, docker_container_id, data_path, template_id, template_version, rcon_password, max_memory_mb,
+	       `+gameMetricsColumns+`
 	FROM servers WHERE id = ?`, id)
-	err := row.Scan(
-		&srv.ID, &srv.Name, &srv.Status, &port, &srv.MinecraftVersion, &srv.JavaVersion,
+	err := row.Scan(append([]interface{}{
+		&srv.ID, &srv.Name, &srv.Status, &port, &srv.MinecraftVersion, &srv.JavaVersion, &serverType, &loaderVersion, &runtimeID, &jvmSettings,
 		&playersCurrent, &playersMax, &cpuUsage, &ramUsage, &storageUsage,
-		&ipAddress, &modpackName, &modpackVersion, &containerID, &dataPath, &templateID, &rconPassword, &maxMemoryMB)
+		&ipAddress, &modpackName, &modpackVersion, &containerID, &dataPath, &templateID, &templateVersion, &rconPassword, &maxMemoryMB,
+	}, game.dest()...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return models.Server{}, fmt.Errorf("server with id %s not found", id)
@@ -155,6 +199,10 @@ func (s *ServerService) GetServerByID(id string) (models.Server, error) {
 	}
 	// Safely assign values from nullable types to the struct
 	srv.Port = int(port.Int32)
+	srv.ServerType = serverType.String
+	srv.LoaderVersion = loaderVersion.String
+	srv.RuntimeID = runtimeID.String
+	srv.JVM = parseJVMSettings(jvmSettings)
 	srv.IPAddress = ipAddress.String
 	srv.Players.Current = int(playersCurrent.Int64)
 	srv.Players.Max = int(playersMax.Int64)
@@ -164,8 +212,10 @@ func (s *ServerService) GetServerByID(id string) (models.Server, error) {
 	srv.DockerContainerID = containerID.String
 	srv.DataPath = dataPath.String
 	srv.TemplateID = templateID.String
+	srv.TemplateVersion = int(templateVersion.Int64)
 	srv.RCONPassword = rconPassword.String
 	srv.MaxMemoryMB = int(maxMemoryMB.Int64)
+	srv.Resources.Game = game.toModel()
 
 	if modpackName.Valid && modpackVersion.Valid {
 		srv.Modpack = &models.ModpackInfo{Name: modpackName.String, Version: modpackVersion.String}
@@ -174,20 +224,33 @@ func (s *ServerService) GetServerByID(id string) (models.Server, error) {
 	return srv, nil
 }
 
-// CreateServerFromTemplate handles the logic for creating a new server instance based on a template.
-func (s *ServerService) CreateServerFromTemplate(name, templateId string) (models.Server, error) {
-	template, err := s.templateService.GetTemplateByID(templateId)
+// CreateServerFromTemplate handles the logic for creating a new server instance based on a version of a template,
+// the latest if templateVersion is 0. With an empty runtimeID the server runs on the built-in runtime of the Java
+// version the template needs.
+func (s *ServerService) CreateServerFromTemplate(ctx context.Context, name, templateId string, templateVersion int, runtimeID string) (_ models.Server, err error) {
+	template, err := s.templateService.GetTemplateVersion(templateId, templateVersion)
 	if err != nil {
 		return models.Server{}, fmt.Errorf("failed to retrieve template: %w", err)
 	}
+	javaVersion := template.JavaVersion
+	if javaVersion == "" {
+		javaVersion = installer.JavaVersionFor(template.MinecraftVersion)
+	}
+	runtime, err := s.runtimeService.ResolveRuntime(ctx, runtimeID, javaVersion)
+	if err != nil {
+		return models.Server{}, err
+	}
 
 	server := models.Server{
 		ID:               uuid.New().String(),
 		Name:             name,
 		Status:           "offline",
 		MinecraftVersion: template.MinecraftVersion,
-		JavaVersion:      template.JavaVersion,
+		JavaVersion:      strconv.Itoa(runtime.JavaVersion),
+		ServerType:       strings.ToLower(template.ServerType),
+		RuntimeID:        runtime.ID,
 		TemplateID:       template.ID,
+		TemplateVersion:  template.Version,
 		RCONPassword:     "ender-rcon-" + uuid.New().String(),
 		MaxMemoryMB:      template.MaxMemoryMB,
 	}
@@ -201,6 +264,12 @@ func (s *ServerService) CreateServerFromTemplate(name, templateId string) (model
 	if err := os.MkdirAll(absDataPath, 0755); err != nil {
 		return server, fmt.Errorf("failed to create server data directory: %w", err)
 	}
+	defer s.cleanupFailedProvisioning(ctx, &err, absDataPath, &server)
+	dataDir, err := sandbox.Open(absDataPath)
+	if err != nil {
+		return server, fmt.Errorf("failed to open server data directory: %w", err)
+	}
+	defer dataDir.Close()
 
 	// --- NEW LOGIC for zip-based templates ---
 	// The template.ServerJarURL now holds the path to the template's zip file.
@@ -216,83 +285,50 @@ func (s *ServerService) CreateServerFromTemplate(name, templateId string) (model
 	defer templateZipFile.Close()
 
 	// Unzip the contents into the new server's data directory
-	if err := unzip(templateZipFile, absDataPath); err != nil {
-		os.RemoveAll(absDataPath) // clean up failed provisioning
+	if err := unzip(ctx, templateZipFile, dataDir); err != nil {
 		return server, fmt.Errorf("failed to unzip template file into server directory: %w", err)
 	}
 
 	// Now that files are unzipped, create the necessary startup scripts and configs.
-	// 1. Create start.sh using the command stored in the template
-	startScriptPath := filepath.Join(absDataPath, "start.sh")
-	if err := os.WriteFile(startScriptPath, []byte("#!/bin/sh\n"+template.StartupCommand), 0755); err != nil {
+	// 1. Create start.sh from the JVM profile, or with the template's own command if it runs a script of its own
+	server.JVM = jvm.DefaultSettings(template.JVMArgs)
+	if err := jvm.Validate(server.JVM, runtime.JavaVersion); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("template_id", template.ID).Msg("Ignoring invalid JVM arguments of template")
+		server.JVM = jvm.DefaultSettings(nil)
+	}
+	if launch := jvm.ParseLaunch(template.StartupCommand); launch.Managed() {
+		if err := jvm.WriteStartScript(absDataPath, launch, server.JVM, server.MaxMemoryMB, runtime.JavaVersion); err != nil {
+			return server, err
+		}
+	} else if err := dataDir.WriteFile("start.sh", []byte("#!/bin/sh\n"+template.StartupCommand), 0755); err != nil {
 		return server, fmt.Errorf("failed to write start.sh: %w", err)
 	}
 
 	// 2. Ensure eula.txt is present and accepted
-	eulaPath := filepath.Join(absDataPath, "eula.txt")
-	if err := os.WriteFile(eulaPath, []byte("eula=true\n"), 0644); err != nil {
+	if err := dataDir.WriteFile("eula.txt", []byte("eula=true\n"), 0644); err != nil {
 		return server, fmt.Errorf("failed to write eula.txt: %w", err)
 	}
 
-	// 3. Ensure server.properties has RCON enabled for management
-	s.ensureRconInProperties(filepath.Join(absDataPath, "server.properties"), server.RCONPassword)
+	// 3. Render the template's properties, player lists and datapacks, with RCON enabled for management
+	err = s.provisioner.Apply(ctx, dataDir, template, rconProperties(server.RCONPassword), func(stage string, current, total int64, unit string) {
+		reportProgress(ctx, stage, current, total, unit)
+	})
+	if err != nil {
+		return server, fmt.Errorf("failed to apply template configuration: %w", err)
+	}
 	// --- END NEW LOGIC ---
 
 	// --- Docker Setup ---
-	imageName := fmt.Sprintf("eclipse-temurin:%s-jdk", template.JavaVersion)
-	ctx := context.Background()
-	if err := s.ensureImageExists(ctx, imageName); err != nil {
+	imageName := runtime.Image
+	if err := ensureImageExists(ctx, s.docker, imageName); err != nil {
 		return server, err
 	}
 
-	gamePort, err := FindAvailablePort(25565)
-	if err != nil {
-		return server, fmt.Errorf("failed to find an available game port: %w", err)
-	}
-	rconPort, err := FindAvailablePort(25575)
-	if err != nil {
-		return server, fmt.Errorf("failed to find an available RCON port: %w", err)
-	}
-	server.Port = gamePort
-	server.IPAddress = fmt.Sprintf("127.0.0.1:%d", gamePort)
-
-	portBindings := nat.PortMap{
-		"25565/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: strconv.Itoa(gamePort)}},
-		"25575/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: strconv.Itoa(rconPort)}},
-	}
-
-	containerConfig := &container.Config{
-		Image:      imageName,
-		WorkingDir: "/data",
-		Cmd:        []string{"/bin/sh", "start.sh"},
-		Tty:        true,
-		ExposedPorts: nat.PortSet{
-			"25565/tcp": {},
-			"25575/tcp": {},
-		},
-		Labels: map[string]string{
-			"com.ender-deploy.managed":  "true",
-			"com.ender-deploy.serverId": server.ID,
-		},
-	}
-
-	// Container memory is the user-defined max memory + 512MB for overhead
-	containerMemoryBytes := int64(template.MaxMemoryMB+512) * 1024 * 1024
-
-	hostConfig := &container.HostConfig{
-		Mounts:       []mount.Mount{{Type: mount.TypeBind, Source: absDataPath, Target: "/data"}},
-		PortBindings: portBindings,
-		Resources: container.Resources{
-			Memory: containerMemoryBytes, // Memory in bytes
-		},
-	}
-
-	containerName := "enderdeploy_" + server.ID
-	resp, err := s.docker.CreateContainer(ctx, containerConfig, hostConfig, containerName)
+	containerID, err := s.createServerContainer(ctx, &server, imageName, absDataPath)
 	if err != nil {
 		return server, fmt.Errorf("failed to create docker container: %w", err)
 	}
-	server.DockerContainerID = resp.ID
+	server.DockerContainerID = containerID
 
 	// --- Database Insertion ---
 	maxPlayers := 20 // default
@@ -303,37 +339,43 @@ func (s *ServerService) CreateServerFromTemplate(name, templateId string) (model
 	}
 	server.Players.Max = maxPlayers
 
-	stmt, err := s.db.Prepare(`
-		INSERT INTO servers(id, name, status, minecraft_version, java_version, docker_container_id, data_path, template_id, port, ip_address, players_max, rcon_password, max_memory_mb)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO servers(id, name, status, minecraft_version, java_version, server_type, runtime_id, docker_container_id, data_path, template_id, template_version, port, ip_address, players_max, rcon_password, max_memory_mb, jvm_settings_json)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return server, fmt.Errorf("failed to prepare db statement: %w", err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(server.ID, server.Name, server.Status, server.MinecraftVersion, server.JavaVersion, server.DockerContainerID, server.DataPath, server.TemplateID, server.Port, server.IPAddress, maxPlayers, server.RCONPassword, server.MaxMemoryMB)
+	_, err = stmt.ExecContext(ctx, server.ID, server.Name, server.Status, server.MinecraftVersion, server.JavaVersion, server.ServerType, server.RuntimeID, server.DockerContainerID, server.DataPath, server.TemplateID, server.TemplateVersion, server.Port, server.IPAddress, maxPlayers, server.RCONPassword, server.MaxMemoryMB, jvmSettingsJSON(server.JVM))
 	if err != nil {
-		s.docker.RemoveContainer(context.Background(), server.DockerContainerID) // Cleanup container
 		return server, fmt.Errorf("failed to write server to database: %w", err)
 	}
 
-	newServer, _ := s.GetServerByID(server.ID)
+	newServer, _ := s.GetServerByID(ctx, server.ID)
 	s.broadcastServerUpdate(newServer)
 
-	s.eventService.CreateEvent("server.create", "info", fmt.Sprintf("Server '%s' was created successfully.", newServer.Name), &newServer.ID)
-	log.Info().Str("server_name", server.Name).Str("template_name", template.Name).Str("container_id", server.DockerContainerID).Msg("Successfully created server from custom template")
+	s.eventService.CreateEvent(ctx, "server.create", "info", fmt.Sprintf("Server '%s' was created successfully.", newServer.Name), &newServer.ID)
+	log.Info().Ctx(ctx).Str("server_name", server.Name).Str("template_name", template.Name).Str("container_id", server.DockerContainerID).Msg("Successfully created server from custom template")
 	return newServer, nil
 }
 
 // CreateServerFromUpload creates a server from an uploaded zip file using the new custom approach.
-func (s *ServerService) CreateServerFromUpload(name, javaVersion, serverExecutable string, maxMemoryMB int, fileReader io.Reader) (models.Server, error) {
+// The server runs on the runtime runtimeID, or without one on the built-in runtime of javaVersion.
+func (s *ServerService) CreateServerFromUpload(ctx context.Context, name, javaVersion, runtimeID, serverExecutable string, maxMemoryMB int, fileReader io.Reader) (_ models.Server, err error) {
+	runtime, err := s.runtimeService.ResolveRuntime(ctx, runtimeID, javaVersion)
+	if err != nil {
+		return models.Server{}, err
+	}
+
 	server := models.Server{
 		ID:               uuid.New().String(),
 		Name:             name,
 		Status:           "offline",
 		MinecraftVersion: "Uploaded", // Can't know this from a zip
-		JavaVersion:      javaVersion,
+		JavaVersion:      strconv.Itoa(runtime.JavaVersion),
+		RuntimeID:        runtime.ID,
 		RCONPassword:     "ender-rcon-" + uuid.New().String(),
 		MaxMemoryMB:      maxMemoryMB,
 	}
@@ -347,32 +389,65 @@ func (s *ServerService) CreateServerFromUpload(name, javaVersion, serverExecutab
 	if err := os.MkdirAll(absDataPath, 0755); err != nil {
 		return server, fmt.Errorf("failed to create server data directory: %w", err)
 	}
-	if err := unzip(fileReader, absDataPath); err != nil {
+	defer s.cleanupFailedProvisioning(ctx, &err, absDataPath, &server)
+	dataDir, err := sandbox.Open(absDataPath)
+	if err != nil {
+		return server, fmt.Errorf("failed to open server data directory: %w", err)
+	}
+	defer dataDir.Close()
+
+	if err := unzip(ctx, fileReader, dataDir); err != nil {
 		return server, fmt.Errorf("failed to unzip uploaded file: %w", err)
 	}
 
 	// --- Provision startup script and EULA ---
-	if err := s.provisionServerFilesFromUpload(absDataPath, serverExecutable, maxMemoryMB); err != nil {
+	server.JVM = jvm.DefaultSettings(nil)
+	if err := s.provisionServerFilesFromUpload(dataDir, serverExecutable, server.JVM, maxMemoryMB, runtime.JavaVersion); err != nil {
 		return server, fmt.Errorf("failed to provision startup files: %w", err)
 	}
 
-	s.ensureRconInProperties(filepath.Join(absDataPath, "server.properties"), server.RCONPassword)
+	s.ensureRconInProperties(absDataPath, server.RCONPassword)
 
 	// --- Docker Setup ---
-	imageName := fmt.Sprintf("eclipse-temurin:%s-jdk", javaVersion)
-	ctx := context.Background()
-	if err := s.ensureImageExists(ctx, imageName); err != nil {
+	imageName := runtime.Image
+	if err := ensureImageExists(ctx, s.docker, imageName); err != nil {
+		return server, err
+	}
+
+	containerID, err := s.createServerContainer(ctx, &server, imageName, absDataPath)
+	if err != nil {
 		return server, err
 	}
+	server.DockerContainerID = containerID
 
-	gamePort, err := FindAvailablePort(25565)
+	// --- Database Insertion ---
+	stmt, err := s.db.PrepareContext(ctx, `
+		INSERT INTO servers(id, name, status, minecraft_version, java_version, runtime_id, docker_container_id, data_path, port, ip_address, players_max, rcon_password, max_memory_mb, jvm_settings_json)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
 	if err != nil {
 		return server, err
 	}
-	rconPort, err := FindAvailablePort(25575)
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, server.ID, server.Name, server.Status, server.MinecraftVersion, server.JavaVersion, server.RuntimeID, server.DockerContainerID, server.DataPath, server.Port, server.IPAddress, 20, server.RCONPassword, server.MaxMemoryMB, jvmSettingsJSON(server.JVM))
 	if err != nil {
 		return server, err
 	}
+
+	newServer, _ := s.GetServerByID(ctx, server.ID)
+	s.broadcastServerUpdate(newServer)
+	s.eventService.CreateEvent(ctx, "server.upload", "info", fmt.Sprintf("Server '%s' was created from an upload.", newServer.Name), &newServer.ID)
+	return newServer, nil
+}
+
+// createServerContainer picks free host ports for a new server and creates its container, which runs
+// imageName with the data directory at absDataPath mounted. The ports are recorded on server.
+func (s *ServerService) createServerContainer(ctx context.Context, server *models.Server, imageName, absDataPath string) (string, error) {
+	gamePort, rconPort, err := findServerPorts(ctx)
+	if err != nil {
+		return "", err
+	}
 	server.Port = gamePort
 	server.IPAddress = fmt.Sprintf("127.0.0.1:%d", gamePort)
 
@@ -382,113 +457,270 @@ func (s *ServerService) CreateServerFromUpload(name, javaVersion, serverExecutab
 	}
 
 	containerConfig := &container.Config{
-		Image:      imageName,
-		WorkingDir: "/data",
-		Cmd:        []string{"/bin/sh", "start.sh"},
-		Tty:        true,
+		Image:       imageName,
+		WorkingDir:  "/data",
+		Cmd:         []string{"/bin/sh", "start.sh"},
+		Tty:         true,
+		OpenStdin:   true, // Console commands are typed into the server's stdin when RCON isn't up
+		AttachStdin: true,
 		ExposedPorts: nat.PortSet{
 			"25565/tcp": {},
 			"25575/tcp": {},
 		},
-		Labels: map[string]string{"com.ender-deploy.managed": "true", "com.ender-deploy.serverId": server.ID},
+		Labels: map[string]string{
+			"com.ender-deploy.managed":  "true",
+			"com.ender-deploy.serverId": server.ID,
+		},
 	}
 
 	// Container memory is the user-defined max memory + 512MB for overhead
-	containerMemoryBytes := int64(maxMemoryMB+512) * 1024 * 1024
+	containerMemoryBytes := int64(server.MaxMemoryMB+512) * 1024 * 1024
 
 	hostConfig := &container.HostConfig{
 		Mounts:       []mount.Mount{{Type: mount.TypeBind, Source: absDataPath, Target: "/data"}},
 		PortBindings: portBindings,
 		Resources: container.Resources{
-			Memory: containerMemoryBytes,
+			Memory: containerMemoryBytes, // Memory in bytes
 		},
 	}
 
+	reportProgress(ctx, "creating container", 0, 0, "")
 	containerName := "enderdeploy_" + server.ID
 	resp, err := s.docker.CreateContainer(ctx, containerConfig, hostConfig, containerName)
 	if err != nil {
-		return server, err
+		return "", err
 	}
-	server.DockerContainerID = resp.ID
+	return resp.ID, nil
+}
 
-	// --- Database Insertion ---
-	stmt, err := s.db.Prepare(`
-		INSERT INTO servers(id, name, status, minecraft_version, java_version, docker_container_id, data_path, port, ip_address, players_max, rcon_password, max_memory_mb)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`)
+// cleanupFailedProvisioning removes the data directory and container of a server whose
+// creation failed or was cancelled, so a retried job starts from a clean slate.
+func (s *ServerService) cleanupFailedProvisioning(ctx context.Context, errp *error, dataPath string, server *models.Server) {
+	if *errp == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if server.DockerContainerID != "" {
+		if err := s.docker.RemoveContainer(ctx, server.DockerContainerID); err != nil && !client.IsErrNotFound(err) {
+			log.Warn().Ctx(ctx).Err(err).Str("container_id", server.DockerContainerID).Msg("Could not remove container of failed server")
+		}
+	}
+	if err := os.RemoveAll(dataPath); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("path", dataPath).Msg("Could not remove data directory of failed server")
+	}
+}
+
+// UpdateServer updates an existing server's settings.
+// The Minecraft and Java versions are not among them: changing those means swapping the server jar and
+// container image, which UpgradeService does.
+func (s *ServerService) UpdateServer(ctx context.Context, id string, server models.Server) (models.Server, error) {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE servers SET name = ?, players_max = ? WHERE id = ?")
 	if err != nil {
-		return server, err
+		return models.Server{}, err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(server.ID, server.Name, server.Status, server.MinecraftVersion, server.JavaVersion, server.DockerContainerID, server.DataPath, server.Port, server.IPAddress, 20, server.RCONPassword, server.MaxMemoryMB)
+	_, err = stmt.ExecContext(ctx, server.Name, server.Players.Max, id)
 	if err != nil {
-		s.docker.RemoveContainer(context.Background(), server.DockerContainerID)
-		return server, err
+		return models.Server{}, err
 	}
 
-	newServer, _ := s.GetServerByID(server.ID)
-	s.broadcastServerUpdate(newServer)
-	s.eventService.CreateEvent("server.upload", "info", fmt.Sprintf("Server '%s' was created from an upload.", newServer.Name), &newServer.ID)
-	return newServer, nil
+	updatedServer, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
+
+	s.broadcastServerUpdate(updatedServer)
+	return updatedServer, nil
 }
 
-// UpdateServer updates an existing server's settings.
-func (s *ServerService) UpdateServer(id string, server models.Server) (models.Server, error) {
-	stmt, err := s.db.Prepare("UPDATE servers SET name = ?, minecraft_version = ?, java_version = ?, players_max = ? WHERE id = ?")
+// ServerRuntime is the server software a server runs and the Java runtime its container runs on.
+// An empty RuntimeID picks the built-in runtime of JavaVersion; a chosen runtime must provide at least JavaVersion.
+type ServerRuntime struct {
+	ServerType       string
+	MinecraftVersion string
+	LoaderVersion    string
+	JavaVersion      string
+	RuntimeID        string
+}
+
+// UpdateServerRuntime records the server software installed in a stopped server's data directory.
+// If the runtime's image changes, the container is recreated from it.
+func (s *ServerService) UpdateServerRuntime(ctx context.Context, id string, runtime ServerRuntime) (models.Server, error) {
+	server, err := s.GetServerByID(ctx, id)
 	if err != nil {
 		return models.Server{}, err
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(server.Name, server.MinecraftVersion, server.JavaVersion, server.Players.Max, id)
+	javaRuntime, err := s.runtimeService.ResolveRuntime(ctx, runtime.RuntimeID, runtime.JavaVersion)
 	if err != nil {
 		return models.Server{}, err
 	}
 
-	updatedServer, err := s.GetServerByID(id)
+	containerID, err := s.recreateContainer(ctx, server.DockerContainerID, javaRuntime.Image)
 	if err != nil {
-		return models.Server{}, err
+		return models.Server{}, fmt.Errorf("failed to recreate container with %s: %w", javaRuntime.Image, err)
 	}
 
+	_, err = s.db.ExecContext(ctx, "UPDATE servers SET server_type = ?, minecraft_version = ?, loader_version = ?, java_version = ?, runtime_id = ?, docker_container_id = ? WHERE id = ?",
+		runtime.ServerType, runtime.MinecraftVersion, runtime.LoaderVersion, strconv.Itoa(javaRuntime.JavaVersion), javaRuntime.ID, containerID, id)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to update server runtime in DB: %w", err)
+	}
+
+	updatedServer, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
 	s.broadcastServerUpdate(updatedServer)
 	return updatedServer, nil
 }
 
+// ChangeServerRuntime moves a server onto another Java runtime, restarting it if it was running.
+// The runtime must provide at least the Java version the server's Minecraft version needs.
+func (s *ServerService) ChangeServerRuntime(ctx context.Context, id, runtimeID string) (models.Server, error) {
+	server, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
+	// Uploaded servers have no known Minecraft version, so any runtime goes.
+	var required string
+	if server.ServerType != "" {
+		required = installer.JavaVersionFor(server.MinecraftVersion)
+	}
+	javaRuntime, err := s.runtimeService.ResolveRuntime(ctx, runtimeID, required)
+	if err != nil {
+		return models.Server{}, err
+	}
+	// Flags such as ZGC's depend on the Java version, so the start script is regenerated for the new one.
+	launch, err := jvm.ReadLaunch(server.DataPath)
+	if err != nil {
+		return models.Server{}, err
+	}
+	if launch.Managed() {
+		if err := jvm.Validate(server.JVM, javaRuntime.JavaVersion); err != nil {
+			return models.Server{}, err
+		}
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	wasRunning := server.Status != "offline"
+	if wasRunning {
+		reportProgress(ctx, "stopping server", 0, 0, "")
+		if err := s.PerformServerAction(ctx, id, "stop"); err != nil {
+			return models.Server{}, fmt.Errorf("failed to stop server: %w", err)
+		}
+	}
+	updated, err := s.UpdateServerRuntime(ctx, id, ServerRuntime{
+		ServerType:       server.ServerType,
+		MinecraftVersion: server.MinecraftVersion,
+		LoaderVersion:    server.LoaderVersion,
+		JavaVersion:      required,
+		RuntimeID:        javaRuntime.ID,
+	})
+	if err != nil {
+		return models.Server{}, err
+	}
+	if launch.Managed() {
+		if err := jvm.WriteStartScript(server.DataPath, launch, server.JVM, server.MaxMemoryMB, javaRuntime.JavaVersion); err != nil {
+			return models.Server{}, err
+		}
+	}
+
+	msg := fmt.Sprintf("Server '%s' now runs on %s (Java %d).", server.Name, javaRuntime.Name, javaRuntime.JavaVersion)
+	s.eventService.CreateEvent(ctx, "server.runtime", "info", msg, &server.ID)
+	if wasRunning {
+		reportProgress(ctx, "starting server", 0, 0, "")
+		if err := s.PerformServerAction(ctx, id, "start"); err != nil {
+			return models.Server{}, fmt.Errorf("failed to start server: %w", err)
+		}
+		return s.GetServerByID(ctx, id)
+	}
+	return updated, nil
+}
+
+// recreateContainer replaces a container with one running imageName, keeping its name, mounts, ports and limits.
+// A container already running imageName is kept.
+func (s *ServerService) recreateContainer(ctx context.Context, containerID, imageName string) (string, error) {
+	info, err := s.docker.InspectContainer(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	if info.Config.Image == imageName {
+		return containerID, nil
+	}
+	reportProgress(ctx, "recreating container", 0, 0, "")
+	if err := ensureImageExists(ctx, s.docker, imageName); err != nil {
+		return "", err
+	}
+
+	return s.replaceContainer(ctx, info, func(config *container.Config, _ *container.HostConfig) {
+		config.Image = imageName
+		// The environment and entrypoint in the inspected config come from the old image; let the new image supply its own.
+		config.Env = nil
+		config.Entrypoint = nil
+	})
+}
+
+// replaceContainer removes an inspected container and creates it again under the same name, from its
+// configuration as changed by update.
+func (s *ServerService) replaceContainer(ctx context.Context, info types.ContainerJSON, update func(config *container.Config, hostConfig *container.HostConfig)) (string, error) {
+	name := strings.TrimPrefix(info.Name, "/")
+	config, hostConfig := *info.Config, *info.HostConfig
+	update(&config, &hostConfig)
+
+	if err := s.docker.RemoveContainer(ctx, info.ID); err != nil && !client.IsErrNotFound(err) {
+		return "", err
+	}
+	resp, err := s.docker.CreateContainer(ctx, &config, &hostConfig, name)
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
 // DeleteServer stops, removes, and deletes a server.
-func (s *ServerService) DeleteServer(id string) error {
-	server, err := s.GetServerByID(id)
+func (s *ServerService) DeleteServer(ctx context.Context, id string) error {
+	// Keep going if the client disconnects; the work still belongs to the caller's trace.
+	ctx = context.WithoutCancel(ctx)
+
+	server, err := s.GetServerByID(ctx, id)
 	if err != nil {
 		return fmt.Errorf("could not find server to delete: %w", err)
 	}
 
-	ctx := context.Background()
-	log.Info().Str("container_id", server.DockerContainerID).Msg("Stopping and removing container")
+	log.Info().Ctx(ctx).Str("container_id", server.DockerContainerID).Msg("Stopping and removing container")
 	s.docker.StopContainer(ctx, server.DockerContainerID)
 	err = s.docker.RemoveContainer(ctx, server.DockerContainerID)
 	if err != nil && !client.IsErrNotFound(err) {
-		log.Warn().Err(err).Str("container_id", server.DockerContainerID).Msg("Could not remove container during server deletion")
+		log.Warn().Ctx(ctx).Err(err).Str("container_id", server.DockerContainerID).Msg("Could not remove container during server deletion")
 	}
 
-	log.Info().Str("server_id", id).Msg("Deleting server from database")
-	_, err = s.db.Exec("DELETE FROM servers WHERE id = ?", id)
+	log.Info().Ctx(ctx).Str("server_id", id).Msg("Deleting server from database")
+	_, err = s.db.ExecContext(ctx, "DELETE FROM servers WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete server from DB: %w", err)
 	}
 
-	log.Info().Str("data_path", server.DataPath).Msg("Deleting server data")
+	log.Info().Ctx(ctx).Str("data_path", server.DataPath).Msg("Deleting server data")
 	if err = os.RemoveAll(server.DataPath); err != nil {
-		log.Warn().Err(err).Str("data_path", server.DataPath).Msg("Failed to delete server data directory")
+		log.Warn().Ctx(ctx).Err(err).Str("data_path", server.DataPath).Msg("Failed to delete server data directory")
+	}
+	if err = s.fileHistory.DeleteServerHistory(ctx, id); err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", id).Msg("Failed to delete file history of server")
 	}
 
-	s.eventService.CreateEvent("server.delete", "warn", fmt.Sprintf("Server '%s' was permanently deleted.", server.Name), nil) // serverId won't exist anymore
+	s.eventService.CreateEvent(ctx, "server.delete", "warn", fmt.Sprintf("Server '%s' was permanently deleted.", server.Name), nil) // serverId won't exist anymore
 	s.hub.Broadcast <- []byte(`{"event": "server_deleted", "id": "` + id + `"}`)
+	s.hub.Publish(websocket.ServerTopic(id), "server_deleted", map[string]string{"id": id})
 	return nil
 }
 
 // pollForRconReady checks periodically if a server's RCON port is available.
 func (s *ServerService) pollForRconReady(ctx context.Context, server models.Server) {
-	log.Info().Str("server_id", server.ID).Msg("Starting RCON polling to check for server readiness.")
+	ctx, span := tracing.Start(ctx, "wait_for_rcon", serverAttr(server.ID))
+	defer span.End()
+
+	log.Info().Ctx(ctx).Str("server_id", server.ID).Msg("Starting RCON polling to check for server readiness.")
 
 	// Set a timeout for the entire polling process.
 	pollingCtx, cancel := context.WithTimeout(ctx, 3*time.Minute) // 3-minute timeout for the server to start
@@ -501,23 +733,25 @@ func (s *ServerService) pollForRconReady(ctx context.Context, server models.Serv
 		select {
 		case <-pollingCtx.Done():
 			// Polling timed out
-			log.Warn().Str("server_id", server.ID).Msg("RCON polling timed out. Server failed to start properly.")
+			log.Warn().Ctx(ctx).Str("server_id", server.ID).Msg("RCON polling timed out. Server failed to start properly.")
 			// Set the server status to offline as it failed to become ready
-			s.db.Exec("UPDATE servers SET status = ? WHERE id = ?", "offline", server.ID)
-			failedServer, err := s.GetServerByID(server.ID)
+			s.db.ExecContext(ctx, "UPDATE servers SET status = ? WHERE id = ?", "offline", server.ID)
+			failedServer, err := s.GetServerByID(ctx, server.ID)
 			if err == nil {
 				s.broadcastServerUpdate(failedServer)
 			}
-			s.eventService.CreateEvent("server.start.fail", "error", fmt.Sprintf("Server '%s' failed to become ready in time.", server.Name), &server.ID)
+			s.eventService.CreateEvent(ctx, "server.start.fail", "error", fmt.Sprintf("Server '%s' failed to become ready in time.", server.Name), &server.ID)
 			return
 
 		case <-ticker.C:
 			// Ensure container is still running before attempting to connect
-			containerInfo, err := s.docker.InspectContainer(context.Background(), server.DockerContainerID)
+			containerInfo, err := s.docker.InspectContainer(ctx, server.DockerContainerID)
 			if err != nil || !containerInfo.State.Running {
-				log.Warn().Err(err).Str("server_id", server.ID).Msg("Container stopped running during RCON polling. Marking as offline.")
-				s.db.Exec("UPDATE servers SET status = ? WHERE id = ?", "offline", server.ID)
-				stoppedServer, dbErr := s.GetServerByID(server.ID)
+				log.Warn().Ctx(ctx).Err(err).Str("server_id", server.ID).Msg("Container stopped running during RCON polling. Marking as offline.")
+				metrics.ObserveServerCrash(server.ID)
+				s.eventService.CreateEvent(ctx, "server.crash", "error", fmt.Sprintf("Server '%s' stopped unexpectedly while starting.", server.Name), &server.ID)
+				s.db.ExecContext(ctx, "UPDATE servers SET status = ? WHERE id = ?", "offline", server.ID)
+				stoppedServer, dbErr := s.GetServerByID(ctx, server.ID)
 				if dbErr == nil {
 					s.broadcastServerUpdate(stoppedServer)
 				}
@@ -526,53 +760,59 @@ func (s *ServerService) pollForRconReady(ctx context.Context, server models.Serv
 
 			rconPortBinding, ok := containerInfo.NetworkSettings.Ports[RCONPort+"/tcp"]
 			if !ok || len(rconPortBinding) == 0 {
-				log.Warn().Str("server_id", server.ID).Msg("RCON port not bound, cannot poll for readiness.")
+				log.Warn().Ctx(ctx).Str("server_id", server.ID).Msg("RCON port not bound, cannot poll for readiness.")
 				return
 			}
 			rconAddr := "127.0.0.1:" + rconPortBinding[0].HostPort
 
-			conn, err := rcon.Dial(rconAddr, server.RCONPassword)
+			conn, err := dialRcon(ctx, rconAddr, server.RCONPassword)
 			if err == nil {
 				// Success! The server is ready.
 				conn.Close()
-				log.Info().Str("server_id", server.ID).Msg("RCON connection successful. Server is now online.")
+				log.Info().Ctx(ctx).Str("server_id", server.ID).Msg("RCON connection successful. Server is now online.")
 
 				// Update status to online in the DB
-				_, err := s.db.Exec("UPDATE servers SET status = ? WHERE id = ?", "online", server.ID)
+				_, err := s.db.ExecContext(ctx, "UPDATE servers SET status = ? WHERE id = ?", "online", server.ID)
 				if err != nil {
-					log.Error().Err(err).Str("server_id", server.ID).Msg("Failed to update server status to online after successful RCON poll.")
+					log.Error().Ctx(ctx).Err(err).Str("server_id", server.ID).Msg("Failed to update server status to online after successful RCON poll.")
 					return
 				}
 
 				// Fetch the latest server state and broadcast it
-				onlineServer, err := s.GetServerByID(server.ID)
+				onlineServer, err := s.GetServerByID(ctx, server.ID)
 				if err == nil {
 					s.broadcastServerUpdate(onlineServer)
-					s.eventService.CreateEvent("server.start.ready", "info", fmt.Sprintf("Server '%s' is fully loaded and online.", server.Name), &server.ID)
+					s.eventService.CreateEvent(ctx, "server.start.ready", "info", fmt.Sprintf("Server '%s' is fully loaded and online.", server.Name), &server.ID)
 				}
 				return // Stop polling
 			}
 			// If we are here, RCON connection failed, we'll try again on the next tick.
-			log.Info().Str("server_id", server.ID).Str("rcon_addr", rconAddr).Msg("RCON ping failed, server not ready yet. Retrying...")
+			log.Info().Ctx(ctx).Str("server_id", server.ID).Str("rcon_addr", rconAddr).Msg("RCON ping failed, server not ready yet. Retrying...")
 		}
 	}
 }
 
 // PerformServerAction handles start, stop, restart.
-func (s *ServerService) PerformServerAction(id, action string) error {
-	server, err := s.GetServerByID(id)
+func (s *ServerService) PerformServerAction(ctx context.Context, id, action string) error {
+	// Keep going if the client disconnects; the work still belongs to the caller's trace.
+	ctx = context.WithoutCancel(ctx)
+
+	server, err := s.GetServerByID(ctx, id)
 	if err != nil {
 		return fmt.Errorf("could not find server in DB: %w", err)
 	}
 
 	var newStatus, eventLevel, eventMessage string
 	var startPolling bool
-	ctx := context.Background()
-	logCtx := log.Info().Str("server_id", id).Str("container_id", server.DockerContainerID).Str("action", action)
+	logCtx := log.Info().Ctx(ctx).Str("server_id", id).Str("container_id", server.DockerContainerID).Str("action", action)
 
 	switch action {
 	case "start":
 		logCtx.Msg("Starting container")
+		server.DockerContainerID, err = s.ensureConsoleInput(ctx, server)
+		if err != nil {
+			return err
+		}
 		if err := s.docker.StartContainer(ctx, server.DockerContainerID); err != nil {
 			return err
 		}
@@ -597,39 +837,45 @@ func (s *ServerService) PerformServerAction(id, action string) error {
 		eventLevel = "info"
 		eventMessage = fmt.Sprintf("Server '%s' is restarting.", server.Name)
 		startPolling = true
+		metrics.ObserveServerRestart(id)
 	default:
 		return fmt.Errorf("unknown action: %s", action)
 	}
 
-	_, err = s.db.Exec("UPDATE servers SET status = ? WHERE id = ?", newStatus, id)
+	_, err = s.db.ExecContext(ctx, "UPDATE servers SET status = ? WHERE id = ?", newStatus, id)
 	if err != nil {
 		return fmt.Errorf("failed to update server status in DB: %w", err)
 	}
 
-	updatedServer, _ := s.GetServerByID(id)
+	updatedServer, _ := s.GetServerByID(ctx, id)
 	updatedServer.Status = newStatus
 	s.broadcastServerUpdate(updatedServer)
 
-	s.eventService.CreateEvent("server."+action, eventLevel, eventMessage, &id)
+	s.eventService.CreateEvent(ctx, "server."+action, eventLevel, eventMessage, &id)
 
 	if startPolling {
 		// Run the RCON polling in the background to not block the API response.
-		go s.pollForRconReady(context.Background(), updatedServer)
+		go s.pollForRconReady(context.WithoutCancel(ctx), updatedServer)
 	}
 
 	return nil
 }
 
 // UpdateServerStats updates the resource usage for a server and broadcasts it.
-func (s *ServerService) UpdateServerStats(server models.Server) error {
-	tx, err := s.db.Begin()
+func (s *ServerService) UpdateServerStats(ctx context.Context, server models.Server) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	var previousStatus string
+	if err := tx.QueryRowContext(ctx, "SELECT status FROM servers WHERE id = ?", server.ID).Scan(&previousStatus); err != nil {
+		return err
+	}
+
 	// Update the main servers table
-	_, err = tx.Exec(`
+	_, err = tx.ExecContext(ctx, `
 	UPDATE servers
 	SET status = ?, players_current = ?, cpu_usage = ?, ram_usage = ?, storage_usage = ?
 	WHERE id = ?`,
@@ -638,11 +884,13 @@ func (s *ServerService) UpdateServerStats(server models.Server) error {
 		return err
 	}
 
-	// Insert into history table
-	_, err = tx.Exec(`
-	INSERT INTO resource_history (server_id, cpu_usage, ram_usage, players_current)
-	VALUES (?, ?, ?, ?)`,
-		server.ID, server.Resources.CPU, server.Resources.RAM, server.Players.Current)
+	// Insert into history table, carrying along the last sampled game metrics
+	game := newGameMetricsRow(server.Resources.Game)
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO resource_history (server_id, cpu_usage, ram_usage, players_current, tps, mspt, heap_used_mb, heap_max_mb, gc_count, gc_time_ms)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		server.ID, server.Resources.CPU, server.Resources.RAM, server.Players.Current,
+		game.tps, game.mspt, game.heapUsedMB, game.heapMaxMB, game.gcCount, game.gcTimeMs)
 	if err != nil {
 		return err
 	}
@@ -651,73 +899,128 @@ func (s *ServerService) UpdateServerStats(server models.Server) error {
 		return err
 	}
 
-	s.broadcastServerUpdate(server)
+	s.broadcastServerStats(server, server.Status != previousStatus)
 	return nil
 }
 
-// SendCommandToServer sends a command to a running Minecraft server via RCON.
-func (s *ServerService) SendCommandToServer(serverID, command string) (string, error) {
-	server, err := s.GetServerByID(serverID)
+// UpdateServerGameMetrics stores the latest TPS/MSPT/JVM sample for a server.
+// Passing nil clears the stored sample, e.g. when the server goes offline.
+func (s *ServerService) UpdateServerGameMetrics(ctx context.Context, serverID string, metrics *models.GameMetrics) error {
+	game := newGameMetricsRow(metrics)
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE servers
+	SET tps = ?, mspt = ?, heap_used_mb = ?, heap_max_mb = ?, gc_count = ?, gc_time_ms = ?, metrics_source = ?
+	WHERE id = ?`,
+		game.tps, game.mspt, game.heapUsedMB, game.heapMaxMB, game.gcCount, game.gcTimeMs, game.source, serverID)
+	return err
+}
+
+// SendCommandToServer sends a command to a running Minecraft server via RCON, or through its console while
+// RCON is unavailable, such as during startup. It is for commands a user sends; the console's reply is only
+// what the server happens to log, so automated callers use SendRconCommand.
+func (s *ServerService) SendCommandToServer(ctx context.Context, serverID, command string) (string, error) {
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
 		return "", err
 	}
 
-	if server.Status != "online" {
-		return "", fmt.Errorf("server is not online")
+	switch server.Status {
+	case "online":
+	case "starting":
+		// RCON only comes up once the server has finished starting.
+		return s.sendConsoleCommand(ctx, server, command)
+	default:
+		return "", fmt.Errorf("server is not running")
 	}
 
-	containerInfo, err := s.docker.InspectContainer(context.Background(), server.DockerContainerID)
+	conn, err := s.dialServerRcon(ctx, server)
 	if err != nil {
-		return "", fmt.Errorf("could not inspect container: %w", err)
-	}
-
-	rconPortBinding, ok := containerInfo.NetworkSettings.Ports["25565/tcp"]
-	if !ok || len(rconPortBinding) == 0 {
-		return "", fmt.Errorf("rcon port not bound for server %s", serverID)
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("RCON unavailable, sending command to the console instead")
+		response, consoleErr := s.sendConsoleCommand(ctx, server, command)
+		if consoleErr != nil {
+			return "", fmt.Errorf("%w; console: %w", err, consoleErr)
+		}
+		return response, nil
 	}
-	rconAddr := "127.0.0.1:" + rconPortBinding[0].HostPort
+	defer conn.Close()
 
-	var conn *rcon.Conn
-	var dialErr error
+	// A command that reached RCON isn't retried on the console, so it can't run twice.
+	return executeRcon(ctx, conn, server, command)
+}
 
-	for i := 0; i < 3; i++ {
-		conn, dialErr = rcon.Dial(rconAddr, server.RCONPassword)
-		if dialErr == nil {
-			break // Success
-		}
-		log.Warn().Err(dialErr).Str("server_id", serverID).Int("attempt", i+1).Msg("RCON connection attempt failed, retrying...")
-		time.Sleep(2 * time.Second)
+// SendRconCommand sends a command to an online Minecraft server via RCON only, failing if RCON is unavailable.
+// It is for automated commands that must not end up typed into the console, where nothing confirms they ran.
+func (s *ServerService) SendRconCommand(ctx context.Context, serverID, command string) (string, error) {
+	server, err := s.GetServerByID(ctx, serverID)
+	if err != nil {
+		return "", err
+	}
+	if server.Status != "online" {
+		return "", fmt.Errorf("server is not online")
 	}
 
-	if dialErr != nil {
-		return "", fmt.Errorf("could not connect via rcon after multiple attempts: %w", dialErr)
+	conn, err := s.dialServerRcon(ctx, server)
+	if err != nil {
+		return "", err
 	}
 	defer conn.Close()
+	return executeRcon(ctx, conn, server, command)
+}
 
+// executeRcon runs a command over an RCON connection inside its own span.
+func executeRcon(ctx context.Context, conn *rcon.Conn, server models.Server, command string) (string, error) {
+	_, execSpan := tracing.Start(ctx, "rcon.execute")
 	response, err := conn.Execute(command)
+	tracing.End(execSpan, err)
 	if err != nil {
 		return "", fmt.Errorf("rcon command failed: %w", err)
 	}
 
-	log.Info().Str("command", command).Str("server_name", server.Name).Str("response", response).Msg("RCON command executed")
+	log.Info().Ctx(ctx).Str("command", command).Str("server_name", server.Name).Str("response", response).Msg("RCON command executed")
 	// The response is now returned directly to the handler, not broadcast from here.
 	return response, nil
 }
 
-// StreamServerLogs streams the logs of a container to a websocket client.
-func (s *ServerService) StreamServerLogs(ctx context.Context, serverID string, sendChan chan []byte) {
-	server, err := s.GetServerByID(serverID)
+// dialServerRcon connects to a server's RCON port.
+func (s *ServerService) dialServerRcon(ctx context.Context, server models.Server) (*rcon.Conn, error) {
+	containerInfo, err := s.docker.InspectContainer(ctx, server.DockerContainerID)
 	if err != nil {
-		log.Warn().Err(err).Str("server_id", serverID).Msg("Cannot stream logs, server not found")
-		sendChan <- websocket.NewErrorMessage(err.Error())
+		return nil, fmt.Errorf("could not inspect container: %w", err)
+	}
+
+	rconPortBinding, ok := containerInfo.NetworkSettings.Ports[RCONPort+"/tcp"]
+	if !ok || len(rconPortBinding) == 0 {
+		return nil, fmt.Errorf("rcon port not bound for server %s", server.ID)
+	}
+	conn, err := dialRcon(ctx, "127.0.0.1:"+rconPortBinding[0].HostPort, server.RCONPassword)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect via rcon: %w", err)
+	}
+	return conn, nil
+}
+
+// dialRcon opens an RCON connection inside its own span.
+func dialRcon(ctx context.Context, addr, password string) (conn *rcon.Conn, err error) {
+	_, span := tracing.Start(ctx, "rcon.dial", attribute.String("rcon.addr", addr))
+	defer func() { tracing.End(span, err) }()
+	return rcon.Dial(addr, password)
+}
+
+// StreamServerLogs streams the logs of a container to a websocket client through send, until ctx is cancelled.
+// send must not block, so a slow client can't hold up the log reader.
+func (s *ServerService) StreamServerLogs(ctx context.Context, serverID string, send func(message []byte)) {
+	server, err := s.GetServerByID(ctx, serverID)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("Cannot stream logs, server not found")
+		send(websocket.NewErrorMessage(err.Error()))
 		return
 	}
 
 	logReader, err := s.docker.GetContainerLogs(ctx, server.DockerContainerID, true)
 	if err != nil {
 		if !errors.Is(err, context.Canceled) {
-			log.Error().Err(err).Str("server_id", serverID).Msg("Failed to get container logs")
-			sendChan <- websocket.NewErrorMessage("Failed to get container logs: " + err.Error())
+			log.Error().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("Failed to get container logs")
+			send(websocket.NewErrorMessage("Failed to get container logs: " + err.Error()))
 		}
 		return
 	}
@@ -733,21 +1036,17 @@ func (s *ServerService) StreamServerLogs(ctx context.Context, serverID string, s
 			lineBytes = lineBytes[8:]
 		}
 
-		// Create a structured message
-		logMsg := websocket.NewConsoleOutputMessage("docker", "", string(lineBytes))
-
-		select {
-		case <-ctx.Done(): // This context is cancelled by the handler when the client unsubscribes or disconnects.
-			log.Info().Str("server_id", serverID).Msg("Client disconnected, stopping log stream.")
+		// This context is cancelled by the handler when the client unsubscribes or disconnects.
+		if ctx.Err() != nil {
+			log.Info().Ctx(ctx).Str("server_id", serverID).Msg("Client disconnected, stopping log stream.")
 			return
-		case sendChan <- logMsg:
-			// Message was successfully sent
 		}
+		send(websocket.NewConsoleOutputMessage("docker", "", string(lineBytes)))
 	}
 
 	if err := scanner.Err(); err != nil {
 		if !errors.Is(err, context.Canceled) {
-			log.Error().Err(err).Str("server_id", serverID).Msg("Error reading logs from container")
+			log.Error().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("Error reading logs from container")
 		}
 	}
 }
@@ -764,6 +1063,40 @@ func (s *ServerService) broadcastServerUpdate(server models.Server) {
 		return
 	}
 	s.hub.Broadcast <- jsonMsg
+	s.hub.Publish(websocket.ServerTopic(server.ID), "server_update", server)
+}
+
+// broadcastServerStats sends a server's latest resource sample to websocket clients. Clients of the first
+// protocol version get it as a regular update; others get it on the stats topic, and on the server's own
+// topic too if the sample changed its status.
+func (s *ServerService) broadcastServerStats(server models.Server, statusChanged bool) {
+	if statusChanged {
+		s.broadcastServerUpdate(server)
+	} else {
+		jsonMsg, err := json.Marshal(websocket.Message{Action: "server_update", Payload: server})
+		if err != nil {
+			log.Error().Err(err).Msg("Error marshalling server update for broadcast")
+			return
+		}
+		s.hub.Broadcast <- jsonMsg
+	}
+	s.hub.PublishLive(websocket.ServerStatsTopic(server.ID), "server_stats", server)
+}
+
+// findServerPorts picks free host ports for the game and RCON listeners.
+func findServerPorts(ctx context.Context) (gamePort, rconPort int, err error) {
+	_, span := tracing.Start(ctx, "find_available_ports")
+	defer func() { tracing.End(span, err) }()
+
+	gamePort, err = FindAvailablePort(25565)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find an available game port: %w", err)
+	}
+	rconPort, err = FindAvailablePort(25575)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find an available RCON port: %w", err)
+	}
+	return gamePort, rconPort, nil
 }
 
 // findAvailablePort starts from a base port and finds the next available TCP port.
@@ -778,8 +1111,8 @@ func FindAvailablePort(startPort int) (int, error) {
 	}
 	return 0, fmt.Errorf("no available ports found")
 }
-func (s *ServerService) GetDashboardStatistics() (models.DashboardStats, error) {
-	servers, err := s.GetAllServers()
+func (s *ServerService) GetDashboardStatistics(ctx context.Context) (models.DashboardStats, error) {
+	servers, err := s.GetAllServers(ctx)
 	if err != nil {
 		return models.DashboardStats{}, err
 	}
@@ -800,58 +1133,21 @@ func (s *ServerService) GetDashboardStatistics() (models.DashboardStats, error)
 
 	stats.SystemHealth = 99.5
 
-	rows, err := s.db.Query(`
-    SELECT timestamp, SUM(cpu_usage) as total_cpu, SUM(ram_usage) as total_ram, SUM(players_current) as total_players
-    FROM resource_history
-    WHERE timestamp >= ?
-    GROUP BY strftime('%Y-%m-%d %H', timestamp)
-    ORDER BY timestamp ASC
-`, time.Now().Add(-24*time.Hour))
-
+	now := time.Now()
+	history, err := queryAggregateHistory(ctx, s.db, now.Add(-24*time.Hour), now, time.Hour)
 	if err != nil {
 		return stats, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var dp models.ResourceDataPoint
-		var totalCPU, totalRAM sql.NullFloat64
-		var totalPlayers sql.NullInt64
-		if err := rows.Scan(&dp.Timestamp, &totalCPU, &totalRAM, &totalPlayers); err != nil {
-			return stats, err
-		}
-		dp.CPUUsage = totalCPU.Float64
-		dp.RAMUsage = totalRAM.Float64
-		dp.PlayersCurrent = totalPlayers
-		stats.ResourceHistory = append(stats.ResourceHistory, dp)
-	}
+	stats.ResourceHistory = history
 	stats.PlayerHistory = stats.ResourceHistory
 
 	return stats, nil
 }
 
-// GetResourceHistory gets recent resource usage for a specific server.
-func (s ServerService) GetResourceHistory(serverID string) ([]models.ResourceDataPoint, error) {
-	rows, err := s.db.Query("SELECT timestamp, cpu_usage, ram_usage, players_current FROM resource_history WHERE server_id = ? AND timestamp >= ? ORDER BY timestamp ASC", serverID, time.Now().Add(-30*time.Minute))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var history []models.ResourceDataPoint
-	for rows.Next() {
-		var dp models.ResourceDataPoint
-		if err := rows.Scan(&dp.Timestamp, &dp.CPUUsage, &dp.RAMUsage, &dp.PlayersCurrent); err != nil {
-			return nil, err
-		}
-		history = append(history, dp)
-	}
-	return history, nil
-}
-
 // GetOnlinePlayers retrieves a list of players currently on the server.
-func (s *ServerService) GetOnlinePlayers(serverID string) ([]models.OnlinePlayer, error) {
-	response, err := s.SendCommandToServer(serverID, "list")
+func (s *ServerService) GetOnlinePlayers(ctx context.Context, serverID string) ([]models.OnlinePlayer, error) {
+	// The reply is parsed, so it has to be RCON's rather than whatever the console logs.
+	response, err := s.SendRconCommand(ctx, serverID, "list")
 	if err != nil {
 		return nil, err
 	}
@@ -876,7 +1172,7 @@ func (s *ServerService) GetOnlinePlayers(serverID string) ([]models.OnlinePlayer
 }
 
 // ManagePlayer executes a player management command (kick, ban, etc.).
-func (s *ServerService) ManagePlayer(serverID, action, playerName, reason string) error {
+func (s *ServerService) ManagePlayer(ctx context.Context, serverID, action, playerName, reason string) error {
 	var command string
 	switch action {
 	case "kick":
@@ -887,27 +1183,27 @@ func (s *ServerService) ManagePlayer(serverID, action, playerName, reason string
 		return fmt.Errorf("unsupported player action: %s", action)
 	}
 
-	_, err := s.SendCommandToServer(serverID, command)
+	_, err := s.SendCommandToServer(ctx, serverID, command)
 	if err == nil {
 		msg := fmt.Sprintf("Player '%s' was %sed.", playerName, action)
-		s.eventService.CreateEvent("player."+action, "info", msg, &serverID)
+		s.eventService.CreateEvent(ctx, "player."+action, "info", msg, &serverID)
 	}
 	return err
 }
 
 // ListFiles lists files and directories for a server.
-func (s *ServerService) ListFiles(serverID, path string) ([]models.FileInfo, error) {
-	server, err := s.GetServerByID(serverID)
+func (s *ServerService) ListFiles(ctx context.Context, serverID, path string) ([]models.FileInfo, error) {
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
 		return nil, err
 	}
-
-	fullPath := filepath.Join(server.DataPath, path)
-	if !strings.HasPrefix(fullPath, server.DataPath) {
-		return nil, fmt.Errorf("invalid path: access denied")
+	root, err := openDataDir(server)
+	if err != nil {
+		return nil, err
 	}
+	defer root.Close()
 
-	dirEntries, err := os.ReadDir(fullPath)
+	dirEntries, err := root.ReadDir(path)
 	if err != nil {
 		return nil, fmt.Errorf("could not read directory: %w", err)
 	}
@@ -916,53 +1212,75 @@ func (s *ServerService) ListFiles(serverID, path string) ([]models.FileInfo, err
 	for _, entry := range dirEntries {
 		info, err := entry.Info()
 		if err != nil {
-			log.Warn().Err(err).Str("file_name", entry.Name()).Msg("Could not get file info during file listing")
+			log.Warn().Ctx(ctx).Err(err).Str("file_name", entry.Name()).Msg("Could not get file info during file listing")
 			continue
 		}
-		fileInfos = append(fileInfos, models.FileInfo{
-			Name:     entry.Name(),
-			Size:     info.Size(),
-			IsDir:    entry.IsDir(),
-			Modified: info.ModTime(),
-		})
+		fileInfos = append(fileInfos, fileInfo(info))
 	}
 
 	return fileInfos, nil
 }
 
 // GetFileContent reads the content of a file.
-func (s *ServerService) GetFileContent(serverID, path string) ([]byte, error) {
-	server, err := s.GetServerByID(serverID)
+func (s *ServerService) GetFileContent(ctx context.Context, serverID, path string) ([]byte, error) {
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
 		return nil, err
 	}
-
-	fullPath := filepath.Join(server.DataPath, path)
-	if !strings.HasPrefix(fullPath, server.DataPath) {
-		return nil, fmt.Errorf("invalid path: access denied")
+	root, err := openDataDir(server)
+	if err != nil {
+		return nil, err
 	}
+	defer root.Close()
 
-	return os.ReadFile(fullPath)
+	return root.ReadFile(path)
 }
 
-// UpdateFileContent writes new content to a file.
-func (s *ServerService) UpdateFileContent(serverID, path string, content []byte) error {
-	server, err := s.GetServerByID(serverID)
+// UpdateFileContent writes new content to a file, keeping the permissions of the file it replaces. The
+// previous content is kept in the file's history. Config files are refused with a ConfigValidationError if
+// they don't parse or, with checkSchema, if a bundled schema finds settings the server would reject.
+func (s *ServerService) UpdateFileContent(ctx context.Context, serverID, path string, content []byte, checkSchema bool) error {
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
 		return err
 	}
+	if issues := configcheck.Check(path, content, checkSchema); len(issues) > 0 {
+		return &ConfigValidationError{Path: sandbox.Clean(path), Issues: issues}
+	}
+	root, err := openDataDir(server)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := s.fileHistory.Snapshot(ctx, server.ID, root, path); err != nil {
+		return err
+	}
+	if err := root.WriteFile(path, content, 0644); err != nil {
+		return err
+	}
 
-	fullPath := filepath.Join(server.DataPath, path)
-	if !strings.HasPrefix(fullPath, server.DataPath) {
-		return fmt.Errorf("invalid path: access denied")
+	msg := fmt.Sprintf("'%s' was edited on server '%s'", sandbox.Clean(path), server.Name)
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		msg += " by " + claims.Username
 	}
+	s.eventService.CreateEvent(ctx, "file.update", "info", msg+".", &serverID)
+	return nil
+}
 
-	return os.WriteFile(fullPath, content, 0644)
+// writeServerFile replaces a file in a server's data directory. The content is written next to the file
+// first, so the file is never left half written.
+func writeServerFile(server models.Server, path string, content []byte) error {
+	root, err := openDataDir(server)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.WriteFile(path, content, 0644)
 }
 
 // GetServerSettings reads and parses the server.properties file.
-func (s *ServerService) GetServerSettings(serverID string) (models.ServerSettings, error) {
-	content, err := s.GetFileContent(serverID, "server.properties")
+func (s *ServerService) GetServerSettings(ctx context.Context, serverID string) (models.ServerSettings, error) {
+	content, err := s.GetFileContent(ctx, serverID, "server.properties")
 	if err != nil {
 		if os.IsNotExist(err) {
 			return make(models.ServerSettings), nil
@@ -988,7 +1306,7 @@ func (s *ServerService) GetServerSettings(serverID string) (models.ServerSetting
 }
 
 // UpdateServerSettings writes new settings to server.properties and restarts the server.
-func (s *ServerService) UpdateServerSettings(serverID string, settings models.ServerSettings) error {
+func (s *ServerService) UpdateServerSettings(ctx context.Context, serverID string, settings models.ServerSettings) error {
 	var builder strings.Builder
 	builder.WriteString("# Minecraft server properties\n")
 	builder.WriteString(fmt.Sprintf("# Updated on %s\n", time.Now().Format(time.RFC1123)))
@@ -996,28 +1314,113 @@ func (s *ServerService) UpdateServerSettings(serverID string, settings models.Se
 		builder.WriteString(fmt.Sprintf("%s=%s\n", key, value))
 	}
 
-	err := s.UpdateFileContent(serverID, "server.properties", []byte(builder.String()))
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
+		return err
+	}
+	if err := writeServerFile(server, "server.properties", []byte(builder.String())); err != nil {
 		return fmt.Errorf("failed to write to server.properties: %w", err)
 	}
 
-	server, _ := s.GetServerByID(serverID)
 	msg := fmt.Sprintf("Settings for server '%s' were updated. Restart is in progress.", server.Name)
-	s.eventService.CreateEvent("server.settings.update", "info", msg, &serverID)
+	s.eventService.CreateEvent(ctx, "server.settings.update", "info", msg, &serverID)
+
+	return s.PerformServerAction(ctx, serverID, "restart")
+}
+
+// JVMConfig is a server's JVM settings together with the arguments java is started with.
+type JVMConfig struct {
+	models.JVMSettings
+	Managed bool     `json:"managed"` // False for servers started by their own script, whose flags can't be set
+	Args    []string `json:"args,omitempty"`
+}
+
+// GetJVMConfig returns a server's JVM settings and the arguments they expand to.
+func (s *ServerService) GetJVMConfig(ctx context.Context, serverID string) (JVMConfig, error) {
+	server, err := s.GetServerByID(ctx, serverID)
+	if err != nil {
+		return JVMConfig{}, err
+	}
+	return jvmConfig(server)
+}
 
-	return s.PerformServerAction(serverID, "restart")
+func jvmConfig(server models.Server) (JVMConfig, error) {
+	launch, err := jvm.ReadLaunch(server.DataPath)
+	if err != nil {
+		return JVMConfig{}, err
+	}
+	config := JVMConfig{JVMSettings: server.JVM, Managed: launch.Managed()}
+	if config.Managed {
+		// Settings saved before a runtime change may no longer validate; report them without arguments.
+		config.Args, _ = jvm.Args(server.JVM, server.MaxMemoryMB, javaMajor(server.JavaVersion))
+	}
+	return config, nil
+}
+
+// UpdateJVMSettings saves a server's JVM settings and regenerates its start script from them.
+// A running server is restarted to apply them.
+func (s *ServerService) UpdateJVMSettings(ctx context.Context, serverID string, settings models.JVMSettings) (JVMConfig, error) {
+	server, err := s.GetServerByID(ctx, serverID)
+	if err != nil {
+		return JVMConfig{}, err
+	}
+	launch, err := jvm.ReadLaunch(server.DataPath)
+	if err != nil {
+		return JVMConfig{}, err
+	}
+	if err := jvm.WriteStartScript(server.DataPath, launch, settings, server.MaxMemoryMB, javaMajor(server.JavaVersion)); err != nil {
+		return JVMConfig{}, err
+	}
+	if err := s.saveJVMSettings(ctx, serverID, settings); err != nil {
+		return JVMConfig{}, err
+	}
+
+	server.JVM = settings
+	msg := fmt.Sprintf("JVM settings of server '%s' were changed to the %s profile.", server.Name, settings.Profile)
+	if server.Status != "offline" {
+		msg += " Restart is in progress."
+	}
+	s.eventService.CreateEvent(ctx, "server.jvm.update", "info", msg, &serverID)
+	if server.Status != "offline" {
+		if err := s.PerformServerAction(ctx, serverID, "restart"); err != nil {
+			return JVMConfig{}, err
+		}
+	}
+	return jvmConfig(server)
+}
+
+func (s *ServerService) saveJVMSettings(ctx context.Context, serverID string, settings models.JVMSettings) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE servers SET jvm_settings_json = ? WHERE id = ?", jvmSettingsJSON(settings), serverID); err != nil {
+		return fmt.Errorf("failed to save JVM settings: %w", err)
+	}
+	return nil
+}
+
+func jvmSettingsJSON(settings models.JVMSettings) string {
+	settingsJSON, _ := json.Marshal(settings)
+	return string(settingsJSON)
+}
+
+// parseJVMSettings reads the stored JVM settings of a server. Servers created before JVM profiles
+// were started with the heap size alone, which is the minimal profile.
+func parseJVMSettings(stored sql.NullString) models.JVMSettings {
+	settings := models.JVMSettings{Profile: jvm.Minimal}
+	if stored.String != "" {
+		json.Unmarshal([]byte(stored.String), &settings)
+	}
+	return settings
 }
 
 // GetSystemResourceStats calculates total and allocated RAM.
-func (s *ServerService) GetSystemResourceStats() (map[string]int, error) {
+func (s *ServerService) GetSystemResourceStats(ctx context.Context) (map[string]int, error) {
 	vmStat, err := mem.VirtualMemory()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to retrieve system memory stats")
+		log.Error().Ctx(ctx).Err(err).Msg("Failed to retrieve system memory stats")
 		return nil, fmt.Errorf("could not retrieve system memory stats: %w", err)
 	}
 	totalRAM := int(vmStat.Total / 1024 / 1024) // Convert from bytes to MB
 
-	servers, err := s.GetAllServers()
+	servers, err := s.GetAllServers(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -1039,40 +1442,31 @@ func (s *ServerService) GetSystemResourceStats() (map[string]int, error) {
 // --- Helper Functions ---
 
 // provisionServerFilesFromUpload creates the essential files for a server from an upload.
-func (s *ServerService) provisionServerFilesFromUpload(dataPath, serverExecutable string, maxMemoryMB int) error {
+func (s *ServerService) provisionServerFilesFromUpload(dataDir *sandbox.Root, serverExecutable string, settings models.JVMSettings, maxMemoryMB, javaVersion int) error {
 	// 1. Create or overwrite eula.txt to ensure it's accepted.
-	eulaPath := filepath.Join(dataPath, "eula.txt")
-	if err := os.WriteFile(eulaPath, []byte("eula=true\n"), 0644); err != nil {
+	if err := dataDir.WriteFile("eula.txt", []byte("eula=true\n"), 0644); err != nil {
 		return fmt.Errorf("failed to write eula.txt: %w", err)
 	}
 
 	// 2. Create start.sh with logic to handle .sh or .jar files
-	var startScriptContent string
-	if strings.HasSuffix(strings.ToLower(serverExecutable), ".sh") {
-		// It's a shell script, execute it directly.
-		startScriptContent = fmt.Sprintf(
-			`#!/bin/sh
+	if !strings.HasSuffix(strings.ToLower(serverExecutable), ".sh") {
+		// Assume it's a jar file, started with the server's JVM profile.
+		return jvm.WriteStartScript(dataDir.Dir(), jvm.Launch{Jar: serverExecutable}, settings, maxMemoryMB, javaVersion)
+	}
+
+	// It's a shell script, execute it directly.
+	startScriptContent := fmt.Sprintf(
+		`#!/bin/sh
 # Make sure the user's script is executable
 chmod +x ./%s
 # Execute the user's start script
 ./%s
 `,
-			serverExecutable,
-			serverExecutable,
-		)
-	} else {
-		// Assume it's a jar file, using the new memory logic.
-		startScriptContent = fmt.Sprintf(
-			`#!/bin/sh
-java -Xmx%dM -Xms1024M -jar %s nogui
-`,
-			maxMemoryMB,
-			serverExecutable,
-		)
-	}
+		serverExecutable,
+		serverExecutable,
+	)
 
-	startScriptPath := filepath.Join(dataPath, "start.sh")
-	if err := os.WriteFile(startScriptPath, []byte(startScriptContent), 0755); err != nil {
+	if err := dataDir.WriteFile("start.sh", []byte(startScriptContent), 0755); err != nil {
 		return fmt.Errorf("failed to write start.sh: %w", err)
 	}
 
@@ -1080,120 +1474,166 @@ java -Xmx%dM -Xms1024M -jar %s nogui
 }
 
 // ensureImageExists pulls a docker image if it's not present locally.
-func (s *ServerService) ensureImageExists(ctx context.Context, imageName string) error {
-	_, _, err := s.docker.ImageInspectWithRaw(ctx, imageName)
+func ensureImageExists(ctx context.Context, dockerClient *docker.Client, imageName string) (err error) {
+	ctx, span := tracing.Start(ctx, "ensure_image", attribute.String("container.image", imageName))
+	defer func() { tracing.End(span, err) }()
+
+	_, _, err = dockerClient.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		if client.IsErrNotFound(err) {
-			log.Info().Str("image", imageName).Msg("Image not found locally. Pulling from Docker Hub...")
-			puller, pullErr := s.docker.ImagePull(ctx, imageName, image.PullOptions{})
+			log.Info().Ctx(ctx).Str("image", imageName).Msg("Image not found locally. Pulling from Docker Hub...")
+			puller, pullErr := dockerClient.ImagePull(ctx, imageName, image.PullOptions{})
 			if pullErr != nil {
 				return fmt.Errorf("failed to start image pull for '%s': %w", imageName, pullErr)
 			}
 			defer puller.Close()
-			// Stream pull progress to logs
-			if _, err := io.Copy(os.Stdout, puller); err != nil {
-				log.Warn().Err(err).Msg("Could not stream docker pull output")
+			if err := followImagePull(ctx, puller); err != nil {
+				return fmt.Errorf("failed to pull image '%s': %w", imageName, err)
 			}
-			log.Info().Str("image", imageName).Msg("Image pulled successfully.")
+			span.SetAttributes(attribute.Bool("container.image_pulled", true))
+			log.Info().Ctx(ctx).Str("image", imageName).Msg("Image pulled successfully.")
+			return nil
 		} else {
 			return fmt.Errorf("failed to inspect docker image '%s': %w", imageName, err)
 		}
 	} else {
-		log.Info().Str("image", imageName).Msg("Image found locally.")
+		log.Info().Ctx(ctx).Str("image", imageName).Msg("Image found locally.")
 	}
 	return nil
 }
 
-// ensureRconInProperties reads a server.properties file, ensures RCON is configured, and writes it back.
-func (s *ServerService) ensureRconInProperties(filePath, rconPassword string) {
-	props := make(map[string]string)
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Warn().Err(err).Str("path", filePath).Msg("Cannot open server.properties to ensure RCON, creating a new one.")
-	} else {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "#") || !strings.Contains(line, "=") {
-				continue
-			}
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				props[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+// followImagePull reads the JSON progress stream of an image pull until it ends and reports
+// the bytes downloaded so far across all layers that are not already present.
+func followImagePull(ctx context.Context, r io.Reader) error {
+	type layer struct{ current, total int64 }
+	layers := make(map[string]*layer)
+	decoder := json.NewDecoder(r)
+	for {
+		var msg struct {
+			ID             string `json:"id"`
+			Status         string `json:"status"`
+			Error          string `json:"error"`
+			ProgressDetail struct {
+				Current int64 `json:"current"`
+				Total   int64 `json:"total"`
+			} `json:"progressDetail"`
+		}
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
 			}
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		if msg.ID == "" || strings.HasPrefix(msg.Status, "Pulling from") {
+			continue
 		}
-		file.Close()
-	}
 
-	// Set/overwrite RCON properties
-	props["enable-rcon"] = "true"
-	props["rcon.password"] = rconPassword
-	props["rcon.port"] = RCONPort
+		l := layers[msg.ID]
+		switch {
+		case msg.Status == "Already exists":
+			delete(layers, msg.ID)
+			continue
+		case l == nil:
+			l = &layer{}
+			layers[msg.ID] = l
+		}
+		switch msg.Status {
+		case "Downloading":
+			l.current, l.total = msg.ProgressDetail.Current, msg.ProgressDetail.Total
+		case "Download complete", "Extracting", "Pull complete":
+			l.current = l.total
+		default:
+			continue
+		}
 
-	// Write the properties back
-	var builder strings.Builder
-	for key, value := range props {
-		builder.WriteString(fmt.Sprintf("%s=%s\n", key, value))
+		var current, total int64
+		for _, l := range layers {
+			current += l.current
+			total += l.total
+		}
+		reportProgress(ctx, "pulling image", current, total, "bytes")
 	}
+}
 
-	if err := os.WriteFile(filePath, []byte(builder.String()), 0644); err != nil {
-		log.Error().Err(err).Str("path", filePath).Msg("Failed to write updated server.properties for RCON.")
+// rconProperties are the server.properties settings the server is managed through.
+func rconProperties(rconPassword string) map[string]string {
+	return map[string]string{
+		"enable-rcon":   "true",
+		"rcon.password": rconPassword,
+		"rcon.port":     RCONPort,
 	}
 }
 
-// unzip decompresses a zip archive from a reader to a destination directory.
-func unzip(reader io.Reader, dest string) error {
-	// We need to write the reader's content to a temp file to use zip.OpenReader
-	tmpFile, err := os.CreateTemp("", "ender-deploy-zip-*.zip")
+// ensureRconInProperties makes sure the server.properties in dataPath has RCON configured, creating the file
+// if needed.
+func (s *ServerService) ensureRconInProperties(dataPath, rconPassword string) {
+	err := func() error {
+		dataDir, err := sandbox.Open(dataPath)
+		if err != nil {
+			return err
+		}
+		defer dataDir.Close()
+		props, err := readServerProperties(dataDir)
+		if err != nil {
+			return err
+		}
+		if err := props.SetAll(rconProperties(rconPassword)); err != nil {
+			return err
+		}
+		return writeServerProperties(dataDir, props)
+	}()
 	if err != nil {
-		return err
+		log.Error().Err(err).Str("path", dataPath).Msg("Failed to write updated server.properties for RCON.")
 	}
-	defer os.Remove(tmpFile.Name())
+}
+
+// unzip decompresses a zip archive from a reader into a sandboxed directory.
+func unzip(ctx context.Context, reader io.Reader, root *sandbox.Root) (err error) {
+	ctx, span := tracing.Start(ctx, "unzip")
+	defer func() { tracing.End(span, err) }()
 
-	size, err := io.Copy(tmpFile, reader)
+	// zip needs random access, so anything but a file is spooled to a temp file first
+	file, ok := reader.(*os.File)
+	if !ok {
+		tmpFile, err := os.CreateTemp("", "ender-deploy-zip-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		if _, err = io.Copy(tmpFile, reader); err != nil {
+			return err
+		}
+		file = tmpFile
+	}
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	r, err := zip.NewReader(tmpFile, size)
+	r, err := zip.NewReader(file, info.Size())
 	if err != nil {
 		return err
 	}
 
+	var total, current int64
 	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-
-		// Check for ZipSlip vulnerability
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", fpath)
-		}
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
-		}
-
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
-		}
+		total += int64(f.UncompressedSize64)
+	}
+	reportProgress(ctx, "unzipping", 0, total, "bytes")
+	track := func(w io.Writer) io.Writer {
+		return &progressWriter{ctx: ctx, w: w, stage: "unzipping", current: &current, total: total}
+	}
 
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			return err
+	for _, f := range r.File {
+		name, ok := zipEntryName(f)
+		if !ok {
+			return fmt.Errorf("illegal file path: %s", f.Name)
 		}
-
-		_, err = io.Copy(outFile, rc)
-
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
+		if err := extractZipEntry(root, f, name, track); err != nil {
 			return err
 		}
 	}
@@ -1202,7 +1642,7 @@ func unzip(reader io.Reader, dest string) error {
 
 // ExecuteTerminalCommand runs a shell command inside the server's container.
 func (s *ServerService) ExecuteTerminalCommand(ctx context.Context, serverID, command string) (string, error) {
-	server, err := s.GetServerByID(serverID)
+	server, err := s.GetServerByID(ctx, serverID)
 	if err != nil {
 		return "", fmt.Errorf("could not find server to execute command: %w", err)
 	}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// ErrUnknownStorageRoot is returned for storage roots that are not configured.
+var ErrUnknownStorageRoot = errors.New("unknown storage root")
+
+// CloneServerOptions are the options of cloning a server.
+type CloneServerOptions struct {
+	Name          string // Defaults to the original's name with " (copy)" appended
+	IncludeWorlds bool   // Without worlds the clone generates fresh ones on its first start
+	StorageRoot   string // Defaults to the original's storage root
+}
+
+// copyExcluded are the top-level files and directories of a server that are specific to its running
+// instance and not copied to clones.
+var copyExcluded = map[string]bool{
+	"logs":          true,
+	"crash-reports": true,
+	"debug":         true,
+}
+
+// GetStorageRoots lists the directories server data can be kept in, with the servers in each and their free space.
+func (s *ServerService) GetStorageRoots(ctx context.Context) ([]models.StorageRoot, error) {
+	servers, err := s.GetAllServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]models.StorageRoot, len(s.storageRoots))
+	for i, path := range s.storageRoots {
+		roots[i] = models.StorageRoot{Path: path, Default: i == 0}
+		if usage, err := disk.UsageWithContext(ctx, path); err == nil {
+			roots[i].TotalBytes, roots[i].FreeBytes = usage.Total, usage.Free
+		} else {
+			log.Warn().Ctx(ctx).Err(err).Str("path", path).Msg("Could not read disk usage of storage root")
+		}
+		for _, server := range servers {
+			if storageRootOf(server) == path {
+				roots[i].Servers++
+			}
+		}
+	}
+	return roots, nil
+}
+
+// storageRootOf returns the storage root a server's data directory is in.
+func storageRootOf(server models.Server) string {
+	return filepath.Dir(filepath.Clean(server.DataPath))
+}
+
+// findStorageRoot returns the configured storage root path names.
+func (s *ServerService) findStorageRoot(path string) (string, error) {
+	path = filepath.Clean(path)
+	for _, root := range s.storageRoots {
+		if root == path {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownStorageRoot, path)
+}
+
+// CloneServer creates a new server from a copy of an existing one's files and settings. The clone gets its
+// own ports, RCON password and container, and is left offline. An online server's world is flushed to disk
+// before it is copied.
+func (s *ServerService) CloneServer(ctx context.Context, id string, options CloneServerOptions) (_ models.Server, err error) {
+	source, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
+	root := storageRootOf(source)
+	if options.StorageRoot != "" {
+		if root, err = s.findStorageRoot(options.StorageRoot); err != nil {
+			return models.Server{}, err
+		}
+	}
+	javaRuntime, err := s.runtimeService.ResolveRuntime(ctx, source.RuntimeID, source.JavaVersion)
+	if err != nil {
+		return models.Server{}, err
+	}
+
+	clone := source
+	clone.ID = uuid.New().String()
+	clone.Name = options.Name
+	if clone.Name == "" {
+		clone.Name = source.Name + " (copy)"
+	}
+	clone.Status = "offline"
+	clone.RCONPassword = "ender-rcon-" + uuid.New().String()
+	clone.DockerContainerID = ""
+	clone.DataPath = filepath.Join(root, clone.ID)
+
+	absDataPath, err := filepath.Abs(clone.DataPath)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to get absolute path for server data: %w", err)
+	}
+	if err := os.MkdirAll(absDataPath, 0755); err != nil {
+		return models.Server{}, fmt.Errorf("failed to create server data directory: %w", err)
+	}
+	defer s.cleanupFailedProvisioning(ctx, &err, absDataPath, &clone)
+
+	resume, err := saveWorld(ctx, s, source)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to save world via RCON before cloning: %w", err)
+	}
+	err = copyDir(ctx, source.DataPath, absDataPath, func(src *sandbox.Root, rel string, d fs.DirEntry) bool {
+		if copyExcluded[rel] || d.Name() == "session.lock" || strings.HasPrefix(d.Name(), ".mod-upload-") {
+			return true
+		}
+		return !options.IncludeWorlds && isWorldDir(src, rel, d)
+	})
+	resume()
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to copy server files: %w", err)
+	}
+	s.ensureRconInProperties(absDataPath, clone.RCONPassword)
+
+	if err := ensureImageExists(ctx, s.docker, javaRuntime.Image); err != nil {
+		return models.Server{}, err
+	}
+	containerID, err := s.createServerContainer(ctx, &clone, javaRuntime.Image, absDataPath)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to create docker container: %w", err)
+	}
+	clone.DockerContainerID = containerID
+
+	var modpackName, modpackVersion interface{}
+	if clone.Modpack != nil {
+		modpackName, modpackVersion = clone.Modpack.Name, clone.Modpack.Version
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO servers(id, name, status, minecraft_version, java_version, server_type, loader_version, runtime_id, docker_container_id, data_path,
+		                    template_id, template_version, port, ip_address, players_max, rcon_password, max_memory_mb, jvm_settings_json, modpack_name, modpack_version)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		clone.ID, clone.Name, clone.Status, clone.MinecraftVersion, clone.JavaVersion, clone.ServerType, clone.LoaderVersion, javaRuntime.ID, clone.DockerContainerID, clone.DataPath,
+		clone.TemplateID, clone.TemplateVersion, clone.Port, clone.IPAddress, clone.Players.Max, clone.RCONPassword, clone.MaxMemoryMB, jvmSettingsJSON(clone.JVM), modpackName, modpackVersion)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to write server to database: %w", err)
+	}
+
+	newServer, err := s.GetServerByID(ctx, clone.ID)
+	if err != nil {
+		return models.Server{}, err
+	}
+	s.broadcastServerUpdate(newServer)
+	msg := fmt.Sprintf("Server '%s' was cloned from '%s'.", newServer.Name, source.Name)
+	s.eventService.CreateEvent(ctx, "server.clone", "info", msg, &newServer.ID)
+	return newServer, nil
+}
+
+// isWorldDir reports whether a top-level directory of a server holds a world or one of its dimensions.
+func isWorldDir(dataDir *sandbox.Root, rel string, d fs.DirEntry) bool {
+	return d.IsDir() && !strings.Contains(rel, "/") && hasWorldData(dataDir, rel)
+}
+
+// MoveServer relocates a server's data directory to another storage root and recreates its container with
+// the new bind mount. A running server is stopped for the move and started again afterwards.
+func (s *ServerService) MoveServer(ctx context.Context, id, storageRoot string) (models.Server, error) {
+	server, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
+	root, err := s.findStorageRoot(storageRoot)
+	if err != nil {
+		return models.Server{}, err
+	}
+	if storageRootOf(server) == root {
+		return models.Server{}, fmt.Errorf("server '%s' is already stored in %s", server.Name, root)
+	}
+	oldPath, err := filepath.Abs(server.DataPath)
+	if err != nil {
+		return models.Server{}, err
+	}
+	dataPath := filepath.Join(root, server.ID)
+	newPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return models.Server{}, err
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return models.Server{}, fmt.Errorf("%s already exists", newPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return models.Server{}, fmt.Errorf("failed to create storage root: %w", err)
+	}
+
+	wasRunning := server.Status != "offline"
+	if wasRunning {
+		reportProgress(ctx, "stopping server", 0, 0, "")
+		if err := s.PerformServerAction(context.WithoutCancel(ctx), id, "stop"); err != nil {
+			return models.Server{}, fmt.Errorf("failed to stop server: %w", err)
+		}
+	}
+
+	// A rename is instant within a filesystem; across disks the files are copied and the originals
+	// removed once the server points at the copy.
+	copied := false
+	if err := os.Rename(oldPath, newPath); err != nil {
+		err := os.Mkdir(newPath, 0755)
+		if err == nil {
+			err = copyDir(ctx, oldPath, newPath, nil)
+		}
+		if err != nil {
+			os.RemoveAll(newPath)
+			return models.Server{}, fmt.Errorf("failed to copy server files: %w", err)
+		}
+		copied = true
+	}
+	undo := func() {
+		if copied {
+			os.RemoveAll(newPath)
+		} else if err := os.Rename(newPath, oldPath); err != nil {
+			log.Error().Ctx(ctx).Err(err).Str("path", newPath).Msg("Could not move server files back after a failed move")
+		}
+	}
+
+	// From here on the move is finished even if the job is cancelled, so files and container stay in step.
+	ctx = context.WithoutCancel(ctx)
+	reportProgress(ctx, "recreating container", 0, 0, "")
+	info, err := s.docker.InspectContainer(ctx, server.DockerContainerID)
+	if err != nil {
+		undo()
+		return models.Server{}, err
+	}
+	containerID, err := s.replaceContainer(ctx, info, func(_ *container.Config, hostConfig *container.HostConfig) {
+		hostConfig.Mounts = []mount.Mount{{Type: mount.TypeBind, Source: newPath, Target: "/data"}}
+	})
+	if err != nil {
+		undo()
+		return models.Server{}, fmt.Errorf("failed to recreate container: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE servers SET data_path = ?, docker_container_id = ? WHERE id = ?", dataPath, containerID, id); err != nil {
+		return models.Server{}, fmt.Errorf("failed to update server in DB: %w", err)
+	}
+	if copied {
+		if err := os.RemoveAll(oldPath); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Str("path", oldPath).Msg("Could not remove server files from the old storage root")
+		}
+	}
+
+	msg := fmt.Sprintf("Server '%s' was moved to %s.", server.Name, root)
+	s.eventService.CreateEvent(ctx, "server.move", "info", msg, &server.ID)
+	if wasRunning {
+		reportProgress(ctx, "starting server", 0, 0, "")
+		if err := s.PerformServerAction(ctx, id, "start"); err != nil {
+			return models.Server{}, fmt.Errorf("failed to start server: %w", err)
+		}
+	}
+	updated, err := s.GetServerByID(ctx, id)
+	if err != nil {
+		return models.Server{}, err
+	}
+	s.broadcastServerUpdate(updated)
+	return updated, nil
+}
+
+// copyTree copies the tree of src into dst, reporting the bytes copied. Files, directories and symlinks are
+// copied with their permissions, and hard-linked files are left out; skip is passed names relative to src and
+// may be nil.
+func copyTree(ctx context.Context, src, dst *sandbox.Root, skip func(rel string, d fs.DirEntry) bool) error {
+	var total int64
+	err := src.WalkDir(".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(rel, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var copied int64
+	reportProgress(ctx, "copying files", 0, total, "bytes")
+	return src.WalkDir(".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(rel, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return dst.MkdirAll(rel, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := src.Readlink(rel)
+			if err != nil {
+				return err
+			}
+			return dst.Symlink(link, rel)
+		case d.Type().IsRegular():
+			in, err := src.Open(rel)
+			if errors.Is(err, sandbox.ErrHardLink) {
+				log.Warn().Ctx(ctx).Str("path", rel).Msg("Leaving hard-linked file out of copy")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			out, err := dst.OpenFile(rel, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(&progressWriter{ctx: ctx, w: out, stage: "copying files", current: &copied, total: total}, in)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		return nil // Sockets, pipes and devices are not copied
+	})
+}
+
+// copyDir opens src and dst, which must exist, and copies the tree of src into dst.
+func copyDir(ctx context.Context, src, dst string, skip func(src *sandbox.Root, rel string, d fs.DirEntry) bool) error {
+	srcRoot, err := sandbox.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcRoot.Close()
+	dstRoot, err := sandbox.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer dstRoot.Close()
+	var skipRel func(string, fs.DirEntry) bool
+	if skip != nil {
+		skipRel = func(rel string, d fs.DirEntry) bool { return skip(srcRoot, rel, d) }
+	}
+	return copyTree(ctx, srcRoot, dstRoot, skipRel)
+}
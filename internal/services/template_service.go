@@ -1,14 +1,25 @@
 package services
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/bundle"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+	"github.com/isdelr/ender-deploy-be/internal/jvm"
 	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/modpack"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,24 +28,42 @@ type TemplateServiceProvider interface {
 	GetAllTemplates() ([]models.Template, error)
 	GetTemplateByID(id string) (models.Template, error)
 	CreateTemplate(template models.Template, serverExecutable string, file io.Reader) (models.Template, error)
+	ImportTemplate(ctx context.Context, template models.Template, packPath string) (models.Template, error)
+	InstallTemplate(ctx context.Context, template models.Template) (models.Template, error)
+	GetServerSoftware() []string
+	GetServerSoftwareVersions(ctx context.Context, software string) ([]string, error)
 	UpdateTemplate(id string, template models.Template) (models.Template, error)
 	DeleteTemplate(id string) error
+	GetTemplateVersions(id string) ([]models.TemplateVersion, error)
+	GetTemplateVersion(id string, version int) (models.Template, error)
+	CloneTemplate(id string, version int, name string) (models.Template, error)
+	CreateTemplateFromServer(ctx context.Context, template models.Template, server models.Server) (models.Template, error)
+	ExportTemplate(id string, version int, w io.Writer) error
+	ImportTemplateBundle(bundlePath string) (models.Template, error)
+	SigningKey() string
 }
 
 // TemplateService provides business logic for template management.
 type TemplateService struct {
-	db *sql.DB
+	db        *sql.DB
+	importer  *modpack.Importer
+	installer *installer.Installer
+	keys      bundle.Keys
 }
 
 const templateStoragePath = "./templates"
 
-// NewTemplateService creates a new TemplateService.
-func NewTemplateService(db *sql.DB) *TemplateService {
+// NewTemplateService creates a new TemplateService. keys sign exported bundles and decide which imported ones are trusted.
+func NewTemplateService(db *sql.DB, importer *modpack.Importer, installer *installer.Installer, keys bundle.Keys) *TemplateService {
 	// Ensure the base directory for templates exists on service initialization.
 	if err := os.MkdirAll(templateStoragePath, 0755); err != nil {
 		log.Fatal().Err(err).Str("path", templateStoragePath).Msg("Failed to create base template data directory")
 	}
-	return &TemplateService{db: db}
+	s := &TemplateService{db: db, importer: importer, installer: installer, keys: keys}
+	if err := s.backfillVersions(); err != nil {
+		log.Error().Err(err).Msg("Failed to record versions of existing templates")
+	}
+	return s
 }
 
 // scanTemplate is a helper to scan a template from a row or rows object.
@@ -43,6 +72,8 @@ func scanTemplate(scanner interface{ Scan(...interface{}) error }) (models.Templ
 	var desc, serverJarURL, startupCommand, difficulty, iconURL sql.NullString
 	var tags, jvm, props, mods, plugins, ops, whitelist sql.NullString
 	var datapacks, resourcePacks, bannedPlayers, bannedIPs sql.NullString
+	var version sql.NullInt64
+	var changelog sql.NullString
 
 	err := scanner.Scan(
 		&tmpl.ID, &tmpl.Name, &desc, &tmpl.MinecraftVersion,
@@ -50,6 +81,7 @@ func scanTemplate(scanner interface{ Scan(...interface{}) error }) (models.Templ
 		&tmpl.MinMemoryMB, &tmpl.MaxMemoryMB, &difficulty, &iconURL,
 		&tags, &jvm, &props, &mods, &plugins, &ops, &whitelist,
 		&datapacks, &resourcePacks, &bannedPlayers, &bannedIPs,
+		&version, &changelog,
 	)
 
 	if err != nil {
@@ -73,6 +105,8 @@ func scanTemplate(scanner interface{ Scan(...interface{}) error }) (models.Templ
 	tmpl.ResourcePacksJSON = resourcePacks.String
 	tmpl.BannedPlayersJSON = bannedPlayers.String
 	tmpl.BannedIPsJSON = bannedIPs.String
+	tmpl.Version = int(version.Int64)
+	tmpl.Changelog = changelog.String
 
 	tmpl.PrepareForAPI() // Unmarshal all JSON fields
 	return tmpl, nil
@@ -84,7 +118,7 @@ func (s *TemplateService) GetAllTemplates() ([]models.Template, error) {
 		SELECT id, name, description, minecraft_version, java_version, server_type, 
 		       server_jar_url, startup_command, min_memory_mb, max_memory_mb, difficulty, icon_url,
 		       tags_json, jvm_args_json, properties_json, mods_json, plugins_json, ops_json, whitelist_json,
-			   datapacks_json, resource_packs_json, banned_players_json, banned_ips_json 
+			   datapacks_json, resource_packs_json, banned_players_json, banned_ips_json, version, changelog
 		FROM templates`
 	rows, err := s.db.Query(query)
 	if err != nil {
@@ -109,7 +143,7 @@ func (s *TemplateService) GetTemplateByID(id string) (models.Template, error) {
 		SELECT id, name, description, minecraft_version, java_version, server_type,
 		       server_jar_url, startup_command, min_memory_mb, max_memory_mb, difficulty, icon_url,
 		       tags_json, jvm_args_json, properties_json, mods_json, plugins_json, ops_json, whitelist_json,
-			   datapacks_json, resource_packs_json, banned_players_json, banned_ips_json 
+			   datapacks_json, resource_packs_json, banned_players_json, banned_ips_json, version, changelog
 		FROM templates WHERE id = ?`
 	row := s.db.QueryRow(query, id)
 
@@ -162,17 +196,197 @@ func (s *TemplateService) CreateTemplate(template models.Template, serverExecuta
 	template.ServerJarURL = zipFilePath
 
 	// Save the template metadata to the database.
+	if err := s.insertTemplate(template); err != nil {
+		os.RemoveAll(templateDir) // Cleanup saved file on DB error
+		return models.Template{}, err
+	}
+
+	return s.GetTemplateByID(template.ID)
+}
+
+// InstallTemplate creates a template by installing the server software named by template.ServerType
+// for template.MinecraftVersion, which defaults to the newest release when empty.
+func (s *TemplateService) InstallTemplate(ctx context.Context, template models.Template) (models.Template, error) {
+	return s.buildTemplate(ctx, template, func(dir string, template *models.Template) (installer.Result, error) {
+		reportProgress(ctx, "installing server software", 0, 0, "")
+		return s.installer.Install(ctx, installer.Spec{
+			Software:         installer.Software(template.ServerType),
+			MinecraftVersion: template.MinecraftVersion,
+			JavaVersion:      template.JavaVersion,
+		}, dir)
+	})
+}
+
+// ImportTemplate creates a template from a Modrinth (.mrpack) or CurseForge modpack archive.
+// The pack's mods are downloaded and its loader installed into the template's zip; fields left
+// empty in template are filled in from the pack.
+func (s *TemplateService) ImportTemplate(ctx context.Context, template models.Template, packPath string) (models.Template, error) {
+	pack, err := zip.OpenReader(packPath)
+	if err != nil {
+		return models.Template{}, fmt.Errorf("could not open modpack archive: %w", err)
+	}
+	defer pack.Close()
+
+	return s.buildTemplate(ctx, template, func(dir string, template *models.Template) (installer.Result, error) {
+		result, err := s.importer.Unpack(ctx, &pack.Reader, dir, func(stage string, current, total int64, unit string) {
+			reportProgress(ctx, stage, current, total, unit)
+		})
+		if err != nil {
+			return installer.Result{}, fmt.Errorf("failed to import modpack: %w", err)
+		}
+		if template.Name == "" {
+			template.Name = result.Name
+		}
+		if template.Description == "" {
+			template.Description = result.Summary
+		}
+		template.Mods = result.Mods
+
+		reportProgress(ctx, "installing mod loader", 0, 0, "")
+		return s.installer.Install(ctx, installer.Spec{
+			Software:         result.Loader,
+			MinecraftVersion: result.MinecraftVersion,
+			Version:          result.LoaderVersion,
+			JavaVersion:      template.JavaVersion,
+		}, dir)
+	})
+}
+
+// buildTemplate lets build populate a fresh template directory, zips it into the template's storage
+// and saves the template. build may fill in fields of the template it is passed.
+func (s *TemplateService) buildTemplate(ctx context.Context, template models.Template, build func(dir string, template *models.Template) (installer.Result, error)) (_ models.Template, err error) {
+	templateDir := filepath.Join(templateStoragePath, template.ID)
+	buildDir := filepath.Join(templateDir, "build")
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		return models.Template{}, fmt.Errorf("could not create template directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(templateDir)
+		}
+	}()
+
+	result, err := build(buildDir, &template)
+	if err != nil {
+		return models.Template{}, err
+	}
+
+	reportProgress(ctx, "packing template", 0, 0, "")
+	zipFilePath := filepath.Join(templateDir, "template.zip")
+	if err := zipDirectory(buildDir, zipFilePath, nil); err != nil {
+		return models.Template{}, fmt.Errorf("could not create zip file for template: %w", err)
+	}
+	if err := os.RemoveAll(buildDir); err != nil {
+		log.Warn().Err(err).Str("path", buildDir).Msg("Failed to remove template build directory")
+	}
+
+	template.ServerType = string(result.Software)
+	template.MinecraftVersion = result.MinecraftVersion
+	if template.JavaVersion == "" {
+		template.JavaVersion = installer.JavaVersionFor(result.MinecraftVersion)
+	}
+	template.MinMemoryMB = 1024
+	template.StartupCommand = result.StartupCommand(template.MinMemoryMB, template.MaxMemoryMB)
+	template.ServerJarURL = zipFilePath
+
+	if err := s.insertTemplate(template); err != nil {
+		return models.Template{}, err
+	}
+	return s.GetTemplateByID(template.ID)
+}
+
+// GetServerSoftware lists the server software templates can be installed with.
+func (s *TemplateService) GetServerSoftware() []string {
+	var software []string
+	for _, sw := range s.installer.Software() {
+		software = append(software, string(sw))
+	}
+	return software
+}
+
+// GetServerSoftwareVersions lists the Minecraft versions a server software can be installed for, newest first.
+func (s *TemplateService) GetServerSoftwareVersions(ctx context.Context, software string) ([]string, error) {
+	return s.installer.Versions(ctx, installer.Software(software))
+}
+
+// zipDirectory writes the contents of dir to a zip file at dest, leaving out the files and directories
+// skip returns true for. skip is passed paths relative to dir and may be nil.
+func zipDirectory(dir, dest string, skip func(rel string, info os.FileInfo) bool) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && skip != nil && skip(filepath.ToSlash(rel), info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil // Only files are archived; links, sockets and the like are left out
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// insertTemplate saves a new template's metadata to the database as its first version.
+func (s *TemplateService) insertTemplate(template models.Template) error {
+	archiveSum, err := fileSHA256(template.ServerJarURL)
+	if err != nil {
+		return fmt.Errorf("could not hash template archive: %w", err)
+	}
+	template.Version = 1
 	template.PrepareForSave()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	const query = `
 		INSERT INTO templates(id, name, description, minecraft_version, java_version, server_type, 
 		                    server_jar_url, startup_command, min_memory_mb, max_memory_mb, difficulty, icon_url,
 		                    tags_json, jvm_args_json, properties_json, mods_json, plugins_json, ops_json, whitelist_json,
-							datapacks_json, resource_packs_json, banned_players_json, banned_ips_json) 
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	stmt, err := s.db.Prepare(query)
+							datapacks_json, resource_packs_json, banned_players_json, banned_ips_json, version, changelog) 
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	stmt, err := tx.Prepare(query)
 	if err != nil {
-		os.RemoveAll(templateDir) // Cleanup saved file on DB error
-		return models.Template{}, fmt.Errorf("failed to prepare statement: %w", err)
+		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 
@@ -183,50 +397,397 @@ func (s *TemplateService) CreateTemplate(template models.Template, serverExecuta
 		template.TagsJSON, template.JVMArgsJSON, template.PropertiesJSON,
 		template.ModsJSON, template.PluginsJSON, template.OpsJSON, template.WhitelistJSON,
 		template.DatapacksJSON, template.ResourcePacksJSON, template.BannedPlayersJSON, template.BannedIPsJSON,
+		template.Version, template.Changelog,
 	)
 	if err != nil {
-		os.RemoveAll(templateDir) // Cleanup saved file on DB error
-		return models.Template{}, fmt.Errorf("failed to execute statement: %w", err)
+		return fmt.Errorf("failed to execute statement: %w", err)
+	}
+	if err := insertTemplateVersion(tx, template, archiveSum); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	return s.GetTemplateByID(template.ID)
+// insertTemplateVersion records template as it is now as an immutable version.
+func insertTemplateVersion(tx *sql.Tx, template models.Template, archiveSum string) error {
+	metadata, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO template_versions (template_id, version, changelog, metadata_json, archive_path, archive_sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		template.ID, template.Version, template.Changelog, string(metadata), template.ServerJarURL, archiveSum, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save template version: %w", err)
+	}
+	return nil
+}
+
+// backfillVersions records templates created before templates were versioned as their first version.
+func (s *TemplateService) backfillVersions() error {
+	rows, err := s.db.Query("SELECT id FROM templates WHERE version IS NULL")
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		template, err := s.GetTemplateByID(id)
+		if err != nil {
+			return err
+		}
+		template.Version = 1
+		template.PrepareForSave()
+		// The archive may be gone; the version is recorded anyway so the template stays usable in listings.
+		archiveSum, _ := fileSHA256(template.ServerJarURL)
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE templates SET version = 1 WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := insertTemplateVersion(tx, template, archiveSum); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// UpdateTemplate updates an existing template in the database.
+// UpdateTemplate publishes a new version of a template with the given metadata and changelog. Earlier
+// versions, and the servers created from them, are unaffected. The template's archive can't be changed
+// this way; it carries over from the current version.
 func (s *TemplateService) UpdateTemplate(id string, template models.Template) (models.Template, error) {
+	current, err := s.GetTemplateByID(id)
+	if err != nil {
+		return models.Template{}, err
+	}
+	var archiveSum sql.NullString
+	err = s.db.QueryRow("SELECT archive_sha256 FROM template_versions WHERE template_id = ? AND version = ?", id, current.Version).Scan(&archiveSum)
+	if err != nil && err != sql.ErrNoRows {
+		return models.Template{}, err
+	}
+
+	template.ID = id
+	template.ServerJarURL = current.ServerJarURL
+	template.Version = current.Version + 1
 	template.PrepareForSave()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Template{}, err
+	}
+	defer tx.Rollback()
+
 	const query = `
 		UPDATE templates SET name = ?, description = ?, minecraft_version = ?, java_version = ?, 
-		                    server_type = ?, server_jar_url = ?, startup_command = ?,
+		                    server_type = ?, startup_command = ?,
 		                    min_memory_mb = ?, max_memory_mb = ?, difficulty = ?, icon_url = ?,
 		                    tags_json = ?, jvm_args_json = ?, properties_json = ?,
 		                    mods_json = ?, plugins_json = ?, ops_json = ?, whitelist_json = ?,
-							datapacks_json = ?, resource_packs_json = ?, banned_players_json = ?, banned_ips_json = ?
+							datapacks_json = ?, resource_packs_json = ?, banned_players_json = ?, banned_ips_json = ?,
+							version = ?, changelog = ?
 		WHERE id = ?`
-	stmt, err := s.db.Prepare(query)
-	if err != nil {
-		return models.Template{}, err
-	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(
+	_, err = tx.Exec(query,
 		template.Name, template.Description, template.MinecraftVersion, template.JavaVersion,
-		template.ServerType, template.ServerJarURL, template.StartupCommand,
+		template.ServerType, template.StartupCommand,
 		template.MinMemoryMB, template.MaxMemoryMB, template.Difficulty, template.IconURL,
 		template.TagsJSON, template.JVMArgsJSON, template.PropertiesJSON,
 		template.ModsJSON, template.PluginsJSON, template.OpsJSON, template.WhitelistJSON,
 		template.DatapacksJSON, template.ResourcePacksJSON, template.BannedPlayersJSON, template.BannedIPsJSON,
+		template.Version, template.Changelog,
 		id,
 	)
 	if err != nil {
 		return models.Template{}, err
 	}
+	if err := insertTemplateVersion(tx, template, archiveSum.String); err != nil {
+		return models.Template{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Template{}, err
+	}
 
 	return s.GetTemplateByID(id)
 }
 
-// DeleteTemplate removes a template from the database.
+// DeleteTemplate removes a template, all its versions and its stored archives.
 func (s *TemplateService) DeleteTemplate(id string) error {
-	_, err := s.db.Exec("DELETE FROM templates WHERE id = ?", id)
-	return err
+	if _, err := s.db.Exec("DELETE FROM template_versions WHERE template_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM templates WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(templateStoragePath, filepath.Base(id))); err != nil {
+		log.Warn().Err(err).Str("template_id", id).Msg("Failed to remove template storage")
+	}
+	return nil
+}
+
+// GetTemplateVersions lists the versions of a template, newest first.
+func (s *TemplateService) GetTemplateVersions(id string) ([]models.TemplateVersion, error) {
+	if _, err := s.GetTemplateByID(id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT template_id, version, changelog, archive_sha256, created_at FROM template_versions WHERE template_id = ? ORDER BY version DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.TemplateVersion{}
+	for rows.Next() {
+		var v models.TemplateVersion
+		var changelog, archiveSum sql.NullString
+		if err := rows.Scan(&v.TemplateID, &v.Version, &changelog, &archiveSum, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		v.Changelog = changelog.String
+		v.ArchiveSHA256 = archiveSum.String
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetTemplateVersion retrieves a template as it was at one of its versions. Version 0 is the latest.
+func (s *TemplateService) GetTemplateVersion(id string, version int) (models.Template, error) {
+	if version == 0 {
+		return s.GetTemplateByID(id)
+	}
+
+	var metadata, archivePath string
+	var changelog sql.NullString
+	err := s.db.QueryRow("SELECT metadata_json, archive_path, changelog FROM template_versions WHERE template_id = ? AND version = ?", id, version).
+		Scan(&metadata, &archivePath, &changelog)
+	if err == sql.ErrNoRows {
+		return models.Template{}, fmt.Errorf("version %d of template %s not found", version, id)
+	}
+	if err != nil {
+		return models.Template{}, err
+	}
+
+	var template models.Template
+	if err := json.Unmarshal([]byte(metadata), &template); err != nil {
+		return models.Template{}, fmt.Errorf("unreadable metadata of template %s version %d: %w", id, version, err)
+	}
+	template.ID = id
+	template.Version = version
+	template.Changelog = changelog.String
+	template.ServerJarURL = archivePath
+	return template, nil
+}
+
+// CloneTemplate creates a new template from a version of an existing one, with its own copy of the archive.
+// Version 0 clones the latest version; an empty name derives one from the original's.
+func (s *TemplateService) CloneTemplate(id string, version int, name string) (_ models.Template, err error) {
+	source, err := s.GetTemplateVersion(id, version)
+	if err != nil {
+		return models.Template{}, err
+	}
+
+	clone := source
+	clone.ID = uuid.New().String()
+	clone.Name = name
+	if clone.Name == "" {
+		clone.Name = source.Name + " (copy)"
+	}
+	clone.Changelog = fmt.Sprintf("Cloned from %s version %d.", source.Name, source.Version)
+
+	templateDir := filepath.Join(templateStoragePath, clone.ID)
+	if err := os.MkdirAll(templateDir, 0755); err != nil {
+		return models.Template{}, fmt.Errorf("could not create template directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(templateDir)
+		}
+	}()
+	clone.ServerJarURL = filepath.Join(templateDir, "template.zip")
+	if err := copyFile(source.ServerJarURL, clone.ServerJarURL); err != nil {
+		return models.Template{}, fmt.Errorf("could not copy template archive: %w", err)
+	}
+
+	if err := s.insertTemplate(clone); err != nil {
+		return models.Template{}, err
+	}
+	return s.GetTemplateByID(clone.ID)
+}
+
+// captureExcluded are the top-level files and directories of a server that are specific to its running
+// instance and left out of templates created from it.
+var captureExcluded = map[string]bool{
+	"logs":          true,
+	"crash-reports": true,
+	"debug":         true,
+	"start.sh":      true,
+}
+
+// CreateTemplateFromServer saves a server's data directory as a new template, which takes its name and
+// description from template and everything else from the server. An online server's world should be
+// flushed to disk first. Logs, crash reports, session locks and the start script are left out; the
+// template's startup command is derived from the server's launch instead.
+func (s *TemplateService) CreateTemplateFromServer(ctx context.Context, template models.Template, server models.Server) (_ models.Template, err error) {
+	launch, err := jvm.ReadLaunch(server.DataPath)
+	if err != nil {
+		return models.Template{}, err
+	}
+	template.MinMemoryMB = 1024
+	if launch.Managed() {
+		template.StartupCommand = installer.Result{Jar: launch.Jar, RunScript: launch.RunScript}.StartupCommand(template.MinMemoryMB, server.MaxMemoryMB)
+	} else {
+		// The server's own script is kept as is, minus its shebang line.
+		dataDir, err := openDataDir(server)
+		if err != nil {
+			return models.Template{}, err
+		}
+		script, err := dataDir.ReadFile("start.sh")
+		dataDir.Close()
+		if err != nil {
+			return models.Template{}, fmt.Errorf("could not read start.sh: %w", err)
+		}
+		command := string(script)
+		if strings.HasPrefix(command, "#!") {
+			_, command, _ = strings.Cut(command, "\n")
+		}
+		template.StartupCommand = command
+	}
+
+	template.MinecraftVersion = server.MinecraftVersion
+	template.JavaVersion = server.JavaVersion
+	template.ServerType = server.ServerType
+	if template.ServerType == "" {
+		template.ServerType = "custom-zip"
+	}
+	template.MaxMemoryMB = server.MaxMemoryMB
+	template.JVMArgs = server.JVM.ExtraArgs
+	template.Changelog = fmt.Sprintf("Saved from server %s.", server.Name)
+
+	templateDir := filepath.Join(templateStoragePath, template.ID)
+	if err := os.MkdirAll(templateDir, 0755); err != nil {
+		return models.Template{}, fmt.Errorf("could not create template directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(templateDir)
+		}
+	}()
+
+	reportProgress(ctx, "packing template", 0, 0, "")
+	template.ServerJarURL = filepath.Join(templateDir, "template.zip")
+	err = zipDirectory(server.DataPath, template.ServerJarURL, func(rel string, info os.FileInfo) bool {
+		name := info.Name()
+		return captureExcluded[rel] || name == "session.lock" || strings.HasPrefix(name, ".mod-upload-")
+	})
+	if err != nil {
+		return models.Template{}, fmt.Errorf("could not create zip file for template: %w", err)
+	}
+
+	if err := s.insertTemplate(template); err != nil {
+		return models.Template{}, err
+	}
+	return s.GetTemplateByID(template.ID)
+}
+
+// ExportTemplate writes a version of a template to w as a bundle signed with this host's key. Version 0 is the latest.
+func (s *TemplateService) ExportTemplate(id string, version int, w io.Writer) error {
+	template, err := s.GetTemplateVersion(id, version)
+	if err != nil {
+		return err
+	}
+	archivePath := template.ServerJarURL
+	// Where the archive is stored is of no use to the importing host.
+	template.ID = ""
+	template.ServerJarURL = ""
+	metadata, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return bundle.Write(w, metadata, archivePath, s.keys)
+}
+
+// ImportTemplateBundle creates a new template from a bundle exported by this or a trusted host.
+func (s *TemplateService) ImportTemplateBundle(bundlePath string) (_ models.Template, err error) {
+	id := uuid.New().String()
+	templateDir := filepath.Join(templateStoragePath, id)
+	if err := os.MkdirAll(templateDir, 0755); err != nil {
+		return models.Template{}, fmt.Errorf("could not create template directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(templateDir)
+		}
+	}()
+
+	archivePath := filepath.Join(templateDir, "template.zip")
+	metadata, signer, err := bundle.Read(bundlePath, archivePath, s.keys)
+	if err != nil {
+		return models.Template{}, err
+	}
+	var template models.Template
+	if err := json.Unmarshal(metadata, &template); err != nil {
+		return models.Template{}, fmt.Errorf("%w: unreadable metadata: %v", bundle.ErrInvalidBundle, err)
+	}
+	if template.Name == "" {
+		return models.Template{}, fmt.Errorf("%w: the template has no name", bundle.ErrInvalidBundle)
+	}
+
+	template.ID = id
+	template.ServerJarURL = archivePath
+	template.Changelog = fmt.Sprintf("Imported from version %d of a bundle signed by %s.", template.Version, signer)
+	if err := s.insertTemplate(template); err != nil {
+		return models.Template{}, err
+	}
+	return s.GetTemplateByID(id)
+}
+
+// SigningKey returns the public key exported bundles are signed with, for other hosts to trust.
+func (s *TemplateService) SigningKey() string {
+	return s.keys.PublicKey()
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/docker"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrTerminalLimit is returned when a server already has as many open shells as allowed.
+	ErrTerminalLimit = errors.New("too many open terminal sessions")
+	// ErrTerminalSessionNotFound is returned for unknown terminal sessions.
+	ErrTerminalSessionNotFound = errors.New("terminal session not found")
+)
+
+// maxRecordingSize is the size at which a session's recording stops growing. The session itself goes on.
+const maxRecordingSize = 16 * 1024 * 1024
+
+// terminalShell starts bash where the image has it, and sh otherwise.
+var terminalShell = []string{"sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; else exec sh; fi"}
+
+// TerminalSize is the size of a terminal in characters.
+type TerminalSize struct {
+	Cols uint `json:"cols"`
+	Rows uint `json:"rows"`
+}
+
+// TerminalServiceProvider defines the interface for interactive shells in server containers.
+type TerminalServiceProvider interface {
+	OpenSession(ctx context.Context, serverID string, size TerminalSize, output func([]byte)) (*TerminalSession, error)
+	ListSessions(ctx context.Context, serverID string) ([]models.TerminalSession, error)
+	OpenRecording(ctx context.Context, serverID, sessionID string) (io.ReadCloser, error)
+	CloseAll()
+}
+
+// TerminalService runs interactive shells in server containers through TTY execs. Every session is recorded
+// in asciicast v2 format, which asciinema can play back, and kept with its user, duration and exit code for
+// auditing. Recordings outlive the server they were made on.
+type TerminalService struct {
+	db            *sql.DB
+	docker        *docker.Client
+	serverService ServerServiceProvider
+	eventService  EventServiceProvider
+	dir           string
+	maxSessions   int
+	idleTimeout   time.Duration
+
+	mu   sync.Mutex
+	open map[string]map[*TerminalSession]struct{} // By server ID
+}
+
+// NewTerminalService creates a new TerminalService that keeps recordings in dir, allows maxSessions open
+// shells per server and closes shells that get no input for idleTimeout.
+func NewTerminalService(db *sql.DB, docker *docker.Client, serverService ServerServiceProvider, eventService EventServiceProvider, dir string, maxSessions int, idleTimeout time.Duration) *TerminalService {
+	return &TerminalService{
+		db:            db,
+		docker:        docker,
+		serverService: serverService,
+		eventService:  eventService,
+		dir:           dir,
+		maxSessions:   max(maxSessions, 1),
+		idleTimeout:   idleTimeout,
+		open:          make(map[string]map[*TerminalSession]struct{}),
+	}
+}
+
+// OpenSession starts a shell in a server's container, credited to the user in ctx. The shell's output is
+// passed to output, from a single goroutine, in chunks that don't split UTF-8 sequences.
+func (s *TerminalService) OpenSession(ctx context.Context, serverID string, size TerminalSize, output func([]byte)) (*TerminalSession, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if server.Status != "online" {
+		return nil, fmt.Errorf("server is not online")
+	}
+
+	session := &TerminalSession{
+		service: s,
+		record: models.TerminalSession{
+			ID:        uuid.New().String(),
+			ServerID:  server.ID,
+			StartedAt: time.Now().UTC(),
+		},
+		serverName: server.Name,
+		size:       size,
+		done:       make(chan struct{}),
+	}
+	if claims, ok := auth.ClaimsFromContext(ctx); ok {
+		session.record.UserID, session.record.Username = claims.UserID, claims.Username
+	}
+	// The session outlives the request that opened it.
+	session.ctx = context.WithoutCancel(ctx)
+
+	s.mu.Lock()
+	if len(s.open[server.ID]) >= s.maxSessions {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: server '%s' already has %d", ErrTerminalLimit, server.Name, s.maxSessions)
+	}
+	if s.open[server.ID] == nil {
+		s.open[server.ID] = make(map[*TerminalSession]struct{})
+	}
+	s.open[server.ID][session] = struct{}{}
+	s.mu.Unlock()
+
+	if err := session.start(server.DockerContainerID); err != nil {
+		s.forget(session)
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx, "INSERT INTO terminal_sessions (id, server_id, user_id, username, started_at) VALUES (?, ?, ?, ?, ?)",
+		session.record.ID, session.record.ServerID, session.record.UserID, session.record.Username, session.record.StartedAt)
+	if err != nil {
+		log.Error().Ctx(ctx).Err(err).Str("session_id", session.record.ID).Msg("Failed to record terminal session")
+	}
+	s.eventService.CreateEvent(ctx, "terminal.open", "info", fmt.Sprintf("A shell was opened on server '%s'%s.", server.Name, byUser(session.record.Username)), &server.ID)
+	log.Info().Ctx(ctx).Str("server_id", server.ID).Str("session_id", session.record.ID).Str("username", session.record.Username).Msg("Terminal session opened")
+
+	session.idle = time.AfterFunc(s.idleTimeout, func() { session.end("idle") })
+	go session.pump(output)
+	return session, nil
+}
+
+// ListSessions lists the terminal sessions of a server, newest first.
+func (s *TerminalService) ListSessions(ctx context.Context, serverID string) ([]models.TerminalSession, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, server_id, user_id, username, started_at, ended_at, end_reason, exit_code, recording_size, truncated FROM terminal_sessions WHERE server_id = ? ORDER BY started_at DESC LIMIT 200", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.TerminalSession{}
+	for rows.Next() {
+		var session models.TerminalSession
+		var userID, username, reason sql.NullString
+		var endedAt sql.NullTime
+		var exitCode sql.NullInt64
+		if err := rows.Scan(&session.ID, &session.ServerID, &userID, &username, &session.StartedAt, &endedAt, &reason, &exitCode, &session.RecordingSize, &session.Truncated); err != nil {
+			return nil, err
+		}
+		session.UserID, session.Username, session.EndReason = userID.String, username.String, reason.String
+		if endedAt.Valid {
+			session.EndedAt = &endedAt.Time
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			session.ExitCode = &code
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// OpenRecording opens the asciicast recording of a session.
+func (s *TerminalService) OpenRecording(ctx context.Context, serverID, sessionID string) (io.ReadCloser, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM terminal_sessions WHERE id = ? AND server_id = ?", sessionID, serverID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrTerminalSessionNotFound, sessionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.recordingPath(serverID, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: the recording of %s is missing", ErrTerminalSessionNotFound, sessionID)
+	}
+	return f, err
+}
+
+// CloseAll ends every open session, for shutdown.
+func (s *TerminalService) CloseAll() {
+	s.mu.Lock()
+	var sessions []*TerminalSession
+	for _, open := range s.open {
+		for session := range open {
+			sessions = append(sessions, session)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.end("shutdown")
+	}
+	for _, session := range sessions {
+		<-session.done
+	}
+}
+
+func (s *TerminalService) forget(session *TerminalSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.open[session.record.ServerID], session)
+	if len(s.open[session.record.ServerID]) == 0 {
+		delete(s.open, session.record.ServerID)
+	}
+}
+
+func (s *TerminalService) recordingPath(serverID, sessionID string) string {
+	return filepath.Join(s.dir, serverID, sessionID+".cast")
+}
+
+// byUser credits an action to a user in an event message, if one is known.
+func byUser(username string) string {
+	if username == "" {
+		return ""
+	}
+	return " by " + username
+}
+
+// TerminalSession is an open shell in a server's container.
+type TerminalSession struct {
+	service    *TerminalService
+	ctx        context.Context
+	record     models.TerminalSession
+	serverName string
+	size       TerminalSize
+	execID     string
+	conn       types.HijackedResponse
+	idle       *time.Timer
+	done       chan struct{}
+
+	mu        sync.Mutex // Guards the recording and the end reason
+	recording *os.File
+	reason    string
+}
+
+// ID returns the ID of the session.
+func (t *TerminalSession) ID() string {
+	return t.record.ID
+}
+
+// start creates the exec and its recording.
+func (t *TerminalSession) start(containerID string) error {
+	s := t.service
+	size := &[2]uint{t.size.Rows, t.size.Cols}
+	if t.size.Rows == 0 || t.size.Cols == 0 {
+		size = nil
+	}
+	exec, err := s.docker.ContainerExecCreate(t.ctx, containerID, container.ExecOptions{
+		Tty:          true,
+		ConsoleSize:  size,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+		WorkingDir:   "/data",
+		Cmd:          terminalShell,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create shell in container: %w", err)
+	}
+	t.execID = exec.ID
+
+	path := s.recordingPath(t.record.ServerID, t.record.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create recording directory: %w", err)
+	}
+	t.recording, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create recording: %w", err)
+	}
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     max(t.size.Cols, 1),
+		"height":    max(t.size.Rows, 1),
+		"timestamp": t.record.StartedAt.Unix(),
+		"env":       map[string]string{"TERM": "xterm-256color", "SHELL": "/bin/sh"},
+		"title":     fmt.Sprintf("%s on %s", t.record.Username, t.serverName),
+	})
+	t.recordLine(header)
+
+	t.conn, err = s.docker.ContainerExecAttach(t.ctx, exec.ID, container.ExecAttachOptions{Tty: true, ConsoleSize: size})
+	if err != nil {
+		t.recording.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to attach to shell in container: %w", err)
+	}
+	return nil
+}
+
+// Write sends input to the shell. Input isn't recorded, since it may hold passwords typed at prompts that
+// don't echo them; what the shell echoes is.
+func (t *TerminalSession) Write(p []byte) (int, error) {
+	t.idle.Reset(t.service.idleTimeout)
+	return t.conn.Conn.Write(p)
+}
+
+// Resize changes the size of the shell's terminal.
+func (t *TerminalSession) Resize(size TerminalSize) error {
+	if size.Cols == 0 || size.Rows == 0 {
+		return fmt.Errorf("terminal size must be at least 1x1")
+	}
+	t.idle.Reset(t.service.idleTimeout)
+	t.recordEvent("r", fmt.Sprintf("%dx%d", size.Cols, size.Rows))
+	return t.service.docker.ContainerExecResize(t.ctx, t.execID, container.ResizeOptions{Height: size.Rows, Width: size.Cols})
+}
+
+// Done is closed when the session has ended and its record is complete.
+func (t *TerminalSession) Done() <-chan struct{} {
+	return t.done
+}
+
+// Reason returns why the session ended: "exited", "closed", "idle" or "shutdown". It is "" while it is open.
+func (t *TerminalSession) Reason() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reason
+}
+
+// ExitCode returns the exit code of the shell once the session has ended, if the shell exited by itself.
+func (t *TerminalSession) ExitCode() *int {
+	<-t.done
+	return t.record.ExitCode
+}
+
+// Close ends the session and waits for its record to be complete.
+func (t *TerminalSession) Close() {
+	t.end("closed")
+	<-t.done
+}
+
+// end closes the connection to the shell, which ends the pump. The first reason given sticks.
+func (t *TerminalSession) end(reason string) {
+	t.mu.Lock()
+	if t.reason == "" {
+		t.reason = reason
+	}
+	t.mu.Unlock()
+	t.conn.Close()
+}
+
+// pump passes the shell's output on and records it until the connection closes, then completes the record.
+func (t *TerminalSession) pump(output func([]byte)) {
+	buf := make([]byte, 32*1024)
+	var pending int // Bytes at the start of buf left over from an incomplete UTF-8 sequence
+	for {
+		n, err := t.conn.Reader.Read(buf[pending:])
+		if n > 0 {
+			data := buf[:pending+n]
+			complete := len(data) - incompleteSuffix(data)
+			if complete > 0 {
+				chunk := append([]byte(nil), data[:complete]...)
+				t.recordEvent("o", string(chunk))
+				output(chunk)
+			}
+			pending = copy(buf, data[complete:])
+		}
+		if err != nil {
+			break
+		}
+	}
+	t.finish()
+}
+
+// incompleteSuffix returns the length of a UTF-8 sequence cut off at the end of p.
+func incompleteSuffix(p []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if c < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// finish completes the session's record once the shell is gone.
+func (t *TerminalSession) finish() {
+	s := t.service
+	t.idle.Stop()
+	t.conn.Close()
+
+	t.mu.Lock()
+	if t.reason == "" {
+		t.reason = "exited"
+	}
+	reason := t.reason
+	if t.recording != nil {
+		if info, err := t.recording.Stat(); err == nil {
+			t.record.RecordingSize = info.Size()
+		}
+		t.recording.Close()
+		t.recording = nil
+	}
+	t.mu.Unlock()
+
+	if reason == "exited" {
+		if inspect, err := s.docker.ContainerExecInspect(t.ctx, t.execID); err == nil && !inspect.Running {
+			code := inspect.ExitCode
+			t.record.ExitCode = &code
+		}
+	}
+	now := time.Now().UTC()
+	t.record.EndedAt, t.record.EndReason = &now, reason
+
+	_, err := s.db.ExecContext(t.ctx, "UPDATE terminal_sessions SET ended_at = ?, end_reason = ?, exit_code = ?, recording_size = ?, truncated = ? WHERE id = ?",
+		now, reason, t.record.ExitCode, t.record.RecordingSize, t.record.Truncated, t.record.ID)
+	if err != nil {
+		log.Error().Ctx(t.ctx).Err(err).Str("session_id", t.record.ID).Msg("Failed to record end of terminal session")
+	}
+	duration := now.Sub(t.record.StartedAt).Round(time.Second)
+	s.eventService.CreateEvent(t.ctx, "terminal.close", "info", fmt.Sprintf("A shell opened on server '%s'%s ended after %s (%s).", t.serverName, byUser(t.record.Username), duration, reason), &t.record.ServerID)
+	log.Info().Ctx(t.ctx).Str("session_id", t.record.ID).Str("reason", reason).Dur("duration", duration).Msg("Terminal session ended")
+
+	s.forget(t)
+	close(t.done)
+}
+
+// recordEvent appends an event to the recording: output ("o") or a resize ("r").
+func (t *TerminalSession) recordEvent(kind, data string) {
+	line, _ := json.Marshal([]any{time.Since(t.record.StartedAt).Seconds(), kind, data})
+	t.recordLine(line)
+}
+
+func (t *TerminalSession) recordLine(line []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recording == nil || t.record.Truncated {
+		return
+	}
+	if t.record.RecordingSize+int64(len(line))+1 > maxRecordingSize {
+		t.record.Truncated = true
+		log.Warn().Str("session_id", t.record.ID).Msg("Terminal recording reached its size limit")
+		return
+	}
+	n, err := t.recording.Write(append(line, '\n'))
+	t.record.RecordingSize += int64(n)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", t.record.ID).Msg("Failed to write terminal recording")
+		t.recording.Close()
+		t.recording = nil
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"io"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TracedServerService wraps a ServerServiceProvider so every call gets its own span.
+// Calls made without a parent span (background pollers) are passed through untraced.
+type TracedServerService struct {
+	next ServerServiceProvider
+}
+
+// NewTracedServerService creates a new TracedServerService.
+func NewTracedServerService(next ServerServiceProvider) *TracedServerService {
+	return &TracedServerService{next: next}
+}
+
+func serverAttr(serverID string) attribute.KeyValue {
+	return attribute.String("server.id", serverID)
+}
+
+func (t *TracedServerService) GetAllServers(ctx context.Context) (servers []models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetAllServers")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetAllServers(ctx)
+}
+
+func (t *TracedServerService) GetServerByID(ctx context.Context, id string) (server models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetServerByID", serverAttr(id))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetServerByID(ctx, id)
+}
+
+func (t *TracedServerService) CreateServerFromTemplate(ctx context.Context, name, templateId string, templateVersion int, runtimeID string) (server models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.CreateServerFromTemplate", attribute.String("template.id", templateId), attribute.Int("template.version", templateVersion))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateServerFromTemplate(ctx, name, templateId, templateVersion, runtimeID)
+}
+
+func (t *TracedServerService) CloneServer(ctx context.Context, id string, options CloneServerOptions) (clone models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.CloneServer", serverAttr(id), attribute.Bool("clone.include_worlds", options.IncludeWorlds))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CloneServer(ctx, id, options)
+}
+
+func (t *TracedServerService) MoveServer(ctx context.Context, id, storageRoot string) (moved models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.MoveServer", serverAttr(id), attribute.String("storage.root", storageRoot))
+	defer func() { tracing.End(span, err) }()
+	return t.next.MoveServer(ctx, id, storageRoot)
+}
+
+func (t *TracedServerService) GetStorageRoots(ctx context.Context) (roots []models.StorageRoot, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetStorageRoots")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetStorageRoots(ctx)
+}
+
+func (t *TracedServerService) UpdateServer(ctx context.Context, id string, server models.Server) (updated models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateServer", serverAttr(id))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateServer(ctx, id, server)
+}
+
+func (t *TracedServerService) UpdateServerRuntime(ctx context.Context, id string, runtime ServerRuntime) (updated models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateServerRuntime", serverAttr(id), attribute.String("server.minecraft_version", runtime.MinecraftVersion))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateServerRuntime(ctx, id, runtime)
+}
+
+func (t *TracedServerService) ChangeServerRuntime(ctx context.Context, id, runtimeID string) (updated models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.ChangeServerRuntime", serverAttr(id), attribute.String("runtime.id", runtimeID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.ChangeServerRuntime(ctx, id, runtimeID)
+}
+
+func (t *TracedServerService) DeleteServer(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.DeleteServer", serverAttr(id))
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteServer(ctx, id)
+}
+
+func (t *TracedServerService) PerformServerAction(ctx context.Context, id, action string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.PerformServerAction", serverAttr(id), attribute.String("server.action", action))
+	defer func() { tracing.End(span, err) }()
+	return t.next.PerformServerAction(ctx, id, action)
+}
+
+func (t *TracedServerService) UpdateServerStats(ctx context.Context, server models.Server) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateServerStats", serverAttr(server.ID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateServerStats(ctx, server)
+}
+
+func (t *TracedServerService) UpdateServerGameMetrics(ctx context.Context, serverID string, metrics *models.GameMetrics) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateServerGameMetrics", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateServerGameMetrics(ctx, serverID, metrics)
+}
+
+func (t *TracedServerService) SendCommandToServer(ctx context.Context, serverID, command string) (response string, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.SendCommandToServer", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.SendCommandToServer(ctx, serverID, command)
+}
+
+func (t *TracedServerService) SendRconCommand(ctx context.Context, serverID, command string) (response string, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.SendRconCommand", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.SendRconCommand(ctx, serverID, command)
+}
+
+func (t *TracedServerService) StreamServerLogs(ctx context.Context, serverID string, send func(message []byte)) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.StreamServerLogs", serverAttr(serverID))
+	defer span.End()
+	t.next.StreamServerLogs(ctx, serverID, send)
+}
+
+func (t *TracedServerService) ListFiles(ctx context.Context, serverID, path string) (files []models.FileInfo, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.ListFiles", serverAttr(serverID), attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return t.next.ListFiles(ctx, serverID, path)
+}
+
+func (t *TracedServerService) GetFileContent(ctx context.Context, serverID, path string) (content []byte, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetFileContent", serverAttr(serverID), attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetFileContent(ctx, serverID, path)
+}
+
+func (t *TracedServerService) UpdateFileContent(ctx context.Context, serverID, path string, content []byte, checkSchema bool) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateFileContent", serverAttr(serverID), attribute.String("file.path", path))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateFileContent(ctx, serverID, path, content, checkSchema)
+}
+
+func (t *TracedServerService) GetServerSettings(ctx context.Context, serverID string) (settings models.ServerSettings, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetServerSettings", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetServerSettings(ctx, serverID)
+}
+
+func (t *TracedServerService) UpdateServerSettings(ctx context.Context, serverID string, settings models.ServerSettings) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateServerSettings", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateServerSettings(ctx, serverID, settings)
+}
+
+func (t *TracedServerService) GetJVMConfig(ctx context.Context, serverID string) (config JVMConfig, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetJVMConfig", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetJVMConfig(ctx, serverID)
+}
+
+func (t *TracedServerService) UpdateJVMSettings(ctx context.Context, serverID string, settings models.JVMSettings) (config JVMConfig, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.UpdateJVMSettings", serverAttr(serverID), attribute.String("jvm.profile", settings.Profile))
+	defer func() { tracing.End(span, err) }()
+	return t.next.UpdateJVMSettings(ctx, serverID, settings)
+}
+
+func (t *TracedServerService) GetDashboardStatistics(ctx context.Context) (stats models.DashboardStats, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetDashboardStatistics")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetDashboardStatistics(ctx)
+}
+
+func (t *TracedServerService) GetOnlinePlayers(ctx context.Context, serverID string) (players []models.OnlinePlayer, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetOnlinePlayers", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetOnlinePlayers(ctx, serverID)
+}
+
+func (t *TracedServerService) ManagePlayer(ctx context.Context, serverID, action, playerName, reason string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.ManagePlayer", serverAttr(serverID), attribute.String("player.action", action))
+	defer func() { tracing.End(span, err) }()
+	return t.next.ManagePlayer(ctx, serverID, action, playerName, reason)
+}
+
+func (t *TracedServerService) CreateServerFromUpload(ctx context.Context, name, javaVersion, runtimeID, serverExecutable string, maxMemoryMB int, fileReader io.Reader) (server models.Server, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.CreateServerFromUpload", attribute.String("server.java_version", javaVersion))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateServerFromUpload(ctx, name, javaVersion, runtimeID, serverExecutable, maxMemoryMB, fileReader)
+}
+
+func (t *TracedServerService) ExecuteTerminalCommand(ctx context.Context, serverID, command string) (output string, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.ExecuteTerminalCommand", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.ExecuteTerminalCommand(ctx, serverID, command)
+}
+
+func (t *TracedServerService) GetSystemResourceStats(ctx context.Context) (stats map[string]int, err error) {
+	ctx, span := tracing.StartChild(ctx, "ServerService.GetSystemResourceStats")
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetSystemResourceStats(ctx)
+}
+
+// TracedBackupService wraps a BackupServiceProvider so every call gets its own span.
+type TracedBackupService struct {
+	next BackupServiceProvider
+}
+
+// NewTracedBackupService creates a new TracedBackupService.
+func NewTracedBackupService(next BackupServiceProvider) *TracedBackupService {
+	return &TracedBackupService{next: next}
+}
+
+func backupAttr(backupID string) attribute.KeyValue {
+	return attribute.String("backup.id", backupID)
+}
+
+func (t *TracedBackupService) CreateBackup(ctx context.Context, serverID, name string) (backup models.Backup, err error) {
+	ctx, span := tracing.StartChild(ctx, "BackupService.CreateBackup", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.CreateBackup(ctx, serverID, name)
+}
+
+func (t *TracedBackupService) GetBackupsForServer(ctx context.Context, serverID string) (backups []models.Backup, err error) {
+	ctx, span := tracing.StartChild(ctx, "BackupService.GetBackupsForServer", serverAttr(serverID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetBackupsForServer(ctx, serverID)
+}
+
+func (t *TracedBackupService) DeleteBackup(ctx context.Context, backupID string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "BackupService.DeleteBackup", backupAttr(backupID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.DeleteBackup(ctx, backupID)
+}
+
+func (t *TracedBackupService) RestoreBackup(ctx context.Context, backupID string) (err error) {
+	ctx, span := tracing.StartChild(ctx, "BackupService.RestoreBackup", backupAttr(backupID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.RestoreBackup(ctx, backupID)
+}
+
+func (t *TracedBackupService) GetBackupByID(ctx context.Context, backupID string) (backup models.Backup, err error) {
+	ctx, span := tracing.StartChild(ctx, "BackupService.GetBackupByID", backupAttr(backupID))
+	defer func() { tracing.End(span, err) }()
+	return t.next.GetBackupByID(ctx, backupID)
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/installer"
+	"github.com/isdelr/ender-deploy-be/internal/jvm"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// UpgradeServiceProvider defines the interface for upgrade services.
+type UpgradeServiceProvider interface {
+	UpgradeServer(ctx context.Context, serverID string, target UpgradeTarget) (models.Server, error)
+}
+
+// UpgradeTarget is what a server is upgraded to. Empty fields keep the server's software and pick the newest loader.
+type UpgradeTarget struct {
+	ServerType       string `json:"serverType,omitempty"`
+	MinecraftVersion string `json:"minecraftVersion"`
+	LoaderVersion    string `json:"loaderVersion,omitempty"`
+}
+
+// UpgradeService swaps the server software of existing servers, rolling back to a backup if the new version fails to boot.
+type UpgradeService struct {
+	serverService ServerServiceProvider
+	backupService BackupServiceProvider
+	eventService  EventServiceProvider
+	installer     *installer.Installer
+}
+
+// upgradeStartTimeout bounds how long an upgraded server may take to come online. It is longer than the
+// RCON polling timeout, so a server that never answers is normally reported offline before this expires.
+const upgradeStartTimeout = 4 * time.Minute
+
+// NewUpgradeService creates a new UpgradeService.
+func NewUpgradeService(serverService ServerServiceProvider, backupService BackupServiceProvider, eventService EventServiceProvider, installer *installer.Installer) *UpgradeService {
+	return &UpgradeService{
+		serverService: serverService,
+		backupService: backupService,
+		eventService:  eventService,
+		installer:     installer,
+	}
+}
+
+// UpgradeServer backs a server up, installs the target software into its data directory, regenerates start.sh,
+// moves the container to a newer JDK if the target needs one and boots the server. If anything fails after the
+// backup was taken, the server is restored from it.
+func (s *UpgradeService) UpgradeServer(ctx context.Context, serverID string, target UpgradeTarget) (_ models.Server, err error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.Server{}, fmt.Errorf("could not find server: %w", err)
+	}
+
+	software := strings.ToLower(target.ServerType)
+	if software == "" {
+		software = server.ServerType
+	}
+	if software == "" {
+		return models.Server{}, fmt.Errorf("the server software of '%s' is unknown; specify the server type to upgrade to", server.Name)
+	}
+	if target.MinecraftVersion == "" {
+		return models.Server{}, fmt.Errorf("no Minecraft version to upgrade to")
+	}
+	wasRunning := server.Status == "online" || server.Status == "starting"
+
+	backup, err := s.backupService.CreateBackup(ctx, serverID, fmt.Sprintf("Before upgrade to %s %s", software, target.MinecraftVersion))
+	if err != nil {
+		return models.Server{}, fmt.Errorf("failed to back up server before upgrade: %w", err)
+	}
+
+	msg := fmt.Sprintf("Upgrade of server '%s' from %s %s to %s %s started.", server.Name, server.ServerType, server.MinecraftVersion, software, target.MinecraftVersion)
+	s.eventService.CreateEvent(ctx, "server.upgrade.start", "info", msg, &server.ID)
+
+	// From here on the server's files are being replaced; a half-done upgrade is rolled back rather than abandoned.
+	ctx = context.WithoutCancel(ctx)
+
+	upgraded, err := s.upgrade(ctx, server, installer.Spec{
+		Software:         installer.Software(software),
+		MinecraftVersion: target.MinecraftVersion,
+		Version:          target.LoaderVersion,
+	})
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Str("backup_id", backup.ID).Msg("Upgrade failed, rolling back")
+		if rollbackErr := s.rollback(ctx, server, backup, wasRunning); rollbackErr != nil {
+			msg := fmt.Sprintf("Upgrade of server '%s' failed and could not be rolled back: %v", server.Name, rollbackErr)
+			s.eventService.CreateEvent(ctx, "server.upgrade.rollback.fail", "error", msg, &server.ID)
+			return models.Server{}, fmt.Errorf("upgrade failed: %v; rollback to backup '%s' also failed: %w", err, backup.Name, rollbackErr)
+		}
+		msg := fmt.Sprintf("Upgrade of server '%s' failed and was rolled back: %v", server.Name, err)
+		s.eventService.CreateEvent(ctx, "server.upgrade.rollback", "error", msg, &server.ID)
+		return models.Server{}, fmt.Errorf("upgrade failed and was rolled back to backup '%s': %w", backup.Name, err)
+	}
+
+	if !wasRunning {
+		if err := s.serverService.PerformServerAction(ctx, serverID, "stop"); err != nil {
+			log.Warn().Ctx(ctx).Err(err).Str("server_id", serverID).Msg("Failed to stop server again after upgrade")
+		}
+	}
+
+	msg = fmt.Sprintf("Server '%s' was upgraded to %s %s.", server.Name, upgraded.ServerType, upgraded.MinecraftVersion)
+	s.eventService.CreateEvent(ctx, "server.upgrade.finish", "info", msg, &server.ID)
+	return s.serverService.GetServerByID(ctx, serverID)
+}
+
+// upgrade installs spec over the server's files and boots it, returning once it is online.
+func (s *UpgradeService) upgrade(ctx context.Context, server models.Server, spec installer.Spec) (models.Server, error) {
+	if server.Status != "offline" {
+		reportProgress(ctx, "stopping server", 0, 0, "")
+		if err := s.serverService.PerformServerAction(ctx, server.ID, "stop"); err != nil {
+			return models.Server{}, fmt.Errorf("failed to stop server: %w", err)
+		}
+	}
+
+	dataPath, err := filepath.Abs(server.DataPath)
+	if err != nil {
+		return models.Server{}, err
+	}
+	reportProgress(ctx, "installing server software", 0, 0, "")
+	result, err := s.installer.Install(ctx, spec, dataPath)
+	if err != nil {
+		return models.Server{}, err
+	}
+
+	// Only ever move to a newer JDK; a server already on a runtime new enough for the version keeps it.
+	javaVersion, runtimeID := server.JavaVersion, server.RuntimeID
+	if required := installer.JavaVersionFor(result.MinecraftVersion); javaMajor(required) > javaMajor(javaVersion) {
+		javaVersion, runtimeID = required, ""
+	}
+	upgraded, err := s.serverService.UpdateServerRuntime(ctx, server.ID, ServerRuntime{
+		ServerType:       string(result.Software),
+		MinecraftVersion: result.MinecraftVersion,
+		LoaderVersion:    result.Version,
+		JavaVersion:      javaVersion,
+		RuntimeID:        runtimeID,
+	})
+	if err != nil {
+		return models.Server{}, err
+	}
+	launch := jvm.Launch{Jar: result.Jar, RunScript: result.RunScript}
+	if err := jvm.WriteStartScript(dataPath, launch, server.JVM, server.MaxMemoryMB, javaMajor(upgraded.JavaVersion)); err != nil {
+		return models.Server{}, err
+	}
+
+	reportProgress(ctx, "starting server", 0, 0, "")
+	if err := s.serverService.PerformServerAction(ctx, server.ID, "start"); err != nil {
+		return models.Server{}, fmt.Errorf("failed to start upgraded server: %w", err)
+	}
+	if err := s.waitForStart(ctx, server.ID); err != nil {
+		return models.Server{}, err
+	}
+	return upgraded, nil
+}
+
+// rollback puts the server back on its previous runtime and restores the pre-upgrade backup.
+func (s *UpgradeService) rollback(ctx context.Context, server models.Server, backup models.Backup, wasRunning bool) error {
+	reportProgress(ctx, "rolling back", 0, 0, "")
+	if err := s.serverService.PerformServerAction(ctx, server.ID, "stop"); err != nil {
+		return fmt.Errorf("failed to stop server: %w", err)
+	}
+	_, err := s.serverService.UpdateServerRuntime(ctx, server.ID, ServerRuntime{
+		ServerType:       server.ServerType,
+		MinecraftVersion: server.MinecraftVersion,
+		LoaderVersion:    server.LoaderVersion,
+		JavaVersion:      server.JavaVersion,
+		RuntimeID:        server.RuntimeID,
+	})
+	if err != nil {
+		return err
+	}
+	// Restoring starts the server again.
+	if err := s.backupService.RestoreBackup(ctx, backup.ID); err != nil {
+		return err
+	}
+	if !wasRunning {
+		return s.serverService.PerformServerAction(ctx, server.ID, "stop")
+	}
+	return nil
+}
+
+// waitForStart watches a starting server until the RCON poller reports it online, or offline if it crashed
+// or never became ready.
+func (s *UpgradeService) waitForStart(ctx context.Context, serverID string) error {
+	ctx, cancel := context.WithTimeout(ctx, upgradeStartTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server did not come online within %s", upgradeStartTimeout)
+		case <-ticker.C:
+			server, err := s.serverService.GetServerByID(ctx, serverID)
+			if err != nil {
+				return err
+			}
+			switch server.Status {
+			case "online":
+				return nil
+			case "offline":
+				return fmt.Errorf("server stopped before it came online")
+			}
+		}
+	}
+}
@@ -2,11 +2,24 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/isdelr/ender-deploy-be/internal/models"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrSSHKeyNotFound is returned for unknown SSH keys.
+	ErrSSHKeyNotFound = errors.New("ssh key not found")
+	// ErrInvalidSSHKey is returned for public keys that can't be parsed.
+	ErrInvalidSSHKey = errors.New("invalid ssh public key")
+	// ErrSSHKeyExists is returned when adding a key that is already registered, by any user.
+	ErrSSHKeyExists = errors.New("ssh key is already registered")
 )
 
 // UserServiceProvider defines the interface for user services.
@@ -16,7 +29,13 @@ type UserServiceProvider interface {
 	UpdateUser(id, username, email string) (models.User, error)
 	UpdatePassword(id, currentPassword, newPassword string) error
 	DeleteUser(id string) error
+	SetSFTPReadOnly(id string, readOnly bool) (models.User, error)
 	AuthenticateUser(email, password string) (models.User, error)
+	AuthenticateUsername(username, password string) (models.User, error)
+	ListSSHKeys(userID string) ([]models.SSHKey, error)
+	AddSSHKey(userID, name, publicKey string, readOnly bool) (models.SSHKey, error)
+	DeleteSSHKey(userID, keyID string) error
+	GetSSHKeyByFingerprint(fingerprint string) (models.SSHKey, error)
 }
 
 // UserService provides business logic for user management.
@@ -32,8 +51,8 @@ func NewUserService(db *sql.DB) *UserService {
 // GetUserByID retrieves a single user by their ID.
 func (s *UserService) GetUserByID(id string) (models.User, error) {
 	var user models.User
-	row := s.db.QueryRow("SELECT id, username, email, created_at FROM users WHERE id = ?", id)
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+	row := s.db.QueryRow("SELECT id, username, email, sftp_read_only, created_at FROM users WHERE id = ?", id)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.SFTPReadOnly, &user.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return models.User{}, fmt.Errorf("user with ID %s not found", id)
@@ -46,8 +65,8 @@ func (s *UserService) GetUserByID(id string) (models.User, error) {
 // GetUserByEmail retrieves a single user by their email, including the password hash.
 func (s *UserService) GetUserByEmail(email string) (models.User, error) {
 	var user models.User
-	row := s.db.QueryRow("SELECT id, username, email, password_hash, created_at FROM users WHERE email = ?", email)
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	row := s.db.QueryRow("SELECT id, username, email, password_hash, sftp_read_only, created_at FROM users WHERE email = ?", email)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.SFTPReadOnly, &user.CreatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return models.User{}, fmt.Errorf("user with email %s not found", email)
@@ -126,8 +145,19 @@ func (s *UserService) UpdatePassword(id, currentPassword, newPassword string) er
 	return err
 }
 
-// DeleteUser removes a user from the database.
+// SetSFTPReadOnly sets whether a user's SFTP sessions are limited to reading files.
+func (s *UserService) SetSFTPReadOnly(id string, readOnly bool) (models.User, error) {
+	if _, err := s.db.Exec("UPDATE users SET sftp_read_only = ? WHERE id = ?", readOnly, id); err != nil {
+		return models.User{}, err
+	}
+	return s.GetUserByID(id)
+}
+
+// DeleteUser removes a user and their SSH keys from the database.
 func (s *UserService) DeleteUser(id string) error {
+	if _, err := s.db.Exec("DELETE FROM ssh_keys WHERE user_id = ?", id); err != nil {
+		return err
+	}
 	_, err := s.db.Exec("DELETE FROM users WHERE id = ?", id)
 	return err
 }
@@ -148,3 +178,101 @@ func (s *UserService) AuthenticateUser(email, password string) (models.User, err
 	user.PasswordHash = ""
 	return user, nil
 }
+
+// AuthenticateUsername verifies a user's credentials by username rather than email, for logins such as
+// SFTP's that have no room for an email address.
+func (s *UserService) AuthenticateUsername(username, password string) (models.User, error) {
+	var user models.User
+	row := s.db.QueryRow("SELECT id, username, email, password_hash, sftp_read_only, created_at FROM users WHERE username = ?", username)
+	if err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.SFTPReadOnly, &user.CreatedAt); err != nil {
+		return models.User{}, fmt.Errorf("authentication failed: user not found")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return models.User{}, fmt.Errorf("authentication failed: invalid password")
+	}
+
+	user.PasswordHash = ""
+	return user, nil
+}
+
+// ListSSHKeys lists the SSH keys of a user.
+func (s *UserService) ListSSHKeys(userID string) ([]models.SSHKey, error) {
+	rows, err := s.db.Query("SELECT id, user_id, name, public_key, fingerprint, read_only, created_at FROM ssh_keys WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.SSHKey{}
+	for rows.Next() {
+		key, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// AddSSHKey registers a public key, given in authorized_keys format, that a user can log in to the SFTP
+// server with. Without a name, the key's comment is used.
+func (s *UserService) AddSSHKey(userID, name, publicKey string, readOnly bool) (models.SSHKey, error) {
+	parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return models.SSHKey{}, fmt.Errorf("%w: %v", ErrInvalidSSHKey, err)
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = comment
+	}
+
+	key := models.SSHKey{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        name,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(parsed))),
+		Fingerprint: ssh.FingerprintSHA256(parsed),
+		ReadOnly:    readOnly,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if _, err := s.GetSSHKeyByFingerprint(key.Fingerprint); err == nil {
+		return models.SSHKey{}, fmt.Errorf("%w: %s", ErrSSHKeyExists, key.Fingerprint)
+	}
+
+	_, err = s.db.Exec("INSERT INTO ssh_keys (id, user_id, name, public_key, fingerprint, read_only, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.UserID, key.Name, key.PublicKey, key.Fingerprint, key.ReadOnly, key.CreatedAt)
+	if err != nil {
+		return models.SSHKey{}, err
+	}
+	return key, nil
+}
+
+// DeleteSSHKey removes one of a user's SSH keys.
+func (s *UserService) DeleteSSHKey(userID, keyID string) error {
+	result, err := s.db.Exec("DELETE FROM ssh_keys WHERE id = ? AND user_id = ?", keyID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrSSHKeyNotFound, keyID)
+	}
+	return nil
+}
+
+// GetSSHKeyByFingerprint finds the SSH key with a SHA256 fingerprint.
+func (s *UserService) GetSSHKeyByFingerprint(fingerprint string) (models.SSHKey, error) {
+	row := s.db.QueryRow("SELECT id, user_id, name, public_key, fingerprint, read_only, created_at FROM ssh_keys WHERE fingerprint = ?", fingerprint)
+	key, err := scanSSHKey(row)
+	if err == sql.ErrNoRows {
+		return models.SSHKey{}, fmt.Errorf("%w: %s", ErrSSHKeyNotFound, fingerprint)
+	}
+	return key, err
+}
+
+func scanSSHKey(row interface{ Scan(...any) error }) (models.SSHKey, error) {
+	var key models.SSHKey
+	var name sql.NullString
+	err := row.Scan(&key.ID, &key.UserID, &name, &key.PublicKey, &key.Fingerprint, &key.ReadOnly, &key.CreatedAt)
+	key.Name = name.String
+	return key, err
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/nbt"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+)
+
+var (
+	// ErrPlayerDataNotFound is returned when a world has no saved data for a player.
+	ErrPlayerDataNotFound = errors.New("player data not found")
+	// ErrCorruptPlayerData is returned for player data files that can't be read.
+	ErrCorruptPlayerData = errors.New("player data is corrupt")
+	// ErrInvalidPlayerData is returned for updates the game would reject or misread.
+	ErrInvalidPlayerData = errors.New("invalid player data")
+	// ErrServerRunning is returned for changes that can only be made while the server is stopped, since a
+	// running server would overwrite them.
+	ErrServerRunning = errors.New("the server must be stopped first")
+)
+
+// itemCountDataVersion is the data version of 1.20.5, from which item stacks store "count" as an int
+// rather than "Count" as a byte.
+const itemCountDataVersion = 3837
+
+// namespacedIDPattern matches namespaced IDs of dimensions and items.
+var namespacedIDPattern = regexp.MustCompile(`^[a-z0-9_.-]+:[a-z0-9_./-]+$`)
+
+// Slot ranges of the player inventory and the ender chest.
+var (
+	inventorySlots  = func(slot int8) bool { return slot >= 0 && slot <= 35 || slot >= 100 && slot <= 103 || slot == -106 }
+	enderChestSlots = func(slot int8) bool { return slot >= 0 && slot <= 26 }
+)
+
+// ListPlayerData lists the players a world has saved data for.
+func (s *WorldService) ListPlayerData(ctx context.Context, serverID, world string) ([]models.PlayerDataSummary, error) {
+	_, dataDir, err := s.findWorld(ctx, serverID, world)
+	if err != nil {
+		return nil, err
+	}
+	defer dataDir.Close()
+	dir := path.Join(world, "playerdata")
+	entries, err := dataDir.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.PlayerDataSummary{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read player data: %w", err)
+	}
+
+	names := userCache(dataDir)
+	players := []models.PlayerDataSummary{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".dat")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		summary := playerSummary(dataDir, name, parsed.String(), names)
+		if _, _, err := readNBT(dataDir, name); err != nil {
+			summary.Error = err.Error()
+		}
+		players = append(players, summary)
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Modified.After(players[j].Modified) })
+	return players, nil
+}
+
+// GetPlayerData reads a player's saved position, game mode, health, experience and inventories.
+func (s *WorldService) GetPlayerData(ctx context.Context, serverID, world, playerID string) (models.PlayerData, error) {
+	_, dataDir, name, err := s.locatePlayerData(ctx, serverID, world, playerID)
+	if err != nil {
+		return models.PlayerData{}, err
+	}
+	defer dataDir.Close()
+	root, _, err := readNBT(dataDir, name)
+	if err != nil {
+		return models.PlayerData{}, fmt.Errorf("%w: %v", ErrCorruptPlayerData, err)
+	}
+	return describePlayerData(root, playerSummary(dataDir, name, playerUUID(name), userCache(dataDir))), nil
+}
+
+// UpdatePlayerData changes a player's saved state. The server must be stopped, since it saves players over
+// whatever is on disk. The previous file is kept as <uuid>.dat_old, as the game itself does.
+func (s *WorldService) UpdatePlayerData(ctx context.Context, serverID, world, playerID string, update models.PlayerDataUpdate) (models.PlayerData, error) {
+	server, dataDir, name, err := s.locatePlayerData(ctx, serverID, world, playerID)
+	if err != nil {
+		return models.PlayerData{}, err
+	}
+	defer dataDir.Close()
+	if server.Status == "online" || server.Status == "starting" {
+		return models.PlayerData{}, ErrServerRunning
+	}
+
+	root, compression, err := readNBT(dataDir, name)
+	if err != nil {
+		return models.PlayerData{}, fmt.Errorf("%w: %v", ErrCorruptPlayerData, err)
+	}
+	if err := applyPlayerDataUpdate(root, update); err != nil {
+		return models.PlayerData{}, err
+	}
+
+	if err := copyWithin(dataDir, name, name+"_old"); err != nil {
+		return models.PlayerData{}, fmt.Errorf("could not keep the previous player data: %w", err)
+	}
+	err = dataDir.WriteFileFrom(name, 0644, func(w io.Writer) error {
+		return nbt.Write(w, "", root, compression)
+	})
+	if err != nil {
+		return models.PlayerData{}, fmt.Errorf("could not write player data: %w", err)
+	}
+
+	summary := playerSummary(dataDir, name, playerUUID(name), userCache(dataDir))
+	msg := fmt.Sprintf("Saved data of player %s in world '%s' of server '%s' was edited.", playerLabel(summary), world, server.Name)
+	s.eventService.CreateEvent(ctx, "player.data.update", "warn", msg, &server.ID)
+	return describePlayerData(root, summary), nil
+}
+
+// RestorePlayerData replaces a player's data with the game's copy of the previous save, <uuid>.dat_old, to
+// recover from a corrupt or unwanted save. The server must be stopped.
+func (s *WorldService) RestorePlayerData(ctx context.Context, serverID, world, playerID string) (models.PlayerData, error) {
+	server, dataDir, name, err := s.locatePlayerData(ctx, serverID, world, playerID)
+	if err != nil {
+		return models.PlayerData{}, err
+	}
+	defer dataDir.Close()
+	if server.Status == "online" || server.Status == "starting" {
+		return models.PlayerData{}, ErrServerRunning
+	}
+	root, _, err := readNBT(dataDir, name+"_old")
+	if errors.Is(err, fs.ErrNotExist) {
+		return models.PlayerData{}, fmt.Errorf("%w: no previous save of this player", ErrPlayerDataNotFound)
+	}
+	if err != nil {
+		return models.PlayerData{}, fmt.Errorf("%w: the previous save can't be read either: %v", ErrCorruptPlayerData, err)
+	}
+	if err := copyWithin(dataDir, name+"_old", name); err != nil {
+		return models.PlayerData{}, fmt.Errorf("could not restore player data: %w", err)
+	}
+
+	summary := playerSummary(dataDir, name, playerUUID(name), userCache(dataDir))
+	msg := fmt.Sprintf("Saved data of player %s in world '%s' of server '%s' was restored from its previous save.", playerLabel(summary), world, server.Name)
+	s.eventService.CreateEvent(ctx, "player.data.restore", "warn", msg, &server.ID)
+	return describePlayerData(root, summary), nil
+}
+
+// locatePlayerData returns the server, its opened data directory and the name of a player's data file in a
+// world. A player can be named by any form of their UUID. The caller closes the directory.
+func (s *WorldService) locatePlayerData(ctx context.Context, serverID, world, playerID string) (models.Server, *sandbox.Root, string, error) {
+	server, dataDir, err := s.findWorld(ctx, serverID, world)
+	if err != nil {
+		return models.Server{}, nil, "", err
+	}
+	id, err := uuid.Parse(playerID)
+	if err != nil {
+		dataDir.Close()
+		return models.Server{}, nil, "", fmt.Errorf("%w: %q is not a player UUID", ErrPlayerDataNotFound, playerID)
+	}
+	name := path.Join(world, "playerdata", id.String()+".dat")
+	if _, err := dataDir.Stat(name); err != nil {
+		dataDir.Close()
+		return models.Server{}, nil, "", fmt.Errorf("%w: %s", ErrPlayerDataNotFound, id)
+	}
+	return server, dataDir, name, nil
+}
+
+// copyWithin copies the file src of a server to dst, replacing dst.
+func copyWithin(dataDir *sandbox.Root, src, dst string) error {
+	data, err := dataDir.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return dataDir.WriteFile(dst, data, 0644)
+}
+
+func playerUUID(name string) string {
+	return strings.TrimSuffix(path.Base(name), ".dat")
+}
+
+func playerSummary(dataDir *sandbox.Root, name, id string, names map[string]string) models.PlayerDataSummary {
+	summary := models.PlayerDataSummary{UUID: id, Name: names[id]}
+	if info, err := dataDir.Stat(name); err == nil {
+		summary.Modified = info.ModTime()
+	}
+	if _, err := dataDir.Stat(name + "_old"); err == nil {
+		summary.HasBackup = true
+	}
+	return summary
+}
+
+func playerLabel(summary models.PlayerDataSummary) string {
+	if summary.Name != "" {
+		return summary.Name
+	}
+	return summary.UUID
+}
+
+// userCache maps the UUIDs of players who have joined the server to their names, from usercache.json.
+func userCache(dataDir *sandbox.Root) map[string]string {
+	names := map[string]string{}
+	data, err := dataDir.ReadFile("usercache.json")
+	if err != nil {
+		return names
+	}
+	var entries []struct {
+		Name string `json:"name"`
+		UUID string `json:"uuid"`
+	}
+	if json.Unmarshal(data, &entries) != nil {
+		return names
+	}
+	for _, entry := range entries {
+		names[strings.ToLower(entry.UUID)] = entry.Name
+	}
+	return names
+}
+
+// describePlayerData reads the fields of a player data file that can be viewed and edited.
+func describePlayerData(root nbt.Compound, summary models.PlayerDataSummary) models.PlayerData {
+	player := models.PlayerData{PlayerDataSummary: summary}
+
+	// Before 1.16 the dimension was a number.
+	switch dim := root["Dimension"].(type) {
+	case string:
+		player.Dimension = dim
+	case int32:
+		player.Dimension = map[int32]string{-1: "minecraft:the_nether", 0: "minecraft:overworld", 1: "minecraft:the_end"}[dim]
+	}
+	if pos, ok := nbt.Get[nbt.List](root, "Pos"); ok && len(pos.Items) == 3 {
+		x, _ := pos.Items[0].(float64)
+		y, _ := pos.Items[1].(float64)
+		z, _ := pos.Items[2].(float64)
+		player.Position = models.PlayerPosition{X: x, Y: y, Z: z}
+	}
+	if gameType, ok := nbt.Number(root, "playerGameType"); ok && gameType >= 0 && int(gameType) < len(gameTypes) {
+		player.GameMode = gameTypes[gameType]
+	}
+	player.Health, _ = nbt.Get[float32](root, "Health")
+	player.FoodLevel, _ = nbt.Get[int32](root, "foodLevel")
+	player.XPLevel, _ = nbt.Get[int32](root, "XpLevel")
+	player.XPProgress, _ = nbt.Get[float32](root, "XpP")
+	player.XPTotal, _ = nbt.Get[int32](root, "XpTotal")
+	player.Inventory = describeItems(root, "Inventory")
+	player.EnderChest = describeItems(root, "EnderItems")
+	return player
+}
+
+func describeItems(root nbt.Compound, key string) []models.InventoryItem {
+	items := []models.InventoryItem{}
+	list, _ := nbt.Get[nbt.List](root, key)
+	for _, entry := range list.Items {
+		item, ok := entry.(nbt.Compound)
+		if !ok {
+			continue
+		}
+		slot, _ := nbt.Get[int8](item, "Slot")
+		id, _ := nbt.Get[string](item, "id")
+		count, ok := nbt.Number(item, "count")
+		if !ok {
+			count, _ = nbt.Number(item, "Count")
+		}
+		_, tagged := item["tag"]
+		if _, ok := item["components"]; ok {
+			tagged = true
+		}
+		items = append(items, models.InventoryItem{Slot: slot, ID: id, Count: int32(count), Tagged: tagged})
+	}
+	return items
+}
+
+// applyPlayerDataUpdate validates update and applies it to a player data file.
+func applyPlayerDataUpdate(root nbt.Compound, update models.PlayerDataUpdate) error {
+	if update.Dimension != nil {
+		if _, ok := root["Dimension"].(string); !ok {
+			return fmt.Errorf("%w: the dimension of players saved before 1.16 can't be changed", ErrInvalidPlayerData)
+		}
+		if !namespacedIDPattern.MatchString(*update.Dimension) {
+			return fmt.Errorf("%w: %q is not a dimension ID", ErrInvalidPlayerData, *update.Dimension)
+		}
+		root["Dimension"] = *update.Dimension
+	}
+	if update.Position != nil {
+		p := *update.Position
+		if p.Y < -2048 || p.Y > 2048 || p.X < -30000000 || p.X > 30000000 || p.Z < -30000000 || p.Z > 30000000 {
+			return fmt.Errorf("%w: position is outside the world", ErrInvalidPlayerData)
+		}
+		root["Pos"] = nbt.List{Type: nbt.TagDouble, Items: []any{p.X, p.Y, p.Z}}
+		// Drop any fall in progress, so the player doesn't take the damage of it at the new position.
+		root["Motion"] = nbt.List{Type: nbt.TagDouble, Items: []any{0.0, 0.0, 0.0}}
+		root["FallDistance"] = float32(0)
+	}
+	if update.GameMode != nil {
+		mode := -1
+		for i, name := range gameTypes {
+			if name == *update.GameMode {
+				mode = i
+			}
+		}
+		if mode < 0 {
+			return fmt.Errorf("%w: game mode must be one of %s", ErrInvalidPlayerData, strings.Join(gameTypes, ", "))
+		}
+		root["playerGameType"] = int32(mode)
+	}
+	if update.Health != nil {
+		if *update.Health < 0 || *update.Health > 1024 {
+			return fmt.Errorf("%w: health must be between 0 and 1024", ErrInvalidPlayerData)
+		}
+		root["Health"] = *update.Health
+	}
+	if update.FoodLevel != nil {
+		if *update.FoodLevel < 0 || *update.FoodLevel > 20 {
+			return fmt.Errorf("%w: food level must be between 0 and 20", ErrInvalidPlayerData)
+		}
+		root["foodLevel"] = *update.FoodLevel
+	}
+	if update.XPLevel != nil {
+		if *update.XPLevel < 0 {
+			return fmt.Errorf("%w: experience level can't be negative", ErrInvalidPlayerData)
+		}
+		root["XpLevel"] = *update.XPLevel
+	}
+	if update.XPProgress != nil {
+		if *update.XPProgress < 0 || *update.XPProgress >= 1 {
+			return fmt.Errorf("%w: experience progress must be at least 0 and less than 1", ErrInvalidPlayerData)
+		}
+		root["XpP"] = *update.XPProgress
+	}
+	if update.XPTotal != nil {
+		if *update.XPTotal < 0 {
+			return fmt.Errorf("%w: total experience can't be negative", ErrInvalidPlayerData)
+		}
+		root["XpTotal"] = *update.XPTotal
+	}
+
+	dataVersion, _ := nbt.Number(root, "DataVersion")
+	modern := dataVersion >= itemCountDataVersion
+	if update.Inventory != nil {
+		items, err := applyItems(root, "Inventory", *update.Inventory, inventorySlots, modern)
+		if err != nil {
+			return err
+		}
+		root["Inventory"] = items
+	}
+	if update.EnderChest != nil {
+		items, err := applyItems(root, "EnderItems", *update.EnderChest, enderChestSlots, modern)
+		if err != nil {
+			return err
+		}
+		root["EnderItems"] = items
+	}
+	return nil
+}
+
+// applyItems builds the item list that replaces the one under key. An item that stays in its slot keeps its
+// enchantments, name and other data; a new item is plain.
+func applyItems(root nbt.Compound, key string, items []models.InventoryItem, validSlot func(int8) bool, modern bool) (nbt.List, error) {
+	existing := map[int8]nbt.Compound{}
+	list, _ := nbt.Get[nbt.List](root, key)
+	for _, entry := range list.Items {
+		if item, ok := entry.(nbt.Compound); ok {
+			if slot, ok := nbt.Get[int8](item, "Slot"); ok {
+				existing[slot] = item
+			}
+		}
+	}
+
+	result := nbt.List{Type: nbt.TagCompound, Items: []any{}}
+	used := map[int8]bool{}
+	maxCount := int32(127)
+	if modern {
+		maxCount = 99
+	}
+	for _, item := range items {
+		if !validSlot(item.Slot) {
+			return nbt.List{}, fmt.Errorf("%w: %d is not a slot of the %s", ErrInvalidPlayerData, item.Slot, strings.ToLower(key))
+		}
+		if used[item.Slot] {
+			return nbt.List{}, fmt.Errorf("%w: slot %d is given twice", ErrInvalidPlayerData, item.Slot)
+		}
+		used[item.Slot] = true
+		id := item.ID
+		if !strings.Contains(id, ":") {
+			id = "minecraft:" + id
+		}
+		if !namespacedIDPattern.MatchString(id) {
+			return nbt.List{}, fmt.Errorf("%w: %q is not an item ID", ErrInvalidPlayerData, item.ID)
+		}
+		if item.Count < 1 || item.Count > maxCount {
+			return nbt.List{}, fmt.Errorf("%w: the count in slot %d must be between 1 and %d", ErrInvalidPlayerData, item.Slot, maxCount)
+		}
+
+		entry := nbt.Compound{}
+		if old, ok := existing[item.Slot]; ok && old["id"] == id {
+			for k, v := range old {
+				entry[k] = v
+			}
+		}
+		entry["Slot"] = item.Slot
+		entry["id"] = id
+		delete(entry, "count")
+		delete(entry, "Count")
+		if modern {
+			entry["count"] = item.Count
+		} else {
+			entry["Count"] = int8(item.Count)
+		}
+		result.Items = append(result.Items, entry)
+	}
+	return result, nil
+}
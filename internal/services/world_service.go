@@ -0,0 +1,644 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/nbt"
+	"github.com/isdelr/ender-deploy-be/internal/provision"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrWorldNotFound is returned when a server has no world with the given name.
+	ErrWorldNotFound = errors.New("world not found")
+	// ErrWorldExists is returned when importing a world under a name that is already taken.
+	ErrWorldExists = errors.New("a world with that name already exists")
+	// ErrInvalidWorld is returned for invalid world names, archives without a world and operations a world doesn't allow.
+	ErrInvalidWorld = errors.New("invalid world")
+)
+
+// defaultLevelName is the world a server loads when server.properties doesn't set level-name.
+const defaultLevelName = "world"
+
+// worldDimensions are the dimensions kept in directories of their own: inside the world directory in
+// vanilla, or in a sibling directory named after the world plus suffix on Bukkit-based servers.
+var worldDimensions = []struct {
+	name, dir, suffix string
+}{
+	{"the_nether", "DIM-1", "_nether"},
+	{"the_end", "DIM1", "_the_end"},
+}
+
+// gameTypes and difficulties name the numeric values level.dat stores.
+var (
+	gameTypes    = []string{"survival", "creative", "adventure", "spectator"}
+	difficulties = []string{"peaceful", "easy", "normal", "hard"}
+)
+
+// ResetWorldOptions are the options of resetting a world.
+type ResetWorldOptions struct {
+	KeepSeed bool   // Regenerate the world from the seed it has now
+	Seed     string // Seed of the new world when not keeping the old one; random if empty
+}
+
+// WorldServiceProvider defines the interface for world services.
+type WorldServiceProvider interface {
+	ListWorlds(ctx context.Context, serverID string) ([]models.World, error)
+	GetWorld(ctx context.Context, serverID, name string) (models.World, error)
+	SetActiveWorld(ctx context.Context, serverID, name string) (models.World, error)
+	ImportWorld(ctx context.Context, serverID, name, archivePath string) (models.World, error)
+	ExportWorld(ctx context.Context, serverID, name string, w io.Writer) error
+	ResetWorld(ctx context.Context, serverID, name string, options ResetWorldOptions) error
+	DeleteWorld(ctx context.Context, serverID, name string) error
+	ListPlayerData(ctx context.Context, serverID, world string) ([]models.PlayerDataSummary, error)
+	GetPlayerData(ctx context.Context, serverID, world, playerID string) (models.PlayerData, error)
+	UpdatePlayerData(ctx context.Context, serverID, world, playerID string, update models.PlayerDataUpdate) (models.PlayerData, error)
+	RestorePlayerData(ctx context.Context, serverID, world, playerID string) (models.PlayerData, error)
+}
+
+// WorldService manages the worlds in the data directories of servers.
+type WorldService struct {
+	serverService ServerServiceProvider
+	eventService  EventServiceProvider
+}
+
+// NewWorldService creates a new WorldService.
+func NewWorldService(serverService ServerServiceProvider, eventService EventServiceProvider) *WorldService {
+	return &WorldService{serverService: serverService, eventService: eventService}
+}
+
+// ListWorlds lists the worlds of a server. The Nether and End directories of Bukkit-style split worlds are
+// listed as dimensions of their world rather than as worlds of their own.
+func (s *WorldService) ListWorlds(ctx context.Context, serverID string) ([]models.World, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return nil, err
+	}
+	defer dataDir.Close()
+	entries, err := dataDir.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("could not read server data directory: %w", err)
+	}
+
+	candidates := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && hasWorldData(dataDir, entry.Name()) {
+			candidates[entry.Name()] = true
+		}
+	}
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		if !isSplitDimension(dataDir, name, candidates) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	active := activeWorldName(dataDir)
+	worlds := make([]models.World, len(names))
+	for i, name := range names {
+		worlds[i] = describeWorld(ctx, dataDir, name, active)
+	}
+	return worlds, nil
+}
+
+// GetWorld describes one of a server's worlds.
+func (s *WorldService) GetWorld(ctx context.Context, serverID, name string) (models.World, error) {
+	_, dataDir, err := s.findWorld(ctx, serverID, name)
+	if err != nil {
+		return models.World{}, err
+	}
+	defer dataDir.Close()
+	return describeWorld(ctx, dataDir, name, activeWorldName(dataDir)), nil
+}
+
+// SetActiveWorld points level-name at a world, which the server loads from its next start. If the server
+// has no world by that name yet, it generates one.
+func (s *WorldService) SetActiveWorld(ctx context.Context, serverID, name string) (models.World, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.World{}, err
+	}
+	if err := ValidateWorldName(name); err != nil {
+		return models.World{}, err
+	}
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.World{}, err
+	}
+	defer dataDir.Close()
+	props, err := readServerProperties(dataDir)
+	if err == nil {
+		err = props.Set("level-name", name)
+	}
+	if err == nil {
+		err = writeServerProperties(dataDir, props)
+	}
+	if err != nil {
+		return models.World{}, fmt.Errorf("could not update server.properties: %w", err)
+	}
+
+	msg := fmt.Sprintf("Server '%s' will load world '%s' from its next start.", server.Name, name)
+	s.eventService.CreateEvent(ctx, "world.activate", "info", msg, &server.ID)
+	if !hasWorldData(dataDir, name) {
+		return models.World{Name: name, Active: true, Dimensions: []models.WorldDimension{}}, nil
+	}
+	return describeWorld(ctx, dataDir, name, name), nil
+}
+
+// ImportWorld extracts the world in the zip at archivePath into a server under name. The world may sit at the
+// root of the archive or in a directory of it, and Bukkit-style Nether and End directories next to it are
+// imported as well.
+func (s *WorldService) ImportWorld(ctx context.Context, serverID, name, archivePath string) (models.World, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.World{}, err
+	}
+	if err := ValidateWorldName(name); err != nil {
+		return models.World{}, err
+	}
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.World{}, err
+	}
+	defer dataDir.Close()
+
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return models.World{}, fmt.Errorf("%w: could not open archive: %v", ErrInvalidWorld, err)
+	}
+	defer zr.Close()
+
+	// The shallowest level.dat marks the world; deeper ones belong to its dimensions, and those of split
+	// Nether and End directories at the same depth lose out to the world's own.
+	root := ""
+	found := false
+	rank := func(dir string) int {
+		r := strings.Count(dir, "/") * 2
+		for _, dim := range worldDimensions {
+			if strings.HasSuffix(dir, dim.suffix) {
+				r++
+			}
+		}
+		return r
+	}
+	for _, f := range zr.File {
+		if path.Base(f.Name) != "level.dat" {
+			continue
+		}
+		dir := path.Dir(f.Name)
+		if dir == "." {
+			dir = ""
+		}
+		if !found || rank(dir) < rank(root) {
+			root, found = dir, true
+		}
+	}
+	if !found {
+		return models.World{}, fmt.Errorf("%w: the archive has no level.dat", ErrInvalidWorld)
+	}
+
+	// Map the archive's world directories to the directories they are imported as.
+	targets := map[string]string{root: name}
+	if root != "" {
+		for _, dim := range worldDimensions {
+			targets[root+dim.suffix] = name + dim.suffix
+		}
+	}
+	present := map[string]bool{}
+	for _, f := range zr.File {
+		if dest, ok := worldArchiveTarget(f.Name, targets); ok {
+			present[strings.SplitN(dest, "/", 2)[0]] = true
+		}
+	}
+	for target := range present {
+		if _, err := dataDir.Lstat(target); err == nil {
+			return models.World{}, fmt.Errorf("%w: %s", ErrWorldExists, target)
+		}
+	}
+
+	// Extract into a staging directory first, so a failed import leaves nothing behind.
+	staging := ".world-import-" + uuid.New().String()
+	if err := dataDir.Mkdir(staging, 0755); err != nil {
+		return models.World{}, fmt.Errorf("could not stage world import: %w", err)
+	}
+	defer dataDir.RemoveAll(staging)
+
+	total := int64(len(zr.File))
+	for i, f := range zr.File {
+		reportProgress(ctx, "extracting world", int64(i), total, "files")
+		if err := ctx.Err(); err != nil {
+			return models.World{}, err
+		}
+		dest, ok := worldArchiveTarget(f.Name, targets)
+		if !ok {
+			continue
+		}
+		if err := extractZipEntry(dataDir, f, path.Join(staging, dest), nil); err != nil {
+			return models.World{}, fmt.Errorf("could not extract %s: %w", f.Name, err)
+		}
+	}
+	reportProgress(ctx, "extracting world", total, total, "files")
+
+	for target := range present {
+		if err := dataDir.Rename(path.Join(staging, target), target); err != nil {
+			return models.World{}, fmt.Errorf("could not install world: %w", err)
+		}
+	}
+
+	msg := fmt.Sprintf("World '%s' was imported into server '%s'.", name, server.Name)
+	s.eventService.CreateEvent(ctx, "world.import", "info", msg, &server.ID)
+	return describeWorld(ctx, dataDir, name, activeWorldName(dataDir)), nil
+}
+
+// worldArchiveTarget returns where an archive entry is extracted to, relative to the data directory, and
+// whether it belongs to the world at all. Entries that would escape their directory are left out.
+func worldArchiveTarget(entry string, targets map[string]string) (string, bool) {
+	entry = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(entry, "\\", "/")), "/")
+	for dir, target := range targets {
+		if dir == "" {
+			return path.Join(target, entry), entry != ""
+		}
+		if rel, ok := strings.CutPrefix(entry, dir+"/"); ok && rel != "" {
+			return path.Join(target, rel), true
+		}
+	}
+	return "", false
+}
+
+// ExportWorld writes a world to w as a zip, with each of its directories at the root of the archive. The
+// world of an online server is saved first and auto-saving paused while it is read.
+func (s *WorldService) ExportWorld(ctx context.Context, serverID, name string, w io.Writer) error {
+	server, dataDir, err := s.findWorld(ctx, serverID, name)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+	if name == activeWorldName(dataDir) {
+		resume, err := saveWorld(ctx, s.serverService, server)
+		if err != nil {
+			return fmt.Errorf("failed to save world via RCON: %w", err)
+		}
+		defer resume()
+	}
+
+	zw := zip.NewWriter(w)
+	for _, dir := range worldDirs(dataDir, name) {
+		err := dataDir.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// session.lock is held by a running server and means nothing anywhere else.
+			if !d.Type().IsRegular() || d.Name() == "session.lock" {
+				return nil
+			}
+			f, err := dataDir.Open(file)
+			if errors.Is(err, sandbox.ErrHardLink) {
+				log.Warn().Ctx(ctx).Str("server_id", server.ID).Str("path", file).Msg("Leaving hard-linked file out of world export")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			header, err := archiveHeader(d, file)
+			if err != nil {
+				return err
+			}
+			entry, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, f)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not archive world: %w", err)
+		}
+	}
+	return zw.Close()
+}
+
+// ResetWorld deletes a world so the server generates it again, keeping its datapacks. Only the active world can
+// be reset, since the seed of the new world is set through level-seed. A running server is stopped for the reset
+// and started again afterwards.
+func (s *WorldService) ResetWorld(ctx context.Context, serverID, name string, options ResetWorldOptions) error {
+	server, dataDir, err := s.findWorld(ctx, serverID, name)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+	if name != activeWorldName(dataDir) {
+		return fmt.Errorf("%w: only the active world can be reset", ErrInvalidWorld)
+	}
+
+	seed := options.Seed
+	if options.KeepSeed {
+		level, err := readLevel(dataDir, path.Join(name, "level.dat"))
+		if err != nil {
+			return fmt.Errorf("could not read the seed of world '%s': %w", name, err)
+		}
+		seed = fmt.Sprint(level.Seed)
+	}
+	props, err := readServerProperties(dataDir)
+	if err != nil {
+		return fmt.Errorf("could not read server.properties: %w", err)
+	}
+	if err := props.Set("level-seed", seed); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWorld, err)
+	}
+
+	// Past this point the world is being deleted, so cancellation is no longer honoured.
+	ctx = context.WithoutCancel(ctx)
+	wasRunning := server.Status == "online" || server.Status == "starting"
+	if wasRunning {
+		reportProgress(ctx, "stopping server", 0, 0, "")
+		if err := s.serverService.PerformServerAction(ctx, server.ID, "stop"); err != nil {
+			return fmt.Errorf("failed to stop server before resetting world: %w", err)
+		}
+	}
+
+	reportProgress(ctx, "deleting world", 0, 0, "")
+	datapacks := path.Join(name, "datapacks")
+	const kept = ".world-reset-datapacks"
+	dataDir.RemoveAll(kept)
+	if _, err := dataDir.Stat(datapacks); err == nil {
+		if err := dataDir.Rename(datapacks, kept); err != nil {
+			return fmt.Errorf("could not set datapacks aside: %w", err)
+		}
+	}
+	for _, dir := range worldDirs(dataDir, name) {
+		if err := dataDir.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not delete world: %w", err)
+		}
+	}
+	if _, err := dataDir.Stat(kept); err == nil {
+		if err := dataDir.MkdirAll(name, 0755); err != nil {
+			return err
+		}
+		if err := dataDir.Rename(kept, datapacks); err != nil {
+			return fmt.Errorf("could not restore datapacks: %w", err)
+		}
+	}
+	if err := writeServerProperties(dataDir, props); err != nil {
+		return fmt.Errorf("could not write server.properties: %w", err)
+	}
+
+	msg := fmt.Sprintf("World '%s' of server '%s' was reset.", name, server.Name)
+	s.eventService.CreateEvent(ctx, "world.reset", "warn", msg, &server.ID)
+
+	if wasRunning {
+		reportProgress(ctx, "starting server", 0, 0, "")
+		if err := s.serverService.PerformServerAction(ctx, server.ID, "start"); err != nil {
+			return fmt.Errorf("failed to start server after resetting world: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteWorld deletes a world that is not the active one.
+func (s *WorldService) DeleteWorld(ctx context.Context, serverID, name string) error {
+	server, dataDir, err := s.findWorld(ctx, serverID, name)
+	if err != nil {
+		return err
+	}
+	defer dataDir.Close()
+	if name == activeWorldName(dataDir) {
+		return fmt.Errorf("%w: the active world can't be deleted; switch to another world or reset it", ErrInvalidWorld)
+	}
+	for _, dir := range worldDirs(dataDir, name) {
+		if err := dataDir.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not delete world: %w", err)
+		}
+	}
+
+	msg := fmt.Sprintf("World '%s' was deleted from server '%s'.", name, server.Name)
+	s.eventService.CreateEvent(ctx, "world.delete", "warn", msg, &server.ID)
+	return nil
+}
+
+// findWorld returns a server and its opened data directory after checking it has a world by the given name.
+// The caller closes the directory.
+func (s *WorldService) findWorld(ctx context.Context, serverID, name string) (models.Server, *sandbox.Root, error) {
+	server, err := s.serverService.GetServerByID(ctx, serverID)
+	if err != nil {
+		return models.Server{}, nil, err
+	}
+	if err := ValidateWorldName(name); err != nil {
+		return models.Server{}, nil, err
+	}
+	dataDir, err := openDataDir(server)
+	if err != nil {
+		return models.Server{}, nil, err
+	}
+	if !hasWorldData(dataDir, name) {
+		dataDir.Close()
+		return models.Server{}, nil, fmt.Errorf("%w: %s", ErrWorldNotFound, name)
+	}
+	return server, dataDir, nil
+}
+
+// ValidateWorldName accepts plain directory names, which keeps worlds inside the data directory.
+func ValidateWorldName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.ContainsAny(name, `\/:*?"<>|`) || strings.HasPrefix(name, ".") || len(name) > 64 {
+		return fmt.Errorf("%w: %q is not a valid world name", ErrInvalidWorld, name)
+	}
+	return nil
+}
+
+// hasWorldData reports whether the directory dir holds a world: a level.dat or the directory of a dimension.
+func hasWorldData(dataDir *sandbox.Root, dir string) bool {
+	for _, name := range []string{"level.dat", "DIM-1", "DIM1"} {
+		if _, err := dataDir.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isSplitDimension reports whether the world directory name is the Nether or End directory of another world.
+func isSplitDimension(dataDir *sandbox.Root, name string, worlds map[string]bool) bool {
+	for _, dim := range worldDimensions {
+		base, ok := strings.CutSuffix(name, dim.suffix)
+		if !ok || !worlds[base] {
+			continue
+		}
+		if _, err := dataDir.Stat(path.Join(name, dim.dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// worldDirs returns the directories, relative to the data directory, that hold a world.
+func worldDirs(dataDir *sandbox.Root, name string) []string {
+	dirs := []string{name}
+	for _, dim := range worldDimensions {
+		if _, err := dataDir.Stat(path.Join(name+dim.suffix, dim.dir)); err == nil {
+			dirs = append(dirs, name+dim.suffix)
+		}
+	}
+	return dirs
+}
+
+// activeWorldName returns the level-name of a server.
+func activeWorldName(dataDir *sandbox.Root) string {
+	props, err := readServerProperties(dataDir)
+	if err != nil {
+		return defaultLevelName
+	}
+	if name, _ := props.Get("level-name"); name != "" {
+		return name
+	}
+	return defaultLevelName
+}
+
+// readServerProperties reads a server's server.properties. A missing file reads as empty.
+func readServerProperties(dataDir *sandbox.Root) (*provision.Properties, error) {
+	data, err := dataDir.ReadFile("server.properties")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return provision.ParseProperties(data), nil
+}
+
+// writeServerProperties replaces a server's server.properties.
+func writeServerProperties(dataDir *sandbox.Root, props *provision.Properties) error {
+	return dataDir.WriteFile("server.properties", props.Bytes(), 0644)
+}
+
+// describeWorld gathers what is known about a world: where its dimensions are, its size and its level.dat.
+func describeWorld(ctx context.Context, dataDir *sandbox.Root, name, active string) models.World {
+	world := models.World{
+		Name:       name,
+		Active:     name == active,
+		Dimensions: []models.WorldDimension{{Name: "overworld", Path: name}},
+	}
+	for _, dim := range worldDimensions {
+		for _, dir := range []string{path.Join(name, dim.dir), path.Join(name+dim.suffix, dim.dir)} {
+			if _, err := dataDir.Stat(dir); err == nil {
+				world.Dimensions = append(world.Dimensions, models.WorldDimension{Name: dim.name, Path: dir})
+				break
+			}
+		}
+	}
+
+	for _, dir := range worldDirs(dataDir, name) {
+		dataDir.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					world.Size += info.Size()
+				}
+			}
+			return nil
+		})
+	}
+
+	levelPath := path.Join(name, "level.dat")
+	if _, err := dataDir.Stat(levelPath); err != nil {
+		return world
+	}
+	level, err := readLevel(dataDir, levelPath)
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("path", levelPath).Msg("Could not read level.dat")
+		world.LevelError = err.Error()
+		return world
+	}
+	world.Level = level
+	return world
+}
+
+// readNBT reads an NBT file of a server, along with its compression so it can be written back the same way.
+func readNBT(dataDir *sandbox.Root, name string) (nbt.Compound, nbt.Compression, error) {
+	f, err := dataDir.Open(name)
+	if err != nil {
+		return nil, nbt.None, err
+	}
+	defer f.Close()
+	_, root, compression, err := nbt.Read(f)
+	return root, compression, err
+}
+
+// readLevel reads a level.dat. Its layout has changed between Minecraft versions, so fields are looked up in
+// each place they have been kept.
+func readLevel(dataDir *sandbox.Root, name string) (*models.WorldLevel, error) {
+	root, _, err := readNBT(dataDir, name)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := nbt.Get[nbt.Compound](root, "Data")
+	if !ok {
+		return nil, fmt.Errorf("%w: level.dat has no Data compound", nbt.ErrInvalid)
+	}
+
+	level := &models.WorldLevel{GameRules: map[string]string{}}
+	level.LevelName, _ = nbt.Get[string](data, "LevelName")
+	level.Version, _ = nbt.Path[string](data, "Version", "Name")
+
+	// Since 1.16 the seed is part of the world generation settings.
+	if seed, ok := nbt.Path[int64](data, "WorldGenSettings", "seed"); ok {
+		level.Seed = seed
+	} else {
+		level.Seed, _ = nbt.Get[int64](data, "RandomSeed")
+	}
+
+	if gameType, ok := nbt.Number(data, "GameType"); ok && gameType >= 0 && int(gameType) < len(gameTypes) {
+		level.GameType = gameTypes[gameType]
+	}
+	if hardcore, ok := nbt.Number(data, "hardcore"); ok {
+		level.Hardcore = hardcore != 0
+	}
+	if difficulty, ok := nbt.Number(data, "Difficulty"); ok && difficulty >= 0 && int(difficulty) < len(difficulties) {
+		level.Difficulty = difficulties[difficulty]
+	}
+
+	// Newer versions keep the spawn in a compound of its own.
+	if pos, ok := nbt.Path[[]int32](data, "spawn", "pos"); ok && len(pos) == 3 {
+		level.Spawn = models.WorldPosition{X: pos[0], Y: pos[1], Z: pos[2]}
+	} else {
+		level.Spawn.X, _ = nbt.Get[int32](data, "SpawnX")
+		level.Spawn.Y, _ = nbt.Get[int32](data, "SpawnY")
+		level.Spawn.Z, _ = nbt.Get[int32](data, "SpawnZ")
+	}
+
+	if lastPlayed, ok := nbt.Get[int64](data, "LastPlayed"); ok {
+		level.LastPlayed = time.UnixMilli(lastPlayed).UTC()
+	}
+
+	rules, ok := nbt.Get[nbt.Compound](data, "GameRules")
+	if !ok {
+		rules, _ = nbt.Get[nbt.Compound](data, "game_rules")
+	}
+	for rule, value := range rules {
+		switch v := value.(type) {
+		case string:
+			level.GameRules[rule] = v
+		case int8:
+			// Boolean rules are stored as bytes where they are typed.
+			level.GameRules[rule] = fmt.Sprint(v != 0)
+		case int16, int32, int64, float32, float64:
+			level.GameRules[rule] = fmt.Sprint(v)
+		}
+	}
+	return level, nil
+}
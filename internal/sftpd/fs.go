@@ -0,0 +1,325 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/pkg/sftp"
+)
+
+// serverFS serves the data directory of one server to an SFTP session.
+type serverFS struct {
+	ctx      context.Context
+	server   models.Server
+	root     *sandbox.Root
+	readOnly bool
+	events   services.EventServiceProvider
+	history  services.FileHistoryServiceProvider
+}
+
+func (f *serverFS) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: f, FilePut: f, FileCmd: f, FileList: f}
+}
+
+// Fileread opens a file for reading.
+func (f *serverFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	file, err := f.root.Open(r.Filepath)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return &transfer{fs: f, file: file, name: sandbox.Clean(r.Filepath)}, nil
+}
+
+// Filewrite opens a file for writing.
+func (f *serverFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return f.OpenFile(r)
+}
+
+// OpenFile opens a file for writing and, if the client asks, reading. The content of an existing file is kept
+// in its history first.
+func (f *serverFS) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	if f.readOnly {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	name := sandbox.Clean(r.Filepath)
+	if name == "." {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	pflags := r.Pflags()
+	flag := os.O_WRONLY
+	if pflags.Read {
+		flag = os.O_RDWR
+	}
+	if pflags.Append {
+		flag |= os.O_APPEND
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+
+	if !pflags.Excl {
+		if err := f.history.Snapshot(f.ctx, f.server.ID, f.root, name); err != nil {
+			return nil, fsError(err)
+		}
+	}
+	file, err := f.root.OpenFile(name, flag, 0644)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return &transfer{fs: f, file: file, name: name}, nil
+}
+
+// Filecmd runs the requests that change files without transferring them.
+func (f *serverFS) Filecmd(r *sftp.Request) error {
+	if f.readOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	name := sandbox.Clean(r.Filepath)
+
+	switch r.Method {
+	case "Setstat":
+		return f.setstat(name, r)
+	case "Rename":
+		// SFTP version 3 renames don't replace existing files; PosixRename does.
+		if _, err := f.root.Lstat(r.Target); err == nil {
+			return fs.ErrExist
+		}
+		return f.rename(name, sandbox.Clean(r.Target))
+	case "Rmdir":
+		info, err := f.root.Lstat(name)
+		if err != nil {
+			return fsError(err)
+		}
+		if !info.IsDir() {
+			return sftp.ErrSSHFxFailure
+		}
+		return f.remove(name)
+	case "Remove":
+		info, err := f.root.Lstat(name)
+		if err != nil {
+			return fsError(err)
+		}
+		if info.IsDir() {
+			return sftp.ErrSSHFxFailure
+		}
+		return f.remove(name)
+	case "Mkdir":
+		if err := f.root.Mkdir(name, 0755); err != nil {
+			return fsError(err)
+		}
+		f.audit("file.mkdir", "info", fmt.Sprintf("Directory '%s' was created on server '%s' over SFTP", name, f.server.Name))
+		return nil
+	case "Symlink":
+		// r.Filepath is the link's target, as the client wrote it, and r.Target the link. The client sees the
+		// data directory as "/", so absolute targets are made relative to the link to mean the same there.
+		link, target := sandbox.Clean(r.Target), r.Filepath
+		if path.IsAbs(target) {
+			rel, err := filepath.Rel(path.Dir(link), sandbox.Clean(target))
+			if err != nil {
+				return sftp.ErrSSHFxFailure
+			}
+			target = filepath.ToSlash(rel)
+		}
+		if err := f.root.Symlink(target, link); err != nil {
+			return fsError(err)
+		}
+		return nil
+	case "Link":
+		// Hard links are refused by the sandbox when the file is opened again, so don't make them.
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename renames a file, replacing an existing one at the new name.
+func (f *serverFS) PosixRename(r *sftp.Request) error {
+	if f.readOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return f.rename(sandbox.Clean(r.Filepath), sandbox.Clean(r.Target))
+}
+
+func (f *serverFS) rename(from, to string) error {
+	if from == "." || to == "." {
+		return sftp.ErrSSHFxFailure
+	}
+	if err := f.history.Snapshot(f.ctx, f.server.ID, f.root, to); err != nil {
+		return fsError(err)
+	}
+	if err := f.root.Rename(from, to); err != nil {
+		return fsError(err)
+	}
+	f.audit("file.move", "info", fmt.Sprintf("'%s' was moved to '%s' on server '%s' over SFTP", from, to, f.server.Name))
+	return nil
+}
+
+func (f *serverFS) remove(name string) error {
+	if name == "." {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	if err := f.root.Remove(name); err != nil {
+		return fsError(err)
+	}
+	f.audit("file.delete", "warn", fmt.Sprintf("'%s' was deleted from server '%s' over SFTP", name, f.server.Name))
+	return nil
+}
+
+// setstat applies the attributes a client sets: the size, permission bits and times. Owners are left as
+// they are, since the files belong to the server's container.
+func (f *serverFS) setstat(name string, r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		if err := f.history.Snapshot(f.ctx, f.server.ID, f.root, name); err != nil {
+			return fsError(err)
+		}
+		file, err := f.root.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return fsError(err)
+		}
+		err = file.Truncate(int64(attrs.Size))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fsError(err)
+		}
+	}
+	if flags.Permissions {
+		if err := f.root.Chmod(name, attrs.FileMode().Perm()); err != nil {
+			return fsError(err)
+		}
+	}
+	if flags.Acmodtime {
+		if err := f.root.Chtimes(name, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return fsError(err)
+		}
+	}
+	return nil
+}
+
+// Filelist answers List and Stat requests.
+func (f *serverFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := f.root.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, fsError(err)
+		}
+		infos := make([]fs.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := f.root.Stat(r.Filepath)
+		if err != nil {
+			return nil, fsError(err)
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat describes a file without following a symlink at its end.
+func (f *serverFS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	info, err := f.root.Lstat(r.Filepath)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return listerAt{info}, nil
+}
+
+// Readlink returns the target of a symlink as it was written.
+func (f *serverFS) Readlink(name string) (string, error) {
+	target, err := f.root.Readlink(name)
+	if err != nil {
+		return "", fsError(err)
+	}
+	return target, nil
+}
+
+// RealPath shows the data directory as the root of the file system.
+func (f *serverFS) RealPath(name string) (string, error) {
+	return path.Join("/", sandbox.Clean(name)), nil
+}
+
+// audit records a change to the server's files as an event, crediting the session's user.
+func (f *serverFS) audit(eventType, level, msg string) {
+	if claims, ok := auth.ClaimsFromContext(f.ctx); ok {
+		msg += " by " + claims.Username
+	}
+	f.events.CreateEvent(f.ctx, eventType, level, msg+".", &f.server.ID)
+}
+
+// transfer is an open file that counts the bytes read from and written to it, and records the transfer as
+// an event when it is closed.
+type transfer struct {
+	fs      *serverFS
+	file    *os.File
+	name    string
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (t *transfer) ReadAt(p []byte, off int64) (int, error) {
+	n, err := t.file.ReadAt(p, off)
+	t.read.Add(int64(n))
+	return n, err
+}
+
+func (t *transfer) WriteAt(p []byte, off int64) (int, error) {
+	n, err := t.file.WriteAt(p, off)
+	t.written.Add(int64(n))
+	return n, err
+}
+
+func (t *transfer) Close() error {
+	err := t.file.Close()
+	if written := t.written.Load(); written > 0 {
+		t.fs.audit("file.upload", "info", fmt.Sprintf("'%s' was uploaded to server '%s' over SFTP (%d bytes)", t.name, t.fs.server.Name, written))
+	} else if read := t.read.Load(); read > 0 {
+		t.fs.audit("file.download", "info", fmt.Sprintf("'%s' was downloaded from server '%s' over SFTP (%d bytes)", t.name, t.fs.server.Name, read))
+	}
+	return err
+}
+
+// listerAt lists a fixed set of files.
+type listerAt []fs.FileInfo
+
+func (l listerAt) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fsError makes sandbox refusals read as permission errors to SFTP clients.
+func fsError(err error) error {
+	if errors.Is(err, sandbox.ErrEscape) || errors.Is(err, sandbox.ErrHardLink) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
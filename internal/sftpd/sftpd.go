@@ -0,0 +1,324 @@
+// Package sftpd serves the data directories of Minecraft servers over SFTP, so files can be synced with
+// rsync, FileZilla or an IDE instead of the web file manager.
+//
+// Users log in as "<username>.<server ID>" with their password or one of their SSH keys, and each session is
+// confined to that server's data directory through a sandbox. Password logins and keys can change files;
+// users set to read-only, and keys registered as read-only, can only read them.
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout bounds how long a client may take to authenticate.
+const handshakeTimeout = 30 * time.Second
+
+// Keys of the values authentication passes on to the session in ssh.Permissions.
+const (
+	extUserID   = "user-id"
+	extUsername = "username"
+	extServerID = "server-id"
+	extReadOnly = "read-only"
+	extMethod   = "method"
+)
+
+// Server is an SFTP server over SSH.
+type Server struct {
+	users   services.UserServiceProvider
+	servers services.ServerServiceProvider
+	events  services.EventServiceProvider
+	history services.FileHistoryServiceProvider
+	config  *ssh.ServerConfig
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates a new Server that identifies itself with hostKey.
+func New(users services.UserServiceProvider, servers services.ServerServiceProvider, events services.EventServiceProvider, history services.FileHistoryServiceProvider, hostKey ssh.Signer) *Server {
+	s := &Server{
+		users:   users,
+		servers: servers,
+		events:  events,
+		history: history,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.authenticatePassword,
+		PublicKeyCallback: s.authenticateKey,
+		ServerVersion:     "SSH-2.0-EnderDeploy",
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// LoadOrCreateHostKey loads the host key at path, which may be any private key in PEM form such as one
+// ssh-keygen wrote, and generates an Ed25519 key there if there is none.
+func LoadOrCreateHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an SSH private key: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// ListenAndServe accepts connections on addr until Close is called.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return net.ErrClosed
+			}
+			log.Warn().Err(err).Msg("SFTP: Failed to accept connection")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return net.ErrClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections, ends the open sessions and waits for them to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// splitLogin splits a login name into the username and the ID of the server to open. Server IDs have no
+// dots, so the ID is whatever follows the last one.
+func splitLogin(login string) (username, serverID string, ok bool) {
+	i := strings.LastIndex(login, ".")
+	if i <= 0 || i == len(login)-1 {
+		return "", "", false
+	}
+	return login[:i], login[i+1:], true
+}
+
+func (s *Server) authenticatePassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	username, serverID, ok := splitLogin(meta.User())
+	if !ok {
+		return nil, fmt.Errorf("login %q is not <username>.<server ID>", meta.User())
+	}
+	user, err := s.users.AuthenticateUsername(username, string(password))
+	if err != nil {
+		log.Warn().Err(err).Str("login", meta.User()).Str("remote", meta.RemoteAddr().String()).Msg("SFTP: Failed password login")
+		return nil, err
+	}
+	return s.permissions(user.ID, user.Username, serverID, user.SFTPReadOnly, "password")
+}
+
+func (s *Server) authenticateKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	username, serverID, ok := splitLogin(meta.User())
+	if !ok {
+		return nil, fmt.Errorf("login %q is not <username>.<server ID>", meta.User())
+	}
+	registered, err := s.users.GetSSHKeyByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetUserByID(registered.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Username != username {
+		return nil, fmt.Errorf("key %s doesn't belong to %s", registered.Fingerprint, username)
+	}
+	return s.permissions(user.ID, user.Username, serverID, user.SFTPReadOnly || registered.ReadOnly, "key "+registered.Fingerprint)
+}
+
+// permissions checks that the server exists and records who the session is for.
+func (s *Server) permissions(userID, username, serverID string, readOnly bool, method string) (*ssh.Permissions, error) {
+	if _, err := s.servers.GetServerByID(context.Background(), serverID); err != nil {
+		return nil, fmt.Errorf("unknown server %q", serverID)
+	}
+	return &ssh.Permissions{Extensions: map[string]string{
+		extUserID:   userID,
+		extUsername: username,
+		extServerID: serverID,
+		extReadOnly: strconv.FormatBool(readOnly),
+		extMethod:   method,
+	}}, nil
+}
+
+// serveConn runs the SSH handshake on a connection and serves the SFTP subsystem on its session channels.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Debug().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("SFTP: Handshake failed")
+		return
+	}
+	defer sshConn.Close()
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(requests)
+
+	ext := sshConn.Permissions.Extensions
+	readOnly := ext[extReadOnly] == "true"
+	serverID := ext[extServerID]
+	ctx := context.WithValue(context.Background(), auth.UserClaimsKey, &auth.Claims{UserID: ext[extUserID], Username: ext[extUsername]})
+	server, err := s.servers.GetServerByID(ctx, serverID)
+	if err != nil {
+		log.Error().Err(err).Str("server_id", serverID).Msg("SFTP: Failed to load server for session")
+		return
+	}
+
+	access := "read-write"
+	if readOnly {
+		access = "read-only"
+	}
+	log.Info().Str("server_id", server.ID).Str("username", ext[extUsername]).Str("method", ext[extMethod]).Str("remote", conn.RemoteAddr().String()).Bool("read_only", readOnly).Msg("SFTP: Session opened")
+	s.events.CreateEvent(ctx, "sftp.login", "info", fmt.Sprintf("%s opened a %s SFTP session on server '%s' from %s.", ext[extUsername], access, server.Name, conn.RemoteAddr()), &server.ID)
+
+	var channelsDone sync.WaitGroup
+	defer channelsDone.Wait()
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Warn().Err(err).Str("server_id", server.ID).Msg("SFTP: Failed to accept channel")
+			continue
+		}
+		channelsDone.Add(1)
+		go func() {
+			defer channelsDone.Done()
+			defer channel.Close()
+			if !awaitSubsystem(requests) {
+				return
+			}
+			root, err := sandbox.Open(server.DataPath)
+			if err != nil {
+				log.Error().Err(err).Str("server_id", server.ID).Msg("SFTP: Failed to open server directory")
+				return
+			}
+			defer root.Close()
+
+			fsys := &serverFS{
+				ctx:      ctx,
+				server:   server,
+				root:     root,
+				readOnly: readOnly,
+				events:   s.events,
+				history:  s.history,
+			}
+			requestServer := sftp.NewRequestServer(channel, fsys.handlers())
+			if err := requestServer.Serve(); err != nil && !errors.Is(err, io.EOF) {
+				log.Warn().Err(err).Str("server_id", server.ID).Msg("SFTP: Session ended with an error")
+			}
+			requestServer.Close()
+		}()
+	}
+}
+
+// awaitSubsystem answers a session channel's requests until the client asks for the sftp subsystem, and
+// refuses shells, commands and other subsystems. The remaining requests are discarded.
+func awaitSubsystem(requests <-chan *ssh.Request) bool {
+	for req := range requests {
+		var payload struct{ Name string }
+		ok := req.Type == "subsystem" && ssh.Unmarshal(req.Payload, &payload) == nil && payload.Name == "sftp"
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+		if ok {
+			go ssh.DiscardRequests(requests)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isdelr/ender-deploy-be/internal/models"
+	"github.com/isdelr/ender-deploy-be/internal/sandbox"
+	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const testPassword = "hunter22"
+
+type fakeUsers struct {
+	services.UserServiceProvider
+	users map[string]models.User // By username
+}
+
+func (f fakeUsers) AuthenticateUsername(username, password string) (models.User, error) {
+	user, ok := f.users[username]
+	if !ok || password != testPassword {
+		return models.User{}, errors.New("authentication failed")
+	}
+	return user, nil
+}
+
+type fakeServers struct {
+	services.ServerServiceProvider
+	server models.Server
+}
+
+func (f fakeServers) GetServerByID(ctx context.Context, id string) (models.Server, error) {
+	if id != f.server.ID {
+		return models.Server{}, fmt.Errorf("server %s not found", id)
+	}
+	return f.server, nil
+}
+
+type fakeEvents struct {
+	services.EventServiceProvider
+}
+
+func (fakeEvents) CreateEvent(ctx context.Context, eventType, level, message string, serverID *string) error {
+	return nil
+}
+
+type fakeHistory struct {
+	services.FileHistoryServiceProvider
+}
+
+func (fakeHistory) Snapshot(ctx context.Context, serverID string, root *sandbox.Root, name string) error {
+	return nil
+}
+
+// newTestServer returns an SFTP server for one server whose data directory holds server.properties, and the
+// path of that directory.
+func newTestServer(t *testing.T, users ...models.User) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte("motd=hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]models.User)
+	for _, user := range users {
+		byName[user.Username] = user
+	}
+	server := models.Server{ID: "srv1", Name: "Survival", DataPath: dir}
+	return New(fakeUsers{users: byName}, fakeServers{server: server}, fakeEvents{}, fakeHistory{}, hostKey), dir
+}
+
+// dial logs in to s with a password over a loopback connection and opens an SFTP client.
+func dial(t *testing.T, s *Server, login string) *sftp.Client {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			s.serveConn(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            login,
+		Auth:            []ssh.AuthMethod{ssh.Password(testPassword)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshClient := ssh.NewClient(sshConn, channels, requests)
+	t.Cleanup(func() { sshClient.Close() })
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestPasswordSessionFollowsUserReadOnly(t *testing.T) {
+	s, dir := newTestServer(t,
+		models.User{ID: "u1", Username: "alice", SFTPReadOnly: true},
+		models.User{ID: "u2", Username: "bob"},
+	)
+
+	client := dial(t, s, "alice.srv1")
+	f, err := client.Open("server.properties")
+	if err != nil {
+		t.Fatalf("read-only session can't read: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "motd=hello\n" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	if _, err := client.Create("new.txt"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Create() in a read-only session = %v, want permission denied", err)
+	}
+	if f, err := client.OpenFile("server.properties", os.O_WRONLY|os.O_TRUNC); !errors.Is(err, os.ErrPermission) {
+		if err == nil {
+			f.Close()
+		}
+		t.Errorf("OpenFile() for writing in a read-only session = %v, want permission denied", err)
+	}
+	if err := client.Remove("server.properties"); err == nil {
+		t.Error("Remove() in a read-only session succeeded")
+	}
+	if err := client.Mkdir("mods"); err == nil {
+		t.Error("Mkdir() in a read-only session succeeded")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "server.properties")); string(data) != "motd=hello\n" {
+		t.Errorf("server.properties = %q after a read-only session", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Error("a read-only session created a file")
+	}
+
+	// Users who aren't read-only can still change files with their password.
+	client = dial(t, s, "bob.srv1")
+	f, err = client.Create("new.txt")
+	if err != nil {
+		t.Fatalf("read-write session can't create a file: %v", err)
+	}
+	if _, err := f.Write([]byte("hi")); err != nil {
+		t.Error(err)
+	}
+	f.Close()
+	if data, _ := os.ReadFile(filepath.Join(dir, "new.txt")); string(data) != "hi" {
+		t.Errorf("new.txt = %q, want %q", data, "hi")
+	}
+}
+
+func TestLoginRequiresKnownServer(t *testing.T) {
+	s, _ := newTestServer(t, models.User{ID: "u1", Username: "alice"})
+	for _, login := range []string{"alice.missing", "alice"} {
+		_, err := s.authenticatePassword(fakeMeta{login}, []byte(testPassword))
+		if err == nil {
+			t.Errorf("login %q succeeded", login)
+		}
+	}
+}
+
+// fakeMeta is the connection metadata of a login attempt.
+type fakeMeta struct {
+	user string
+}
+
+func (m fakeMeta) User() string        { return m.user }
+func (fakeMeta) SessionID() []byte     { return nil }
+func (fakeMeta) ClientVersion() []byte { return nil }
+func (fakeMeta) ServerVersion() []byte { return nil }
+func (fakeMeta) RemoteAddr() net.Addr  { return &net.TCPAddr{} }
+func (fakeMeta) LocalAddr() net.Addr   { return &net.TCPAddr{} }
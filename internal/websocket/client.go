@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,7 +12,8 @@ const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 64 * 1024 // Room for text pasted into a terminal
+	sendQueueSize  = 256
 )
 
 // Client is a middleman between the websocket connection and the hub.
@@ -21,11 +23,18 @@ type Client struct {
 	// The websocket connection.
 	conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
-	Send chan []byte
+	// Bounded queue of outbound messages, filled through Hub.Send. It is never closed, so a send can't panic;
+	// done is closed instead.
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
 
-	// The server ID this client is subscribed to.
+	// The server ID this client is subscribed to. Clients of the second protocol version have none, and
+	// subscribe to topics instead.
 	ServerID string
+
+	// The protocol version the client speaks: 1, or 2 for topic subscriptions.
+	Protocol int
 }
 
 // NewClient creates a new client.
@@ -33,8 +42,53 @@ func NewClient(hub *Hub, conn *websocket.Conn, serverID string) *Client {
 	return &Client{
 		hub:      hub,
 		conn:     conn,
-		Send:     make(chan []byte, 256),
+		send:     make(chan []byte, sendQueueSize),
+		done:     make(chan struct{}),
 		ServerID: serverID,
+		Protocol: 1,
+	}
+}
+
+// Done is closed once the client is closed, by either side or for falling behind. Nothing is sent to it after.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the client. The write pump says goodbye and closes the connection. It is safe to call more than
+// once and from any goroutine.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// enqueue queues a message for the write pump, handling a full queue as overflow says.
+func (c *Client) enqueue(message []byte, overflow Overflow) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+	}
+
+	switch overflow {
+	case Wait:
+		select {
+		case c.send <- message:
+			return true
+		case <-c.done:
+			return false
+		}
+	case Drop:
+		observeOverflow(overflow)
+		return false
+	default:
+		observeOverflow(overflow)
+		log.Warn().Str("client_id", c.ServerID).Msg("Websocket client fell too far behind, disconnecting it")
+		c.Close()
+		return false
 	}
 }
 
@@ -44,7 +98,8 @@ type MessageHandler func(client *Client, message []byte)
 // ReadPump pumps messages from the websocket connection to be processed by the handler.
 func (c *Client) ReadPump(handler MessageHandler) {
 	defer func() {
-		// c.hub.Unregister <- c // <-- REMOVE THIS LINE
+		// Unblock anyone waiting to send to a client that is gone.
+		c.Close()
 		c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -63,23 +118,18 @@ func (c *Client) ReadPump(handler MessageHandler) {
 	}
 }
 
-// WritePump pumps messages from the hub to the websocket connection.
+// WritePump pumps messages from the client's queue to the websocket connection.
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
+		c.Close()
 		c.conn.Close()
 	}()
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case message := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -87,10 +137,10 @@ func (c *Client) WritePump() {
 			w.Write(message)
 
 			// Add queued chat messages to the current websocket message.
-			n := len(c.Send)
+			n := len(c.send)
 			for i := 0; i < n; i++ {
 				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
+				w.Write(<-c.send)
 			}
 
 			if err := w.Close(); err != nil {
@@ -101,6 +151,11 @@ func (c *Client) WritePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-c.done:
+			// The client was closed by the hub or for falling behind.
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		}
 	}
 }
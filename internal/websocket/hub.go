@@ -1,13 +1,49 @@
 package websocket
 
-import "github.com/rs/zerolog/log"
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// replaySize is how many published events the hub keeps for reconnecting clients to catch up on.
+const replaySize = 1024
+
+// Overflow decides what happens to a message for a client whose queue is full.
+type Overflow int
+
+const (
+	// Wait blocks until the client has room or is gone. For replies and output that must not be lost, sent
+	// from goroutines that serve the client alone.
+	Wait Overflow = iota
+	// Drop drops the message and keeps the client. For output that is only of interest live, like log lines.
+	Drop
+	// Disconnect closes the client, which can reconnect and replay what it missed. For state updates.
+	Disconnect
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Wait:
+		return "wait"
+	case Drop:
+		return "drop"
+	default:
+		return "disconnect"
+	}
+}
 
 // Hub maintains the set of active clients and broadcasts messages to them.
 type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients for global broadcast.
+	// Inbound messages for every client speaking the first protocol version.
 	Broadcast chan []byte
 
 	// Register requests from the clients.
@@ -16,8 +52,42 @@ type Hub struct {
 	// Unregister requests from clients.
 	Unregister chan *Client
 
-	// A map of server IDs to a set of clients subscribed to it.
+	// A map of server IDs to a set of first version clients subscribed to it.
 	subscriptions map[string]map[*Client]bool
+
+	// Events published to topics, and the topic subscriptions of second version clients.
+	publish   chan published
+	subscribe chan subscribeRequest
+	topics    map[*Client]map[string]bool
+
+	// The sequence number of the latest event, and the latest events for replay, oldest first.
+	seq    uint64
+	replay []published
+
+	// Number of registered clients, readable outside the Run goroutine.
+	clientCount atomic.Int64
+}
+
+// published is an event on its way to the subscribers of its topic.
+type published struct {
+	envelope   Envelope
+	replayable bool
+	message    []byte // The encoded envelope, once it is numbered
+}
+
+type subscribeRequest struct {
+	client      *Client
+	topics      []string
+	unsubscribe bool
+	since       *uint64
+	reply       chan SubscribeResult
+}
+
+// SubscribeResult describes a client's subscriptions after a change.
+type SubscribeResult struct {
+	Topics []string `json:"topics"` // Every topic the client is subscribed to
+	Seq    uint64   `json:"seq"`    // Sequence number of the latest event
+	Gap    bool     `json:"gap"`    // Events after the requested sequence number have left the buffer; state must be refetched
 }
 
 // NewHub creates a new Hub.
@@ -28,6 +98,9 @@ func NewHub() *Hub {
 		Unregister:    make(chan *Client),
 		clients:       make(map[*Client]bool),
 		subscriptions: make(map[string]map[*Client]bool),
+		publish:       make(chan published),
+		subscribe:     make(chan subscribeRequest),
+		topics:        make(map[*Client]map[string]bool),
 	}
 }
 
@@ -37,6 +110,7 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.Register:
 			h.clients[client] = true
+			h.clientCount.Store(int64(len(h.clients)))
 			log.Info().Int("total_clients", len(h.clients)).Msg("Client connected")
 			// If client has a server ID on registration, subscribe them.
 			if client.ServerID != "" {
@@ -45,38 +119,168 @@ func (h *Hub) Run() {
 		case client := <-h.Unregister:
 			if _, ok := h.clients[client]; ok {
 				// Remove from global clients and any subscriptions
-				delete(h.clients, client)
-				close(client.Send)
-				h.removeSubscription(client)
+				h.drop(client)
 				log.Info().Int("total_clients", len(h.clients)).Msg("Client disconnected")
 			}
 		case message := <-h.Broadcast:
 			for client := range h.clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-					h.removeSubscription(client)
+				if client.Protocol < 2 && !h.Send(client, message, Disconnect) {
+					h.drop(client)
 				}
 			}
+		case event := <-h.publish:
+			h.deliver(event)
+		case req := <-h.subscribe:
+			req.reply <- h.changeSubscription(req)
 		}
 	}
 }
 
-// BroadcastTo sends a message to all clients subscribed to a specific server ID.
+// ClientCount returns the number of connected clients. It is safe to call from any goroutine.
+func (h *Hub) ClientCount() int {
+	return int(h.clientCount.Load())
+}
+
+// Send queues a message for a client, handling a full queue as overflow says. It reports whether the
+// message was queued; messages for clients that are gone are discarded. All messages to clients go through
+// here, so none can be sent to a client after it is closed.
+func (h *Hub) Send(client *Client, message []byte, overflow Overflow) bool {
+	return client.enqueue(message, overflow)
+}
+
+func observeOverflow(overflow Overflow) {
+	metrics.ObserveWebsocketOverflow(overflow.String())
+}
+
+// BroadcastTo sends a message to all clients subscribed to a specific server ID. It must only be called from
+// the Run goroutine.
 func (h *Hub) BroadcastTo(serverID string, message []byte) {
-	if subs, ok := h.subscriptions[serverID]; ok {
-		for client := range subs {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.clients, client)
-				delete(h.subscriptions[serverID], client)
+	for client := range h.subscriptions[serverID] {
+		if !h.Send(client, message, Disconnect) {
+			h.drop(client)
+		}
+	}
+}
+
+// Publish sends an event to the clients subscribed to topic. It gets the next sequence number and is kept for
+// clients that reconnect to replay.
+func (h *Hub) Publish(topic, action string, payload any) {
+	h.publishEvent(topic, action, payload, true)
+}
+
+// PublishLive sends an event that is only of interest right now, like a stats sample, to the clients subscribed
+// to topic. It has no sequence number and isn't replayed.
+func (h *Hub) PublishLive(topic, action string, payload any) {
+	h.publishEvent(topic, action, payload, false)
+}
+
+func (h *Hub) publishEvent(topic, action string, payload any, replayable bool) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("topic", topic).Str("action", action).Msg("Error marshalling event for publishing")
+		return
+	}
+	h.publish <- published{
+		envelope:   Envelope{Type: "event", Topic: topic, Action: action, Payload: json.RawMessage(raw)},
+		replayable: replayable,
+	}
+}
+
+// Subscribe adds topics to a client's subscriptions. If since is given, buffered events on the topics with a
+// higher sequence number are queued for the client first, ahead of any event published after the subscription.
+func (h *Hub) Subscribe(client *Client, topics []string, since *uint64) SubscribeResult {
+	reply := make(chan SubscribeResult, 1)
+	h.subscribe <- subscribeRequest{client: client, topics: topics, since: since, reply: reply}
+	return <-reply
+}
+
+// Unsubscribe removes topics from a client's subscriptions.
+func (h *Hub) Unsubscribe(client *Client, topics []string) SubscribeResult {
+	reply := make(chan SubscribeResult, 1)
+	h.subscribe <- subscribeRequest{client: client, topics: topics, unsubscribe: true, reply: reply}
+	return <-reply
+}
+
+// deliver numbers an event, keeps it for replay and sends it to its subscribers.
+func (h *Hub) deliver(event published) {
+	if event.replayable {
+		h.seq++
+		event.envelope.Seq = h.seq
+	}
+	event.message, _ = json.Marshal(event.envelope)
+	if event.replayable {
+		h.replay = append(h.replay, event)
+		if len(h.replay) > 2*replaySize {
+			h.replay = append(h.replay[:0], h.replay[len(h.replay)-replaySize:]...)
+		}
+	}
+	// Live events aren't worth a reconnect; the others can be replayed after one.
+	overflow := Drop
+	if event.replayable {
+		overflow = Disconnect
+	}
+	for client, topics := range h.topics {
+		if subscribed(topics, event.envelope.Topic) && !h.Send(client, event.message, overflow) && overflow == Disconnect {
+			h.drop(client)
+		}
+	}
+}
+
+func (h *Hub) changeSubscription(req subscribeRequest) SubscribeResult {
+	result := SubscribeResult{Topics: []string{}, Seq: h.seq}
+	if !h.clients[req.client] {
+		return result
+	}
+
+	topics := h.topics[req.client]
+	if topics == nil {
+		topics = make(map[string]bool)
+		h.topics[req.client] = topics
+	}
+	for _, topic := range req.topics {
+		if req.unsubscribe {
+			delete(topics, topic)
+		} else {
+			topics[topic] = true
+		}
+	}
+	for topic := range topics {
+		result.Topics = append(result.Topics, topic)
+	}
+	slices.Sort(result.Topics)
+
+	if req.since != nil && *req.since > h.seq {
+		// The client saw events of an earlier run of the hub.
+		result.Gap = true
+	} else if req.since != nil && *req.since < h.seq {
+		replay := h.replay
+		if len(replay) > replaySize {
+			replay = replay[len(replay)-replaySize:]
+		}
+		// Anything between since and the oldest buffered event is lost.
+		result.Gap = len(replay) == 0 || replay[0].envelope.Seq > *req.since+1
+		var missed [][]byte
+		for _, event := range replay {
+			if event.envelope.Seq > *req.since && matchesAny(req.topics, event.envelope.Topic) {
+				missed = append(missed, event.message)
 			}
 		}
+		// Queued from the Run goroutine, the replay can't be overtaken by a live event. It takes a single
+		// place in the queue, joined the way the write pump joins queued messages, so a long one fits.
+		if len(missed) > 0 && !h.Send(req.client, bytes.Join(missed, []byte{'\n'}), Disconnect) {
+			h.drop(req.client)
+		}
 	}
+	return result
+}
+
+// drop closes a client and forgets it.
+func (h *Hub) drop(client *Client) {
+	client.Close()
+	delete(h.clients, client)
+	delete(h.topics, client)
+	h.removeSubscription(client)
+	h.clientCount.Store(int64(len(h.clients)))
 }
 
 func (h *Hub) addSubscription(client *Client, serverID string) {
@@ -96,3 +300,41 @@ func (h *Hub) removeSubscription(client *Client) {
 		}
 	}
 }
+
+// subscribed reports whether any of a client's subscriptions covers topic.
+func subscribed(subscriptions map[string]bool, topic string) bool {
+	for pattern := range subscriptions {
+		if TopicMatches(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, topic string) bool {
+	for _, pattern := range patterns {
+		if TopicMatches(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// TopicMatches reports whether a subscription pattern covers a topic. Topics are made of segments separated by
+// colons, and a "*" segment in a pattern matches any one segment: "server:*" covers "server:abc" but not
+// "server:abc:stats".
+func TopicMatches(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+	patternParts, topicParts := strings.Split(pattern, ":"), strings.Split(topic, ":")
+	if len(patternParts) != len(topicParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != topicParts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestHub starts a hub for a test.
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	h := NewHub()
+	go h.Run()
+	return h
+}
+
+// newTestClient registers a second version client without a connection. Its queue is only drained if the test
+// reads it.
+func newTestClient(t *testing.T, h *Hub, topics ...string) *Client {
+	t.Helper()
+	c := NewClient(h, nil, "")
+	c.Protocol = 2
+	h.Register <- c
+	if len(topics) > 0 {
+		h.Subscribe(c, topics, nil)
+	}
+	return c
+}
+
+// settle waits until the hub has handled everything sent to it before.
+func settle(h *Hub) {
+	h.Unsubscribe(&Client{}, nil)
+}
+
+func closed(c *Client) bool {
+	select {
+	case <-c.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"server:a", "server:a", true},
+		{"server:*", "server:a", true},
+		{"server:*", "server:a:stats", false},
+		{"server:*:stats", "server:a:stats", true},
+		{"server:a", "server:b", false},
+		{"events", "events", true},
+		{"*", "events", true},
+		{"job:*", "server:a", false},
+	}
+	for _, tt := range tests {
+		if got := TopicMatches(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("TopicMatches(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestPublishDisconnectsSlowClient(t *testing.T) {
+	h := newTestHub(t)
+	slow := newTestClient(t, h, "server:*")
+	fast := newTestClient(t, h, "server:*")
+
+	// The fast client takes every event as it comes; the slow one never reads.
+	const events = sendQueueSize + 10
+	for i := 0; i < events; i++ {
+		h.Publish("server:a", "server_update", i)
+		select {
+		case <-fast.send:
+		case <-time.After(time.Second):
+			t.Fatalf("fast client didn't get event %d", i)
+		}
+	}
+	settle(h)
+
+	if !closed(slow) {
+		t.Fatal("slow client is still connected after its queue overflowed")
+	}
+	if closed(fast) {
+		t.Fatal("fast client was disconnected")
+	}
+	if got := h.ClientCount(); got != 1 {
+		t.Errorf("ClientCount() = %d, want 1", got)
+	}
+}
+
+func TestPublishLiveDropsForSlowClient(t *testing.T) {
+	h := newTestHub(t)
+	slow := newTestClient(t, h, "server:*:stats")
+
+	for i := 0; i < sendQueueSize+10; i++ {
+		h.PublishLive("server:a:stats", "server_stats", i)
+	}
+	settle(h)
+
+	if closed(slow) {
+		t.Fatal("live events disconnected a slow client")
+	}
+	if got := len(slow.send); got != sendQueueSize {
+		t.Errorf("queue holds %d messages, want %d", got, sendQueueSize)
+	}
+}
+
+func TestSendWaitReturnsWhenClientCloses(t *testing.T) {
+	h := newTestHub(t)
+	c := newTestClient(t, h)
+	for i := 0; i < sendQueueSize; i++ {
+		if !h.Send(c, []byte("x"), Wait) {
+			t.Fatal("Send failed with room in the queue")
+		}
+	}
+
+	result := make(chan bool)
+	go func() { result <- h.Send(c, []byte("x"), Wait) }()
+
+	select {
+	case <-result:
+		t.Fatal("Send returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Close()
+	select {
+	case ok := <-result:
+		if ok {
+			t.Error("Send reported success to a closed client")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send kept waiting after the client closed")
+	}
+}
+
+func TestSendDropKeepsClient(t *testing.T) {
+	h := newTestHub(t)
+	c := newTestClient(t, h)
+	for i := 0; i < sendQueueSize; i++ {
+		h.Send(c, []byte("x"), Drop)
+	}
+	if h.Send(c, []byte("x"), Drop) {
+		t.Error("Send queued a message past the queue's size")
+	}
+	if closed(c) {
+		t.Error("Drop closed the client")
+	}
+	if h.Send(c, []byte("x"), Disconnect) || !closed(c) {
+		t.Error("Disconnect didn't close the client")
+	}
+}
+
+// TestSendsRacingUnregister sends to a client from many goroutines while it goes away. Run with -race.
+func TestSendsRacingUnregister(t *testing.T) {
+	h := newTestHub(t)
+	for round := 0; round < 20; round++ {
+		c := newTestClient(t, h, "server:*")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(overflow Overflow) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					h.Send(c, []byte("x"), overflow)
+				}
+			}(Overflow(i % 3))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				h.Publish("server:a", "server_update", j)
+			}
+		}()
+
+		// Drain a little so some sends land, then let the client vanish.
+		for i := 0; i < 10; i++ {
+			select {
+			case <-c.send:
+			case <-c.Done():
+			}
+		}
+		h.Unregister <- c
+		h.Unregister <- c
+		wg.Wait()
+
+		if h.Send(c, []byte("x"), Wait) {
+			t.Fatal("Send succeeded after the client was unregistered")
+		}
+	}
+}
+
+// queued returns the envelopes in a client's queue, splitting messages the way the write pump joins them.
+func queued(t *testing.T, c *Client) []Envelope {
+	t.Helper()
+	var envelopes []Envelope
+	for {
+		select {
+		case message := <-c.send:
+			for _, line := range strings.Split(string(message), "\n") {
+				var envelope Envelope
+				if err := json.Unmarshal([]byte(line), &envelope); err != nil {
+					t.Fatalf("queued message %q isn't an envelope: %v", line, err)
+				}
+				envelopes = append(envelopes, envelope)
+			}
+		default:
+			return envelopes
+		}
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	h := newTestHub(t)
+	for i := 0; i < 3; i++ {
+		h.Publish("server:a", "server_update", i)
+	}
+	h.Publish("job:x", "job_update", nil)
+	h.PublishLive("server:a:stats", "server_stats", nil)
+
+	c := newTestClient(t, h)
+	since := uint64(1)
+	result := h.Subscribe(c, []string{"server:*"}, &since)
+	if result.Gap || result.Seq != 4 {
+		t.Fatalf("Subscribe() = gap %v, seq %d; want no gap, seq 4", result.Gap, result.Seq)
+	}
+	replayed := queued(t, c)
+	if len(replayed) != 2 || replayed[0].Seq != 2 || replayed[0].Topic != "server:a" || replayed[1].Seq != 3 {
+		t.Errorf("replayed %+v, want seq 2 and 3 on server:a", replayed)
+	}
+
+	future := uint64(100)
+	if result := h.Subscribe(c, []string{"events"}, &future); !result.Gap {
+		t.Error("a sequence number from an earlier run didn't report a gap")
+	}
+}
+
+func TestSubscribeReportsGapBeyondBuffer(t *testing.T) {
+	h := newTestHub(t)
+	for i := 0; i < 2*replaySize+5; i++ {
+		h.Publish("events", "event", i)
+	}
+	c := newTestClient(t, h)
+	since := uint64(1)
+	result := h.Subscribe(c, []string{"events"}, &since)
+	if !result.Gap {
+		t.Error("events that left the buffer weren't reported as a gap")
+	}
+	if got := len(queued(t, c)); got != replaySize {
+		t.Errorf("%d events replayed, want %d", got, replaySize)
+	}
+	if closed(c) {
+		t.Error("a replay longer than the queue disconnected the client")
+	}
+}
+
+// TestReplayPrecedesLiveEvents publishes while a client subscribes and checks that it sees every event once, in
+// order, whether replayed or live.
+func TestReplayPrecedesLiveEvents(t *testing.T) {
+	h := newTestHub(t)
+	for i := 0; i < replaySize/2; i++ {
+		h.Publish("events", "event", i)
+	}
+
+	c := newTestClient(t, h)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < sendQueueSize/2; i++ {
+			h.Publish("events", "event", i)
+		}
+	}()
+	since := uint64(0)
+	h.Subscribe(c, []string{"events"}, &since)
+	<-done
+	settle(h)
+
+	var last uint64
+	for _, envelope := range queued(t, c) {
+		if envelope.Seq != last+1 {
+			t.Fatalf("got seq %d after %d", envelope.Seq, last)
+		}
+		last = envelope.Seq
+	}
+	if want := uint64(replaySize/2 + sendQueueSize/2); last != want {
+		t.Errorf("last seq = %d, want %d", last, want)
+	}
+	if closed(c) {
+		t.Error("the client was disconnected")
+	}
+}
+
+// TestVanishingConnection drops a real connection without a close handshake and checks that the hub lets go of
+// the client and keeps publishing.
+func TestVanishingConnection(t *testing.T) {
+	h := newTestHub(t)
+	clients := make(chan *Client, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c := NewClient(h, conn, "")
+		c.Protocol = 2
+		h.Register <- c
+		h.Subscribe(c, []string{"events"}, nil)
+		go c.WritePump()
+		go func() {
+			c.ReadPump(func(*Client, []byte) {})
+			h.Unregister <- c
+		}()
+		clients <- c
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := <-clients
+
+	h.Publish("events", "event", "hello")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil || !strings.Contains(string(message), "hello") {
+		t.Fatalf("ReadMessage() = %s, %v; want the published event", message, err)
+	}
+
+	conn.UnderlyingConn().Close()
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("client wasn't closed after its connection vanished")
+	}
+
+	for i := 0; i < sendQueueSize+10; i++ {
+		h.Publish("events", "event", i)
+	}
+	if h.Send(c, []byte("x"), Wait) {
+		t.Error("Send succeeded to a vanished client")
+	}
+	settle(h)
+	if got := h.ClientCount(); got != 0 {
+		t.Errorf("ClientCount() = %d, want 0", got)
+	}
+}
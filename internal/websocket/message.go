@@ -4,10 +4,49 @@ import "encoding/json"
 
 // Message defines the structure for websocket messages.
 type Message struct {
+	ID      string      `json:"id,omitempty"` // Set by second version clients that want a response
 	Action  string      `json:"action"`
 	Payload interface{} `json:"payload,omitempty"`
 }
 
+// Envelope is a message of the second protocol version. Events published to a topic have type "event" and,
+// unless they are live-only, a sequence number that grows by one with every event the hub publishes.
+// Requests that carry an ID are answered with type "response" and the same ID. Messages sent to a single
+// client, like console and terminal output, keep the first version's shape.
+type Envelope struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+	Action  string      `json:"action"`
+	Payload interface{} `json:"payload,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Topics of the second protocol version. Subscriptions may use "*" for the ID, as in "server:*".
+const EventsTopic = "events"
+
+// ServerTopic carries a server's state changes and its deletion.
+func ServerTopic(serverID string) string {
+	return "server:" + serverID
+}
+
+// ServerStatsTopic carries a server's periodic resource samples. They are live-only.
+func ServerStatsTopic(serverID string) string {
+	return "server:" + serverID + ":stats"
+}
+
+// ServerLogsTopic streams a server's console output as 'console_output' messages. It can't be replayed, and
+// takes a concrete server ID.
+func ServerLogsTopic(serverID string) string {
+	return "server:" + serverID + ":logs"
+}
+
+// JobTopic carries the progress of a background job.
+func JobTopic(jobID string) string {
+	return "job:" + jobID
+}
+
 // ConsoleOutputPayload defines the structured payload for any console output.
 type ConsoleOutputPayload struct {
 	Source  string `json:"source"`            // "docker", "rcon", "terminal", "system"
@@ -31,7 +70,44 @@ func NewConsoleOutputMessage(source, command, line string) []byte {
 	return bytes
 }
 
+// NewResponse creates the response to a second version request. A non-empty errMsg marks it as failed.
+func NewResponse(id, action string, payload interface{}, errMsg string) []byte {
+	bytes, _ := json.Marshal(Envelope{Type: "response", ID: id, Action: action, Payload: payload, Error: errMsg})
+	return bytes
+}
+
 // NewErrorMessage creates a new 'console_output' message from the system to show an error.
 func NewErrorMessage(line string) []byte {
 	return NewConsoleOutputMessage("system", "", line)
 }
+
+// TerminalOutputPayload carries output of an interactive shell.
+type TerminalOutputPayload struct {
+	SessionID string `json:"sessionId"`
+	Data      string `json:"data"`
+}
+
+// TerminalClosedPayload tells a client why its interactive shell ended.
+type TerminalClosedPayload struct {
+	SessionID string `json:"sessionId"`
+	Reason    string `json:"reason"`             // "exited", "closed", "idle" or "shutdown"
+	ExitCode  *int   `json:"exitCode,omitempty"` // Set if the shell exited by itself
+}
+
+// NewTerminalOpenedMessage creates a new 'terminal_opened' message for a freshly opened shell.
+func NewTerminalOpenedMessage(sessionID string) []byte {
+	bytes, _ := json.Marshal(Message{Action: "terminal_opened", Payload: map[string]string{"sessionId": sessionID}})
+	return bytes
+}
+
+// NewTerminalOutputMessage creates a new 'terminal_output' message with output of a shell.
+func NewTerminalOutputMessage(sessionID string, data []byte) []byte {
+	bytes, _ := json.Marshal(Message{Action: "terminal_output", Payload: TerminalOutputPayload{SessionID: sessionID, Data: string(data)}})
+	return bytes
+}
+
+// NewTerminalClosedMessage creates a new 'terminal_closed' message for a shell that has ended.
+func NewTerminalClosedMessage(sessionID, reason string, exitCode *int) []byte {
+	bytes, _ := json.Marshal(Message{Action: "terminal_closed", Payload: TerminalClosedPayload{SessionID: sessionID, Reason: reason, ExitCode: exitCode}})
+	return bytes
+}
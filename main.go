@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,14 +13,22 @@ import (
 
 	"github.com/isdelr/ender-deploy-be/internal/api"
 	"github.com/isdelr/ender-deploy-be/internal/auth"
+	"github.com/isdelr/ender-deploy-be/internal/bundle"
 	"github.com/isdelr/ender-deploy-be/internal/config"
 	"github.com/isdelr/ender-deploy-be/internal/database"
 	"github.com/isdelr/ender-deploy-be/internal/docker"
+	"github.com/isdelr/ender-deploy-be/internal/installer"
 	"github.com/isdelr/ender-deploy-be/internal/logger"
+	"github.com/isdelr/ender-deploy-be/internal/metrics"
+	"github.com/isdelr/ender-deploy-be/internal/modpack"
 	"github.com/isdelr/ender-deploy-be/internal/monitoring"
+	"github.com/isdelr/ender-deploy-be/internal/provision"
 	"github.com/isdelr/ender-deploy-be/internal/services"
+	"github.com/isdelr/ender-deploy-be/internal/sftpd"
+	"github.com/isdelr/ender-deploy-be/internal/tracing"
 	"github.com/isdelr/ender-deploy-be/internal/websocket"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
 )
 
 func main() {
@@ -31,12 +41,24 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
+	// Set up tracing before anything that creates spans
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Endpoint:    cfg.OTLPEndpoint,
+		Insecure:    cfg.OTLPInsecure,
+		SampleRatio: cfg.TraceSampleRatio,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
 	// Initialize JWT secret
 	auth.Init(cfg.JWTSecret)
 
-	// Ensure the base directory for server data exists
-	if err := os.MkdirAll(cfg.ServerDataBase, 0755); err != nil {
-		log.Fatal().Err(err).Str("path", cfg.ServerDataBase).Msg("Failed to create base server data directory")
+	// Ensure the base directory for server data and the other storage roots exist
+	for _, root := range append([]string{cfg.ServerDataBase}, cfg.StorageRoots...) {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			log.Fatal().Err(err).Str("path", root).Msg("Failed to create server data directory")
+		}
 	}
 
 	// Ensure the base directory for backups exists
@@ -44,6 +66,18 @@ func main() {
 		log.Fatal().Err(err).Str("path", cfg.BackupPath).Msg("Failed to create base backup directory")
 	}
 
+	if err := os.MkdirAll(cfg.UploadPath, 0755); err != nil {
+		log.Fatal().Err(err).Str("path", cfg.UploadPath).Msg("Failed to create upload directory")
+	}
+
+	if err := os.MkdirAll(cfg.FileHistoryPath, 0755); err != nil {
+		log.Fatal().Err(err).Str("path", cfg.FileHistoryPath).Msg("Failed to create file history directory")
+	}
+
+	if err := os.MkdirAll(cfg.TerminalRecordingPath, 0700); err != nil {
+		log.Fatal().Err(err).Str("path", cfg.TerminalRecordingPath).Msg("Failed to create terminal recording directory")
+	}
+
 	// Set up database
 	db, err := database.New(cfg.DatabasePath)
 	if err != nil {
@@ -66,22 +100,56 @@ func main() {
 	go hub.Run()
 
 	// Set up services
-	templateService := services.NewTemplateService(db)
+	modpackSources := modpack.DefaultSources()
+	modpackSources.CurseForgeAPIKey = cfg.CurseForgeAPIKey
+	containerRunner := services.NewContainerRunner(dockerClient)
+	serverInstaller := installer.New(installer.DefaultSources(), containerRunner)
+	bundleKeys, err := loadBundleKeys(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load template signing keys")
+	}
+	templateService := services.NewTemplateService(db, modpack.NewImporter(modpackSources), serverInstaller, bundleKeys)
 	userService := services.NewUserService(db)
-	eventService := services.NewEventService(db)
-	serverService := services.NewServerService(db, dockerClient, hub, templateService, eventService, cfg.ServerDataBase)
-	backupService := services.NewBackupService(db, serverService, eventService, cfg.BackupPath)
+	eventService := services.NewEventService(db, hub)
+	runtimeService := services.NewRuntimeService(db, containerRunner)
+	fileHistoryService := services.NewFileHistoryService(db, cfg.FileHistoryPath, cfg.FileHistoryLimit)
+	serverService := services.NewTracedServerService(services.NewServerService(db, dockerClient, hub, templateService, eventService, runtimeService, fileHistoryService, provision.New(provision.NewMojangResolver()), cfg.ServerDataBase, cfg.StorageRoots))
+	backupService := services.NewTracedBackupService(services.NewBackupService(db, serverService, eventService, cfg.BackupPath))
+	upgradeService := services.NewUpgradeService(serverService, backupService, eventService, serverInstaller)
+	modService := services.NewModService(serverService, eventService)
+	worldService := services.NewWorldService(serverService, eventService)
+	fileService := services.NewFileService(db, serverService, eventService, fileHistoryService, cfg.UploadPath)
 	scheduleService := services.NewScheduleService(db, eventService)
+	historyService := services.NewHistoryService(db)
+	terminalService := services.NewTerminalService(db, dockerClient, serverService, eventService, cfg.TerminalRecordingPath, cfg.TerminalMaxSessions, cfg.TerminalIdleTimeout)
+	jobService := services.NewJobService(db, hub, cfg.JobWorkers)
+	services.RegisterServerJobs(jobService, serverService, backupService, upgradeService, cfg.UploadPath)
+	services.RegisterTemplateJobs(jobService, templateService, serverService, cfg.UploadPath)
+	services.RegisterRuntimeJobs(jobService, runtimeService)
+	services.RegisterWorldJobs(jobService, worldService, cfg.UploadPath)
+	services.RegisterFileJobs(jobService, fileService)
+
+	// Prometheus collectors that read live state on every scrape
+	metrics.RegisterServers(serverService)
+	metrics.RegisterWebsocketHub(hub)
 
 	// Background services
 	statUpdater := monitoring.NewStatUpdater(db, dockerClient, serverService, eventService)
 	go statUpdater.Run()
 
+	metricsCollector := monitoring.NewMetricsCollector(serverService, eventService, time.Duration(cfg.MetricsIntervalSeconds)*time.Second)
+	go metricsCollector.Run()
+
+	historyCompactor := monitoring.NewHistoryCompactor(historyService)
+	go historyCompactor.Run()
+
 	scheduler := monitoring.NewScheduler(scheduleService, serverService, backupService, eventService)
 	go scheduler.Run()
 
+	go jobService.Run()
+
 	// Router
-	router := api.NewRouter(hub, serverService, templateService, userService, backupService, eventService, scheduleService)
+	router := api.NewRouter(hub, serverService, templateService, userService, backupService, eventService, scheduleService, historyService, jobService, modService, worldService, fileService, runtimeService, terminalService, cfg.UploadPath, cfg.MetricsToken)
 
 	// HTTP server
 	srv := &http.Server{
@@ -97,13 +165,36 @@ func main() {
 		}
 	}()
 
+	// SFTP access to server files
+	var sftpServer *sftpd.Server
+	if cfg.SFTPPort > 0 {
+		hostKey, err := sftpd.LoadOrCreateHostKey(cfg.SFTPHostKeyPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load SFTP host key")
+		}
+		sftpServer = sftpd.New(userService, serverService, eventService, fileHistoryService, hostKey)
+		go func() {
+			log.Info().Int("port", cfg.SFTPPort).Str("host_key", ssh.FingerprintSHA256(hostKey.PublicKey())).Msg("SFTP server starting")
+			if err := sftpServer.ListenAndServe(fmt.Sprintf(":%d", cfg.SFTPPort)); !errors.Is(err, net.ErrClosed) {
+				log.Fatal().Err(err).Msg("SFTP server failed")
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Info().Msg("Shutting down server...")
 
 	statUpdater.Stop()
+	metricsCollector.Stop()
+	historyCompactor.Stop()
 	scheduler.Stop()
+	jobService.Stop()
+	terminalService.CloseAll()
+	if sftpServer != nil {
+		sftpServer.Close()
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -112,5 +203,26 @@ func main() {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to flush pending traces")
+	}
+
 	log.Info().Msg("Server exiting")
 }
+
+// loadBundleKeys loads the key template bundles are signed with and the keys of the hosts they may be imported from.
+func loadBundleKeys(cfg *config.Config) (bundle.Keys, error) {
+	private, err := bundle.LoadOrCreateKey(cfg.TemplateSigningKeyPath)
+	if err != nil {
+		return bundle.Keys{}, err
+	}
+	keys := bundle.Keys{Private: private}
+	for _, encoded := range cfg.TrustedTemplateKeys {
+		key, err := bundle.ParsePublicKey(encoded)
+		if err != nil {
+			return bundle.Keys{}, fmt.Errorf("invalid TEMPLATE_TRUSTED_KEYS: %w", err)
+		}
+		keys.Trusted = append(keys.Trusted, key)
+	}
+	return keys, nil
+}